@@ -1,6 +1,6 @@
 module github.com/recogni/newtmgr
 
-go 1.12
+go 1.13
 
 require (
 	github.com/JuulLabs-OSS/ble v0.0.0-20200716215611-d4fcc9d598bb
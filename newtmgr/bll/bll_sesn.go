@@ -50,6 +50,7 @@ type BllSesn struct {
 	cln ble.Client
 
 	txvr   *mgmt.Transceiver
+	rxTap  sesn.RxTapFn
 	mtx    sync.Mutex
 	attMtu uint16
 
@@ -282,6 +283,7 @@ func (s *BllSesn) openOnce() (bool, error) {
 		return false, err
 	}
 	s.txvr = txvr
+	s.txvr.SetRxTap(s.rxTap)
 
 	if err := s.connect(); err != nil {
 		return false, err
@@ -359,6 +361,13 @@ func (s *BllSesn) AbortRx(nmpSeq uint8) error {
 	return nil
 }
 
+func (s *BllSesn) NextSeq() uint8 {
+	if s.txvr == nil {
+		return nmxutil.NextNmpSeq()
+	}
+	return s.txvr.NextSeq()
+}
+
 func (s *BllSesn) RxAccept() (sesn.Sesn, *sesn.SesnCfg, error) {
 	return nil, nil, fmt.Errorf("Op not implemented yet")
 }
@@ -446,3 +455,10 @@ func (s *BllSesn) SetFilters(txFilter nmcoap.TxMsgFilter,
 
 	s.txvr.SetFilters(txFilter, rxFilter)
 }
+
+func (s *BllSesn) SetRxTap(tap sesn.RxTapFn) {
+	s.rxTap = tap
+	if s.txvr != nil {
+		s.txvr.SetRxTap(tap)
+	}
+}
@@ -26,15 +26,23 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
 	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/sesn"
 	"mynewt.apache.org/newt/util"
 )
 
 var NewtmgrLogLevel log.Level
 var NewtmgrHelp bool
 
+// The command being executed; used to determine which flags were specified
+// on the command line.
+var globalCmd *cobra.Command
+
 func Commands() *cobra.Command {
 	logLevelStr := ""
+	smpVersion := 2
+	retryOnStr := nmutil.RetryOn.String()
 	nmCmd := &cobra.Command{
 		Use:   nmutil.ToolInfo.ExeName,
 		Short: nmutil.ToolInfo.ShortName + " helps you manage remote devices",
@@ -51,6 +59,30 @@ func Commands() *cobra.Command {
 			}
 			nmxutil.SetLogLevel(NewtmgrLogLevel)
 
+			nmutil.RetryOn, err = sesn.ParseRetryClass(retryOnStr)
+			if err != nil {
+				nmUsage(nil, util.ChildNewtError(err))
+			}
+			if nmutil.RetryJitter < 0 || nmutil.RetryJitter > 1 {
+				nmUsage(nil, util.FmtNewtError(
+					"Invalid retry jitter: %g; must be between 0 and 1",
+					nmutil.RetryJitter))
+			}
+			if err := outputFormatCheck(nmutil.OutputFormat); err != nil {
+				nmUsage(nil, err)
+			}
+			globalCmd = cmd
+
+			switch smpVersion {
+			case 1:
+				nmp.DfltVersion = nmp.NMP_VER1
+			case 2:
+				nmp.DfltVersion = nmp.NMP_VER2
+			default:
+				nmUsage(nil, util.FmtNewtError(
+					"Invalid SMP version: %d; must be 1 or 2", smpVersion))
+			}
+
 			// Set cbgo log level if we're using macOS.
 			OSSpecificInit()
 		},
@@ -68,6 +100,21 @@ func Commands() *cobra.Command {
 	nmCmd.PersistentFlags().IntVarP(&nmutil.Tries, "tries", "r", 1,
 		"total number of tries in case of timeout")
 
+	nmCmd.PersistentFlags().Float64Var(&nmutil.RetryBackoff, "retry-backoff",
+		0, "delay in seconds before the first retry; doubles with each "+
+			"further retry")
+
+	nmCmd.PersistentFlags().Float64Var(&nmutil.RetryMaxBackoff,
+		"retry-max-backoff", 0,
+		"maximum delay in seconds between retries (0 for no limit)")
+
+	nmCmd.PersistentFlags().Float64Var(&nmutil.RetryJitter, "retry-jitter", 0,
+		"fraction (0-1) by which retry delays are randomized")
+
+	nmCmd.PersistentFlags().StringVar(&retryOnStr, "retry-on", retryOnStr,
+		"comma separated error classes to retry: timeout, xport, "+
+			"disconnect, other, all, or none")
+
 	nmCmd.PersistentFlags().StringVarP(&logLevelStr, "loglevel", "l", "info",
 		"log level to use")
 
@@ -90,11 +137,33 @@ func Commands() *cobra.Command {
 	nmCmd.PersistentFlags().StringVar(&nmxutil.OmpRes, "ompres", "/omgr",
 		"Use this CoAP resource instead of /omgr")
 
+	nmCmd.PersistentFlags().StringVar(&nmutil.CaptureFile, "capture", "",
+		"Record all traffic with the device to this file; replay it with "+
+			"the \"replay\" connection type")
+
+	// Unlike the library, which defaults to version 1 for compatibility
+	// with existing applications, the tool requests version 2 and relies
+	// on the fallback to version 1.
+	nmCmd.PersistentFlags().IntVar(&smpVersion, "smp-version", 2,
+		"SMP header version to request (1 or 2); falls back to 1 if the "+
+			"device does not support 2")
+
+	nmCmd.PersistentFlags().StringVarP(&nmutil.OutputFormat, "output", "o",
+		OUTPUT_TEXT, "output format: text, json, or yaml")
+
 	versCmd := &cobra.Command{
 		Use:     "version",
 		Short:   "Display the " + nmutil.ToolInfo.ShortName + " version number",
 		Example: "  " + nmutil.ToolInfo.ExeName + " version",
 		Run: func(cmd *cobra.Command, args []string) {
+			if structuredOutput() {
+				render(map[string]string{
+					"name":    nmutil.ToolInfo.LongName,
+					"version": nmutil.ToolInfo.VersionString,
+				})
+				return
+			}
+
 			fmt.Printf("%s %s\n",
 				nmutil.ToolInfo.LongName,
 				nmutil.ToolInfo.VersionString)
@@ -121,6 +190,8 @@ func Commands() *cobra.Command {
 	nmCmd.AddCommand(resCmd())
 	nmCmd.AddCommand(interactiveCmd())
 	nmCmd.AddCommand(shellCmd())
+	nmCmd.AddCommand(decodeCmd())
+	nmCmd.AddCommand(rawCmd())
 
 	return nmCmd
 }
@@ -21,18 +21,25 @@ package cli
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 
+	"github.com/mitchellh/go-homedir"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/recogni/newtmgr/newtmgr/bll"
 	"github.com/recogni/newtmgr/newtmgr/config"
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/capture"
 	"github.com/recogni/newtmgr/nmxact/mtech_lora"
 	"github.com/recogni/newtmgr/nmxact/nmble"
 	"github.com/recogni/newtmgr/nmxact/nmcoap"
 	"github.com/recogni/newtmgr/nmxact/nmserial"
+	"github.com/recogni/newtmgr/nmxact/nmsim"
 	"github.com/recogni/newtmgr/nmxact/sesn"
+	"github.com/recogni/newtmgr/nmxact/tcp"
 	"github.com/recogni/newtmgr/nmxact/udp"
+	"github.com/recogni/newtmgr/nmxact/xact"
 	"github.com/recogni/newtmgr/nmxact/xport"
 	"mynewt.apache.org/newt/util"
 )
@@ -47,6 +54,9 @@ var globalXportSet bool
 var globalTxFilter nmcoap.TxMsgFilter
 var globalRxFilter nmcoap.RxMsgFilter
 
+// Capture file opened by GetSesn; nil if traffic is not being captured.
+var globalCaptureFile *os.File
+
 func initConnProfile() error {
 	var p *config.ConnProfile
 
@@ -88,12 +98,46 @@ func initConnProfile() error {
 		return util.FmtNewtError("No connection type specified")
 	}
 
+	if err := applyProfileRetry(p); err != nil {
+		return err
+	}
+
 	log.Debugf("Using connection profile: %v", p)
 	globalP = p
 
 	return nil
 }
 
+func flagSet(name string) bool {
+	return globalCmd != nil && globalCmd.Flags().Changed(name)
+}
+
+// Applies the profile's retry settings to those not specified on the command
+// line.
+func applyProfileRetry(p *config.ConnProfile) error {
+	if p.Tries != 0 && !flagSet("tries") {
+		nmutil.Tries = p.Tries
+	}
+	if p.RetryBackoff != 0 && !flagSet("retry-backoff") {
+		nmutil.RetryBackoff = p.RetryBackoff
+	}
+	if p.RetryMaxBackoff != 0 && !flagSet("retry-max-backoff") {
+		nmutil.RetryMaxBackoff = p.RetryMaxBackoff
+	}
+	if p.RetryJitter != 0 && !flagSet("retry-jitter") {
+		nmutil.RetryJitter = p.RetryJitter
+	}
+	if p.RetryOn != "" && !flagSet("retry-on") {
+		rc, err := sesn.ParseRetryClass(p.RetryOn)
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+		nmutil.RetryOn = rc
+	}
+
+	return nil
+}
+
 func getConnProfile() (*config.ConnProfile, error) {
 	if globalP == nil {
 		if err := initConnProfile(); err != nil {
@@ -149,10 +193,29 @@ func GetXport() (xport.Xport, error) {
 	case config.CONN_TYPE_UDP_PLAIN, config.CONN_TYPE_UDP_OIC:
 		globalXport = udp.NewUdpXport()
 
+	case config.CONN_TYPE_TCP_PLAIN, config.CONN_TYPE_TCP_OIC:
+		globalXport = tcp.NewTcpXport()
+
 	case config.CONN_TYPE_MTECH_LORA_OIC:
 		cfg := mtech_lora.NewXportCfg()
 		globalXport = mtech_lora.NewLoraXport(cfg)
 
+	case config.CONN_TYPE_SIM_PLAIN, config.CONN_TYPE_SIM_OIC:
+		sc, err := config.ParseSimConnString(cp.ConnString)
+		if err != nil {
+			return nil, err
+		}
+
+		globalXport = nmsim.NewSimXport(sc)
+
+	case config.CONN_TYPE_REPLAY:
+		rc, err := config.ParseReplayConnString(cp.ConnString)
+		if err != nil {
+			return nil, err
+		}
+
+		globalXport = capture.NewReplayXport(rc)
+
 	default:
 		return nil, util.FmtNewtError("Unknown connection type: %s (%d)",
 			config.ConnTypeToString(cp.Type), int(cp.Type))
@@ -242,6 +305,20 @@ func buildSesnCfg() (sesn.SesnCfg, error) {
 
 		return sc, nil
 
+	case config.CONN_TYPE_TCP_PLAIN:
+		sc.MgmtProto = sesn.MGMT_PROTO_NMP
+		sc.PeerSpec.Tcp = cp.ConnString
+		sc.Tcp.ConnTimeout = nmutil.TxOptions().Timeout
+
+		return sc, nil
+
+	case config.CONN_TYPE_TCP_OIC:
+		sc.MgmtProto = sesn.MGMT_PROTO_OMP
+		sc.PeerSpec.Tcp = cp.ConnString
+		sc.Tcp.ConnTimeout = nmutil.TxOptions().Timeout
+
+		return sc, nil
+
 	case config.CONN_TYPE_MTECH_LORA_OIC:
 		mc, err := config.ParseMtechLoraConnString(cp.ConnString)
 		if err != nil {
@@ -251,6 +328,19 @@ func buildSesnCfg() (sesn.SesnCfg, error) {
 		err = config.FillMtechLoraSesnCfg(mc, &sc)
 		return sc, err
 
+	case config.CONN_TYPE_SIM_PLAIN:
+		sc.MgmtProto = sesn.MGMT_PROTO_NMP
+		return sc, nil
+
+	case config.CONN_TYPE_SIM_OIC:
+		sc.MgmtProto = sesn.MGMT_PROTO_OMP
+		return sc, nil
+
+	case config.CONN_TYPE_REPLAY:
+		// The replayed session uses the recorded protocol.
+		sc.MgmtProto = sesn.MGMT_PROTO_NMP
+		return sc, nil
+
 	default:
 		return sc, util.FmtNewtError("Unknown connection type: %s (%d)",
 			config.ConnTypeToString(cp.Type), int(cp.Type))
@@ -334,6 +424,15 @@ func GetSesn() (sesn.Sesn, error) {
 		}
 	}
 
+	if nmutil.CaptureFile != "" {
+		f, err := os.Create(nmutil.CaptureFile)
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+		globalCaptureFile = f
+		s = capture.NewCaptureSesn(s, capture.NewWriter(f))
+	}
+
 	globalSesn = s
 	if err := globalSesn.Open(); err != nil {
 		return nil, util.ChildNewtError(err)
@@ -350,7 +449,85 @@ func GetSesnIfOpen() (sesn.Sesn, error) {
 	return globalSesn, nil
 }
 
+// Closes the capture file, if one is open.  This should be called after the
+// session is closed so that the capture ends with the session's close record.
+func CloseCapture() {
+	if globalCaptureFile != nil {
+		globalCaptureFile.Close()
+		globalCaptureFile = nil
+	}
+}
+
+// Reports the frame errors seen on a serial link, if any, on stderr.  The
+// full set of counters is logged at the debug level.
+func ReportXportStats() {
+	sx, ok := globalXport.(*nmserial.SerialXport)
+	if !ok {
+		return
+	}
+
+	st := sx.Stats()
+	log.Debugf("Serial stats: tx=%d rx=%d crc_errors=%d truncated=%d "+
+		"desync=%d noise=%d", st.TxPkts, st.RxPkts, st.CrcErrors,
+		st.TruncatedPkts, st.DesyncFrames, st.NoiseLines)
+
+	if st.CrcErrors == 0 && st.TruncatedPkts == 0 && st.DesyncFrames == 0 {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Serial link errors: %d CRC errors, %d truncated "+
+		"packets, %d unsynchronized frames (%d packets sent, %d received)\n",
+		st.CrcErrors, st.TruncatedPkts, st.DesyncFrames, st.TxPkts, st.RxPkts)
+}
+
 func SetFilters(txFilter nmcoap.TxMsgFilter, rxFilter nmcoap.RxMsgFilter) {
 	globalTxFilter = txFilter
 	globalRxFilter = rxFilter
 }
+
+// Identifies the target device in the records of unfinished uploads.  The
+// connection type and string, and the BLE peer name if specified, are what
+// distinguish one device from another.
+func deviceId() (string, error) {
+	cp, err := getConnProfile()
+	if err != nil {
+		return "", err
+	}
+
+	id := config.ConnTypeToString(cp.Type) + ":" + cp.ConnString
+	if nmutil.DeviceName != "" {
+		id += ",name=" + nmutil.DeviceName
+	}
+
+	return id, nil
+}
+
+// Returns the store in which upload progress is recorded, or nil if uploads
+// should not be resumed.
+func xferStateStore() *xact.XferStateStore {
+	if noResume {
+		return nil
+	}
+
+	dir, err := homedir.Dir()
+	if err != nil {
+		log.Debugf("Not recording upload progress: %s", err.Error())
+		return nil
+	}
+
+	return xact.NewXferStateStore(
+		filepath.Join(dir, "."+nmutil.ToolInfo.ExeName, "uploads"))
+}
+
+// Returns the store in which the log module and level names of devices are
+// cached, or nil if they cannot be cached.
+func logNameStore() *xact.LogNameStore {
+	dir, err := homedir.Dir()
+	if err != nil {
+		log.Debugf("Not caching log names: %s", err.Error())
+		return nil
+	}
+
+	return xact.NewLogNameStore(
+		filepath.Join(dir, "."+nmutil.ToolInfo.ExeName, "lognames"))
+}
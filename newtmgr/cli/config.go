@@ -25,6 +25,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
 	"github.com/recogni/newtmgr/nmxact/sesn"
 	"github.com/recogni/newtmgr/nmxact/xact"
 	"mynewt.apache.org/newt/util"
@@ -41,8 +42,16 @@ func configRead(s sesn.Sesn, args []string) {
 	}
 
 	sres := res.(*xact.ConfigReadResult)
+	if structuredOutput() {
+		renderResult(sres, map[string]string{
+			"name":  c.Name,
+			"value": sres.Rsp.Val,
+		})
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(sres.Rsp))
 	} else {
 		fmt.Printf("Value: %s\n", sres.Rsp.Val)
 	}
@@ -60,8 +69,16 @@ func configWrite(s sesn.Sesn, args []string) {
 	}
 
 	sres := res.(*xact.ConfigWriteResult)
+	if structuredOutput() {
+		renderResult(sres, map[string]string{
+			"name":  c.Name,
+			"value": c.Val,
+		})
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(sres.Rsp))
 	} else {
 		fmt.Printf("Done\n")
 	}
@@ -78,8 +95,13 @@ func configSave(s sesn.Sesn, args []string) {
 	}
 
 	sres := res.(*xact.ConfigWriteResult)
+	if structuredOutput() {
+		renderResult(sres, nil)
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(sres.Rsp))
 	} else {
 		fmt.Printf("Done\n")
 	}
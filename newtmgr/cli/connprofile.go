@@ -21,15 +21,37 @@ package cli
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/recogni/newtmgr/newtmgr/config"
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/sesn"
 	"mynewt.apache.org/newt/util"
 
 	"github.com/spf13/cobra"
 )
 
+type connProfileOut struct {
+	Name            string  `json:"name"`
+	Type            string  `json:"type"`
+	ConnString      string  `json:"connstring"`
+	Tries           int     `json:"tries,omitempty"`
+	RetryBackoff    float64 `json:"retry_backoff,omitempty"`
+	RetryMaxBackoff float64 `json:"retry_max_backoff,omitempty"`
+	RetryJitter     float64 `json:"retry_jitter,omitempty"`
+	RetryOn         string  `json:"retry_on,omitempty"`
+}
+
+func parseRetrySecs(cmd *cobra.Command, name string, val string) float64 {
+	f, err := strconv.ParseFloat(val, 64)
+	if err != nil || f < 0 {
+		nmUsage(cmd, util.FmtNewtError("Invalid %s: %s", name, val))
+	}
+
+	return f
+}
+
 func connProfileAddCmd(cmd *cobra.Command, args []string) {
 	cpm := config.GlobalConnProfileMgr()
 
@@ -54,6 +76,28 @@ func connProfileAddCmd(cmd *cobra.Command, args []string) {
 			}
 		case "connstring":
 			cp.ConnString = s[1]
+		case "tries":
+			n, err := strconv.Atoi(s[1])
+			if err != nil || n < 1 {
+				nmUsage(cmd, util.FmtNewtError("Invalid tries: %s", s[1]))
+			}
+			cp.Tries = n
+		case "retry-backoff":
+			cp.RetryBackoff = parseRetrySecs(cmd, s[0], s[1])
+		case "retry-max-backoff":
+			cp.RetryMaxBackoff = parseRetrySecs(cmd, s[0], s[1])
+		case "retry-jitter":
+			f, err := strconv.ParseFloat(s[1], 64)
+			if err != nil || f < 0 || f > 1 {
+				nmUsage(cmd, util.FmtNewtError(
+					"Invalid retry-jitter: %s; must be between 0 and 1", s[1]))
+			}
+			cp.RetryJitter = f
+		case "retry-on":
+			if _, err := sesn.ParseRetryClass(s[1]); err != nil {
+				nmUsage(cmd, util.ChildNewtError(err))
+			}
+			cp.RetryOn = s[1]
 		default:
 			nmUsage(cmd, util.NewNewtError("Unknown variable "+s[0]))
 		}
@@ -69,6 +113,11 @@ func connProfileAddCmd(cmd *cobra.Command, args []string) {
 		nmUsage(cmd, err)
 	}
 
+	if structuredOutput() {
+		render(map[string]string{"name": name})
+		return
+	}
+
 	fmt.Printf("Connection profile %s successfully added\n", name)
 }
 
@@ -85,6 +134,27 @@ func connProfileShowCmd(cmd *cobra.Command, args []string) {
 		nmUsage(cmd, err)
 	}
 
+	if structuredOutput() {
+		profiles := []connProfileOut{}
+		for _, cp := range cpList {
+			if name != "" && cp.Name != name {
+				continue
+			}
+			profiles = append(profiles, connProfileOut{
+				Name:            cp.Name,
+				Type:            config.ConnTypeToString(cp.Type),
+				ConnString:      cp.ConnString,
+				Tries:           cp.Tries,
+				RetryBackoff:    cp.RetryBackoff,
+				RetryMaxBackoff: cp.RetryMaxBackoff,
+				RetryJitter:     cp.RetryJitter,
+				RetryOn:         cp.RetryOn,
+			})
+		}
+		render(map[string]interface{}{"profiles": profiles})
+		return
+	}
+
 	found := false
 	for _, cp := range cpList {
 		// Print out the connection profile, if name is "" or name
@@ -97,8 +167,9 @@ func connProfileShowCmd(cmd *cobra.Command, args []string) {
 			found = true
 			fmt.Printf("Connection profiles: \n")
 		}
-		fmt.Printf("  %s: type=%s, connstring='%s'\n",
-			cp.Name, config.ConnTypeToString(cp.Type), cp.ConnString)
+		fmt.Printf("  %s: type=%s, connstring='%s'%s\n",
+			cp.Name, config.ConnTypeToString(cp.Type), cp.ConnString,
+			cp.RetryString())
 	}
 
 	if !found {
@@ -123,6 +194,11 @@ func connProfileDelCmd(cmd *cobra.Command, args []string) {
 		nmUsage(cmd, err)
 	}
 
+	if structuredOutput() {
+		render(map[string]string{"name": name})
+		return
+	}
+
 	fmt.Printf("Connection profile %s successfully deleted.\n", name)
 }
 
@@ -26,6 +26,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
 	"github.com/recogni/newtmgr/nmxact/xact"
 	"mynewt.apache.org/newt/util"
 )
@@ -55,8 +56,13 @@ func crashRunCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.CrashResult)
+	if structuredOutput() {
+		renderResult(sres, nil)
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(sres.Rsp))
 	} else {
 		fmt.Printf("Done\n")
 	}
@@ -26,6 +26,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
 	"github.com/recogni/newtmgr/nmxact/sesn"
 	"github.com/recogni/newtmgr/nmxact/xact"
 	"mynewt.apache.org/newt/util"
@@ -41,6 +42,13 @@ func dateTimeRead(s sesn.Sesn) error {
 	}
 
 	sres := res.(*xact.DateTimeReadResult)
+	if structuredOutput() {
+		renderResult(sres, map[string]string{
+			"datetime": sres.Rsp.DateTime,
+		})
+		return nil
+	}
+
 	fmt.Println("Datetime(RFC 3339 format):", sres.Rsp.DateTime)
 
 	return nil
@@ -54,7 +62,7 @@ func dateTimeWrite(s sesn.Sesn, args []string) error {
 		c.DateTime = args[0]
 	} else {
 		c.DateTime = time.Now().Format(time.RFC3339)
-		fmt.Printf("Setting time to %s\n", c.DateTime)
+		fmt.Fprintf(infoWriter(), "Setting time to %s\n", c.DateTime)
 	}
 
 	res, err := c.Run(s)
@@ -63,8 +71,15 @@ func dateTimeWrite(s sesn.Sesn, args []string) error {
 	}
 
 	sres := res.(*xact.DateTimeWriteResult)
+	if structuredOutput() {
+		renderResult(sres, map[string]string{
+			"datetime": c.DateTime,
+		})
+		return nil
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(sres.Rsp))
 	} else {
 		fmt.Printf("Done\n")
 	}
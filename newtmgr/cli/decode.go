@@ -0,0 +1,605 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/joaojeronimo/go-crc16"
+	"github.com/runtimeco/go-coap"
+	"github.com/spf13/cobra"
+
+	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmserial"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"mynewt.apache.org/newt/util"
+)
+
+var optDecodeFile string
+var optDecodeFormat string
+var optDecodeCoapTcp bool
+
+// Matches the offset column of a hexdump line (e.g., "00000010  ").
+var hexDumpOffRe = regexp.MustCompile(`^[0-9a-fA-F]{8}\s+`)
+
+// Matches the ASCII column of a hexdump line (e.g., "  |..hello|").
+var hexDumpAsciiRe = regexp.MustCompile(`\s+\|.*\|\s*$`)
+
+// Console text may contain the frame markers as literal escape sequences
+// rather than as raw bytes.
+var frameMarkerReplacer = strings.NewReplacer(
+	`\x06\x09`, "\x06\x09",
+	`\x04\x14`, "\x04\x14",
+)
+
+type decoder struct {
+	deframer *nmserial.Deframer
+	blob     []string
+	count    int
+
+	// Decoded messages; only collected for structured output.
+	msgs []*decodeMsgOut
+}
+
+func newDecoder() *decoder {
+	return &decoder{
+		deframer: nmserial.NewDeframer(0),
+	}
+}
+
+// Removes formatting from a hex string.  Returns false if the text is not
+// hex.
+func normalizeHex(text string) (string, bool) {
+	var b strings.Builder
+	for _, line := range strings.Split(text, "\n") {
+		line = hexDumpOffRe.ReplaceAllString(line, "")
+		line = hexDumpAsciiRe.ReplaceAllString(line, "")
+		for _, f := range strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == '\r' || r == ':' || r == ','
+		}) {
+			f = strings.TrimPrefix(strings.TrimPrefix(f, "0x"), "0X")
+			b.WriteString(f)
+		}
+	}
+
+	s := b.String()
+	if len(s) == 0 || len(s)%2 != 0 {
+		return s, false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return s, false
+		}
+	}
+
+	return s, true
+}
+
+func parseBlob(text string) ([]byte, error) {
+	if optDecodeFormat != "base64" {
+		if s, ok := normalizeHex(text); ok {
+			return hex.DecodeString(s)
+		} else if optDecodeFormat == "hex" {
+			return nil, util.FmtNewtError("Invalid hex string: %s", text)
+		}
+	}
+
+	s := strings.Join(strings.Fields(text), "")
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, util.FmtNewtError("Input is neither hex nor base64: %s",
+			text)
+	}
+
+	return b, nil
+}
+
+// If the data is a complete serial packet (length, payload, CRC16), returns
+// the payload; otherwise returns the data unchanged.
+func unwrapSerialPkt(b []byte) []byte {
+	if len(b) < 4 {
+		return b
+	}
+	if int(binary.BigEndian.Uint16(b[0:2])) != len(b)-2 {
+		return b
+	}
+	if crc16.Crc16(b[2:]) != 0 {
+		return b
+	}
+
+	return b[2 : len(b)-2]
+}
+
+// Describes one decoded message.  In text mode, each message is printed as
+// soon as it is decoded; with --output, the messages are collected and
+// printed as a single document.
+type decodeMsgOut struct {
+	Coap *decodeCoapOut `json:"coap,omitempty"`
+	Nmp  *decodeNmpOut  `json:"nmp,omitempty"`
+
+	// Set if the body is shorter than the length in the NMP header.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// The decoded CBOR body, or, if the body is not valid CBOR, the error
+	// and the body in hex.
+	Body      interface{} `json:"body,omitempty"`
+	BodyError string      `json:"body_error,omitempty"`
+	BodyHex   string      `json:"body_hex,omitempty"`
+
+	// Problems with a response body that decoded as CBOR.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Set if the data is not a valid message.
+	Error string `json:"error,omitempty"`
+
+	hdr     *nmp.NmpHdr
+	present int
+}
+
+type decodeNmpOut struct {
+	Dir       string `json:"dir"`
+	Version   int    `json:"version"`
+	Op        int    `json:"op"`
+	OpName    string `json:"op_name"`
+	Group     int    `json:"group"`
+	GroupName string `json:"group_name"`
+	Id        int    `json:"id"`
+	IdName    string `json:"id_name"`
+	Seq       int    `json:"seq"`
+	Flags     int    `json:"flags"`
+	Len       int    `json:"len"`
+}
+
+type decodeCoapOut struct {
+	Dir   string `json:"dir"`
+	Code  string `json:"code"`
+	Type  int    `json:"type"`
+	MsgId int    `json:"msg_id"`
+	Token string `json:"token"`
+	Path  string `json:"path"`
+}
+
+type decodeOut struct {
+	Messages []*decodeMsgOut `json:"messages"`
+}
+
+func nmpHdrDir(hdr *nmp.NmpHdr) string {
+	if nmp.OpIsRsp(hdr.Op) {
+		return "response"
+	}
+	return "request"
+}
+
+func decodeNmpHdrOut(hdr *nmp.NmpHdr) *decodeNmpOut {
+	return &decodeNmpOut{
+		Dir:       nmpHdrDir(hdr),
+		Version:   int(hdr.Version) + 1,
+		Op:        int(hdr.Op),
+		OpName:    nmp.OpName(hdr.Op),
+		Group:     int(hdr.Group),
+		GroupName: nmp.GroupName(hdr.Group),
+		Id:        int(hdr.Id),
+		IdName:    nmp.IdName(hdr.Group, hdr.Id),
+		Seq:       int(hdr.Seq),
+		Flags:     int(hdr.Flags),
+		Len:       int(hdr.Len),
+	}
+}
+
+// Decodes a CBOR body into the message.
+func (m *decodeMsgOut) setBody(body []byte) interface{} {
+	if len(body) == 0 {
+		return nil
+	}
+
+	v, err := nmxutil.DecodeCbor(body)
+	if err != nil {
+		m.BodyError = err.Error()
+		m.BodyHex = hex.EncodeToString(body)
+		return nil
+	}
+
+	m.Body = nmxutil.CborToJson(v)
+	return v
+}
+
+func printJsonBody(v interface{}) {
+	j, err := json.MarshalIndent(v, "    ", "    ")
+	if err != nil {
+		fmt.Printf("    %v\n", v)
+		return
+	}
+
+	fmt.Printf("    %s\n", j)
+}
+
+func printDecodeMsg(m *decodeMsgOut) {
+	if m.Error != "" {
+		fmt.Println(m.Error)
+		return
+	}
+
+	bodyName := "body"
+	if m.Coap != nil {
+		c := m.Coap
+		fmt.Printf("CoAP %s: code=%s type=%d msgid=%d token=%s path=/%s\n",
+			c.Dir, c.Code, c.Type, c.MsgId, c.Token, c.Path)
+		if m.hdr != nil {
+			fmt.Printf("  %s\n", nmpHdrString(m.hdr))
+		}
+		bodyName = "payload"
+	} else {
+		fmt.Println(nmpHdrString(m.hdr))
+	}
+
+	if m.Truncated {
+		fmt.Printf("    truncated; body length %d, %d bytes present\n",
+			m.hdr.Len, m.present)
+	}
+
+	if m.BodyError != "" {
+		fmt.Printf("    invalid CBOR %s: %s\n", bodyName, m.BodyError)
+		fmt.Printf("    %s\n", m.BodyHex)
+	} else if m.Body != nil {
+		printJsonBody(m.Body)
+	}
+
+	for _, w := range m.Warnings {
+		fmt.Printf("    warning: %s\n", w)
+	}
+}
+
+func nameAndNum(name string, num int) string {
+	if name == "" {
+		name = "unknown"
+	}
+	return fmt.Sprintf("%s(%d)", name, num)
+}
+
+func nmpHdrString(hdr *nmp.NmpHdr) string {
+	return fmt.Sprintf("NMP %s (%s): ver=%d group=%s id=%s seq=%d "+
+		"flags=0x%02x len=%d",
+		nmpHdrDir(hdr), nameAndNum(nmp.OpName(hdr.Op), int(hdr.Op)),
+		hdr.Version+1,
+		nameAndNum(nmp.GroupName(hdr.Group), int(hdr.Group)),
+		nameAndNum(nmp.IdName(hdr.Group, hdr.Id), int(hdr.Id)),
+		hdr.Seq, hdr.Flags, hdr.Len)
+}
+
+// Reports responses whose body does not match the format expected for their
+// command.
+func (m *decodeMsgOut) checkRspBody(hdr *nmp.NmpHdr, body []byte) {
+	if !nmp.OpIsRsp(hdr.Op) {
+		return
+	}
+
+	if _, err := nmp.DecodeRspBody(hdr, body); err != nil {
+		m.Warnings = append(m.Warnings, err.Error())
+	}
+}
+
+// Decodes one or more concatenated NMP messages.
+func decodeNmp(b []byte) []*decodeMsgOut {
+	var msgs []*decodeMsgOut
+
+	for len(b) > 0 {
+		m := &decodeMsgOut{}
+		msgs = append(msgs, m)
+
+		hdr, err := nmp.DecodeNmpHdr(b)
+		if err != nil {
+			m.Error = fmt.Sprintf("Invalid NMP message: %s", err.Error())
+			break
+		}
+		m.hdr = hdr
+		m.Nmp = decodeNmpHdrOut(hdr)
+
+		end := nmp.NMP_HDR_SIZE + int(hdr.Len)
+		if end > len(b) {
+			m.Truncated = true
+			m.present = len(b) - nmp.NMP_HDR_SIZE
+			end = len(b)
+		}
+
+		body := b[nmp.NMP_HDR_SIZE:end]
+		m.setBody(body)
+		m.checkRspBody(hdr, body)
+
+		b = b[end:]
+	}
+
+	return msgs
+}
+
+func decodeCoap(b []byte) *decodeMsgOut {
+	var cm coap.Message
+	var err error
+	if optDecodeCoapTcp {
+		cm, _, err = coap.PullTcp(b)
+	} else {
+		cm, err = coap.ParseDgramMessage(b)
+	}
+	if err != nil {
+		return &decodeMsgOut{
+			Error: fmt.Sprintf("Invalid CoAP message: %s", err.Error()),
+		}
+	}
+	if cm == nil {
+		return &decodeMsgOut{Error: "Truncated CoAP message"}
+	}
+
+	m := &decodeMsgOut{
+		Coap: &decodeCoapOut{
+			Dir:   coapDir(cm.Code()),
+			Code:  cm.Code().String(),
+			Type:  int(cm.Type()),
+			MsgId: int(cm.MessageID()),
+			Token: hex.EncodeToString(cm.Token()),
+			Path:  cm.PathString(),
+		},
+	}
+
+	v := m.setBody(cm.Payload())
+	body, ok := m.Body.(map[string]interface{})
+	if !ok {
+		return m
+	}
+
+	// OMP messages carry the NMP header in the "_h" field.
+	if raw, ok := v.(map[interface{}]interface{})["_h"].([]byte); ok {
+		if hdr, err := nmp.DecodeNmpHdr(raw); err == nil {
+			delete(body, "_h")
+			m.hdr = hdr
+			m.Nmp = decodeNmpHdrOut(hdr)
+			m.checkRspBody(hdr, cm.Payload())
+		}
+	}
+
+	return m
+}
+
+func coapDir(code coap.COAPCode) string {
+	if code>>5 == 0 {
+		return "request"
+	}
+	return "response"
+}
+
+// Reports data that could not be deframed.  In text mode, frame errors are
+// not counted as messages.
+func (d *decoder) frameError(err error) {
+	text := fmt.Sprintf("Frame error: %s", err.Error())
+	if structuredOutput() {
+		d.msgs = append(d.msgs, &decodeMsgOut{Error: text})
+	} else {
+		fmt.Println(text)
+	}
+}
+
+func (d *decoder) decodeMsg(b []byte) {
+	var msgs []*decodeMsgOut
+
+	b = unwrapSerialPkt(b)
+	if len(b) == 0 {
+		msgs = []*decodeMsgOut{{Error: "Empty message"}}
+	} else if optDecodeCoapTcp || b[0]>>6 == 1 {
+		// NMP ops occupy the low bits of the first byte; a CoAP datagram
+		// starts with version 1.
+		msgs = []*decodeMsgOut{decodeCoap(b)}
+	} else {
+		msgs = decodeNmp(b)
+	}
+
+	// Concatenated NMP messages are printed as one block of text.
+	for i, m := range msgs {
+		if structuredOutput() {
+			d.msgs = append(d.msgs, m)
+			continue
+		}
+
+		if i == 0 && d.count > 0 {
+			fmt.Println()
+		}
+		printDecodeMsg(m)
+	}
+	d.count++
+}
+
+func (d *decoder) flushBlob() error {
+	if len(d.blob) == 0 {
+		return nil
+	}
+
+	text := strings.Join(d.blob, "\n")
+	d.blob = nil
+
+	b, err := parseBlob(text)
+	if err != nil {
+		return err
+	}
+
+	d.decodeMsg(b)
+	return nil
+}
+
+func hasFrameMarker(line string) bool {
+	return strings.Contains(line, "\x06\x09") ||
+		strings.Contains(line, "\x04\x14")
+}
+
+// Processes one line of serial console output.  Text preceding a frame
+// marker is discarded; lines without a marker are noise.
+func (d *decoder) rxConsoleLine(line string) {
+	start := strings.Index(line, "\x06\x09")
+	if start == -1 {
+		start = strings.Index(line, "\x04\x14")
+	}
+	if start != -1 {
+		line = strings.TrimRight(line[start:], " \t")
+	}
+
+	pkt, err := d.deframer.RxLine([]byte(line))
+	if err != nil {
+		d.frameError(err)
+		return
+	}
+	if pkt != nil {
+		d.decodeMsg(pkt)
+	}
+}
+
+// Processes one line of hex or base64 text.  Text is accumulated until a
+// blank line.
+func (d *decoder) rxBlobLine(line string) error {
+	if strings.TrimSpace(line) == "" {
+		return d.flushBlob()
+	}
+
+	d.blob = append(d.blob, line)
+	return nil
+}
+
+// Decodes a block of input.  If any line contains a frame marker, the input
+// is treated as serial console output.
+func (d *decoder) decodeText(text string) error {
+	text = frameMarkerReplacer.Replace(text)
+	lines := strings.Split(strings.Replace(text, "\r\n", "\n", -1), "\n")
+
+	if hasFrameMarker(text) {
+		for _, line := range lines {
+			d.rxConsoleLine(line)
+		}
+		return nil
+	}
+
+	for _, line := range lines {
+		if err := d.rxBlobLine(line); err != nil {
+			return err
+		}
+	}
+
+	return d.flushBlob()
+}
+
+func (d *decoder) finish() error {
+	if err := d.flushBlob(); err != nil {
+		return err
+	}
+
+	if err := d.deframer.Abandon(); err != nil {
+		d.frameError(err)
+	}
+
+	if d.count == 0 {
+		return util.NewNewtError("No messages found in input")
+	}
+
+	return nil
+}
+
+func decodeRunCmd(cmd *cobra.Command, args []string) {
+	switch optDecodeFormat {
+	case "auto", "hex", "base64":
+	default:
+		nmUsage(cmd, util.FmtNewtError(
+			"Invalid format \"%s\"; must be auto, hex, or base64",
+			optDecodeFormat))
+	}
+
+	d := newDecoder()
+
+	if len(args) > 0 && optDecodeFile == "" {
+		for _, arg := range args {
+			if err := d.decodeText(arg); err != nil {
+				nmUsage(nil, err)
+			}
+		}
+	} else {
+		var r io.Reader = os.Stdin
+		if optDecodeFile != "" && optDecodeFile != "-" {
+			f, err := os.Open(optDecodeFile)
+			if err != nil {
+				nmUsage(nil, util.ChildNewtError(err))
+			}
+			defer f.Close()
+			r = f
+		}
+
+		text, err := ioutil.ReadAll(r)
+		if err != nil {
+			nmUsage(nil, util.ChildNewtError(err))
+		}
+		if err := d.decodeText(string(text)); err != nil {
+			nmUsage(nil, err)
+		}
+	}
+
+	if err := d.finish(); err != nil {
+		nmUsage(nil, err)
+	}
+
+	if structuredOutput() {
+		render(&decodeOut{Messages: d.msgs})
+	}
+}
+
+func decodeCmd() *cobra.Command {
+	decodeHelpText := "Decode newtmgr messages captured offline.  Input is " +
+		"read from the\ncommand line, from a file, or from stdin.  Accepted " +
+		"formats are:\n\n"
+	decodeHelpText += "- Serial console lines containing \\x06\\x09 and " +
+		"\\x04\\x14 frames.\n"
+	decodeHelpText += "- Hex strings or hexdump output.\n"
+	decodeHelpText += "- Base64 strings.\n\n"
+	decodeHelpText += "Decoded data may be an NMP message, a serial packet " +
+		"(length, payload, CRC),\nor a CoAP message.  Blank lines separate " +
+		"messages in hex and base64 input.\n"
+
+	decodeEx := nmutil.ToolInfo.ExeName +
+		" decode 0200000600000000a16164626869\n"
+	decodeEx += nmutil.ToolInfo.ExeName + " decode -f console.log\n"
+	decodeEx += "cat console.log | " + nmutil.ToolInfo.ExeName + " decode\n"
+
+	decodeCmd := &cobra.Command{
+		Use:     "decode [data...]",
+		Short:   "Decode captured newtmgr messages",
+		Long:    decodeHelpText,
+		Example: decodeEx,
+		Run:     decodeRunCmd,
+	}
+	decodeCmd.PersistentFlags().StringVarP(&optDecodeFile, "file", "f", "",
+		"read input from file (\"-\" for stdin)")
+	decodeCmd.PersistentFlags().StringVar(&optDecodeFormat, "format", "auto",
+		"input format: auto, hex, or base64")
+	decodeCmd.PersistentFlags().BoolVar(&optDecodeCoapTcp, "coap-tcp", false,
+		"decode input as CoAP-over-TCP messages")
+
+	return decodeCmd
+}
@@ -49,6 +49,13 @@ func echoRunCmd(cmd *cobra.Command, args []string) {
 	}
 
 	eres := res.(*xact.EchoResult)
+	if structuredOutput() {
+		renderResult(eres, map[string]string{
+			"payload": eres.Rsp.Payload,
+		})
+		return
+	}
+
 	fmt.Println(eres.Rsp.Payload)
 }
 
@@ -52,8 +52,13 @@ func fsDownloadRunCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewFsDownloadCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 	c.Name = args[0]
+	c.MaxWinSz = maxWinSz
+	size := 0
 	c.ProgressCb = func(c *xact.FsDownloadCmd, rsp *nmp.FsDownloadRsp) {
-		fmt.Printf("%d\n", rsp.Off)
+		if !structuredOutput() {
+			fmt.Printf("%d\n", rsp.Off)
+		}
+		size += len(rsp.Data)
 		if _, err := file.Write(rsp.Data); err != nil {
 			nmUsage(nil, util.ChildNewtError(err))
 		}
@@ -65,9 +70,18 @@ func fsDownloadRunCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.FsDownloadResult)
+	if structuredOutput() {
+		renderResult(sres, map[string]interface{}{
+			"name": c.Name,
+			"file": args[1],
+			"size": size,
+		})
+		return
+	}
+
 	rsp := sres.Rsps[len(sres.Rsps)-1]
 	if rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(rsp))
 		return
 	}
 
@@ -93,8 +107,16 @@ func fsUploadRunCmd(cmd *cobra.Command, args []string) {
 	c.SetTxOptions(nmutil.TxOptions())
 	c.Name = args[1]
 	c.Data = data
-	c.ProgressCb = func(c *xact.FsUploadCmd, rsp *nmp.FsUploadRsp) {
-		fmt.Printf("%d\r", rsp.Off)
+	c.MaxWinSz = maxWinSz
+	c.Resume = xferStateStore()
+	c.DeviceId, err = deviceId()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+	if !structuredOutput() {
+		c.ProgressCb = func(c *xact.FsUploadCmd, rsp *nmp.FsUploadRsp) {
+			fmt.Printf("%d\r", rsp.Off)
+		}
 	}
 
 	res, err := c.Run(s)
@@ -103,12 +125,25 @@ func fsUploadRunCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.FsUploadResult)
+	if structuredOutput() {
+		renderResult(sres, map[string]interface{}{
+			"name":       c.Name,
+			"size":       len(data),
+			"resume_off": sres.ResumeOff,
+		})
+		return
+	}
+
 	rsp := sres.Rsps[len(sres.Rsps)-1]
 	if rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(rsp))
 		return
 	}
 
+	if sres.ResumeOff > 0 {
+		fmt.Printf("Resumed upload at offset %d\n", sres.ResumeOff)
+	}
+
 	fmt.Printf("Done\n")
 }
 
@@ -130,6 +165,11 @@ func fsCmd() *cobra.Command {
 		Example: uploadEx,
 		Run:     fsUploadRunCmd,
 	}
+	uploadCmd.Flags().IntVarP(&maxWinSz,
+		"maxwinsize", "w", xact.PIPELINE_DEF_MAX_WS,
+		"Set the maximum number of outstanding chunks in transit")
+	uploadCmd.Flags().BoolVar(&noResume, "noresume", false,
+		"Don't resume an interrupted upload of the same file; start over")
 	fsCmd.AddCommand(uploadCmd)
 
 	downloadEx := "  " + nmutil.ToolInfo.ExeName +
@@ -141,6 +181,9 @@ func fsCmd() *cobra.Command {
 		Example: downloadEx,
 		Run:     fsDownloadRunCmd,
 	}
+	downloadCmd.Flags().IntVarP(&maxWinSz,
+		"maxwinsize", "w", xact.PIPELINE_DEF_MAX_WS,
+		"Set the maximum number of outstanding requests in transit")
 	fsCmd.AddCommand(downloadCmd)
 
 	return fsCmd
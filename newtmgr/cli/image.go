@@ -25,12 +25,14 @@ import (
 	"io/ioutil"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	pb "gopkg.in/cheggaaa/pb.v1"
 
 	"github.com/recogni/newtmgr/newtmgr/core"
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmimage"
 	"github.com/recogni/newtmgr/nmxact/nmp"
 	"github.com/recogni/newtmgr/nmxact/xact"
 	"mynewt.apache.org/newt/util"
@@ -45,7 +47,147 @@ var (
 var noerase bool
 var upgrade bool
 var imageNum int
+var imageForce bool
+var imageKeys []string
+var deployBootTimeout float64
+var deployNoConfirm bool
 var maxWinSz int
+var noResume bool
+
+type imageOut struct {
+	Image     int    `json:"image"`
+	Slot      int    `json:"slot"`
+	Version   string `json:"version"`
+	Bootable  bool   `json:"bootable"`
+	Active    bool   `json:"active"`
+	Confirmed bool   `json:"confirmed"`
+	Pending   bool   `json:"pending"`
+	Permanent bool   `json:"permanent"`
+	Hash      string `json:"hash"`
+}
+
+type imageStateOut struct {
+	Images          []imageOut `json:"images"`
+	SplitStatus     int        `json:"split_status"`
+	SplitStatusName string     `json:"split_status_name"`
+}
+
+type imageTlvOut struct {
+	Type      string `json:"type"`
+	Len       int    `json:"len"`
+	Protected bool   `json:"protected"`
+}
+
+type imageDepOut struct {
+	Image   int    `json:"image"`
+	Version string `json:"version"`
+}
+
+type imageInspectOut struct {
+	File         string        `json:"file"`
+	Version      string        `json:"version"`
+	HeaderSize   int           `json:"header_size"`
+	BodySize     int           `json:"body_size"`
+	ProtTlvSize  int           `json:"prot_tlv_size"`
+	TotalSize    int           `json:"total_size"`
+	TrailingData int           `json:"trailing_data"`
+	LoadAddr     uint32        `json:"load_addr"`
+	Flags        string        `json:"flags"`
+	Hash         string        `json:"hash"`
+	CalcHash     string        `json:"calc_hash"`
+	KeyHashes    []string      `json:"key_hashes"`
+	Dependencies []imageDepOut `json:"dependencies"`
+	Tlvs         []imageTlvOut `json:"tlvs"`
+	Valid        bool          `json:"valid"`
+}
+
+type imageUploadOut struct {
+	File      string                `json:"file"`
+	Image     int                   `json:"image"`
+	ResumeOff int                   `json:"resume_off"`
+	Stats     *xferStatsOut         `json:"stats,omitempty"`
+	Signature *nmimage.VerifyResult `json:"signature,omitempty"`
+}
+
+type imageDeployOut struct {
+	File           string                `json:"file"`
+	Image          int                   `json:"image"`
+	Hash           string                `json:"hash"`
+	AlreadyActive  bool                  `json:"already_active"`
+	RolledBack     bool                  `json:"rolled_back"`
+	RollbackReason string                `json:"rollback_reason,omitempty"`
+	ResumeOff      int                   `json:"resume_off"`
+	Stats          *xferStatsOut         `json:"stats,omitempty"`
+	Signature      *nmimage.VerifyResult `json:"signature,omitempty"`
+	State          *imageStateOut        `json:"state,omitempty"`
+}
+
+func imageStateOutput(rsp *nmp.ImageStateRsp) *imageStateOut {
+	out := &imageStateOut{
+		Images:          []imageOut{},
+		SplitStatus:     int(rsp.SplitStatus),
+		SplitStatusName: rsp.SplitStatus.String(),
+	}
+
+	for _, img := range rsp.Images {
+		out.Images = append(out.Images, imageOut{
+			Image:     img.Image,
+			Slot:      img.Slot,
+			Version:   img.Version,
+			Bootable:  img.Bootable,
+			Active:    img.Active,
+			Confirmed: img.Confirmed,
+			Pending:   img.Pending,
+			Permanent: img.Permanent,
+			Hash:      hex.EncodeToString(img.Hash),
+		})
+	}
+
+	return out
+}
+
+func imageInspectOutput(filename string, data []byte,
+	img *nmimage.Image) *imageInspectOut {
+
+	out := &imageInspectOut{
+		File:         filename,
+		Version:      img.Hdr.Vers.String(),
+		HeaderSize:   int(img.Hdr.HdrSz),
+		BodySize:     int(img.Hdr.ImgSz),
+		ProtTlvSize:  int(img.Hdr.ProtTlvSz),
+		TotalSize:    img.TotalLen,
+		LoadAddr:     img.Hdr.LoadAddr,
+		Flags:        nmimage.FlagsToString(img.Hdr.Flags),
+		Hash:         hex.EncodeToString(img.Hash()),
+		CalcHash:     hex.EncodeToString(img.CalcHash()),
+		KeyHashes:    []string{},
+		Dependencies: []imageDepOut{},
+		Tlvs:         []imageTlvOut{},
+	}
+
+	if pad := len(data) - img.TotalLen; pad > 0 {
+		out.TrailingData = pad
+	}
+	for _, kh := range img.KeyHashes() {
+		out.KeyHashes = append(out.KeyHashes, hex.EncodeToString(kh))
+	}
+	deps, _ := img.Dependencies()
+	for _, d := range deps {
+		out.Dependencies = append(out.Dependencies, imageDepOut{
+			Image:   d.ImageNum,
+			Version: d.Vers.String(),
+		})
+	}
+	for _, t := range img.Tlvs {
+		out.Tlvs = append(out.Tlvs, imageTlvOut{
+			Type:      nmimage.TlvTypeToString(t.Type),
+			Len:       len(t.Data),
+			Protected: t.Protected,
+		})
+	}
+
+	return out
+}
 
 func imageFlagsStr(image nmp.ImageStateEntry) string {
 	strs := []string{}
@@ -68,7 +210,7 @@ func imageFlagsStr(image nmp.ImageStateEntry) string {
 
 func imageStatePrintRsp(rsp *nmp.ImageStateRsp) error {
 	if rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(rsp))
 		return nil
 	}
 	fmt.Println("Images:")
@@ -103,6 +245,10 @@ func imageStateListCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.ImageStateReadResult)
+	if structuredOutput() {
+		renderResult(ires, imageStateOutput(ires.Rsp))
+		return
+	}
 
 	if err := imageStatePrintRsp(ires.Rsp); err != nil {
 		nmUsage(nil, err)
@@ -134,6 +280,10 @@ func imageStateTestCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.ImageStateWriteResult)
+	if structuredOutput() {
+		renderResult(ires, imageStateOutput(ires.Rsp))
+		return
+	}
 
 	if err := imageStatePrintRsp(ires.Rsp); err != nil {
 		nmUsage(nil, err)
@@ -165,12 +315,221 @@ func imageStateConfirmCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.ImageStateWriteResult)
+	if structuredOutput() {
+		renderResult(ires, imageStateOutput(ires.Rsp))
+		return
+	}
 
 	if err := imageStatePrintRsp(ires.Rsp); err != nil {
 		nmUsage(nil, err)
 	}
 }
 
+// Parses an image file and checks that it can be uploaded as the specified
+// image number.  If the file parses, the image is returned even if it fails
+// the checks.
+func imageCheck(data []byte, imageNum int) (*nmimage.Image, error) {
+	img, err := nmimage.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := img.Validate(); err != nil {
+		return img, err
+	}
+	if err := img.CheckImageNum(imageNum); err != nil {
+		return img, err
+	}
+
+	return img, nil
+}
+
+func imageInspectCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, util.NewNewtError("Need to specify image to inspect"))
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		nmUsage(cmd, util.NewNewtError(err.Error()))
+	}
+
+	img, err := nmimage.Parse(data)
+	if err != nil {
+		nmUsage(nil, util.NewNewtError(err.Error()))
+	}
+
+	if structuredOutput() {
+		out := imageInspectOutput(args[0], data, img)
+		err = img.Validate()
+		if err == nil && cmd.Flags().Changed("image") {
+			err = img.CheckImageNum(imageNum)
+		}
+		out.Valid = err == nil
+		if err != nil {
+			renderError(err, out)
+			NmExit(1)
+		}
+		render(out)
+		return
+	}
+
+	flags := nmimage.FlagsToString(img.Hdr.Flags)
+	if flags == "" {
+		flags = "none"
+	}
+
+	fmt.Printf("Image: %s\n", args[0])
+	fmt.Printf("    version: %s\n", img.Hdr.Vers.String())
+	fmt.Printf("    header size: %d\n", img.Hdr.HdrSz)
+	fmt.Printf("    body size: %d\n", img.Hdr.ImgSz)
+	fmt.Printf("    protected TLV size: %d\n", img.Hdr.ProtTlvSz)
+	fmt.Printf("    total size: %d\n", img.TotalLen)
+	if pad := len(data) - img.TotalLen; pad > 0 {
+		fmt.Printf("    trailing data: %d\n", pad)
+	}
+	fmt.Printf("    load address: 0x%08x\n", img.Hdr.LoadAddr)
+	fmt.Printf("    flags: %s\n", flags)
+	if hash := img.Hash(); hash == nil {
+		fmt.Printf("    hash: Unavailable\n")
+	} else {
+		fmt.Printf("    hash: %x\n", hash)
+	}
+	fmt.Printf("    calculated hash: %x\n", img.CalcHash())
+	for _, kh := range img.KeyHashes() {
+		fmt.Printf("    key hash: %x\n", kh)
+	}
+	deps, _ := img.Dependencies()
+	for _, d := range deps {
+		fmt.Printf("    depends on: image=%d version>=%s\n", d.ImageNum,
+			d.Vers.String())
+	}
+
+	fmt.Printf("    TLVs:\n")
+	for _, t := range img.Tlvs {
+		area := "unprotected"
+		if t.Protected {
+			area = "protected"
+		}
+		fmt.Printf("        %s (%s)\n", t.String(), area)
+	}
+
+	err = img.Validate()
+	if err == nil && cmd.Flags().Changed("image") {
+		err = img.CheckImageNum(imageNum)
+	}
+	if err != nil {
+		fmt.Printf("Status: invalid; %s\n", err.Error())
+		NmExit(1)
+	}
+	fmt.Printf("Status: valid\n")
+}
+
+// Checks the signatures of an image file against the trusted public keys in
+// the specified PEM files.
+func imageVerify(data []byte, keyFiles []string) (
+	*nmimage.VerifyResult, error) {
+
+	keys, err := nmimage.ReadPublicKeys(keyFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := nmimage.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot verify signatures; %s", err.Error())
+	}
+
+	return img.VerifySigs(keys), nil
+}
+
+func imageVerifyCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, util.NewNewtError("Need to specify image to verify"))
+	}
+	if len(imageKeys) == 0 {
+		nmUsage(cmd, util.NewNewtError("Need to specify at least one key"))
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		nmUsage(cmd, util.NewNewtError(err.Error()))
+	}
+
+	vres, err := imageVerify(data, imageKeys)
+	if err != nil {
+		nmUsage(nil, util.NewNewtError(err.Error()))
+	}
+
+	if structuredOutput() {
+		if vres.Verified {
+			render(vres)
+		} else {
+			renderError(fmt.Errorf("Image signature verification failed: %s",
+				vres.String()), vres)
+		}
+	} else {
+		hashStatus := "valid"
+		if !vres.HashValid {
+			hashStatus = "invalid"
+		}
+
+		fmt.Printf("Image: %s\n", args[0])
+		fmt.Printf("    hash: %s (%s)\n", vres.Hash, hashStatus)
+		for _, sr := range vres.Sigs {
+			if sr.Valid {
+				fmt.Printf("    %s signature: verified with key %s\n",
+					sr.Type, sr.Key)
+			} else {
+				fmt.Printf("    %s signature: not verified; %s\n",
+					sr.Type, sr.Err)
+			}
+			if sr.KeyHash != "" {
+				fmt.Printf("        key hash: %s\n", sr.KeyHash)
+			}
+		}
+		fmt.Printf("Status: %s\n", vres.String())
+	}
+
+	if !vres.Verified {
+		NmExit(1)
+	}
+}
+
+// Performs the checks that precede an upload: the image number, the validity
+// of the image file, and, if trusted keys were specified, its signatures.
+// Exits on failure.
+func imageUploadCheck(cmd *cobra.Command,
+	imageFile []byte) *nmimage.VerifyResult {
+
+	if imageNum < 0 {
+		nmUsage(cmd, util.NewNewtError("Invalid image number"))
+	}
+
+	if _, err := imageCheck(imageFile, imageNum); err != nil {
+		if !imageForce {
+			nmUsage(nil, util.NewNewtError(fmt.Sprintf(
+				"%s; use --force to upload anyway", err.Error())))
+		}
+		printWarning("%s", err.Error())
+	}
+
+	if len(imageKeys) == 0 {
+		return nil
+	}
+
+	vres, err := imageVerify(imageFile, imageKeys)
+	if err != nil {
+		nmUsage(nil, util.NewNewtError(err.Error()))
+	}
+	if !vres.Verified {
+		nmUsage(nil, util.NewNewtError(fmt.Sprintf(
+			"Image signature verification failed: %s", vres.String())))
+	}
+
+	return vres
+}
+
 func imageUploadCmd(cmd *cobra.Command, args []string) {
 	if len(args) < 1 {
 		nmUsage(cmd, util.NewNewtError("Need to specify image to upload"))
@@ -181,6 +540,8 @@ func imageUploadCmd(cmd *cobra.Command, args []string) {
 		nmUsage(cmd, util.NewNewtError(err.Error()))
 	}
 
+	vres := imageUploadCheck(cmd, imageFile)
+
 	s, err := GetSesn()
 	if err != nil {
 		nmUsage(nil, err)
@@ -192,18 +553,22 @@ func imageUploadCmd(cmd *cobra.Command, args []string) {
 	if noerase == true {
 		c.NoErase = true
 	}
-	if imageNum < 0 {
-		nmUsage(cmd, util.NewNewtError("Invalid image number"))
-	}
 	c.ImageNum = imageNum
 	c.Upgrade = upgrade
-	c.ProgressBar = pb.StartNew(len(imageFile))
-	c.ProgressBar.SetUnits(pb.U_BYTES)
-	c.ProgressBar.ShowSpeed = true
+	if !structuredOutput() {
+		c.ProgressBar = pb.StartNew(len(imageFile))
+		c.ProgressBar.SetUnits(pb.U_BYTES)
+		c.ProgressBar.ShowSpeed = true
+	}
 	c.LastOff = 0
 	c.MaxWinSz = maxWinSz
+	c.Resume = xferStateStore()
+	c.DeviceId, err = deviceId()
+	if err != nil {
+		nmUsage(nil, err)
+	}
 	c.ProgressCb = func(cmd *xact.ImageUploadCmd, rsp *nmp.ImageUploadRsp) {
-		if rsp.Off > c.LastOff {
+		if c.ProgressBar != nil && rsp.Off > c.LastOff {
 			c.ProgressBar.Add(int(rsp.Off - c.LastOff))
 			c.LastOff = rsp.Off
 		}
@@ -214,15 +579,206 @@ func imageUploadCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
+	if structuredOutput() {
+		upres := res.(*xact.ImageUpgradeResult)
+		out := &imageUploadOut{
+			File:      args[0],
+			Image:     imageNum,
+			ResumeOff: upres.ResumeOff,
+			Signature: vres,
+		}
+		if ures := upres.UploadRes; ures != nil && ures.Stats.Requests > 0 {
+			out.Stats = xferStatsOutput(&ures.Stats)
+		}
+		renderResult(res, out)
+		return
+	}
+
 	if res.Status() != 0 {
-		fmt.Printf("Error: %d\n", res.Status())
+		fmt.Printf("Error: %s\n", xact.StatusError(res))
 		return
 	}
 
 	c.ProgressBar.Finish()
+
+	upres := res.(*xact.ImageUpgradeResult)
+	if upres.ResumeOff > 0 {
+		fmt.Printf("Resumed upload at offset %d\n", upres.ResumeOff)
+	}
+	if ures := upres.UploadRes; ures != nil && ures.Stats.Requests > 0 {
+		fmt.Printf("Uploaded %s\n", ures.Stats.String())
+	}
+	if vres != nil {
+		fmt.Printf("Signature: %s\n", vres.String())
+	}
+	fmt.Printf("Done\n")
+}
+
+func imageDeployCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 1 {
+		nmUsage(cmd, util.NewNewtError("Need to specify image to deploy"))
+	}
+
+	imageFile, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		nmUsage(cmd, util.NewNewtError(err.Error()))
+	}
+
+	vres := imageUploadCheck(cmd, imageFile)
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	var lastOff uint32
+	var bar *pb.ProgressBar
+
+	c := xact.NewImageDeployCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Data = imageFile
+	c.ImageNum = imageNum
+	c.NoErase = noerase
+	c.Upgrade = upgrade
+	c.MaxWinSz = maxWinSz
+	c.NoConfirm = deployNoConfirm
+	c.Resume = xferStateStore()
+	c.DeviceId, err = deviceId()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+	c.BootTimeout = time.Duration(deployBootTimeout * float64(time.Second))
+	c.ProgressCb = func(uc *xact.ImageUploadCmd, rsp *nmp.ImageUploadRsp) {
+		if bar != nil && rsp.Off > lastOff {
+			bar.Add(int(rsp.Off - lastOff))
+			lastOff = rsp.Off
+		}
+	}
+	c.StageCb = func(c *xact.ImageDeployCmd, stage xact.ImageDeployStage) {
+		if structuredOutput() {
+			return
+		}
+		if bar != nil {
+			bar.Finish()
+			bar = nil
+		}
+
+		switch stage {
+		case xact.IMAGE_DEPLOY_UPLOAD:
+			fmt.Printf("Uploading image\n")
+			bar = pb.StartNew(len(imageFile))
+			bar.SetUnits(pb.U_BYTES)
+			bar.ShowSpeed = true
+		case xact.IMAGE_DEPLOY_TEST:
+			fmt.Printf("Marking image for test\n")
+		case xact.IMAGE_DEPLOY_RESET:
+			fmt.Printf("Resetting device\n")
+		case xact.IMAGE_DEPLOY_WAIT:
+			fmt.Printf("Waiting for device\n")
+		case xact.IMAGE_DEPLOY_HEALTH:
+			fmt.Printf("New image running; checking health\n")
+		case xact.IMAGE_DEPLOY_CONFIRM:
+			fmt.Printf("Confirming image\n")
+		case xact.IMAGE_DEPLOY_ROLLBACK:
+			fmt.Printf("Resetting device to revert image\n")
+		}
+	}
+
+	res, err := c.Run(s)
+	if bar != nil {
+		bar.Finish()
+	}
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	dres := res.(*xact.ImageDeployResult)
+	if structuredOutput() {
+		renderDeploy(args[0], dres, vres)
+		return
+	}
+
+	if dres.AlreadyActive {
+		fmt.Printf("Image %x is already running and confirmed\n", dres.Hash)
+		return
+	}
+
+	if err := xact.StatusError(dres); err != nil {
+		fmt.Printf("Error: %s\n", err.Error())
+		NmExit(1)
+	}
+
+	if upres := dres.UpgradeRes; upres != nil {
+		if upres.ResumeOff > 0 {
+			fmt.Printf("Resumed upload at offset %d\n", upres.ResumeOff)
+		}
+		if ures := upres.UploadRes; ures != nil && ures.Stats.Requests > 0 {
+			fmt.Printf("Uploaded %s\n", ures.Stats.String())
+		}
+	}
+	if vres != nil {
+		fmt.Printf("Signature: %s\n", vres.String())
+	}
+
+	if dres.ConfirmRes != nil {
+		imageStatePrintRsp(dres.ConfirmRes.Rsp)
+	} else if dres.StateRsp != nil {
+		imageStatePrintRsp(dres.StateRsp)
+	}
+
+	if dres.RolledBack {
+		fmt.Printf("Rollback: %s\n", dres.RollbackReason)
+		NmExit(1)
+	}
+
+	if deployNoConfirm {
+		fmt.Printf("Image %x is running unconfirmed\n", dres.Hash)
+	} else {
+		fmt.Printf("Image %x deployed\n", dres.Hash)
+	}
 	fmt.Printf("Done\n")
 }
 
+func renderDeploy(filename string, dres *xact.ImageDeployResult,
+	vres *nmimage.VerifyResult) {
+
+	out := &imageDeployOut{
+		File:           filename,
+		Image:          imageNum,
+		Hash:           hex.EncodeToString(dres.Hash),
+		AlreadyActive:  dres.AlreadyActive,
+		RolledBack:     dres.RolledBack,
+		RollbackReason: dres.RollbackReason,
+		Signature:      vres,
+	}
+	if upres := dres.UpgradeRes; upres != nil {
+		out.ResumeOff = upres.ResumeOff
+		if ures := upres.UploadRes; ures != nil && ures.Stats.Requests > 0 {
+			out.Stats = xferStatsOutput(&ures.Stats)
+		}
+	}
+	if dres.ConfirmRes != nil {
+		out.State = imageStateOutput(dres.ConfirmRes.Rsp)
+	} else if dres.StateRsp != nil {
+		out.State = imageStateOutput(dres.StateRsp)
+	}
+
+	doc := &outputDoc{
+		Rc:     dres.Status(),
+		Result: out,
+	}
+	if err := xact.StatusError(dres); err != nil {
+		doc.Error = err.Error()
+	} else if dres.RolledBack {
+		doc.Error = "Rollback: " + dres.RollbackReason
+	}
+	renderDoc(doc)
+
+	if doc.Error != "" {
+		NmExit(1)
+	}
+}
+
 func coreListCmd(cmd *cobra.Command, args []string) {
 	s, err := GetSesn()
 	if err != nil {
@@ -237,6 +793,14 @@ func coreListCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.CoreListResult)
+	if structuredOutput() {
+		if ires.Status() == nmp.NMP_ERR_ENOENT {
+			render(map[string]bool{"present": false})
+		} else {
+			renderResult(ires, map[string]bool{"present": true})
+		}
+		return
+	}
 
 	switch ires.Status() {
 	case 0:
@@ -244,7 +808,7 @@ func coreListCmd(cmd *cobra.Command, args []string) {
 	case nmp.NMP_ERR_ENOENT:
 		fmt.Printf("No corefiles\n")
 	default:
-		fmt.Printf("Error: %d\n", ires.Status())
+		fmt.Printf("Error: %s\n", xact.StatusError(ires))
 	}
 }
 
@@ -269,8 +833,13 @@ func coreDownloadCmd(cmd *cobra.Command, args []string) {
 
 	c := xact.NewCoreLoadCmd()
 	c.SetTxOptions(nmutil.TxOptions())
+	c.MaxWinSz = maxWinSz
+	size := 0
 	c.ProgressCb = func(c *xact.CoreLoadCmd, rsp *nmp.CoreLoadRsp) {
-		fmt.Printf("%d\n", rsp.Off)
+		if !structuredOutput() {
+			fmt.Printf("%d\n", rsp.Off)
+		}
+		size += len(rsp.Data)
 		if _, err := file.Write(rsp.Data); err != nil {
 			nmUsage(nil, util.ChildNewtError(err))
 		}
@@ -283,12 +852,25 @@ func coreDownloadCmd(cmd *cobra.Command, args []string) {
 
 	sres := res.(*xact.CoreLoadResult)
 	if sres.Status() != 0 {
-		fmt.Printf("Error: %d\n", sres.Status())
+		if structuredOutput() {
+			renderResult(sres, nil)
+			return
+		}
+		fmt.Printf("Error: %s\n", xact.StatusError(sres))
 		return
 	}
 
+	out := map[string]interface{}{
+		"file":  args[0],
+		"bytes": size,
+	}
+
 	if !coreElfify {
 		os.Rename(tmpName, args[0])
+		if structuredOutput() {
+			render(out)
+			return
+		}
 		fmt.Printf("Done writing core file to %s\n", args[0])
 	} else {
 		coreConvert, err := core.ConvertFilenames(tmpName, args[0])
@@ -297,6 +879,11 @@ func coreDownloadCmd(cmd *cobra.Command, args []string) {
 			return
 		}
 
+		if structuredOutput() {
+			out["image_hash"] = hex.EncodeToString(coreConvert.ImageHash)
+			render(out)
+			return
+		}
 		fmt.Printf("Done writing core file to %s; hash=%x\n", args[0],
 			coreConvert.ImageHash)
 	}
@@ -316,9 +903,13 @@ func coreEraseCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.CoreEraseResult)
+	if structuredOutput() {
+		renderResult(ires, nil)
+		return
+	}
 
 	if ires.Status() != 0 {
-		fmt.Printf("Error: %d\n", ires.Status())
+		fmt.Printf("Error: %s\n", xact.StatusError(ires))
 		return
 	}
 
@@ -339,9 +930,13 @@ func imageEraseCmd(cmd *cobra.Command, args []string) {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 	ires := res.(*xact.ImageEraseResult)
+	if structuredOutput() {
+		renderResult(ires, nil)
+		return
+	}
 
 	if ires.Status() != 0 {
-		fmt.Printf("Error: %d\n", ires.Status())
+		fmt.Printf("Error: %s\n", xact.StatusError(ires))
 		return
 	}
 
@@ -360,6 +955,13 @@ func coreConvertCmd(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	if structuredOutput() {
+		render(map[string]string{
+			"image_hash": hex.EncodeToString(coreConvert.ImageHash),
+		})
+		return
+	}
+
 	fmt.Printf("Corefile created for\n   %x\n", coreConvert.ImageHash)
 }
 
@@ -415,12 +1017,92 @@ func imageCmd() *cobra.Command {
 	uploadCmd.PersistentFlags().IntVarP(&imageNum,
 		"image", "n", 0,
 		"In a multi-image system, which image should be uploaded")
+	uploadCmd.PersistentFlags().BoolVar(&imageForce,
+		"force", false,
+		"Upload even if the file is not a valid image for the target "+
+			"image number")
+	uploadCmd.PersistentFlags().StringArrayVarP(&imageKeys,
+		"key", "k", nil,
+		"Only upload the image if it is signed with this trusted public "+
+			"key (PEM file); may be repeated")
 	uploadCmd.PersistentFlags().IntVarP(&maxWinSz,
 		"maxwinsize", "w", xact.IMAGE_UPLOAD_DEF_MAX_WS,
 		"Set the maximum size for the window of outstanding chunks in transit. "+
 			"caution:higher num may not translate to better perf and may result in errors")
+	uploadCmd.PersistentFlags().BoolVar(&noResume, "noresume", false,
+		"Don't resume an interrupted upload of the same image; start over")
 	imageCmd.AddCommand(uploadCmd)
 
+	inspectEx := "  " + nmutil.ToolInfo.ExeName +
+		" image inspect bin/slinky_zero/apps/slinky.img\n"
+
+	inspectCmd := &cobra.Command{
+		Use:     "inspect <image-file>",
+		Short:   "Show and validate the contents of an image file",
+		Example: inspectEx,
+		Run:     imageInspectCmd,
+	}
+	inspectCmd.Flags().IntVarP(&imageNum, "image", "n", 0,
+		"Also check that the image can be uploaded as this image number")
+	imageCmd.AddCommand(inspectCmd)
+
+	verifyEx := "  " + nmutil.ToolInfo.ExeName +
+		" image verify -k root-ec-p256-pub.pem " +
+		"bin/slinky_zero/apps/slinky.img\n"
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify <image-file> -k <key-file> [-k <key-file>...]",
+		Short: "Verify the signatures of an image file",
+		Long: "Verify the signatures of an image file against one or " +
+			"more trusted public keys (ECDSA P-256, RSA-2048/3072 or " +
+			"Ed25519, in PEM form).  The image is verified if its hash " +
+			"is valid and at least one signature is made by a trusted key.",
+		Example: verifyEx,
+		Run:     imageVerifyCmd,
+	}
+	verifyCmd.Flags().StringArrayVarP(&imageKeys, "key", "k", nil,
+		"Trusted public key (PEM file); may be repeated")
+	imageCmd.AddCommand(verifyCmd)
+
+	deployEx := "  " + nmutil.ToolInfo.ExeName +
+		" -c olimex image deploy bin/slinky_zero/apps/slinky.img\n"
+
+	deployCmd := &cobra.Command{
+		Use:   "deploy <image-file> -c <conn_profile>",
+		Short: "Upload, test and confirm an image",
+		Long: "Upload an image, mark it for test and reset the device.  " +
+			"Once the device is back, verify that the new image is " +
+			"running and responsive, then confirm it.  If the device " +
+			"reverted or the new image is unresponsive, the deploy is " +
+			"rolled back.",
+		Example: deployEx,
+		Run:     imageDeployCmd,
+	}
+	deployCmd.Flags().BoolVarP(&noerase, "noerase", "e", true,
+		"Don't send specific image erase command to start with")
+	deployCmd.Flags().BoolVarP(&upgrade, "upgrade", "u", false,
+		"Only allow the upload if the new image's version is greater than "+
+			"that of the currently running image")
+	deployCmd.Flags().IntVarP(&imageNum, "image", "n", 0,
+		"In a multi-image system, which image should be deployed")
+	deployCmd.Flags().BoolVar(&imageForce, "force", false,
+		"Upload even if the file is not a valid image for the target "+
+			"image number")
+	deployCmd.Flags().StringArrayVarP(&imageKeys, "key", "k", nil,
+		"Only deploy the image if it is signed with this trusted public "+
+			"key (PEM file); may be repeated")
+	deployCmd.Flags().IntVarP(&maxWinSz, "maxwinsize", "w",
+		xact.IMAGE_UPLOAD_DEF_MAX_WS,
+		"Set the maximum size for the window of outstanding chunks in transit")
+	deployCmd.Flags().BoolVar(&noResume, "noresume", false,
+		"Don't resume an interrupted upload of the same image; start over")
+	deployCmd.Flags().Float64Var(&deployBootTimeout, "boot-timeout",
+		xact.IMAGE_DEPLOY_DEF_BOOT_TIMEOUT.Seconds(),
+		"Seconds to wait for the device to come back after a reset")
+	deployCmd.Flags().BoolVar(&deployNoConfirm, "noconfirm", false,
+		"Leave the new image running unconfirmed")
+	imageCmd.AddCommand(deployCmd)
+
 	coreListCmd := &cobra.Command{
 		Use:     "corelist -c <conn_profile>",
 		Short:   "List core(s) on a device",
@@ -445,6 +1127,9 @@ func imageCmd() *cobra.Command {
 	coreDownloadCmd.Flags().Uint32Var(&coreOffset, "offset", 0, "Start offset")
 	coreDownloadCmd.Flags().Uint32VarP(&coreNumBytes, "bytes", "n", 0,
 		"Number of bytes of the core to download")
+	coreDownloadCmd.Flags().IntVarP(&maxWinSz,
+		"maxwinsize", "w", xact.PIPELINE_DEF_MAX_WS,
+		"Set the maximum number of outstanding requests in transit")
 	imageCmd.AddCommand(coreDownloadCmd)
 
 	coreEraseEx := "  " + nmutil.ToolInfo.ExeName +
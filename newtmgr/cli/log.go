@@ -25,6 +25,7 @@ import (
 	"fmt"
 	"sort"
 	"strconv"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -37,6 +38,8 @@ import (
 )
 
 var optLogShowFull bool
+var optLogFollow bool
+var optLogFollowInterval float64
 
 // Converts the provided CBOR map to a JSON string.
 func logCborMsgText(cborMap []byte) (string, error) {
@@ -53,11 +56,102 @@ func logCborMsgText(cborMap []byte) (string, error) {
 	return string(msg), nil
 }
 
+type logEntryOut struct {
+	Index      uint32      `json:"index"`
+	Timestamp  int64       `json:"timestamp"`
+	Module     uint8       `json:"module"`
+	ModuleName string      `json:"module_name"`
+	Level      uint8       `json:"level"`
+	LevelName  string      `json:"level_name"`
+	Type       string      `json:"type"`
+	ImageHash  string      `json:"image_hash"`
+	Msg        string      `json:"msg"`
+	Cbor       interface{} `json:"cbor,omitempty"`
+}
+
+type logOut struct {
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Entries []logEntryOut `json:"entries"`
+}
+
+type logFollowOut struct {
+	Log   string      `json:"log"`
+	Entry logEntryOut `json:"entry"`
+}
+
+type logShowOut struct {
+	NextIndex uint32    `json:"next_index"`
+	Logs      []*logOut `json:"logs"`
+}
+
+// String entries carry their text in msg; other entries carry their body as
+// hex.  CBOR entries that decode successfully also carry the decoded body.
+func logEntryOutput(entry nmp.LogEntry) logEntryOut {
+	out := logEntryOut{
+		Index:      entry.Index,
+		Timestamp:  entry.Timestamp,
+		Module:     entry.Module,
+		ModuleName: logModuleName(entry),
+		Level:      entry.Level,
+		LevelName:  logLevelName(entry),
+		Type:       entry.Type.String(),
+		ImageHash:  hex.EncodeToString(entry.ImgHash),
+	}
+
+	if entry.Type == nmp.LOG_ENTRY_TYPE_STRING {
+		out.Msg = string(entry.Msg)
+	} else {
+		out.Msg = hex.EncodeToString(entry.Msg)
+	}
+
+	if entry.Type == nmp.LOG_ENTRY_TYPE_CBOR {
+		if m, err := nmxutil.DecodeCborMap(entry.Msg); err == nil {
+			out.Cbor = nmxutil.CborToJson(m)
+		}
+	}
+
+	return out
+}
+
+// Combines a sequence of log show responses into a single result; entries
+// from successive responses are appended to their logs.
+func logShowOutput(rsps []*nmp.LogShowRsp) *logShowOut {
+	out := &logShowOut{
+		Logs: []*logOut{},
+	}
+
+	byName := map[string]*logOut{}
+	for _, rsp := range rsps {
+		out.NextIndex = rsp.NextIndex
+
+		for _, log := range rsp.Logs {
+			lo := byName[log.Name]
+			if lo == nil {
+				lo = &logOut{
+					Name:    log.Name,
+					Type:    nmp.LogTypeToString(log.Type),
+					Entries: []logEntryOut{},
+				}
+				byName[log.Name] = lo
+				out.Logs = append(out.Logs, lo)
+			}
+
+			for _, entry := range log.Entries {
+				lo.Entries = append(lo.Entries, logEntryOutput(entry))
+			}
+		}
+	}
+
+	return out
+}
+
 type logShowCfg struct {
 	Name      string
 	Last      bool
 	Index     uint32
 	Timestamp int64
+	Filter    *xact.LogFilter
 }
 
 func logShowParseArgs(args []string) (*logShowCfg, error) {
@@ -117,9 +211,9 @@ func printLogShowRsp(rsp *nmp.LogShowRsp, printHdr bool) {
 
 		for _, entry := range log.Entries {
 			modText := fmt.Sprintf("%s (%d)",
-				nmp.LogModuleToString(int(entry.Module)), entry.Module)
+				logModuleName(entry), entry.Module)
 			levText := fmt.Sprintf("%s (%d)",
-				nmp.LogLevelToString(int(entry.Level)), entry.Level)
+				logLevelName(entry), entry.Level)
 
 			var err error
 			msgText := ""
@@ -167,18 +261,169 @@ func logShowFullCmd(s sesn.Sesn, cfg *logShowCfg) error {
 
 	c.Name = cfg.Name
 	c.Index = cfg.Index
-
-	first := true
-	c.ProgressCb = func(_ *xact.LogShowFullCmd, rsp *nmp.LogShowRsp) {
-		printLogShowRsp(rsp, first)
-		first = false
+	c.MaxWinSz = maxWinSz
+	c.Filter = cfg.Filter
+
+	if !structuredOutput() {
+		first := true
+		c.ProgressCb = func(_ *xact.LogShowFullCmd, rsp *nmp.LogShowRsp) {
+			if logExp != nil {
+				logExportRsp(rsp)
+			} else {
+				printLogShowRsp(rsp, first)
+				first = false
+			}
+		}
 	}
 
-	_, err := c.Run(s)
+	res, err := c.Run(s)
 	if err != nil {
 		return err
 	}
 
+	if logExp != nil {
+		if err := xact.StatusError(res); err != nil {
+			return util.ChildNewtError(err)
+		}
+	}
+
+	if structuredOutput() {
+		sres := res.(*xact.LogShowFullResult)
+		renderResult(sres, logShowOutput(sres.Rsps))
+	}
+
+	return nil
+}
+
+// Prints the entries of a followed log as they arrive.  A header is printed
+// whenever the entries switch to a different log.
+type logFollowPrinter struct {
+	lastName string
+}
+
+func (p *logFollowPrinter) print(rsp *nmp.LogShowRsp) {
+	if logExp != nil {
+		logExportRsp(rsp)
+		return
+	}
+
+	for _, log := range rsp.Logs {
+		if len(log.Entries) == 0 {
+			continue
+		}
+
+		if structuredOutput() {
+			for _, entry := range log.Entries {
+				render(&logFollowOut{
+					Log:   log.Name,
+					Entry: logEntryOutput(entry),
+				})
+			}
+			continue
+		}
+
+		one := &nmp.LogShowRsp{Logs: []nmp.LogShowLog{log}}
+		printLogShowRsp(one, log.Name != p.lastName)
+		p.lastName = log.Name
+	}
+}
+
+// Returns the index following the newest entry in rsp, or the device's next
+// index if rsp contains no entries.
+func logNextIndex(rsp *nmp.LogShowRsp) uint32 {
+	found := false
+	var next uint32
+	for _, log := range rsp.Logs {
+		for _, entry := range log.Entries {
+			if !found || entry.Index >= next {
+				next = entry.Index + 1
+				found = true
+			}
+		}
+	}
+
+	if !found {
+		return rsp.NextIndex
+	}
+	return next
+}
+
+func logShowFollowCmd(s sesn.Sesn, cfg *logShowCfg) error {
+	if cfg.Timestamp > 0 {
+		return util.NewNewtError(
+			"min-timestamp cannot be specified with --follow")
+	}
+	if optLogFollowInterval <= 0 {
+		return util.FmtNewtError("Invalid interval: %g; must be positive",
+			optLogFollowInterval)
+	}
+
+	outputStream = true
+	p := &logFollowPrinter{}
+
+	c := xact.NewLogFollowCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+	c.Name = cfg.Name
+	c.Index = cfg.Index
+	c.MaxWinSz = maxWinSz
+	c.Interval = time.Duration(optLogFollowInterval * float64(time.Second))
+	c.Filter = cfg.Filter
+
+	if cfg.Timestamp == -1 {
+		// "last": show the newest entry, then follow from there.
+		lc := xact.NewLogShowCmd()
+		lc.SetTxOptions(nmutil.TxOptions())
+		lc.Name = cfg.Name
+		lc.Timestamp = -1
+
+		res, err := lc.Run(s)
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+
+		lres := res.(*xact.LogShowResult)
+		if err := xact.StatusError(lres); err != nil {
+			return util.ChildNewtError(err)
+		}
+
+		c.Index = logNextIndex(lres.Rsp)
+		if cfg.Filter != nil {
+			cfg.Filter.Apply(lres.Rsp)
+		}
+		p.print(lres.Rsp)
+	}
+
+	lastErr := ""
+	c.ProgressCb = func(_ *xact.LogFollowCmd, rsp *nmp.LogShowRsp) {
+		lastErr = ""
+		p.print(rsp)
+	}
+	c.ResetCb = func(_ *xact.LogFollowCmd, last uint32, next uint32) {
+		printWarning("log index went back from %d to %d; the log was "+
+			"cleared or the device reset; reading from the start",
+			last, next)
+	}
+	c.ErrCb = func(_ *xact.LogFollowCmd, err error) {
+		// Don't repeat the same failure on every poll.
+		if err.Error() != lastErr {
+			lastErr = err.Error()
+			printWarning("%s; retrying", lastErr)
+		}
+	}
+
+	res, err := c.Run(s)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+
+	fres := res.(*xact.LogFollowResult)
+	if structuredOutput() {
+		renderResult(fres, nil)
+	} else {
+		fmt.Fprintf(infoWriter(), "Error: %s\n", xact.StatusError(fres))
+	}
+	NmExit(1)
+
 	return nil
 }
 
@@ -189,6 +434,7 @@ func logShowPartialCmd(s sesn.Sesn, cfg *logShowCfg) error {
 	c.Name = cfg.Name
 	c.Index = cfg.Index
 	c.Timestamp = cfg.Timestamp
+	c.Filter = cfg.Filter
 
 	res, err := c.Run(s)
 	if err != nil {
@@ -196,7 +442,24 @@ func logShowPartialCmd(s sesn.Sesn, cfg *logShowCfg) error {
 	}
 
 	sres := res.(*xact.LogShowResult)
-	fmt.Printf("Status: %d\n", sres.Status())
+	if structuredOutput() {
+		renderResult(sres, logShowOutput([]*nmp.LogShowRsp{sres.Rsp}))
+		return nil
+	}
+
+	if logExp != nil {
+		if err := xact.StatusError(sres); err != nil {
+			return util.ChildNewtError(err)
+		}
+		logExportRsp(sres.Rsp)
+		return nil
+	}
+
+	status := "ok"
+	if err := xact.StatusError(sres); err != nil {
+		status = err.Error()
+	}
+	fmt.Printf("Status: %s\n", status)
 	fmt.Printf("Next index: %d\n", sres.Rsp.NextIndex)
 	if len(sres.Rsp.Logs) == 0 {
 		fmt.Printf("(no logs retrieved)\n")
@@ -213,12 +476,25 @@ func logShowCmd(cmd *cobra.Command, args []string) {
 		nmUsage(cmd, err)
 	}
 
+	if err := logExportInit(); err != nil {
+		nmUsage(cmd, err)
+	}
+
 	s, err := GetSesn()
 	if err != nil {
 		nmUsage(nil, err)
 	}
 
-	if optLogShowFull {
+	logNamesInit(s)
+
+	cfg.Filter, err = logShowFilter()
+	if err != nil {
+		nmUsage(cmd, err)
+	}
+
+	if optLogFollow {
+		err = logShowFollowCmd(s, cfg)
+	} else if optLogShowFull {
 		err = logShowFullCmd(s, cfg)
 	} else {
 		err = logShowPartialCmd(s, cfg)
@@ -226,6 +502,8 @@ func logShowCmd(cmd *cobra.Command, args []string) {
 	if err != nil {
 		nmUsage(nil, err)
 	}
+
+	CloseLogExport()
 }
 
 func logListCmd(cmd *cobra.Command, args []string) {
@@ -243,8 +521,17 @@ func logListCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.LogListResult)
+	if structuredOutput() {
+		logs := append([]string{}, sres.Rsp.List...)
+		sort.Strings(logs)
+		renderResult(sres, map[string]interface{}{
+			"logs": logs,
+		})
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("error: %s\n", nmp.RspError(sres.Rsp))
 		return
 	}
 
@@ -271,8 +558,19 @@ func logModuleListCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.LogModuleListResult)
+	if structuredOutput() {
+		modules := sres.Rsp.Map
+		if modules == nil {
+			modules = map[string]int{}
+		}
+		renderResult(sres, map[string]interface{}{
+			"modules": modules,
+		})
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("error: %s\n", nmp.RspError(sres.Rsp))
 		return
 	}
 
@@ -303,8 +601,19 @@ func logLevelListCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.LogLevelListResult)
+	if structuredOutput() {
+		levels := sres.Rsp.Map
+		if levels == nil {
+			levels = map[string]int{}
+		}
+		renderResult(sres, map[string]interface{}{
+			"levels": levels,
+		})
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("error: %s\n", nmp.RspError(sres.Rsp))
 		return
 	}
 
@@ -337,8 +646,13 @@ func logClearCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.LogClearResult)
+	if structuredOutput() {
+		renderResult(sres, nil)
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("error: %s\n", nmp.RspError(sres.Rsp))
 		return
 	}
 
@@ -359,12 +673,19 @@ func logCmd() *cobra.Command {
 	logShowHelpText += "- min-index specifies to only display the log entries with an index value equal to or higher than min-index.  "
 	logShowHelpText += "If \"last\"  is specified for min-index, the last\nlog entry is displayed.\n\n"
 	logShowHelpText += "- min-timestamp specifies to only display the log entries with a timestamp\nequal to or later than min-timestamp. Log entries with a timestamp equal to\nmin-timestamp are only displayed if the entry index is equal to or higher than min-index.\n"
+	logShowHelpText += "\nWith --follow, the log is read from min-index and then polled for new entries\nuntil newtmgr is interrupted.  If the log is cleared or the device resets, the\nlog is read again from the start, skipping entries that were already shown.\n"
+	logShowHelpText += "\nThe --module, --level, --since, --until, --grep, and --regex flags further\nfilter the entries; newtmgr still reads every entry from the device.  Modules\nand levels can be given by name or number.\n"
+	logShowHelpText += "\nWith --export, each entry is written as a JSON Lines, CSV, or RFC 5424 syslog\nrecord for log aggregation tools instead of being displayed.\n"
 
 	logShowEx := nmutil.ToolInfo.ExeName + " log show -c myserial\n"
 	logShowEx += nmutil.ToolInfo.ExeName + " log show reboot_log -c myserial\n"
 	logShowEx += nmutil.ToolInfo.ExeName + " log show reboot_log last -c myserial\n"
 	logShowEx += nmutil.ToolInfo.ExeName + " log show reboot_log 5 -c myserial\n"
 	logShowEx += nmutil.ToolInfo.ExeName + " log show reboot_log 3 1122222 -c myserial\n"
+	logShowEx += nmutil.ToolInfo.ExeName + " log show log --follow -c myserial\n"
+	logShowEx += nmutil.ToolInfo.ExeName + " log show log -a --module REBOOT --level warn -c myserial\n"
+	logShowEx += nmutil.ToolInfo.ExeName + " log show log -a --since 2024-01-02T15:00:00Z --grep timeout -c myserial\n"
+	logShowEx += nmutil.ToolInfo.ExeName + " log show log -a --export csv --export-file log.csv -c myserial\n"
 
 	showCmd := &cobra.Command{
 		Use:     "show [log-name [min-index [min-timestamp]]] -c <conn_profile>",
@@ -374,6 +695,32 @@ func logCmd() *cobra.Command {
 		Run:     logShowCmd,
 	}
 	showCmd.PersistentFlags().BoolVarP(&optLogShowFull, "all", "a", false, "read until end of log")
+	showCmd.PersistentFlags().IntVarP(&maxWinSz, "maxwinsize", "w",
+		xact.PIPELINE_DEF_MAX_WS,
+		"maximum number of outstanding requests in transit when reading a full log")
+	showCmd.PersistentFlags().BoolVarP(&optLogFollow, "follow", "f", false,
+		"keep polling for new entries until interrupted")
+	showCmd.PersistentFlags().Float64Var(&optLogFollowInterval, "interval",
+		xact.LOG_FOLLOW_DEF_INTERVAL.Seconds(),
+		"seconds between polls when following a log")
+	showCmd.PersistentFlags().StringVarP(&optLogExport, "export", "x", "",
+		"write entries as records of this format: jsonl, csv, or syslog")
+	showCmd.PersistentFlags().StringVar(&optLogExportFile, "export-file", "",
+		"append exported records to this file instead of writing them to "+
+			"stdout")
+	showCmd.PersistentFlags().StringArrayVar(&optLogModules, "module", nil,
+		"only show entries from this module (name or number); may be "+
+			"repeated or comma separated")
+	showCmd.PersistentFlags().StringVar(&optLogLevel, "level", "",
+		"only show entries of this level (name or number) or higher")
+	showCmd.PersistentFlags().StringVar(&optLogSince, "since", "",
+		"only show entries at or after this time (microseconds or RFC 3339)")
+	showCmd.PersistentFlags().StringVar(&optLogUntil, "until", "",
+		"only show entries at or before this time (microseconds or RFC 3339)")
+	showCmd.PersistentFlags().StringVar(&optLogGrep, "grep", "",
+		"only show entries whose message contains this text")
+	showCmd.PersistentFlags().StringVar(&optLogRegex, "regex", "",
+		"only show entries whose message matches this regular expression")
 	logCmd.AddCommand(showCmd)
 
 	clearCmd := &cobra.Command{
@@ -0,0 +1,460 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"mynewt.apache.org/newt/util"
+)
+
+// Log export.
+//
+// `log show --export <format>` writes each log entry as a record of a format
+// that log aggregation tools ingest, instead of printing a table.  Every
+// record carries the entry's log, index, device timestamp and its wall-clock
+// time, module and level (number and name), type, image hash, and message.
+// The message of a CBOR entry is its decoded body as JSON.
+
+const (
+	LOG_EXPORT_JSONL  = "jsonl"
+	LOG_EXPORT_CSV    = "csv"
+	LOG_EXPORT_SYSLOG = "syslog"
+)
+
+var logExportFormats = []string{
+	LOG_EXPORT_JSONL,
+	LOG_EXPORT_CSV,
+	LOG_EXPORT_SYSLOG,
+}
+
+// Structured data ID of syslog records.  32473 is the private enterprise
+// number reserved for documentation (RFC 5612).
+const LOG_SYSLOG_SD_ID = "newtmgr@32473"
+
+// Syslog facility of exported records: user-level messages.
+const LOG_SYSLOG_FACILITY = 1
+
+var logCsvHdr = []string{
+	"log", "index", "timestamp", "time", "module", "module_name", "level",
+	"level_name", "type", "image_hash", "msg",
+}
+
+type logRecord struct {
+	Log        string      `json:"log"`
+	Index      uint32      `json:"index"`
+	Timestamp  int64       `json:"timestamp"`
+	Time       string      `json:"time"`
+	Module     uint8       `json:"module"`
+	ModuleName string      `json:"module_name"`
+	Level      uint8       `json:"level"`
+	LevelName  string      `json:"level_name"`
+	Type       string      `json:"type"`
+	ImageHash  string      `json:"image_hash"`
+	Msg        string      `json:"msg"`
+	Cbor       interface{} `json:"cbor,omitempty"`
+
+	time time.Time
+}
+
+type logExporter interface {
+	export(rec *logRecord) error
+
+	// Writes any buffered records.
+	flush() error
+}
+
+// Converts a device timestamp, in microseconds since the Unix epoch, to
+// wall-clock time.  A device whose clock was never set counts from 1970.
+func logTimestampTime(ts int64) time.Time {
+	return time.Unix(ts/1000000, (ts%1000000)*1000).UTC()
+}
+
+func newLogRecord(log *nmp.LogShowLog, entry nmp.LogEntry) *logRecord {
+	rec := &logRecord{
+		Log:        log.Name,
+		Index:      entry.Index,
+		Timestamp:  entry.Timestamp,
+		Module:     entry.Module,
+		ModuleName: logModuleName(entry),
+		Level:      entry.Level,
+		LevelName:  logLevelName(entry),
+		Type:       entry.Type.String(),
+		ImageHash:  hex.EncodeToString(entry.ImgHash),
+		time:       logTimestampTime(entry.Timestamp),
+	}
+	rec.Time = rec.time.Format(time.RFC3339Nano)
+
+	switch entry.Type {
+	case nmp.LOG_ENTRY_TYPE_STRING:
+		rec.Msg = string(entry.Msg)
+
+	case nmp.LOG_ENTRY_TYPE_CBOR:
+		m, err := nmxutil.DecodeCborMap(entry.Msg)
+		if err != nil {
+			rec.Msg = hex.EncodeToString(entry.Msg)
+			break
+		}
+		rec.Cbor = nmxutil.CborToJson(m)
+
+		b, err := json.Marshal(rec.Cbor)
+		if err != nil {
+			rec.Msg = hex.EncodeToString(entry.Msg)
+		} else {
+			rec.Msg = string(b)
+		}
+
+	default:
+		rec.Msg = hex.EncodeToString(entry.Msg)
+	}
+
+	return rec
+}
+
+func logExportFormatCheck(format string) error {
+	for _, f := range logExportFormats {
+		if format == f {
+			return nil
+		}
+	}
+
+	return util.FmtNewtError("Invalid export format \"%s\"; must be one of: %s",
+		format, strings.Join(logExportFormats, ", "))
+}
+
+// Creates an exporter that writes records to w.  hdr indicates whether the
+// output is new, i.e., whether a CSV header should be written.  host names
+// the device in syslog records.
+func newLogExporter(format string, w io.Writer, hdr bool,
+	host string) (logExporter, error) {
+
+	switch format {
+	case LOG_EXPORT_JSONL:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return &logJsonlExporter{enc: enc}, nil
+
+	case LOG_EXPORT_CSV:
+		e := &logCsvExporter{w: csv.NewWriter(w)}
+		if hdr {
+			if err := e.w.Write(logCsvHdr); err != nil {
+				return nil, err
+			}
+		}
+		return e, nil
+
+	case LOG_EXPORT_SYSLOG:
+		return &logSyslogExporter{w: w, host: syslogName(host, 255)}, nil
+
+	default:
+		return nil, logExportFormatCheck(format)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $jsonl                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+type logJsonlExporter struct {
+	enc *json.Encoder
+}
+
+func (e *logJsonlExporter) export(rec *logRecord) error {
+	return e.enc.Encode(rec)
+}
+
+func (e *logJsonlExporter) flush() error {
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $csv                                                                     //
+//////////////////////////////////////////////////////////////////////////////
+
+type logCsvExporter struct {
+	w *csv.Writer
+}
+
+func (e *logCsvExporter) export(rec *logRecord) error {
+	return e.w.Write([]string{
+		rec.Log,
+		strconv.FormatUint(uint64(rec.Index), 10),
+		strconv.FormatInt(rec.Timestamp, 10),
+		rec.Time,
+		strconv.Itoa(int(rec.Module)),
+		rec.ModuleName,
+		strconv.Itoa(int(rec.Level)),
+		rec.LevelName,
+		rec.Type,
+		rec.ImageHash,
+		rec.Msg,
+	})
+}
+
+func (e *logCsvExporter) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $syslog                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+// Records are written one per line in the RFC 5424 format:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+//
+// APP-NAME is the entry's module name and MSGID is the log name.  The
+// structured data element carries the remaining fields of the record.
+type logSyslogExporter struct {
+	w    io.Writer
+	host string
+}
+
+// Maps Mynewt log levels to syslog severities.
+func syslogSeverity(level uint8) int {
+	switch int(level) {
+	case nmp.LEVEL_DEBUG:
+		return 7
+	case nmp.LEVEL_INFO:
+		return 6
+	case nmp.LEVEL_WARN:
+		return 4
+	case nmp.LEVEL_ERROR:
+		return 3
+	case nmp.LEVEL_CRITICAL:
+		return 2
+	default:
+		return 5
+	}
+}
+
+// Converts s to a syslog header field: printable ASCII without spaces, at
+// most maxLen characters.  An empty field is written as "-".
+func syslogName(s string, maxLen int) string {
+	b := []byte{}
+	for i := 0; i < len(s) && len(b) < maxLen; i++ {
+		c := s[i]
+		if c <= ' ' || c > '~' {
+			c = '_'
+		}
+		b = append(b, c)
+	}
+
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+var syslogParamEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	`]`, `\]`,
+)
+
+var syslogMsgEscaper = strings.NewReplacer(
+	"\r\n", " ",
+	"\n", " ",
+	"\r", " ",
+)
+
+func (e *logSyslogExporter) export(rec *logRecord) error {
+	params := []struct {
+		name string
+		val  string
+	}{
+		{"log", rec.Log},
+		{"index", strconv.FormatUint(uint64(rec.Index), 10)},
+		{"ts", strconv.FormatInt(rec.Timestamp, 10)},
+		{"module", strconv.Itoa(int(rec.Module))},
+		{"level", strconv.Itoa(int(rec.Level))},
+		{"level_name", rec.LevelName},
+		{"type", rec.Type},
+		{"imghash", rec.ImageHash},
+	}
+
+	sd := "[" + LOG_SYSLOG_SD_ID
+	for _, p := range params {
+		sd += fmt.Sprintf(` %s="%s"`, p.name, syslogParamEscaper.Replace(p.val))
+	}
+	sd += "]"
+
+	msg := strings.TrimRight(rec.Msg, "\r\n")
+
+	_, err := fmt.Fprintf(e.w, "<%d>1 %s %s %s - %s %s %s\n",
+		LOG_SYSLOG_FACILITY*8+syslogSeverity(rec.Level),
+		rec.time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		e.host,
+		syslogName(rec.ModuleName, 48),
+		syslogName(rec.Log, 32),
+		sd,
+		syslogMsgEscaper.Replace(msg))
+	return err
+}
+
+func (e *logSyslogExporter) flush() error {
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $cli                                                                     //
+//////////////////////////////////////////////////////////////////////////////
+
+var optLogExport string
+var optLogExportFile string
+
+// The exporter of the running `log show` command; nil if entries are
+// printed.
+var logExp logExporter
+
+// The file records are exported to; nil if they are written to stdout.
+var logExpFile *os.File
+
+// Serializes exports with CloseLogExport, which may run when newtmgr is
+// interrupted.  Records are dropped once the export is closed.
+var logExpMtx sync.Mutex
+var logExpClosed bool
+
+// Sets up the exporter requested on the command line, if any.
+func logExportInit() error {
+	if optLogExport == "" {
+		if optLogExportFile != "" {
+			return util.NewNewtError("--export-file requires --export")
+		}
+		return nil
+	}
+
+	if err := logExportFormatCheck(optLogExport); err != nil {
+		return err
+	}
+	if structuredOutput() {
+		return util.NewNewtError(
+			"--export cannot be combined with --output json or yaml")
+	}
+
+	var w io.Writer = os.Stdout
+	hdr := true
+
+	if optLogExportFile != "" {
+		f, err := os.OpenFile(optLogExportFile,
+			os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return util.ChildNewtError(err)
+		}
+		if fi, err := f.Stat(); err == nil && fi.Size() > 0 {
+			hdr = false
+		}
+		w = f
+		logExpFile = f
+	}
+
+	host, err := logExportHost()
+	if err != nil {
+		return err
+	}
+
+	logExp, err = newLogExporter(optLogExport, w, hdr, host)
+	if err != nil {
+		return util.ChildNewtError(err)
+	}
+	outputRecords = optLogExportFile == ""
+
+	return nil
+}
+
+// Names the device in syslog records: the BLE device name, or else the
+// connection profile name.
+func logExportHost() (string, error) {
+	if nmutil.DeviceName != "" {
+		return nmutil.DeviceName, nil
+	}
+
+	cp, err := getConnProfile()
+	if err != nil {
+		return "", err
+	}
+	return cp.Name, nil
+}
+
+// Exports the entries in a log show response.
+func logExportRsp(rsp *nmp.LogShowRsp) {
+	// Exit only after releasing the lock; exiting closes the export.
+	if err := logExportEntries(rsp); err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+}
+
+func logExportEntries(rsp *nmp.LogShowRsp) error {
+	logExpMtx.Lock()
+	defer logExpMtx.Unlock()
+
+	if logExpClosed {
+		return nil
+	}
+
+	for i, _ := range rsp.Logs {
+		log := &rsp.Logs[i]
+		for _, entry := range log.Entries {
+			if err := logExp.export(newLogRecord(log, entry)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return logExp.flush()
+}
+
+// Flushes the exporter and closes the export file, if any.  This is called
+// when `log show` finishes and when newtmgr exits.
+func CloseLogExport() {
+	logExpMtx.Lock()
+	defer logExpMtx.Unlock()
+
+	if logExp == nil || logExpClosed {
+		return
+	}
+	logExpClosed = true
+
+	if err := logExp.flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: failed to flush log export: %s\n",
+			err.Error())
+	}
+
+	if logExpFile != nil {
+		if err := logExpFile.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to close %s: %s\n",
+				logExpFile.Name(), err.Error())
+		}
+		logExpFile = nil
+	}
+}
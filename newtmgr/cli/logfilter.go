@@ -0,0 +1,185 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/xact"
+	"mynewt.apache.org/newt/util"
+)
+
+// Log filters.
+//
+// `log show` can discard entries that are not of interest before they are
+// displayed or exported: by module, minimum level, timestamp range, and
+// message text.  The filtering happens in newtmgr; the device still sends
+// every entry.
+
+var optLogModules []string
+var optLogLevel string
+var optLogSince string
+var optLogUntil string
+var optLogGrep string
+var optLogRegex string
+
+// Converts a module or level, given as a number or a name, to its number.
+// Names are looked up case-insensitively in the device's map, then in
+// nmp's.
+func logParseId(kind string, val string, devMap map[string]int,
+	builtin map[int]string) (uint8, error) {
+
+	if n, err := strconv.ParseUint(val, 0, 8); err == nil {
+		return uint8(n), nil
+	}
+
+	for name, id := range devMap {
+		if strings.EqualFold(name, val) && id >= 0 && id <= 255 {
+			return uint8(id), nil
+		}
+	}
+
+	for id, name := range builtin {
+		if strings.EqualFold(name, val) {
+			return uint8(id), nil
+		}
+	}
+
+	nameSet := map[string]struct{}{}
+	for _, name := range builtin {
+		nameSet[name] = struct{}{}
+	}
+	for name, _ := range devMap {
+		nameSet[name] = struct{}{}
+	}
+
+	names := make([]string, 0, len(nameSet))
+	for name, _ := range nameSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return 0, util.FmtNewtError("Unknown log %s \"%s\"; must be a number "+
+		"or one of: %s", kind, val, strings.Join(names, ", "))
+}
+
+// Parses a time range bound: a timestamp in microseconds, or an RFC 3339
+// time.
+func logParseTime(flag string, val string) (int64, error) {
+	if ts, err := strconv.ParseInt(val, 0, 64); err == nil {
+		return ts, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, val)
+	if err != nil {
+		return 0, util.FmtNewtError("Invalid %s time \"%s\"; must be a "+
+			"timestamp in microseconds or an RFC 3339 time, e.g., %s",
+			flag, val, "2006-01-02T15:04:05Z")
+	}
+
+	// Avoid UnixNano(); it overflows for times after the year 2262.
+	return t.Unix()*1000000 + int64(t.Nanosecond()/1000), nil
+}
+
+// Builds the filter specified on the command line.  It returns nil if no
+// filter flags were specified.  Module and level names are resolved with the
+// running image's names; see logNamesInit.
+func logShowFilter() (*xact.LogFilter, error) {
+	if len(optLogModules) == 0 && optLogLevel == "" && optLogSince == "" &&
+		optLogUntil == "" && optLogGrep == "" && optLogRegex == "" {
+
+		return nil, nil
+	}
+
+	f := &xact.LogFilter{}
+
+	var devModules map[string]int
+	var devLevels map[string]int
+	if logDevNames != nil && logDevNames.running != nil {
+		devModules = logDevNames.running.Modules
+		devLevels = logDevNames.running.Levels
+	}
+
+	for _, arg := range optLogModules {
+		for _, val := range strings.Split(arg, ",") {
+			val = strings.TrimSpace(val)
+			if val == "" {
+				continue
+			}
+
+			m, err := logParseId("module", val, devModules,
+				nmp.LogModuleNameMap)
+			if err != nil {
+				return nil, err
+			}
+			f.Modules = append(f.Modules, m)
+		}
+	}
+
+	if optLogLevel != "" {
+		l, err := logParseId("level", optLogLevel, devLevels,
+			nmp.LogLevelNameMap)
+		if err != nil {
+			return nil, err
+		}
+		f.MinLevel = l
+	}
+
+	if optLogSince != "" {
+		ts, err := logParseTime("--since", optLogSince)
+		if err != nil {
+			return nil, err
+		}
+		f.MinTimestamp = ts
+	}
+	if optLogUntil != "" {
+		ts, err := logParseTime("--until", optLogUntil)
+		if err != nil {
+			return nil, err
+		}
+		f.MaxTimestamp = ts
+	}
+	if f.MinTimestamp != 0 && f.MaxTimestamp != 0 &&
+		f.MaxTimestamp < f.MinTimestamp {
+
+		return nil, util.NewNewtError("--until is earlier than --since")
+	}
+
+	if optLogGrep != "" && optLogRegex != "" {
+		return nil, util.NewNewtError(
+			"--grep and --regex cannot be combined")
+	}
+	if optLogGrep != "" {
+		f.Msg = regexp.MustCompile(regexp.QuoteMeta(optLogGrep))
+	}
+	if optLogRegex != "" {
+		re, err := regexp.Compile(optLogRegex)
+		if err != nil {
+			return nil, util.FmtNewtError("Invalid regex: %s", err.Error())
+		}
+		f.Msg = re
+	}
+
+	return f, nil
+}
@@ -0,0 +1,152 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package cli
+
+import (
+	"encoding/hex"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+	"github.com/recogni/newtmgr/nmxact/xact"
+)
+
+// The log module and level names of the target device.  Log entries are
+// displayed, exported, and filtered with the names that the device reports,
+// rather than just the few that nmp knows.  The names are cached per device
+// and image, so they are only retrieved the first time an image's logs are
+// read.  Entries written by another image use that image's names if they
+// are cached, and the running image's names otherwise.
+type logNameCache struct {
+	store  *xact.LogNameStore
+	device string
+
+	// Hash of the running image in hex, and the names it reports.
+	hash    string
+	running *xact.LogNames
+
+	// Names resolved so far, keyed by entry image hash.
+	byHash map[string]*xact.LogNames
+}
+
+// nil until logNamesInit is called; nmp's names are used until then.
+var logDevNames *logNameCache
+
+// Returns the hash of the running image, in hex.
+func logRunningImageHash(s sesn.Sesn) (string, error) {
+	c := xact.NewImageStateReadCmd()
+	c.SetTxOptions(nmutil.TxOptions())
+
+	res, err := c.Run(s)
+	if err != nil {
+		return "", err
+	}
+	if err := xact.StatusError(res); err != nil {
+		return "", err
+	}
+
+	for _, img := range res.(*xact.ImageStateReadResult).Rsp.Images {
+		if img.Image == 0 && img.Active {
+			return hex.EncodeToString(img.Hash), nil
+		}
+	}
+
+	return "", nil
+}
+
+// Determines the names of the device's log modules and levels, from the
+// cache if possible.  This is best effort: if the device cannot report its
+// names, nmp's names are used.
+func logNamesInit(s sesn.Sesn) {
+	c := &logNameCache{
+		store:  logNameStore(),
+		byHash: map[string]*xact.LogNames{},
+	}
+	logDevNames = c
+
+	device, err := deviceId()
+	if err != nil {
+		c.store = nil
+	}
+	c.device = device
+
+	c.hash, err = logRunningImageHash(s)
+	if err != nil {
+		log.Debugf("Cannot determine running image: %s", err.Error())
+	}
+
+	if c.store != nil && c.hash != "" {
+		c.running, err = c.store.Load(c.device, c.hash)
+		if err != nil {
+			log.Debugf("Cannot read cached log names: %s", err.Error())
+		}
+		if c.running != nil {
+			return
+		}
+	}
+
+	c.running, err = xact.FetchLogNames(s, nmutil.TxOptions())
+	if err != nil {
+		log.Debugf("Cannot retrieve log names: %s", err.Error())
+		return
+	}
+
+	if c.store != nil && c.hash != "" {
+		if err := c.store.Save(c.device, c.hash, c.running); err != nil {
+			log.Warnf("Failed to cache log names: %s", err.Error())
+		}
+	}
+}
+
+// Returns the names that apply to entries written by the image with the
+// specified hash.  A nil result means only nmp's names apply.
+func (c *logNameCache) names(imgHash []byte) *xact.LogNames {
+	if c == nil {
+		return nil
+	}
+
+	h := hex.EncodeToString(imgHash)
+	if n, ok := c.byHash[h]; ok {
+		return n
+	}
+
+	n := c.running
+	if h != "" && c.store != nil && !strings.HasPrefix(c.hash, h) {
+		cached, err := c.store.Load(c.device, h)
+		if err != nil {
+			log.Debugf("Cannot read cached log names: %s", err.Error())
+		} else if cached != nil {
+			n = cached
+		}
+	}
+
+	c.byHash[h] = n
+	return n
+}
+
+func logModuleName(entry nmp.LogEntry) string {
+	return logDevNames.names(entry.ImgHash).ModuleString(int(entry.Module))
+}
+
+func logLevelName(entry nmp.LogEntry) string {
+	return logDevNames.names(entry.ImgHash).LevelString(int(entry.Level))
+}
@@ -0,0 +1,429 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/xact"
+	"mynewt.apache.org/newt/util"
+)
+
+// Structured output.
+//
+// By default, commands print human-readable text.  With `--output json` or
+// `--output yaml`, a command instead prints a single document describing its
+// outcome:
+//
+//     command  The command that ran (e.g., "image list").
+//     rc       The status code reported by the device; 0 on success.
+//     error    Present if the command failed.
+//     result   The command's result; its schema depends on the command.
+//
+// The documents are an interface that scripts depend on: members may be
+// added, but existing members keep their names and meanings.  The schema of
+// each command's result is documented in
+// docs/command_list/newtmgr_output.rst.  Progress indicators are suppressed,
+// and warnings are written to stderr, so that stdout carries only the
+// document.
+
+const (
+	OUTPUT_TEXT = "text"
+	OUTPUT_JSON = "json"
+	OUTPUT_YAML = "yaml"
+)
+
+var outputFormats = []string{OUTPUT_TEXT, OUTPUT_JSON, OUTPUT_YAML}
+
+// Set when stdout carries machine-readable records other than structured
+// documents (e.g., exported log entries).
+var outputRecords bool
+
+// Set by commands that print a stream of documents (e.g., `log show
+// --follow`).  JSON documents are then printed one per line (JSON Lines);
+// YAML documents are separated by their "---" markers.
+var outputStream bool
+
+// Set once a document has been printed.  Outside of a stream, a command
+// prints at most one document.
+var outputDone bool
+
+type outputDoc struct {
+	Command string      `json:"command"`
+	Rc      int         `json:"rc"`
+	Error   string      `json:"error,omitempty"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+func outputFormatCheck(format string) error {
+	for _, f := range outputFormats {
+		if format == f {
+			return nil
+		}
+	}
+
+	return util.FmtNewtError("Invalid output format \"%s\"; must be one of: %s",
+		format, strings.Join(outputFormats, ", "))
+}
+
+// Indicates whether commands print a structured document rather than text.
+func structuredOutput() bool {
+	return nmutil.OutputFormat == OUTPUT_JSON ||
+		nmutil.OutputFormat == OUTPUT_YAML
+}
+
+// Returns the name of the command being executed, without the executable
+// name (e.g., "image list").
+func outputCmdName() string {
+	if globalCmd == nil {
+		return ""
+	}
+
+	path := globalCmd.CommandPath()
+	if i := strings.IndexByte(path, ' '); i >= 0 {
+		return path[i+1:]
+	}
+	return ""
+}
+
+// Encodes v as JSON or YAML.
+func marshalOutput(v interface{}, format string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if !outputStream {
+		enc.SetIndent("", "    ")
+	}
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+
+	if format == OUTPUT_YAML {
+		return jsonToYaml(buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+func renderDoc(doc *outputDoc) {
+	doc.Command = outputCmdName()
+
+	b, err := marshalOutput(doc, nmutil.OutputFormat)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+	os.Stdout.Write(b)
+	outputDone = true
+}
+
+// Prints an error document for a failure that ends the command (e.g., a usage
+// error or a failure to communicate with the device).  Returns false if the
+// error must be reported as text instead: in text mode, or if the command has
+// already printed its document.
+func renderUsageError(text string) bool {
+	if !structuredOutput() || (outputDone && !outputStream) {
+		return false
+	}
+
+	b, err := marshalOutput(&outputDoc{
+		Command: outputCmdName(),
+		Error:   text,
+	}, nmutil.OutputFormat)
+	if err != nil {
+		return false
+	}
+	os.Stdout.Write(b)
+	outputDone = true
+
+	return true
+}
+
+// Prints the result of a successful command.
+func render(out interface{}) {
+	renderDoc(&outputDoc{Result: out})
+}
+
+// Prints the outcome of an xact command.  If the device reported an error,
+// the document carries the status code and its description instead of out.
+func renderResult(res xact.Result, out interface{}) {
+	doc := &outputDoc{
+		Rc: res.Status(),
+	}
+
+	if err := xact.StatusError(res); err != nil {
+		doc.Error = err.Error()
+	} else {
+		doc.Result = out
+	}
+
+	renderDoc(doc)
+}
+
+// Prints a failure that the device did not report (e.g., an invalid image
+// file).  out, if not nil, describes what the command found.
+func renderError(err error, out interface{}) {
+	renderDoc(&outputDoc{
+		Error:  err.Error(),
+		Result: out,
+	})
+}
+
+// Returns the writer for informational messages that accompany a command's
+// result.  When stdout carries a structured document or other records, such
+// messages go to stderr so that they do not corrupt it.
+func infoWriter() io.Writer {
+	if structuredOutput() || outputRecords {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func printWarning(format string, args ...interface{}) {
+	fmt.Fprintf(infoWriter(), "Warning: "+format+"\n", args...)
+}
+
+// Durations are reported in milliseconds.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+type xferStatsOut struct {
+	Bytes       int     `json:"bytes"`
+	ElapsedMs   float64 `json:"elapsed_ms"`
+	Throughput  float64 `json:"throughput"`
+	Requests    int     `json:"requests"`
+	Losses      int     `json:"losses"`
+	Retransmits int     `json:"retransmits"`
+	RttMinMs    float64 `json:"rtt_min_ms"`
+	RttAvgMs    float64 `json:"rtt_avg_ms"`
+	RttMaxMs    float64 `json:"rtt_max_ms"`
+	MaxWindow   int     `json:"max_window"`
+	ChunkLimit  int     `json:"chunk_limit"`
+}
+
+func xferStatsOutput(s *xact.XferStats) *xferStatsOut {
+	return &xferStatsOut{
+		Bytes:       s.Bytes,
+		ElapsedMs:   durationMs(s.Elapsed),
+		Throughput:  s.Throughput(),
+		Requests:    s.Requests,
+		Losses:      s.Losses,
+		Retransmits: s.Retransmits,
+		RttMinMs:    durationMs(s.MinRtt),
+		RttAvgMs:    durationMs(s.AvgRtt),
+		RttMaxMs:    durationMs(s.MaxRtt),
+		MaxWindow:   s.MaxWinSz,
+		ChunkLimit:  s.ChunkLen,
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $yaml                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+// YAML documents are produced by converting the JSON encoding, which keeps
+// the two formats identical in content and preserves the order of object
+// members.  Strings are double-quoted; YAML accepts JSON's escape sequences,
+// so JSON string literals are valid YAML scalars.
+
+type yamlMember struct {
+	key string
+	val interface{}
+}
+
+type yamlObject []yamlMember
+
+var yamlPlainKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func jsonToYaml(j []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.UseNumber()
+
+	v, err := yamlReadNode(dec)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	buf.WriteString("---\n")
+	if yamlIsScalar(v) {
+		buf.WriteString(yamlScalar(v) + "\n")
+	} else {
+		yamlWriteNode(buf, v, 0, false)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func yamlReadNode(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		obj := yamlObject{}
+		for dec.More() {
+			ktok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := ktok.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid JSON object key: %v", ktok)
+			}
+
+			val, err := yamlReadNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, yamlMember{key, val})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return obj, nil
+
+	case json.Delim('['):
+		arr := []interface{}{}
+		for dec.More() {
+			val, err := yamlReadNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, val)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return arr, nil
+
+	default:
+		return tok, nil
+	}
+}
+
+// Empty collections are written in flow style, like scalars.
+func yamlIsScalar(v interface{}) bool {
+	switch t := v.(type) {
+	case yamlObject:
+		return len(t) == 0
+	case []interface{}:
+		return len(t) == 0
+	default:
+		return true
+	}
+}
+
+// JSON leaves DEL and the C1 control characters unescaped, but YAML does not
+// allow most of them to appear literally, and folds NEL (U+0085) as a line
+// break.
+var yamlNonPrintableRe = regexp.MustCompile("[\u007f-\u009f]")
+
+func yamlQuote(s string) string {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+
+	q := strings.TrimSuffix(buf.String(), "\n")
+	return yamlNonPrintableRe.ReplaceAllStringFunc(q, func(c string) string {
+		return fmt.Sprintf("\\u%04x", []rune(c)[0])
+	})
+}
+
+func yamlScalar(v interface{}) string {
+	switch t := v.(type) {
+	case yamlObject:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	case nil:
+		return "null"
+	case bool:
+		if t {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return t.String()
+	case string:
+		return yamlQuote(t)
+	default:
+		return yamlQuote(fmt.Sprintf("%v", t))
+	}
+}
+
+func yamlKey(key string) string {
+	switch strings.ToLower(key) {
+	case "true", "false", "null", "yes", "no", "on", "off", "y", "n":
+		return yamlQuote(key)
+	}
+
+	if yamlPlainKeyRe.MatchString(key) {
+		return key
+	}
+	return yamlQuote(key)
+}
+
+// Writes a collection in block style.  If inline is true, the first line
+// continues the current line (e.g., after a sequence entry's "- ").
+func yamlWriteNode(buf *bytes.Buffer, v interface{}, indent int,
+	inline bool) {
+
+	pad := strings.Repeat(" ", indent)
+
+	switch t := v.(type) {
+	case yamlObject:
+		for i, m := range t {
+			if i > 0 || !inline {
+				buf.WriteString(pad)
+			}
+			buf.WriteString(yamlKey(m.key) + ":")
+			if yamlIsScalar(m.val) {
+				buf.WriteString(" " + yamlScalar(m.val) + "\n")
+			} else {
+				buf.WriteString("\n")
+				yamlWriteNode(buf, m.val, indent+2, false)
+			}
+		}
+
+	case []interface{}:
+		for i, e := range t {
+			if i > 0 || !inline {
+				buf.WriteString(pad)
+			}
+			buf.WriteString("- ")
+			if yamlIsScalar(e) {
+				buf.WriteString(yamlScalar(e) + "\n")
+			} else {
+				yamlWriteNode(buf, e, indent+2, true)
+			}
+		}
+	}
+}
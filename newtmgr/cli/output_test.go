@@ -0,0 +1,198 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"testing"
+)
+
+func TestJsonToYaml(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		yaml string
+	}{
+		{
+			name: "scalars",
+			json: `{"s":"hi","i":-12,"f":1.5,"t":true,"f2":false,"nil":null}`,
+			yaml: "---\n" +
+				"s: \"hi\"\n" +
+				"i: -12\n" +
+				"f: 1.5\n" +
+				"t: true\n" +
+				"f2: false\n" +
+				"nil: null\n",
+		},
+		{
+			name: "top-level scalar",
+			json: `"a: b"`,
+			yaml: "---\n\"a: b\"\n",
+		},
+		{
+			name: "colon",
+			json: `{"msg":"key: value"}`,
+			yaml: "---\nmsg: \"key: value\"\n",
+		},
+		{
+			name: "hash",
+			json: `{"msg":"# not a comment","tail":"a #b"}`,
+			yaml: "---\n" +
+				"msg: \"# not a comment\"\n" +
+				"tail: \"a #b\"\n",
+		},
+		{
+			name: "newlines",
+			json: `{"msg":"line1\nline2\r\n"}`,
+			yaml: "---\nmsg: \"line1\\nline2\\r\\n\"\n",
+		},
+		{
+			name: "leading and trailing spaces",
+			json: `{"msg":"  indented ","tab":"\tx"}`,
+			yaml: "---\n" +
+				"msg: \"  indented \"\n" +
+				"tab: \"\\tx\"\n",
+		},
+		{
+			name: "quotes and backslashes",
+			json: `{"msg":"say \"hi\" \\ 'bye'"}`,
+			yaml: "---\nmsg: \"say \\\"hi\\\" \\\\ 'bye'\"\n",
+		},
+		{
+			name: "strings that look like other types",
+			json: `{"a":"true","b":"null","c":"12","d":"","e":"~","f":"- x"}`,
+			yaml: "---\n" +
+				"a: \"true\"\n" +
+				"b: \"null\"\n" +
+				"c: \"12\"\n" +
+				"d: \"\"\n" +
+				"e: \"~\"\n" +
+				"f: \"- x\"\n",
+		},
+		{
+			name: "html characters are not escaped",
+			json: `{"msg":"<a>&"}`,
+			yaml: "---\nmsg: \"<a>&\"\n",
+		},
+		{
+			name: "non-printable characters",
+			json: "{\"msg\":\"a\\u0000b\u007fc\u0085d\u0090e\"}",
+			yaml: "---\nmsg: \"a\\u0000b\\u007fc\\u0085d\\u0090e\"\n",
+		},
+		{
+			name: "keys",
+			json: `{"plain_1":1,"a b":2,"a:b":3,"#x":4,"yes":5,"No":6,` +
+				`"123":7,"":8,"-x":9}`,
+			yaml: "---\n" +
+				"plain_1: 1\n" +
+				"\"a b\": 2\n" +
+				"\"a:b\": 3\n" +
+				"\"#x\": 4\n" +
+				"\"yes\": 5\n" +
+				"\"No\": 6\n" +
+				"\"123\": 7\n" +
+				"\"\": 8\n" +
+				"\"-x\": 9\n",
+		},
+		{
+			name: "empty collections",
+			json: `{"o":{},"a":[],"m":{"o":{}}}`,
+			yaml: "---\n" +
+				"o: {}\n" +
+				"a: []\n" +
+				"m:\n" +
+				"  o: {}\n",
+		},
+		{
+			name: "member order is preserved",
+			json: `{"z":1,"a":2,"m":3}`,
+			yaml: "---\nz: 1\na: 2\nm: 3\n",
+		},
+		{
+			name: "nested collections",
+			json: `{"images":[{"slot":0,"hash":"ab","flags":["active",` +
+				`"confirmed"]},{"slot":1,"tlvs":[]}],"grid":[[1,2],[],` +
+				`[{"a":1}]]}`,
+			yaml: "---\n" +
+				"images:\n" +
+				"  - slot: 0\n" +
+				"    hash: \"ab\"\n" +
+				"    flags:\n" +
+				"      - \"active\"\n" +
+				"      - \"confirmed\"\n" +
+				"  - slot: 1\n" +
+				"    tlvs: []\n" +
+				"grid:\n" +
+				"  - - 1\n" +
+				"    - 2\n" +
+				"  - []\n" +
+				"  - - a: 1\n",
+		},
+		{
+			name: "large numbers keep their precision",
+			json: `{"big":18446744073709551615}`,
+			yaml: "---\nbig: 18446744073709551615\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			y, err := jsonToYaml([]byte(test.json))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if string(y) != test.yaml {
+				t.Errorf("wrong YAML:\ngot:\n%s\nwant:\n%s", y, test.yaml)
+			}
+		})
+	}
+}
+
+func TestJsonToYamlInvalid(t *testing.T) {
+	for _, j := range []string{``, `{`, `{"a":`, `[1,`, `{"a" 1}`} {
+		if _, err := jsonToYaml([]byte(j)); err == nil {
+			t.Errorf("no error for invalid JSON %q", j)
+		}
+	}
+}
+
+func TestMarshalOutputYaml(t *testing.T) {
+	doc := &outputDoc{
+		Command: "echo",
+		Error:   "bad: thing\n#2",
+		Result: map[string]interface{}{
+			"payload": " x",
+		},
+	}
+
+	y, err := marshalOutput(doc, OUTPUT_YAML)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := "---\n" +
+		"command: \"echo\"\n" +
+		"rc: 0\n" +
+		"error: \"bad: thing\\n#2\"\n" +
+		"result:\n" +
+		"  payload: \" x\"\n"
+	if string(y) != want {
+		t.Errorf("wrong YAML:\ngot:\n%s\nwant:\n%s", y, want)
+	}
+}
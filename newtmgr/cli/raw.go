@@ -0,0 +1,218 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package cli
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/xact"
+	"mynewt.apache.org/newt/util"
+)
+
+var optRawCbor bool
+
+func parseRawOp(s string) (uint8, error) {
+	switch strings.ToLower(s) {
+	case "read", "r":
+		return nmp.NMP_OP_READ, nil
+	case "write", "w":
+		return nmp.NMP_OP_WRITE, nil
+	default:
+		return 0, util.FmtNewtError(
+			"Invalid op \"%s\"; must be read or write", s)
+	}
+}
+
+func parseRawGroup(s string) (uint16, error) {
+	if group, ok := nmp.GroupFromName(s); ok {
+		return group, nil
+	}
+
+	group, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, util.FmtNewtError("Invalid group \"%s\"", s)
+	}
+
+	return uint16(group), nil
+}
+
+func parseRawId(group uint16, s string) (uint8, error) {
+	if id, ok := nmp.IdFromName(group, s); ok {
+		return id, nil
+	}
+
+	id, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, util.FmtNewtError("Invalid command ID \"%s\"", s)
+	}
+
+	return uint8(id), nil
+}
+
+// Converts JSON numbers into integers where possible; CBOR distinguishes
+// between integers and floats.
+func jsonToCbor(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+
+	case map[string]interface{}:
+		for k, v := range t {
+			t[k] = jsonToCbor(v)
+		}
+		return t
+
+	case []interface{}:
+		for i, v := range t {
+			t[i] = jsonToCbor(v)
+		}
+		return t
+
+	default:
+		return v
+	}
+}
+
+func parseRawBody(s string) (map[string]interface{}, error) {
+	if optRawCbor {
+		s = strings.Join(strings.Fields(s), "")
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return nil, util.FmtNewtError("Invalid CBOR hex string: %s",
+				err.Error())
+		}
+
+		m, err := nmxutil.DecodeCborMap(b)
+		if err != nil {
+			return nil, util.ChildNewtError(err)
+		}
+		return m, nil
+	}
+
+	m := map[string]interface{}{}
+	dec := json.NewDecoder(bytes.NewReader([]byte(s)))
+	dec.UseNumber()
+	if err := dec.Decode(&m); err != nil {
+		return nil, util.FmtNewtError("Invalid JSON body: %s", err.Error())
+	}
+
+	return jsonToCbor(m).(map[string]interface{}), nil
+}
+
+func rawRunCmd(cmd *cobra.Command, args []string) {
+	if len(args) < 3 || len(args) > 4 {
+		nmUsage(cmd, nil)
+	}
+
+	c := xact.NewRawCmd()
+
+	var err error
+	if c.Op, err = parseRawOp(args[0]); err != nil {
+		nmUsage(cmd, err)
+	}
+	if c.Group, err = parseRawGroup(args[1]); err != nil {
+		nmUsage(cmd, err)
+	}
+	if c.Id, err = parseRawId(c.Group, args[2]); err != nil {
+		nmUsage(cmd, err)
+	}
+	if len(args) > 3 {
+		if c.Body, err = parseRawBody(args[3]); err != nil {
+			nmUsage(cmd, err)
+		}
+	}
+
+	s, err := GetSesn()
+	if err != nil {
+		nmUsage(nil, err)
+	}
+
+	c.SetTxOptions(nmutil.TxOptions())
+
+	res, err := c.Run(s)
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+
+	rres := res.(*xact.RawResult)
+	if structuredOutput() {
+		doc := &outputDoc{
+			Rc: rres.Status(),
+			Result: map[string]interface{}{
+				"body": nmxutil.CborToJson(rres.Rsp.Body),
+			},
+		}
+		if err := xact.StatusError(rres); err != nil {
+			doc.Error = err.Error()
+		}
+		renderDoc(doc)
+		return
+	}
+
+	j, err := json.MarshalIndent(nmxutil.CborToJson(rres.Rsp.Body), "", "    ")
+	if err != nil {
+		nmUsage(nil, util.ChildNewtError(err))
+	}
+	fmt.Println(string(j))
+}
+
+func rawCmd() *cobra.Command {
+	rawHelpText := "Send a request with an arbitrary op, group, and command " +
+		"ID, and display the\nresponse as JSON.\n\n"
+	rawHelpText += "- op is read or write.\n"
+	rawHelpText += "- group is a group name (e.g., image) or number.  " +
+		"Application groups start at\n  " +
+		strconv.Itoa(nmp.NMP_GROUP_PERUSER) + ".\n"
+	rawHelpText += "- id is a command name (e.g., state) or number.\n"
+	rawHelpText += "- body is a JSON object, or hex-encoded CBOR if --cbor " +
+		"is specified.\n"
+
+	rawEx := nmutil.ToolInfo.ExeName +
+		" raw write default echo '{\"d\":\"hello\"}' -c myserial\n"
+	rawEx += nmutil.ToolInfo.ExeName + " raw read 64 0 -c myserial\n"
+	rawEx += nmutil.ToolInfo.ExeName +
+		" raw write 64 1 --cbor a1616101 -c myserial\n"
+
+	rawCmd := &cobra.Command{
+		Use:     "raw <op> <group> <id> [body] -c <conn_profile>",
+		Short:   "Send a custom request to a device",
+		Long:    rawHelpText,
+		Example: rawEx,
+		Run:     rawRunCmd,
+	}
+	rawCmd.PersistentFlags().BoolVar(&optRawCbor, "cbor", false,
+		"body is hex-encoded CBOR")
+
+	return rawCmd
+}
@@ -119,6 +119,10 @@ func printCode(code coap.COAPCode) string {
 	return s
 }
 
+func codeStr(code coap.COAPCode) string {
+	return fmt.Sprintf("%d.%d%d", (code&0xE0)>>5, (code&0x18)>>3, code&0x07)
+}
+
 func printDetails(msg coap.Message) string {
 	var s string
 	s += printCode(msg.Code())
@@ -391,6 +395,11 @@ func runResCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.ResResult)
+	if structuredOutput() {
+		renderRes(path, sres)
+		return
+	}
+
 	if sres.Status() != 0 {
 		fmt.Printf("Error: %s (%d)\n", sres.Rsp.Code(), sres.Rsp.Code())
 		return
@@ -405,6 +414,34 @@ func runResCmd(cmd *cobra.Command, args []string) {
 	}
 }
 
+func renderRes(path string, sres *xact.ResResult) {
+	out := map[string]interface{}{
+		"path":  path,
+		"code":  codeStr(sres.Rsp.Code()),
+		"token": hex.EncodeToString(sres.Rsp.Token()),
+	}
+
+	doc := &outputDoc{
+		Rc:     sres.Status(),
+		Result: out,
+	}
+	if doc.Rc != 0 {
+		doc.Error = sres.Rsp.Code().String()
+	}
+
+	if len(sres.Rsp.Payload()) > 0 {
+		m, err := nmxutil.DecodeCbor(sres.Rsp.Payload())
+		if err != nil {
+			out["payload_hex"] = hex.EncodeToString(sres.Rsp.Payload())
+			doc.Error = "invalid incoming cbor: " + err.Error()
+		} else {
+			out["payload"] = nmxutil.CborToJson(m)
+		}
+	}
+
+	renderDoc(doc)
+}
+
 func resCmd() *cobra.Command {
 	resCmd := &cobra.Command{
 		Use:   "res <op> <path> <k=v> [k=v] [k=v]",
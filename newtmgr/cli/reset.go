@@ -38,10 +38,16 @@ func resetRunCmd(cmd *cobra.Command, args []string) {
 	c := xact.NewResetCmd()
 	c.SetTxOptions(nmutil.TxOptions())
 
-	if _, err := c.Run(s); err != nil {
+	res, err := c.Run(s)
+	if err != nil {
 		nmUsage(nil, util.ChildNewtError(err))
 	}
 
+	if structuredOutput() {
+		renderResult(res, nil)
+		return
+	}
+
 	fmt.Printf("Done\n")
 }
 
@@ -26,6 +26,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
 	"github.com/recogni/newtmgr/nmxact/xact"
 	"mynewt.apache.org/newt/util"
 )
@@ -54,8 +55,13 @@ func runTestCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.RunTestResult)
+	if structuredOutput() {
+		renderResult(sres, nil)
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(sres.Rsp))
 		return
 	}
 
@@ -77,8 +83,17 @@ func runListCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.RunListResult)
+	if structuredOutput() {
+		tests := append([]string{}, sres.Rsp.List...)
+		sort.Strings(tests)
+		renderResult(sres, map[string]interface{}{
+			"tests": tests,
+		})
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(sres.Rsp))
 		return
 	}
 
@@ -49,6 +49,14 @@ func shellExecCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.ShellExecResult)
+	if structuredOutput() {
+		render(map[string]interface{}{
+			"status": sres.Rsp.Rc,
+			"output": sres.Rsp.O,
+		})
+		return
+	}
+
 	fmt.Printf("status=%d\n", sres.Rsp.Rc)
 	if len(sres.Rsp.O) > 0 {
 		fmt.Printf("%s", sres.Rsp.O)
@@ -26,6 +26,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
 	"github.com/recogni/newtmgr/nmxact/xact"
 	"mynewt.apache.org/newt/util"
 )
@@ -45,8 +46,17 @@ func statsListRunCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.StatListResult)
+	if structuredOutput() {
+		groups := append([]string{}, sres.Rsp.List...)
+		sort.Strings(groups)
+		renderResult(sres, map[string]interface{}{
+			"groups": groups,
+		})
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(sres.Rsp))
 	} else if len(sres.Rsp.List) == 0 {
 		fmt.Printf("stat groups: none\n")
 	} else {
@@ -83,8 +93,20 @@ func statsRunCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.StatReadResult)
+	if structuredOutput() {
+		fields := sres.Rsp.Fields
+		if fields == nil {
+			fields = map[string]interface{}{}
+		}
+		renderResult(sres, map[string]interface{}{
+			"name":   sres.Rsp.Name,
+			"fields": fields,
+		})
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(sres.Rsp))
 	} else {
 		fmt.Printf("stat group: %s\n", sres.Rsp.Name)
 		if len(sres.Rsp.Fields) == 0 {
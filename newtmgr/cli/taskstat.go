@@ -26,6 +26,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
+	"github.com/recogni/newtmgr/nmxact/nmp"
 	"github.com/recogni/newtmgr/nmxact/xact"
 	"mynewt.apache.org/newt/util"
 )
@@ -45,8 +46,15 @@ func taskStatRunCmd(cmd *cobra.Command, args []string) {
 	}
 
 	sres := res.(*xact.TaskStatResult)
+	if structuredOutput() {
+		renderResult(sres, map[string]interface{}{
+			"tasks": sres.Rsp.Tasks,
+		})
+		return
+	}
+
 	if sres.Rsp.Rc != 0 {
-		fmt.Printf("Error: %d\n", sres.Rsp.Rc)
+		fmt.Printf("Error: %s\n", nmp.RspError(sres.Rsp))
 		return
 	}
 
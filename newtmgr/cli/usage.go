@@ -65,12 +65,17 @@ func nmUsage(cmd *cobra.Command, err error) {
 			}
 
 			log.Debugf("%s", sErr.StackTrace)
-			fmt.Fprintf(os.Stderr, "Error: %s\n", sErr.Text)
+			if !renderUsageError(sErr.Text) {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", sErr.Text)
+			}
 		}
 
 		if cmd != nil {
-			fmt.Printf("\n")
-			fmt.Printf("%s - ", cmd.Name())
+			// Keep stdout free for the error document.
+			w := infoWriter()
+			cmd.SetOutput(w)
+			fmt.Fprintf(w, "\n")
+			fmt.Fprintf(w, "%s - ", cmd.Name())
 			cmd.Help()
 		}
 	}
@@ -44,11 +44,42 @@ type ConnProfile struct {
 	Name       string   `json:"MyName"`
 	Type       ConnType `json:"MyType"`
 	ConnString string   `json:"MyConnString"`
+
+	// Retry policy settings.  Zero values leave the command line settings
+	// in effect.
+	Tries           int     `json:"MyTries,omitempty"`
+	RetryBackoff    float64 `json:"MyRetryBackoff,omitempty"`
+	RetryMaxBackoff float64 `json:"MyRetryMaxBackoff,omitempty"`
+	RetryJitter     float64 `json:"MyRetryJitter,omitempty"`
+	RetryOn         string  `json:"MyRetryOn,omitempty"`
 }
 
 func (p *ConnProfile) String() string {
-	return fmt.Sprintf("name=%s type=%s connstring=%s",
-		p.Name, ConnTypeToString(p.Type), p.ConnString)
+	return fmt.Sprintf("name=%s type=%s connstring=%s%s",
+		p.Name, ConnTypeToString(p.Type), p.ConnString, p.RetryString())
+}
+
+// Returns the profile's retry settings as a string of " key=value" pairs, or
+// "" if the profile has none.
+func (p *ConnProfile) RetryString() string {
+	s := ""
+	if p.Tries != 0 {
+		s += fmt.Sprintf(" tries=%d", p.Tries)
+	}
+	if p.RetryBackoff != 0 {
+		s += fmt.Sprintf(" retry-backoff=%g", p.RetryBackoff)
+	}
+	if p.RetryMaxBackoff != 0 {
+		s += fmt.Sprintf(" retry-max-backoff=%g", p.RetryMaxBackoff)
+	}
+	if p.RetryJitter != 0 {
+		s += fmt.Sprintf(" retry-jitter=%g", p.RetryJitter)
+	}
+	if p.RetryOn != "" {
+		s += fmt.Sprintf(" retry-on=%s", p.RetryOn)
+	}
+
+	return s
 }
 
 const (
@@ -62,6 +93,11 @@ const (
 	CONN_TYPE_UDP_PLAIN
 	CONN_TYPE_UDP_OIC
 	CONN_TYPE_MTECH_LORA_OIC
+	CONN_TYPE_SIM_PLAIN
+	CONN_TYPE_SIM_OIC
+	CONN_TYPE_TCP_PLAIN
+	CONN_TYPE_TCP_OIC
+	CONN_TYPE_REPLAY
 )
 
 var connTypeNameMap = map[ConnType]string{
@@ -74,6 +110,11 @@ var connTypeNameMap = map[ConnType]string{
 	CONN_TYPE_UDP_PLAIN:      "udp",
 	CONN_TYPE_UDP_OIC:        "oic_udp",
 	CONN_TYPE_MTECH_LORA_OIC: "oic_mtech",
+	CONN_TYPE_SIM_PLAIN:      "sim",
+	CONN_TYPE_SIM_OIC:        "oic_sim",
+	CONN_TYPE_TCP_PLAIN:      "tcp",
+	CONN_TYPE_TCP_OIC:        "oic_tcp",
+	CONN_TYPE_REPLAY:         "replay",
 	CONN_TYPE_NONE:           "???",
 }
 
@@ -0,0 +1,83 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/recogni/newtmgr/nmxact/capture"
+	"mynewt.apache.org/newt/util"
+)
+
+func einvalReplayConnString(f string, args ...interface{}) error {
+	suffix := fmt.Sprintf(f, args...)
+	return util.FmtNewtError("Invalid replay connstring; %s", suffix)
+}
+
+func ParseReplayConnString(cs string) (*capture.ReplayXportCfg, error) {
+	rc := capture.NewReplayXportCfg()
+
+	parts := strings.Split(cs, ",")
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		kv := strings.SplitN(p, "=", 2)
+		// A single token indicates the capture file.
+		if len(kv) == 1 {
+			kv = []string{"file", kv[0]}
+		}
+
+		k := kv[0]
+		v := kv[1]
+
+		switch k {
+		case "file":
+			rc.Path = v
+
+		case "realtime":
+			var err error
+			rc.Realtime, err = strconv.ParseBool(v)
+			if err != nil {
+				return rc, einvalReplayConnString("Invalid realtime: %s", v)
+			}
+
+		case "sesn":
+			var err error
+			rc.Sesn, err = strconv.Atoi(v)
+			if err != nil || rc.Sesn < 0 {
+				return rc, einvalReplayConnString("Invalid sesn: %s", v)
+			}
+
+		default:
+			return rc, einvalReplayConnString("Unrecognized key: %s", k)
+		}
+	}
+
+	if rc.Path == "" {
+		return rc, einvalReplayConnString("Missing capture file")
+	}
+
+	return rc, nil
+}
@@ -21,8 +21,10 @@ package config
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
 	"github.com/recogni/newtmgr/nmxact/nmserial"
@@ -30,7 +32,7 @@ import (
 )
 
 func einvalSerialConnString(f string, args ...interface{}) error {
-	suffix := fmt.Sprintf(f, args)
+	suffix := fmt.Sprintf(f, args...)
 	return util.FmtNewtError("Invalid serial connstring; %s", suffix)
 }
 
@@ -41,6 +43,7 @@ func ParseSerialConnString(cs string) (*nmserial.XportCfg, error) {
 
 	parts := strings.Split(cs, ",")
 	for _, p := range parts {
+		p = strings.TrimSpace(p)
 		kv := strings.SplitN(p, "=", 2)
 		// Handle old-style conn string (single token indicating dev file).
 		if len(kv) == 1 {
@@ -68,6 +71,38 @@ func ParseSerialConnString(cs string) (*nmserial.XportCfg, error) {
 				return sc, einvalSerialConnString("Invalid mtu: %s", v)
 			}
 
+		case "segsize":
+			var err error
+			sc.SegmentSize, err = strconv.Atoi(v)
+			if err != nil || sc.SegmentSize <= 0 || sc.SegmentSize%4 != 0 {
+				return sc, einvalSerialConnString(
+					"Invalid segsize: %s; must be a multiple of 4", v)
+			}
+
+		case "framedelay":
+			var err error
+			sc.FrameDelay, err = time.ParseDuration(v)
+			if err != nil || sc.FrameDelay < 0 {
+				return sc, einvalSerialConnString("Invalid framedelay: %s", v)
+			}
+
+		case "conntimeout":
+			var err error
+			sc.ConnectTimeout, err = time.ParseDuration(v)
+			if err != nil || sc.ConnectTimeout <= 0 {
+				return sc, einvalSerialConnString(
+					"Invalid conntimeout: %s", v)
+			}
+
+		case "maxpkt":
+			var err error
+			sc.MaxPacketSize, err = strconv.Atoi(v)
+			if err != nil || sc.MaxPacketSize <= 0 ||
+				sc.MaxPacketSize > math.MaxUint16 {
+
+				return sc, einvalSerialConnString("Invalid maxpkt: %s", v)
+			}
+
 		default:
 			return sc, einvalSerialConnString("Unrecognized key: %s", k)
 		}
@@ -0,0 +1,98 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmsim"
+	"mynewt.apache.org/newt/util"
+)
+
+func einvalSimConnString(f string, args ...interface{}) error {
+	suffix := fmt.Sprintf(f, args...)
+	return util.FmtNewtError("Invalid sim connstring; %s", suffix)
+}
+
+func ParseSimConnString(cs string) (*nmsim.XportCfg, error) {
+	sc := nmsim.NewXportCfg()
+
+	parts := strings.Split(cs, ",")
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+
+		kv := strings.SplitN(p, "=", 2)
+		// A single token indicates the state file.
+		if len(kv) == 1 {
+			kv = []string{"state", kv[0]}
+		}
+
+		k := kv[0]
+		v := kv[1]
+
+		switch k {
+		case "state":
+			sc.StatePath = v
+
+		case "mtu":
+			var err error
+			sc.Mtu, err = strconv.Atoi(v)
+			if err != nil || sc.Mtu <= 0 {
+				return sc, einvalSimConnString("Invalid mtu: %s", v)
+			}
+
+		case "latency":
+			var err error
+			sc.Latency, err = time.ParseDuration(v)
+			if err != nil {
+				return sc, einvalSimConnString("Invalid latency: %s", v)
+			}
+
+		case "reboot":
+			var err error
+			sc.RebootTime, err = time.ParseDuration(v)
+			if err != nil {
+				return sc, einvalSimConnString("Invalid reboot time: %s", v)
+			}
+
+		case "smpver":
+			switch v {
+			case "1":
+				sc.SmpVersion = nmp.NMP_VER1
+			case "2":
+				sc.SmpVersion = nmp.NMP_VER2
+			default:
+				return sc, einvalSimConnString("Invalid SMP version: %s", v)
+			}
+
+		default:
+			return sc, einvalSimConnString("Unrecognized key: %s", k)
+		}
+	}
+
+	return sc, nil
+}
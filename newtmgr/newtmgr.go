@@ -69,7 +69,12 @@ func cleanup() {
 	if !isSerial() {
 		closeSesn()
 		stopXport()
+	} else {
+		cli.ReportXportStats()
 	}
+
+	cli.CloseLogExport()
+	cli.CloseCapture()
 }
 
 func main() {
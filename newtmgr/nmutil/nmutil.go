@@ -37,6 +37,10 @@ type ToolInfoType struct {
 
 var Timeout float64
 var Tries int
+var RetryBackoff float64
+var RetryMaxBackoff float64
+var RetryJitter float64
+var RetryOn = sesn.DfltRetryPolicy.RetryOn
 var ConnProfile string
 var DeviceName string
 var BleWriteRsp bool
@@ -45,11 +49,28 @@ var ConnString string
 var ConnExtra string
 var ToolInfo ToolInfoType
 var HciIdx int
+var CaptureFile string
+var OutputFormat string
+
+func secsToDuration(secs float64) time.Duration {
+	return time.Duration(secs * float64(time.Second))
+}
+
+func RetryPolicy() sesn.RetryPolicy {
+	p := sesn.NewRetryPolicy()
+	p.Backoff = secsToDuration(RetryBackoff)
+	p.MaxBackoff = secsToDuration(RetryMaxBackoff)
+	p.Jitter = RetryJitter
+	p.RetryOn = RetryOn
+
+	return p
+}
 
 func TxOptions() sesn.TxOptions {
 	return sesn.TxOptions{
-		Timeout: time.Duration(Timeout * float64(time.Second)),
+		Timeout: secsToDuration(Timeout),
 		Tries:   Tries,
+		Retry:   RetryPolicy(),
 	}
 }
 
@@ -0,0 +1,187 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package capture records the management traffic exchanged over a session
+// and replays it later.
+//
+// A capture file is a sequence of JSON objects, one per line.  Each object
+// is a Record.  Management requests are recorded as plain NMP frames,
+// regardless of the transport or encapsulation (NMP or OMP) that carried
+// them.  Received frames are recorded exactly as they came off the
+// transport, after reassembly: plain NMP packets for NMP sessions, and CoAP
+// messages in the session's wire format for OMP and CoAP traffic.  Sessions
+// that cannot report their received frames record re-encoded responses
+// instead.
+package capture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/runtimeco/go-coap"
+
+	"github.com/recogni/newtmgr/nmxact/nmcoap"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+)
+
+// Record directions.
+const (
+	DIR_OPEN  = "open"
+	DIR_CLOSE = "close"
+	DIR_TX    = "tx"
+	DIR_RX    = "rx"
+)
+
+// Record protocols.
+const (
+	PROTO_NMP  = "nmp"
+	PROTO_COAP = "coap"
+)
+
+type Record struct {
+	Time time.Time `json:"time"`
+
+	// Identifies the session that the record belongs to.  Sessions are
+	// numbered from 0 in the order they were opened.
+	Sesn int `json:"sesn"`
+
+	Dir   string `json:"dir"`
+	Proto string `json:"proto,omitempty"`
+
+	// NMP sequence number of the request or response.
+	Seq uint8 `json:"seq,omitempty"`
+
+	// Raw frame.  Absent when the record describes an error.
+	Data []byte `json:"data,omitempty"`
+
+	// Error reported in place of a response.
+	Err     string `json:"err,omitempty"`
+	Timeout bool   `json:"timeout,omitempty"`
+
+	// Session attributes; only present in "open" records.
+	MgmtProto string `json:"mgmt_proto,omitempty"`
+	CoapTcp   bool   `json:"coap_tcp,omitempty"`
+	MtuIn     int    `json:"mtu_in,omitempty"`
+	MtuOut    int    `json:"mtu_out,omitempty"`
+}
+
+// Decodes the NMP frame contained in a record.
+func (r *Record) NmpMsg() (*nmp.NmpHdr, []byte, error) {
+	if r.Proto != PROTO_NMP {
+		return nil, nil, fmt.Errorf("Not an NMP record: proto=%s", r.Proto)
+	}
+
+	hdr, err := nmp.DecodeNmpHdr(r.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return hdr, r.Data[nmp.NMP_HDR_SIZE:], nil
+}
+
+// Decodes the CoAP message contained in a record.
+func (r *Record) CoapMsg(isTcp bool) (coap.Message, error) {
+	if r.Proto != PROTO_COAP {
+		return nil, fmt.Errorf("Not a CoAP record: proto=%s", r.Proto)
+	}
+
+	if isTcp {
+		m, _, err := coap.PullTcp(r.Data)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			return nil, fmt.Errorf("Incomplete CoAP message")
+		}
+		return m, nil
+	} else {
+		return coap.ParseDgramMessage(r.Data)
+	}
+}
+
+// Writes capture records to a stream.  A single writer can be shared by
+// several sessions.
+type Writer struct {
+	w      io.Writer
+	enc    *json.Encoder
+	nextId int
+	mtx    sync.Mutex
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:   w,
+		enc: json.NewEncoder(w),
+	}
+}
+
+func (cw *Writer) newSesnId() int {
+	cw.mtx.Lock()
+	defer cw.mtx.Unlock()
+
+	id := cw.nextId
+	cw.nextId++
+
+	return id
+}
+
+// Appends a record to the capture.  If the record does not specify a time,
+// the current time is used.
+func (cw *Writer) Write(r Record) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	cw.mtx.Lock()
+	defer cw.mtx.Unlock()
+
+	return cw.enc.Encode(r)
+}
+
+// Reads all records from a capture stream.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var recs []Record
+
+	dec := json.NewDecoder(r)
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); err != nil {
+			if err == io.EOF {
+				return recs, nil
+			}
+			return nil, fmt.Errorf("Invalid capture record %d: %s",
+				len(recs)+1, err.Error())
+		}
+		recs = append(recs, rec)
+	}
+}
+
+func encodeNmp(m *nmp.NmpMsg) ([]byte, error) {
+	// Encoding fills in the header's length field; don't modify the
+	// caller's message.
+	cp := *m
+	return nmp.EncodeNmpPlain(&cp)
+}
+
+func encodeCoap(m coap.Message) ([]byte, error) {
+	return nmcoap.Encode(m)
+}
@@ -0,0 +1,367 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package capture
+
+import (
+	"sync"
+	"time"
+
+	"github.com/runtimeco/go-coap"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/recogni/newtmgr/nmxact/nmcoap"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// Wraps a session, recording all traffic that passes through it.
+type CaptureSesn struct {
+	s  sesn.Sesn
+	w  *Writer
+	id int
+
+	// Whether received frames are recorded as they come off the transport.
+	// If the wrapped session cannot report them, decoded responses are
+	// re-encoded instead.
+	tapped bool
+
+	// Stop channels for the goroutines relaying CoAP listeners, keyed by
+	// listener criteria.
+	listeners map[string]chan struct{}
+	mtx       sync.Mutex
+}
+
+func NewCaptureSesn(s sesn.Sesn, w *Writer) *CaptureSesn {
+	c := &CaptureSesn{
+		s:         s,
+		w:         w,
+		id:        w.newSesnId(),
+		listeners: map[string]chan struct{}{},
+	}
+
+	if rt, ok := s.(sesn.RxTapper); ok {
+		rt.SetRxTap(c.recordRxFrame)
+		c.tapped = true
+	}
+
+	return c
+}
+
+// Retrieves the wrapped session.
+func (c *CaptureSesn) Sesn() sesn.Sesn {
+	return c.s
+}
+
+func (c *CaptureSesn) record(r Record) {
+	r.Sesn = c.id
+	if err := c.w.Write(r); err != nil {
+		log.Debugf("Failed to write capture record: %s", err.Error())
+	}
+}
+
+func (c *CaptureSesn) recordOpen() {
+	c.record(Record{
+		Dir:       DIR_OPEN,
+		MgmtProto: c.s.MgmtProto().String(),
+		CoapTcp:   c.s.CoapIsTcp(),
+		MtuIn:     c.s.MtuIn(),
+		MtuOut:    c.s.MtuOut(),
+	})
+}
+
+func (c *CaptureSesn) recordNmp(dir string, m *nmp.NmpMsg) {
+	b, err := encodeNmp(m)
+	if err != nil {
+		log.Debugf("Failed to encode NMP message for capture: %s",
+			err.Error())
+		return
+	}
+
+	c.record(Record{
+		Dir:   dir,
+		Proto: PROTO_NMP,
+		Seq:   m.Hdr.Seq,
+		Data:  b,
+	})
+}
+
+// Arranges for an outgoing request to be recorded once the session has
+// assigned its final sequence number and version, i.e., when it calls the
+// request's SeqCb.  The returned function restores the original callback.
+func (c *CaptureSesn) recordTxOnSeq(m *nmp.NmpMsg) func() {
+	seqCb := m.SeqCb
+	m.SeqCb = func(seq uint8) {
+		c.recordNmp(DIR_TX, m)
+		if seqCb != nil {
+			seqCb(seq)
+		}
+	}
+
+	return func() { m.SeqCb = seqCb }
+}
+
+// Records a frame exactly as it was received.
+func (c *CaptureSesn) recordRxFrame(frame []byte, isCoap bool) {
+	r := Record{
+		Dir:  DIR_RX,
+		Data: frame,
+	}
+
+	if isCoap {
+		r.Proto = PROTO_COAP
+	} else {
+		r.Proto = PROTO_NMP
+		if hdr, err := nmp.DecodeNmpHdr(frame); err == nil {
+			r.Seq = hdr.Seq
+		}
+	}
+
+	c.record(r)
+}
+
+func (c *CaptureSesn) recordNmpErr(seq uint8, err error) {
+	c.record(Record{
+		Dir:     DIR_RX,
+		Proto:   PROTO_NMP,
+		Seq:     seq,
+		Err:     err.Error(),
+		Timeout: nmxutil.IsRspTimeout(err),
+	})
+}
+
+func (c *CaptureSesn) recordCoap(dir string, m coap.Message) {
+	b, err := encodeCoap(m)
+	if err != nil {
+		log.Debugf("Failed to encode CoAP message for capture: %s",
+			err.Error())
+		return
+	}
+
+	c.record(Record{
+		Dir:   dir,
+		Proto: PROTO_COAP,
+		Data:  b,
+	})
+}
+
+func (c *CaptureSesn) Open() error {
+	if err := c.s.Open(); err != nil {
+		return err
+	}
+
+	c.recordOpen()
+	return nil
+}
+
+func (c *CaptureSesn) Close() error {
+	if err := c.s.Close(); err != nil {
+		return err
+	}
+
+	c.record(Record{Dir: DIR_CLOSE})
+	return nil
+}
+
+func (c *CaptureSesn) IsOpen() bool {
+	return c.s.IsOpen()
+}
+
+func (c *CaptureSesn) MtuIn() int {
+	return c.s.MtuIn()
+}
+
+func (c *CaptureSesn) MtuOut() int {
+	return c.s.MtuOut()
+}
+
+func (c *CaptureSesn) MgmtProto() sesn.MgmtProto {
+	return c.s.MgmtProto()
+}
+
+func (c *CaptureSesn) CoapIsTcp() bool {
+	return c.s.CoapIsTcp()
+}
+
+func (c *CaptureSesn) AbortRx(nmpSeq uint8) error {
+	return c.s.AbortRx(nmpSeq)
+}
+
+func (c *CaptureSesn) NextSeq() uint8 {
+	return sesn.NextSeq(c.s)
+}
+
+func (c *CaptureSesn) RxAccept() (sesn.Sesn, *sesn.SesnCfg, error) {
+	s, cfg, err := c.s.RxAccept()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Accepted sessions are already open.
+	cs := NewCaptureSesn(s, c.w)
+	cs.recordOpen()
+
+	return cs, cfg, nil
+}
+
+func (c *CaptureSesn) RxCoap(opt sesn.TxOptions) (coap.Message, error) {
+	m, err := c.s.RxCoap(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.tapped {
+		c.recordCoap(DIR_RX, m)
+	}
+	return m, nil
+}
+
+func (c *CaptureSesn) TxRxMgmt(m *nmp.NmpMsg,
+	timeout time.Duration) (nmp.NmpRsp, error) {
+
+	restore := c.recordTxOnSeq(m)
+	rsp, err := c.s.TxRxMgmt(m, timeout)
+	restore()
+
+	if err != nil {
+		c.recordNmpErr(m.Hdr.Seq, err)
+		return nil, err
+	}
+
+	if !c.tapped {
+		c.recordNmp(DIR_RX, rsp.Msg())
+	}
+	return rsp, nil
+}
+
+func (c *CaptureSesn) TxRxMgmtAsync(m *nmp.NmpMsg, timeout time.Duration,
+	ch chan nmp.NmpRsp, errc chan error) error {
+
+	ich := make(chan nmp.NmpRsp, 1)
+	ierrc := make(chan error, 1)
+
+	restore := c.recordTxOnSeq(m)
+	if err := c.s.TxRxMgmtAsync(m, timeout, ich, ierrc); err != nil {
+		restore()
+		return err
+	}
+
+	seq := m.Hdr.Seq
+	go func() {
+		// The session may resend the request with a different version
+		// before it reports the outcome; keep recording until then.
+		select {
+		case rsp := <-ich:
+			restore()
+			if !c.tapped {
+				c.recordNmp(DIR_RX, rsp.Msg())
+			}
+			ch <- rsp
+		case err := <-ierrc:
+			restore()
+			c.recordNmpErr(seq, err)
+			errc <- err
+		}
+	}()
+
+	return nil
+}
+
+// Creates a listener on the wrapped session, and relays its messages to the
+// caller via a second listener so that they can be recorded.
+func (c *CaptureSesn) ListenCoap(
+	mc nmcoap.MsgCriteria) (*nmcoap.Listener, error) {
+
+	il, err := c.s.ListenCoap(mc)
+	if err != nil {
+		return nil, err
+	}
+
+	ol := nmcoap.NewListener(mc)
+	stopChan := make(chan struct{})
+
+	c.mtx.Lock()
+	c.listeners[mc.String()] = stopChan
+	c.mtx.Unlock()
+
+	go func() {
+		defer ol.Close()
+
+		for {
+			select {
+			case m, ok := <-il.RspChan:
+				if !ok {
+					return
+				}
+				if !c.tapped {
+					c.recordCoap(DIR_RX, m)
+				}
+				select {
+				case ol.RspChan <- m:
+				case <-stopChan:
+					return
+				}
+
+			case err, ok := <-il.ErrChan:
+				if !ok {
+					return
+				}
+				select {
+				case ol.ErrChan <- err:
+				case <-stopChan:
+					return
+				}
+
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return ol, nil
+}
+
+func (c *CaptureSesn) StopListenCoap(mc nmcoap.MsgCriteria) {
+	c.s.StopListenCoap(mc)
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	key := mc.String()
+	if stopChan := c.listeners[key]; stopChan != nil {
+		close(stopChan)
+		delete(c.listeners, key)
+	}
+}
+
+func (c *CaptureSesn) TxCoap(m coap.Message) error {
+	c.recordCoap(DIR_TX, m)
+	return c.s.TxCoap(m)
+}
+
+func (c *CaptureSesn) Filters() (nmcoap.TxMsgFilter, nmcoap.RxMsgFilter) {
+	return c.s.Filters()
+}
+
+func (c *CaptureSesn) SetFilters(txFilter nmcoap.TxMsgFilter,
+	rxFilter nmcoap.RxMsgFilter) {
+
+	c.s.SetFilters(txFilter, rxFilter)
+}
@@ -0,0 +1,64 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package capture
+
+import (
+	"github.com/recogni/newtmgr/nmxact/sesn"
+	"github.com/recogni/newtmgr/nmxact/xport"
+)
+
+// Wraps a transport such that every session it builds is captured.
+type CaptureXport struct {
+	x xport.Xport
+	w *Writer
+}
+
+func NewCaptureXport(x xport.Xport, w *Writer) *CaptureXport {
+	return &CaptureXport{
+		x: x,
+		w: w,
+	}
+}
+
+// Retrieves the wrapped transport.
+func (cx *CaptureXport) Xport() xport.Xport {
+	return cx.x
+}
+
+func (cx *CaptureXport) Start() error {
+	return cx.x.Start()
+}
+
+func (cx *CaptureXport) Stop() error {
+	return cx.x.Stop()
+}
+
+func (cx *CaptureXport) BuildSesn(cfg sesn.SesnCfg) (sesn.Sesn, error) {
+	s, err := cx.x.BuildSesn(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCaptureSesn(s, cx.w), nil
+}
+
+func (cx *CaptureXport) Tx(data []byte) error {
+	return cx.x.Tx(data)
+}
@@ -0,0 +1,498 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/runtimeco/go-coap"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/recogni/newtmgr/nmxact/mgmt"
+	"github.com/recogni/newtmgr/nmxact/nmcoap"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/omp"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// ReplaySesn answers requests with the responses recorded in a capture.
+//
+// Requests are matched against the recorded requests in order.  A
+// management request matches if its op, group and ID equal those of the next
+// recorded management request; a CoAP message matches if its code and path
+// equal those of the next recorded CoAP message.  The recorded response to
+// a matched request is returned with the sequence number or token of the
+// live request.
+type ReplaySesn struct {
+	cfg    sesn.SesnCfg
+	rx     *ReplayXport
+	id     int
+	recs   []Record
+	used   []bool
+	open   Record
+	txvr   *mgmt.Transceiver
+	isOpen bool
+
+	// This mutex ensures:
+	//     * accesses to isOpen are protected.
+	//     * requests are matched one at a time.
+	m  sync.Mutex
+	wg sync.WaitGroup
+
+	stopChan chan struct{}
+}
+
+func newReplaySesn(rx *ReplayXport, cfg sesn.SesnCfg, id int,
+	recs []Record) *ReplaySesn {
+
+	s := &ReplaySesn{
+		cfg:  cfg,
+		rx:   rx,
+		id:   id,
+		recs: recs,
+		used: make([]bool, len(recs)),
+	}
+
+	for _, r := range recs {
+		if r.Dir == DIR_OPEN {
+			s.open = r
+			break
+		}
+	}
+
+	// Use the management protocol of the recorded session.
+	for _, p := range []sesn.MgmtProto{
+		sesn.MGMT_PROTO_NMP,
+		sesn.MGMT_PROTO_OMP,
+		sesn.MGMT_PROTO_COAP_SERVER,
+	} {
+		if p.String() == s.open.MgmtProto {
+			s.cfg.MgmtProto = p
+		}
+	}
+
+	return s
+}
+
+func (s *ReplaySesn) Open() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.isOpen {
+		return nmxutil.NewSesnAlreadyOpenError(
+			"Attempt to open an already-open replay session")
+	}
+
+	txvr, err := mgmt.NewTransceiver(s.cfg.TxFilter, s.cfg.RxFilter,
+		s.open.CoapTcp, s.cfg.MgmtProto, 3)
+	if err != nil {
+		return err
+	}
+
+	s.txvr = txvr
+	s.stopChan = make(chan struct{})
+	s.isOpen = true
+
+	return nil
+}
+
+func (s *ReplaySesn) Close() error {
+	s.m.Lock()
+
+	if !s.isOpen {
+		s.m.Unlock()
+		return nmxutil.NewSesnClosedError(
+			"Attempt to close an unopened replay session")
+	}
+
+	s.isOpen = false
+	close(s.stopChan)
+	s.m.Unlock()
+
+	s.wg.Wait()
+	s.txvr.ErrorAll(fmt.Errorf("closed"))
+	s.txvr.Stop()
+
+	return nil
+}
+
+func (s *ReplaySesn) IsOpen() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.isOpen
+}
+
+// The recorded MTUs are reported so that commands split their requests
+// exactly as they did during the capture.
+func (s *ReplaySesn) MtuIn() int {
+	if s.open.MtuIn != 0 {
+		return s.open.MtuIn
+	}
+	return 512 - omp.OMP_MSG_OVERHEAD - nmp.NMP_HDR_SIZE
+}
+
+func (s *ReplaySesn) MtuOut() int {
+	if s.open.MtuOut != 0 {
+		return s.open.MtuOut
+	}
+	return 512 - omp.OMP_MSG_OVERHEAD - nmp.NMP_HDR_SIZE
+}
+
+func (s *ReplaySesn) MgmtProto() sesn.MgmtProto {
+	return s.cfg.MgmtProto
+}
+
+func (s *ReplaySesn) CoapIsTcp() bool {
+	return s.open.CoapTcp
+}
+
+func (s *ReplaySesn) AbortRx(seq uint8) error {
+	return nil
+}
+
+func (s *ReplaySesn) NextSeq() uint8 {
+	if s.txvr == nil {
+		return nmxutil.NextNmpSeq()
+	}
+	return s.txvr.NextSeq()
+}
+
+func (s *ReplaySesn) RxAccept() (sesn.Sesn, *sesn.SesnCfg, error) {
+	return nil, nil, fmt.Errorf("Op not implemented yet")
+}
+
+func (s *ReplaySesn) RxCoap(opt sesn.TxOptions) (coap.Message, error) {
+	return nil, fmt.Errorf("Op not implemented yet")
+}
+
+// Finds the first unused record of this session at or after the specified
+// index satisfying the predicate.  Returns -1 if there is no such record.
+func (s *ReplaySesn) findRec(start int, pred func(r *Record) bool) int {
+	for i := start; i < len(s.recs); i++ {
+		if !s.used[i] && s.recs[i].Sesn == s.id && pred(&s.recs[i]) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Calculates the delay to apply to a response.
+func (s *ReplaySesn) delay(tx *Record, rsp *Record) time.Duration {
+	if !s.rx.cfg.Realtime {
+		return 0
+	}
+
+	d := rsp.Time.Sub(tx.Time)
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// Waits for the specified delay.  Returns false if the session was closed in
+// the meantime.
+func (s *ReplaySesn) sleep(d time.Duration) bool {
+	if d == 0 {
+		return true
+	}
+
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.stopChan:
+		return false
+	}
+}
+
+// Matches a management request against the capture and produces the
+// recorded outcome.
+func (s *ReplaySesn) replayNmp(m *nmp.NmpMsg) (nmp.NmpRsp, time.Duration,
+	error) {
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.isOpen {
+		return nil, 0, nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed replay session")
+	}
+
+	txIdx := s.findRec(0, func(r *Record) bool {
+		return r.Dir == DIR_TX && r.Proto == PROTO_NMP
+	})
+	if txIdx == -1 {
+		return nil, 0, fmt.Errorf("Replay: no request recorded in session "+
+			"%d matches op=%d group=%d id=%d",
+			s.id, m.Hdr.Op, m.Hdr.Group, m.Hdr.Id)
+	}
+	tx := &s.recs[txIdx]
+
+	hdr, _, err := tx.NmpMsg()
+	if err != nil {
+		return nil, 0, err
+	}
+	if hdr.Op != m.Hdr.Op || hdr.Group != m.Hdr.Group || hdr.Id != m.Hdr.Id {
+		return nil, 0, fmt.Errorf("Replay: request mismatch; "+
+			"recorded op=%d group=%d id=%d, got op=%d group=%d id=%d",
+			hdr.Op, hdr.Group, hdr.Id, m.Hdr.Op, m.Hdr.Group, m.Hdr.Id)
+	}
+	s.used[txIdx] = true
+
+	rspIdx := s.findRec(txIdx+1, func(r *Record) bool {
+		return r.Dir == DIR_RX && s.isNmpRsp(r, tx.Seq)
+	})
+	if rspIdx == -1 {
+		// The capture ended before the response arrived.
+		return nil, 0, nmxutil.NewRspTimeoutError(
+			"Replay: no recorded response")
+	}
+	s.used[rspIdx] = true
+	rec := &s.recs[rspIdx]
+	d := s.delay(tx, rec)
+
+	if rec.Err != "" {
+		if rec.Timeout {
+			return nil, d, nmxutil.NewRspTimeoutError(rec.Err)
+		}
+		return nil, d, errors.New(rec.Err)
+	}
+
+	rsp, err := s.decodeNmpRsp(rec)
+	if err != nil {
+		return nil, d, err
+	}
+
+	rhdr := *rsp.Hdr()
+	rhdr.Seq = m.Hdr.Seq
+	rsp.SetHdr(&rhdr)
+
+	return rsp, d, nil
+}
+
+// Indicates whether a received record holds the response to the management
+// request with the specified sequence number.  Responses to OMP requests are
+// recorded as the CoAP messages that carried them.
+func (s *ReplaySesn) isNmpRsp(r *Record, seq uint8) bool {
+	switch r.Proto {
+	case PROTO_NMP:
+		if r.Err != "" {
+			return r.Seq == seq
+		}
+
+		// Skip requests echoed by the device.
+		hdr, _, err := r.NmpMsg()
+		return err == nil && hdr.Seq == seq &&
+			(hdr.Op == nmp.NMP_OP_READ_RSP || hdr.Op == nmp.NMP_OP_WRITE_RSP)
+
+	case PROTO_COAP:
+		if s.cfg.MgmtProto != sesn.MGMT_PROTO_OMP {
+			return false
+		}
+
+		m, err := r.CoapMsg(s.open.CoapTcp)
+		return err == nil && m.Code() >= coap.Created &&
+			bytes.Equal(m.Token(), nmxutil.SeqToToken(seq))
+
+	default:
+		return false
+	}
+}
+
+func (s *ReplaySesn) decodeNmpRsp(r *Record) (nmp.NmpRsp, error) {
+	if r.Proto == PROTO_COAP {
+		m, err := r.CoapMsg(s.open.CoapTcp)
+		if err != nil {
+			return nil, err
+		}
+
+		_, rxFilter := s.txvr.Filters()
+		rsp, err := omp.DecodeOmp(m, rxFilter)
+		if err != nil {
+			return nil, err
+		}
+		if rsp == nil {
+			return nil, fmt.Errorf("Replay: recorded CoAP message is not " +
+				"a management response")
+		}
+		return rsp, nil
+	}
+
+	hdr, body, err := r.NmpMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	return nmp.DecodeRspBody(hdr, body)
+}
+
+func (s *ReplaySesn) TxRxMgmt(m *nmp.NmpMsg,
+	timeout time.Duration) (nmp.NmpRsp, error) {
+
+	rsp, d, err := s.replayNmp(m)
+	if timeout > 0 && d > timeout {
+		s.sleep(timeout)
+		return nil, nmxutil.NewRspTimeoutError("NMP timeout")
+	}
+	if !s.sleep(d) {
+		return nil, fmt.Errorf("Session closed")
+	}
+
+	return rsp, err
+}
+
+func (s *ReplaySesn) TxRxMgmtAsync(m *nmp.NmpMsg, timeout time.Duration,
+	ch chan nmp.NmpRsp, errc chan error) error {
+
+	// Match synchronously so that requests are matched in the order they
+	// are sent.
+	rsp, d, err := s.replayNmp(m)
+	if nmxutil.IsSesnClosed(err) {
+		return err
+	}
+
+	go func() {
+		if timeout > 0 && d > timeout {
+			s.sleep(timeout)
+			errc <- nmxutil.NewRspTimeoutError("NMP timeout")
+			return
+		}
+		if !s.sleep(d) {
+			errc <- fmt.Errorf("Session closed")
+			return
+		}
+
+		if err != nil {
+			errc <- err
+		} else {
+			ch <- rsp
+		}
+	}()
+
+	return nil
+}
+
+func (s *ReplaySesn) TxCoap(m coap.Message) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.isOpen {
+		return nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed replay session")
+	}
+
+	txIdx := s.findRec(0, func(r *Record) bool {
+		return r.Dir == DIR_TX && r.Proto == PROTO_COAP
+	})
+	if txIdx == -1 {
+		return fmt.Errorf("Replay: no CoAP message recorded in session "+
+			"%d matches code=%s path=%s", s.id, m.Code(), m.PathString())
+	}
+	tx := &s.recs[txIdx]
+
+	txm, err := tx.CoapMsg(s.open.CoapTcp)
+	if err != nil {
+		return err
+	}
+	if txm.Code() != m.Code() || txm.PathString() != m.PathString() {
+		return fmt.Errorf("Replay: CoAP message mismatch; "+
+			"recorded code=%s path=%s, got code=%s path=%s",
+			txm.Code(), txm.PathString(), m.Code(), m.PathString())
+	}
+	s.used[txIdx] = true
+
+	// Gather the recorded messages carrying the same token, up to the next
+	// request that reuses it (e.g., an observe cancellation).
+	type rspRec struct {
+		msg coap.Message
+		d   time.Duration
+	}
+	var rsps []rspRec
+	for i := txIdx + 1; i < len(s.recs); i++ {
+		r := &s.recs[i]
+		if s.used[i] || r.Proto != PROTO_COAP {
+			continue
+		}
+
+		rm, err := r.CoapMsg(s.open.CoapTcp)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(rm.Token(), txm.Token()) {
+			continue
+		}
+		if r.Dir == DIR_TX {
+			break
+		}
+
+		s.used[i] = true
+		rm.SetToken(m.Token())
+		rsps = append(rsps, rspRec{rm, s.delay(tx, r)})
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		var elapsed time.Duration
+		for _, r := range rsps {
+			if !s.sleep(r.d - elapsed) {
+				return
+			}
+			elapsed = r.d
+
+			b, err := encodeCoap(r.msg)
+			if err != nil {
+				log.Debugf("Replay: failed to encode CoAP message: %s",
+					err.Error())
+				continue
+			}
+			s.txvr.DispatchCoap(b)
+		}
+	}()
+
+	return nil
+}
+
+func (s *ReplaySesn) ListenCoap(
+	mc nmcoap.MsgCriteria) (*nmcoap.Listener, error) {
+
+	return s.txvr.ListenCoap(mc)
+}
+
+func (s *ReplaySesn) StopListenCoap(mc nmcoap.MsgCriteria) {
+	s.txvr.StopListenCoap(mc)
+}
+
+func (s *ReplaySesn) Filters() (nmcoap.TxMsgFilter, nmcoap.RxMsgFilter) {
+	return s.txvr.Filters()
+}
+
+func (s *ReplaySesn) SetFilters(txFilter nmcoap.TxMsgFilter,
+	rxFilter nmcoap.RxMsgFilter) {
+
+	s.txvr.SetFilters(txFilter, rxFilter)
+}
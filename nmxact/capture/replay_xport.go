@@ -0,0 +1,167 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package capture
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+type ReplayXportCfg struct {
+	// Capture file to replay.  Ignored if Records is non-nil.
+	Path string
+
+	// Records to replay.
+	Records []Record
+
+	// If true, responses are delayed by the interval observed when they
+	// were recorded.  Otherwise, responses are delivered immediately.
+	Realtime bool
+
+	// ID of the first recorded session to replay.  Recorded sessions with
+	// lower IDs are skipped.
+	Sesn int
+}
+
+func NewReplayXportCfg() *ReplayXportCfg {
+	return &ReplayXportCfg{}
+}
+
+// ReplayXport serves recorded responses back to clients.  Each session built
+// from the transport replays the next session contained in the capture, in
+// order of session ID.
+type ReplayXport struct {
+	cfg      *ReplayXportCfg
+	sesns    []recSesn
+	nextSesn int
+	started  bool
+
+	sync.Mutex
+}
+
+func NewReplayXport(cfg *ReplayXportCfg) *ReplayXport {
+	return &ReplayXport{
+		cfg: cfg,
+	}
+}
+
+// The records belonging to one recorded session.
+type recSesn struct {
+	id   int
+	recs []Record
+}
+
+// Splits a capture into per-session record lists, ordered by session ID.
+func splitSesns(recs []Record) []recSesn {
+	idxMap := map[int]int{}
+	var sesns []recSesn
+
+	for _, r := range recs {
+		idx, ok := idxMap[r.Sesn]
+		if !ok {
+			idx = len(sesns)
+			idxMap[r.Sesn] = idx
+			sesns = append(sesns, recSesn{id: r.Sesn})
+		}
+		sesns[idx].recs = append(sesns[idx].recs, r)
+	}
+
+	sort.SliceStable(sesns, func(i int, j int) bool {
+		return sesns[i].id < sesns[j].id
+	})
+
+	return sesns
+}
+
+func (rx *ReplayXport) Start() error {
+	rx.Lock()
+	defer rx.Unlock()
+
+	if rx.started {
+		return nmxutil.NewXportError("Replay xport started twice")
+	}
+
+	recs := rx.cfg.Records
+	if recs == nil {
+		f, err := os.Open(rx.cfg.Path)
+		if err != nil {
+			return nmxutil.NewXportError(err.Error())
+		}
+		defer f.Close()
+
+		recs, err = ReadRecords(f)
+		if err != nil {
+			return nmxutil.NewXportError(err.Error())
+		}
+	}
+
+	rx.sesns = splitSesns(recs)
+	rx.nextSesn = sort.Search(len(rx.sesns), func(i int) bool {
+		return rx.sesns[i].id >= rx.cfg.Sesn
+	})
+	if rx.nextSesn >= len(rx.sesns) {
+		return nmxutil.NewXportError(fmt.Sprintf(
+			"Capture contains no session with ID %d or greater",
+			rx.cfg.Sesn))
+	}
+	rx.started = true
+
+	return nil
+}
+
+func (rx *ReplayXport) Stop() error {
+	rx.Lock()
+	defer rx.Unlock()
+
+	if !rx.started {
+		return nmxutil.NewXportError("Replay xport stopped twice")
+	}
+
+	rx.started = false
+	return nil
+}
+
+func (rx *ReplayXport) BuildSesn(cfg sesn.SesnCfg) (sesn.Sesn, error) {
+	rx.Lock()
+	defer rx.Unlock()
+
+	if !rx.started {
+		return nil, nmxutil.NewXportError("Replay xport not started")
+	}
+
+	if rx.nextSesn >= len(rx.sesns) {
+		return nil, fmt.Errorf("Capture contains no more sessions; "+
+			"count=%d", len(rx.sesns))
+	}
+
+	rs := rx.sesns[rx.nextSesn]
+	rx.nextSesn++
+
+	return newReplaySesn(rx, cfg, rs.id, rs.recs), nil
+}
+
+func (rx *ReplayXport) Tx(data []byte) error {
+	return nmxutil.NewXportError("unsupported")
+}
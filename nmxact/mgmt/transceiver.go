@@ -21,9 +21,12 @@ package mgmt
 
 import (
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/rand"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/runtimeco/go-coap"
@@ -50,6 +53,17 @@ type Transceiver struct {
 	isTcp bool
 	proto sesn.MgmtProto
 	wg    sync.WaitGroup
+
+	// SMP header version used for outgoing requests.  Accessed atomically.
+	smpVer uint32
+
+	// Sequence number allocation.  The mutex also serializes listener
+	// registration so that a number cannot be claimed twice.
+	nextSeq uint8
+	seqMtx  sync.Mutex
+
+	// Receives raw incoming frames; holds a sesn.RxTapFn.
+	rxTap atomic.Value
 }
 
 func NewTransceiver(txFilter nmcoap.TxMsgFilter, rxFilter nmcoap.RxMsgFilter, isTcp bool,
@@ -59,25 +73,117 @@ func NewTransceiver(txFilter nmcoap.TxMsgFilter, rxFilter nmcoap.RxMsgFilter, is
 		txFilter: txFilter,
 		isTcp:    isTcp,
 		proto:    mgmtProto,
+		smpVer:   uint32(nmp.DfltVersion),
+		nextSeq:  uint8(rand.Uint32()),
 	}
 
 	if mgmtProto == sesn.MGMT_PROTO_NMP {
 		t.nd = nmp.NewDispatcher(logDepth)
+		t.nd.SetRxTap(func(pkt []byte) { t.tap(pkt, false) })
 	}
 
 	od, err := omp.NewDispatcher(rxFilter, isTcp, logDepth)
 	if err != nil {
 		return nil, err
 	}
+	od.SetRxTap(func(msg []byte) { t.tap(msg, true) })
 	t.od = od
 
 	return t, nil
 }
 
+// Registers a function that receives every complete incoming frame, after
+// reassembly and before decoding.
+func (t *Transceiver) SetRxTap(tap sesn.RxTapFn) {
+	t.rxTap.Store(tap)
+}
+
+func (t *Transceiver) tap(frame []byte, coap bool) {
+	if tap, _ := t.rxTap.Load().(sesn.RxTapFn); tap != nil {
+		tap(frame, coap)
+	}
+}
+
+func (t *Transceiver) seqInUse(seq uint8) bool {
+	if t.nd != nil {
+		return t.nd.HasListener(seq)
+	} else {
+		return t.od.HasNmpListener(seq)
+	}
+}
+
+// Indicates whether a listener is waiting for the response to the request
+// with the specified sequence number.
+func (t *Transceiver) Listening(seq uint8) bool {
+	t.seqMtx.Lock()
+	defer t.seqMtx.Unlock()
+
+	return t.seqInUse(seq)
+}
+
+func (t *Transceiver) nextSeqNoLock() uint8 {
+	for i := 0; i < 256; i++ {
+		seq := t.nextSeq
+		t.nextSeq++
+
+		if !t.seqInUse(seq) {
+			return seq
+		}
+	}
+
+	// Every number is in use; registering a listener will fail.
+	seq := t.nextSeq
+	t.nextSeq++
+	return seq
+}
+
+// Allocates a sequence number for an outgoing management request.  Numbers
+// in use by outstanding requests are skipped.
+func (t *Transceiver) NextSeq() uint8 {
+	t.seqMtx.Lock()
+	defer t.seqMtx.Unlock()
+
+	return t.nextSeqNoLock()
+}
+
+// Registers a listener for the response to the specified request.  If the
+// request's sequence number is already in use by another outstanding
+// request, the request is assigned a free number.  The request's SeqCb, if
+// any, is called with the final number.
+func (t *Transceiver) addListener(req *nmp.NmpMsg) (*nmp.Listener, error) {
+	t.seqMtx.Lock()
+	defer t.seqMtx.Unlock()
+
+	if t.seqInUse(req.Hdr.Seq) {
+		seq := t.nextSeqNoLock()
+		log.Debugf("NMP seq %d already in use; using %d instead",
+			req.Hdr.Seq, seq)
+		req.Hdr.Seq = seq
+	}
+
+	var nl *nmp.Listener
+	var err error
+	if t.nd != nil {
+		nl, err = t.nd.AddReqListener(&req.Hdr)
+	} else {
+		nl, err = t.od.AddReqNmpListener(&req.Hdr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if req.SeqCb != nil {
+		req.SeqCb(req.Hdr.Seq)
+	}
+	return nl, nil
+}
+
 func (t *Transceiver) txRxNmp(txCb TxFn, req *nmp.NmpMsg, mtu int,
 	timeout time.Duration) (nmp.NmpRsp, error) {
 
-	nl, err := t.nd.AddListener(req.Hdr.Seq)
+	req.Hdr.Version = t.SmpVersion()
+
+	nl, err := t.addListener(req)
 	if err != nil {
 		return nil, err
 	}
@@ -117,45 +223,58 @@ func (t *Transceiver) txRxNmp(txCb TxFn, req *nmp.NmpMsg, mtu int,
 func (t *Transceiver) txRxNmpAsync(txCb TxFn, req *nmp.NmpMsg, mtu int,
 	timeout time.Duration, ch chan nmp.NmpRsp, errc chan error) error {
 
-	nl, err := t.nd.AddListener(req.Hdr.Seq)
+	req.Hdr.Version = t.SmpVersion()
+
+	nl, err := t.addListener(req)
 	if err != nil {
 		return err
 	}
 
 	b, err := nmp.EncodeNmpPlain(req)
 	if err != nil {
+		t.nd.RemoveListener(req.Hdr.Seq)
 		return err
 	}
 
 	log.Debugf("Tx NMP async request: seq %d %s", req.Hdr.Seq, hex.Dump(b))
 	if t.isTcp == false && len(b) > mtu {
+		t.nd.RemoveListener(req.Hdr.Seq)
 		return fmt.Errorf("Request too big")
 	}
 	frags := nmxutil.Fragment(b, mtu)
 	for _, frag := range frags {
 		if err := txCb(frag); err != nil {
+			t.nd.RemoveListener(req.Hdr.Seq)
 			return err
 		}
 	}
 
 	// Now wait for NMP response.
 	go func() {
-		defer t.nd.RemoveListener(req.Hdr.Seq)
-		for {
-			select {
-			case err := <-nl.ErrChan:
-				errc <- err
-				return
-			case rsp := <-nl.RspChan:
-				ch <- rsp
-				return
-			case _, ok := <-nl.AfterTimeout(timeout):
-				if ok {
-					errc <- nmxutil.NewRspTimeoutError("NMP timeout")
-					return
+		rsp, err := func() (nmp.NmpRsp, error) {
+			defer t.nd.RemoveListener(req.Hdr.Seq)
+			for {
+				select {
+				case err := <-nl.ErrChan:
+					return nil, err
+				case rsp := <-nl.RspChan:
+					return rsp, nil
+				case _, ok := <-nl.AfterTimeout(timeout):
+					if ok {
+						return nil, nmxutil.NewRspTimeoutError("NMP timeout")
+					}
 				}
 			}
+		}()
+
+		if err == nil && t.checkRspVersion(req, rsp) {
+			rsp, err = t.txRxNmp(txCb, req, mtu, timeout)
+		}
 
+		if err != nil {
+			errc <- err
+		} else {
+			ch <- rsp
 		}
 	}()
 
@@ -165,7 +284,9 @@ func (t *Transceiver) txRxNmpAsync(txCb TxFn, req *nmp.NmpMsg, mtu int,
 func (t *Transceiver) txRxOmp(txCb TxFn, req *nmp.NmpMsg, mtu int,
 	timeout time.Duration) (nmp.NmpRsp, error) {
 
-	nl, err := t.od.AddNmpListener(req.Hdr.Seq)
+	req.Hdr.Version = t.SmpVersion()
+
+	nl, err := t.addListener(req)
 	if err != nil {
 		return nil, err
 	}
@@ -211,11 +332,13 @@ func (t *Transceiver) txRxOmp(txCb TxFn, req *nmp.NmpMsg, mtu int,
 func (t *Transceiver) txRxOmpAsync(txCb TxFn, req *nmp.NmpMsg, mtu int,
 	timeout time.Duration, ch chan nmp.NmpRsp, errc chan error) error {
 
-	seq := req.Hdr.Seq
-	nl, err := t.od.AddNmpListener(seq)
+	req.Hdr.Version = t.SmpVersion()
+
+	nl, err := t.addListener(req)
 	if err != nil {
 		return err
 	}
+	seq := req.Hdr.Seq
 
 	var b []byte
 	if t.isTcp {
@@ -224,12 +347,14 @@ func (t *Transceiver) txRxOmpAsync(txCb TxFn, req *nmp.NmpMsg, mtu int,
 		b, err = omp.EncodeOmpDgram(t.txFilter, req)
 	}
 	if err != nil {
+		t.od.RemoveNmpListener(seq)
 		return err
 	}
 
 	log.Debugf("Tx OMP request: %v %s", seq, hex.Dump(b))
 
 	if t.isTcp == false && len(b) > mtu {
+		t.od.RemoveNmpListener(seq)
 		return fmt.Errorf("Request too big")
 	}
 	frags := nmxutil.Fragment(b, mtu)
@@ -243,28 +368,37 @@ func (t *Transceiver) txRxOmpAsync(txCb TxFn, req *nmp.NmpMsg, mtu int,
 
 	// Now wait for NMP response.
 	go func() {
-		defer t.od.RemoveNmpListener(seq)
-		for {
-			select {
-			case err := <-nl.ErrChan:
-				log.Debugf("Error reported %v seq %v", err, seq)
-				errc <- err
-				return
-			case rsp := <-nl.RspChan:
-				ch <- rsp
-				return
-			case _, ok := <-nl.AfterTimeout(timeout):
-				if ok {
-					errc <- fmt.Errorf("Request timedout")
-					return
+		rsp, err := func() (nmp.NmpRsp, error) {
+			defer t.od.RemoveNmpListener(seq)
+			for {
+				select {
+				case err := <-nl.ErrChan:
+					log.Debugf("Error reported %v seq %v", err, seq)
+					return nil, err
+				case rsp := <-nl.RspChan:
+					return rsp, nil
+				case _, ok := <-nl.AfterTimeout(timeout):
+					if ok {
+						return nil, fmt.Errorf("Request timedout")
+					}
 				}
 			}
+		}()
+
+		if err == nil && t.checkRspVersion(req, rsp) {
+			rsp, err = t.txRxOmp(txCb, req, mtu, timeout)
+		}
+
+		if err != nil {
+			errc <- err
+		} else {
+			ch <- rsp
 		}
 	}()
 	return nil
 }
 
-func (t *Transceiver) TxRxMgmt(txCb TxFn, req *nmp.NmpMsg, mtu int,
+func (t *Transceiver) txRxMgmt(txCb TxFn, req *nmp.NmpMsg, mtu int,
 	timeout time.Duration) (nmp.NmpRsp, error) {
 
 	if t.nd != nil {
@@ -274,6 +408,21 @@ func (t *Transceiver) TxRxMgmt(txCb TxFn, req *nmp.NmpMsg, mtu int,
 	}
 }
 
+func (t *Transceiver) TxRxMgmt(txCb TxFn, req *nmp.NmpMsg, mtu int,
+	timeout time.Duration) (nmp.NmpRsp, error) {
+
+	rsp, err := t.txRxMgmt(txCb, req, mtu, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.checkRspVersion(req, rsp) {
+		return t.txRxMgmt(txCb, req, mtu, timeout)
+	}
+
+	return rsp, nil
+}
+
 func (t *Transceiver) TxRxMgmtAsync(txCb TxFn, req *nmp.NmpMsg, mtu int,
 	timeout time.Duration, ch chan nmp.NmpRsp, errc chan error) error {
 
@@ -352,6 +501,45 @@ func (t *Transceiver) ErrorAll(err error) {
 	t.od.ErrorAll(err)
 }
 
+// Returns the SMP header version used for outgoing requests.
+func (t *Transceiver) SmpVersion() uint8 {
+	return uint8(atomic.LoadUint32(&t.smpVer))
+}
+
+func (t *Transceiver) SetSmpVersion(ver uint8) {
+	atomic.StoreUint32(&t.smpVer, uint32(ver))
+}
+
+// Detects a peer that does not support SMP version 2.  Such a peer either
+// responds with a version 1 header or rejects the request as too new.  In
+// either case, the transceiver falls back to version 1.  Returns true if the
+// request failed because of its version and should be resent.
+func (t *Transceiver) checkRspVersion(req *nmp.NmpMsg, rsp nmp.NmpRsp) bool {
+	if req.Hdr.Version != nmp.NMP_VER2 || rsp == nil {
+		return false
+	}
+
+	var rc int
+	var merr *nmp.MgmtError
+	if errors.As(nmp.RspError(rsp), &merr) && !merr.GroupSpecific {
+		rc = merr.Rc
+	}
+
+	if rsp.Hdr().Version == nmp.NMP_VER2 &&
+		rc != nmp.NMP_ERR_EUNSUPPORTED_TOO_NEW {
+
+		return false
+	}
+
+	if t.SmpVersion() == nmp.NMP_VER2 {
+		log.Debugf("Peer does not support SMP version 2; " +
+			"falling back to version 1")
+		t.SetSmpVersion(nmp.NMP_VER1)
+	}
+
+	return rc != 0
+}
+
 func (t *Transceiver) AbortRx(seq uint8) {
 	t.ErrorOne(seq, fmt.Errorf("rx aborted"))
 }
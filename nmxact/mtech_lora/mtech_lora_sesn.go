@@ -44,6 +44,7 @@ import (
 type LoraSesn struct {
 	cfg           sesn.SesnCfg
 	txvr          *mgmt.Transceiver
+	rxTap         sesn.RxTapFn
 	isOpen        bool
 	mtu           int
 	xport         *LoraXport
@@ -94,6 +95,7 @@ func (s *LoraSesn) Open() error {
 		return err
 	}
 	s.txvr = txvr
+	s.txvr.SetRxTap(s.rxTap)
 	s.stopChan = make(chan struct{})
 
 	msgType := "rsp"
@@ -329,10 +331,17 @@ func (s *LoraSesn) TxRxMgmtAsync(m *nmp.NmpMsg,
 }
 
 func (s *LoraSesn) AbortRx(seq uint8) error {
-	s.txvr.ErrorAll(fmt.Errorf("Rx aborted"))
+	s.txvr.AbortRx(seq)
 	return nil
 }
 
+func (s *LoraSesn) NextSeq() uint8 {
+	if s.txvr == nil {
+		return nmxutil.NextNmpSeq()
+	}
+	return s.txvr.NextSeq()
+}
+
 func (s *LoraSesn) TxCoap(m coap.Message) error {
 	if !s.IsOpen() {
 		return nmxutil.NewSesnClosedError(
@@ -446,3 +455,10 @@ func (s *LoraSesn) SetFilters(txFilter nmcoap.TxMsgFilter,
 
 	s.txvr.SetFilters(txFilter, rxFilter)
 }
+
+func (s *LoraSesn) SetRxTap(tap sesn.RxTapFn) {
+	s.rxTap = tap
+	if s.txvr != nil {
+		s.txvr.SetRxTap(tap)
+	}
+}
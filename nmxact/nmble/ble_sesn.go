@@ -57,6 +57,10 @@ func (s *BleSesn) AbortRx(seq uint8) error {
 	return s.Ns.AbortRx(seq)
 }
 
+func (s *BleSesn) NextSeq() uint8 {
+	return s.Ns.NextSeq()
+}
+
 func (s *BleSesn) Open() error {
 	if err := s.bx.AcquireMasterPrimary(s); err != nil {
 		return err
@@ -145,3 +149,7 @@ func (s *BleSesn) SetFilters(txFilter nmcoap.TxMsgFilter,
 
 	s.Ns.SetFilters(txFilter, rxFilter)
 }
+
+func (s *BleSesn) SetRxTap(tap sesn.RxTapFn) {
+	s.Ns.SetRxTap(tap)
+}
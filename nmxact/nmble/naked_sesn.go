@@ -61,6 +61,7 @@ type NakedSesn struct {
 	conn     *Conn
 	mgmtChrs BleMgmtChrs
 	txvr     *mgmt.Transceiver
+	rxTap    sesn.RxTapFn
 	tq       task.TaskQueue
 
 	wg sync.WaitGroup
@@ -91,6 +92,7 @@ func (s *NakedSesn) init() error {
 		return err
 	}
 	s.txvr = txvr
+	s.txvr.SetRxTap(s.rxTap)
 
 	s.tq.Stop(fmt.Errorf("Ensuring task is stopped"))
 	if err := s.tq.Start(10); err != nil {
@@ -409,6 +411,13 @@ func (s *NakedSesn) AbortRx(seq uint8) error {
 	return s.runTask(fn)
 }
 
+func (s *NakedSesn) NextSeq() uint8 {
+	if s.txvr == nil {
+		return nmxutil.NextNmpSeq()
+	}
+	return s.txvr.NextSeq()
+}
+
 func (s *NakedSesn) Close() error {
 	if err := s.failIfNotOpen(); err != nil {
 		return err
@@ -663,3 +672,10 @@ func (s *NakedSesn) SetFilters(txFilter nmcoap.TxMsgFilter,
 
 	s.txvr.SetFilters(txFilter, rxFilter)
 }
+
+func (s *NakedSesn) SetRxTap(tap sesn.RxTapFn) {
+	s.rxTap = tap
+	if s.txvr != nil {
+		s.txvr.SetRxTap(tap)
+	}
+}
@@ -34,6 +34,7 @@ import (
 type Dispatcher struct {
 	listeners []*Listener
 	rxer      Receiver
+	rxTap     func(msg []byte)
 	logDepth  int
 	mtx       sync.Mutex
 }
@@ -47,6 +48,22 @@ func NewDispatcher(isTcp bool, logDepth int) *Dispatcher {
 	return d
 }
 
+// Registers a function that receives every complete incoming message before
+// it is parsed.  This must be called before the dispatcher is used.
+func (d *Dispatcher) SetRxTap(tap func(msg []byte)) {
+	d.rxTap = tap
+}
+
+// Reassembles and parses an incoming message.
+func (d *Dispatcher) rx(data []byte) coap.Message {
+	msg, raw := d.rxer.RxRaw(data)
+	if msg != nil && d.rxTap != nil {
+		d.rxTap(raw)
+	}
+
+	return msg
+}
+
 func (d *Dispatcher) findListenerIdx(mc MsgCriteria) int {
 	for i, lner := range d.listeners {
 		if CompareMsgCriteria(lner.Criteria, mc) == 0 {
@@ -105,7 +122,7 @@ func (d *Dispatcher) RemoveListener(mc MsgCriteria) *Listener {
 // Returns true if the response was dispatched.
 func (d *Dispatcher) Dispatch(data []byte) bool {
 	// See if this fragment completes a packet.
-	msg := d.rxer.Rx(data)
+	msg := d.rx(data)
 	if msg == nil {
 		return false
 	}
@@ -125,7 +142,7 @@ func (d *Dispatcher) Dispatch(data []byte) bool {
 }
 
 func (d *Dispatcher) ProcessCoapReq(data []byte) (coap.Message, error) {
-	m := d.rxer.Rx(data)
+	m := d.rx(data)
 	if m == nil {
 		return nil, nil
 	}
@@ -33,21 +33,34 @@ func NewReassembler() *Reassembler {
 }
 
 func (r *Reassembler) RxFrag(frag []byte) *coap.TcpMessage {
+	tm, _ := r.RxFragRaw(frag)
+	return tm
+}
+
+// Like RxFrag, but also returns the bytes that made up the completed
+// message.
+func (r *Reassembler) RxFragRaw(frag []byte) (*coap.TcpMessage, []byte) {
 	r.cur = append(r.cur, frag...)
 
-	var tm *coap.TcpMessage
-	var err error
-	tm, r.cur, err = coap.PullTcp(r.cur)
+	tm, rest, err := coap.PullTcp(r.cur)
 	if err != nil {
+		// The stream is out of sync; discard everything received so far.
 		log.Debugf("received invalid CoAP-TCP packet: %s", err.Error())
-		panic("GA")
-		return nil
+		r.cur = nil
+		return nil, nil
 	}
 
 	if tm == nil {
-		return nil
+		return nil, nil
 	}
 
-	r.cur = nil
-	return tm
+	raw := r.cur[:len(r.cur)-len(rest)]
+
+	// Retain any bytes belonging to the next message.
+	if len(rest) > 0 {
+		r.cur = append([]byte{}, rest...)
+	} else {
+		r.cur = nil
+	}
+	return tm, raw
 }
@@ -39,21 +39,27 @@ func NewReceiver(isTcp bool) Receiver {
 }
 
 func (r *Receiver) Rx(data []byte) coap.Message {
+	m, _ := r.RxRaw(data)
+	return m
+}
+
+// Like Rx, but also returns the bytes that made up the received message.
+func (r *Receiver) RxRaw(data []byte) (coap.Message, []byte) {
 	if r.reassembler != nil {
 		// TCP.
-		tm := r.reassembler.RxFrag(data)
+		tm, raw := r.reassembler.RxFragRaw(data)
 		if tm == nil {
-			return nil
+			return nil, nil
 		}
-		return tm
+		return tm, raw
 	} else {
 		// UDP.
 		m, err := coap.ParseDgramMessage(data)
 		if err != nil {
 			log.Debugf("CoAP parse failure: %s", err.Error())
-			return nil
+			return nil, nil
 		}
 
-		return m
+		return m, data
 	}
 }
@@ -0,0 +1,514 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+// Package nmimage parses Mynewt / MCUboot image files: the image header, the
+// version, and the TLV trailer carrying the image hash, key hashes and
+// signatures.
+package nmimage
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	IMAGE_MAGIC        = 0x96f3b83d
+	IMAGE_MAGIC_LEGACY = 0x96f3b83c
+	IMAGE_HEADER_SIZE  = 32
+
+	IMAGE_TLV_INFO_MAGIC      = 0x6907
+	IMAGE_TLV_PROT_INFO_MAGIC = 0x6908
+	IMAGE_TLV_INFO_SIZE       = 4
+	IMAGE_TLV_HDR_SIZE        = 4
+)
+
+// Image header flags.
+const (
+	IMAGE_F_PIC              = 0x00000001
+	IMAGE_F_ENCRYPTED_AES128 = 0x00000004
+	IMAGE_F_ENCRYPTED_AES256 = 0x00000008
+	IMAGE_F_NON_BOOTABLE     = 0x00000010
+	IMAGE_F_RAM_LOAD         = 0x00000020
+	IMAGE_F_ROM_FIXED        = 0x00000100
+)
+
+// TLV types.
+const (
+	IMAGE_TLV_KEYHASH     = 0x01
+	IMAGE_TLV_PUBKEY      = 0x02
+	IMAGE_TLV_SHA256      = 0x10
+	IMAGE_TLV_SHA384      = 0x11
+	IMAGE_TLV_SHA512      = 0x12
+	IMAGE_TLV_RSA2048_PSS = 0x20
+	IMAGE_TLV_ECDSA224    = 0x21
+	IMAGE_TLV_ECDSA_SIG   = 0x22
+	IMAGE_TLV_RSA3072_PSS = 0x23
+	IMAGE_TLV_ED25519     = 0x24
+	IMAGE_TLV_SIG_PURE    = 0x25
+	IMAGE_TLV_ENC_RSA2048 = 0x30
+	IMAGE_TLV_ENC_KW      = 0x31
+	IMAGE_TLV_ENC_EC256   = 0x32
+	IMAGE_TLV_ENC_X25519  = 0x33
+	IMAGE_TLV_DEPENDENCY  = 0x40
+	IMAGE_TLV_SEC_CNT     = 0x50
+	IMAGE_TLV_BOOT_RECORD = 0x60
+)
+
+// Size of the value of a DEPENDENCY TLV.
+const IMAGE_TLV_DEPENDENCY_SZ = 12
+
+var imageFlagNames = map[uint32]string{
+	IMAGE_F_PIC:              "pic",
+	IMAGE_F_ENCRYPTED_AES128: "encrypted-aes128",
+	IMAGE_F_ENCRYPTED_AES256: "encrypted-aes256",
+	IMAGE_F_NON_BOOTABLE:     "non-bootable",
+	IMAGE_F_RAM_LOAD:         "ram-load",
+	IMAGE_F_ROM_FIXED:        "rom-fixed",
+}
+
+var imageTlvTypeNames = map[uint16]string{
+	IMAGE_TLV_KEYHASH:     "KEYHASH",
+	IMAGE_TLV_PUBKEY:      "PUBKEY",
+	IMAGE_TLV_SHA256:      "SHA256",
+	IMAGE_TLV_SHA384:      "SHA384",
+	IMAGE_TLV_SHA512:      "SHA512",
+	IMAGE_TLV_RSA2048_PSS: "RSA2048_PSS",
+	IMAGE_TLV_ECDSA224:    "ECDSA224",
+	IMAGE_TLV_ECDSA_SIG:   "ECDSA_SIG",
+	IMAGE_TLV_RSA3072_PSS: "RSA3072_PSS",
+	IMAGE_TLV_ED25519:     "ED25519",
+	IMAGE_TLV_SIG_PURE:    "SIG_PURE",
+	IMAGE_TLV_ENC_RSA2048: "ENC_RSA2048",
+	IMAGE_TLV_ENC_KW:      "ENC_KW",
+	IMAGE_TLV_ENC_EC256:   "ENC_EC256",
+	IMAGE_TLV_ENC_X25519:  "ENC_X25519",
+	IMAGE_TLV_DEPENDENCY:  "DEPENDENCY",
+	IMAGE_TLV_SEC_CNT:     "SEC_CNT",
+	IMAGE_TLV_BOOT_RECORD: "BOOT_RECORD",
+}
+
+// Indicates whether a TLV of the specified type carries a signature.
+func IsSigTlvType(typ uint16) bool {
+	switch typ {
+	case IMAGE_TLV_RSA2048_PSS, IMAGE_TLV_ECDSA224, IMAGE_TLV_ECDSA_SIG,
+		IMAGE_TLV_RSA3072_PSS, IMAGE_TLV_ED25519, IMAGE_TLV_SIG_PURE:
+		return true
+	default:
+		return false
+	}
+}
+
+func TlvTypeToString(typ uint16) string {
+	if name, ok := imageTlvTypeNames[typ]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", typ)
+}
+
+func FlagsToString(flags uint32) string {
+	var bits []uint32
+	for bit, _ := range imageFlagNames {
+		bits = append(bits, bit)
+	}
+	sort.Slice(bits, func(i, j int) bool { return bits[i] < bits[j] })
+
+	strs := []string{}
+	for _, bit := range bits {
+		if flags&bit != 0 {
+			strs = append(strs, imageFlagNames[bit])
+			flags &^= bit
+		}
+	}
+	if flags != 0 {
+		strs = append(strs, fmt.Sprintf("0x%x", flags))
+	}
+
+	return strings.Join(strs, " ")
+}
+
+type ImageVersion struct {
+	Major    uint8
+	Minor    uint8
+	Rev      uint16
+	BuildNum uint32
+}
+
+// String formats the version the way Mynewt devices report it: the build
+// number is omitted if it is zero.
+func (v ImageVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Rev)
+	if v.BuildNum != 0 {
+		s += fmt.Sprintf(".%d", v.BuildNum)
+	}
+	return s
+}
+
+type ImageHdr struct {
+	Magic     uint32
+	LoadAddr  uint32
+	HdrSz     uint16
+	ProtTlvSz uint16
+	ImgSz     uint32
+	Flags     uint32
+	Vers      ImageVersion
+	Pad1      uint32
+}
+
+type ImageTlv struct {
+	Type uint16
+	Data []byte
+
+	// The TLV is covered by the image hash.
+	Protected bool
+
+	// Offset of the TLV header within the image file.
+	Offset int
+}
+
+func (t ImageTlv) String() string {
+	return fmt.Sprintf("%s len=%d", TlvTypeToString(t.Type), len(t.Data))
+}
+
+// ImageDependency is the content of a DEPENDENCY TLV: the image requires the
+// specified minimum version of another image.
+type ImageDependency struct {
+	ImageNum int
+	Vers     ImageVersion
+}
+
+type Image struct {
+	Hdr  ImageHdr
+	Tlvs []ImageTlv
+
+	// The entire image file.
+	Data []byte
+
+	// Length of the hashed part of the image: header, body and protected
+	// TLVs.
+	HashLen int
+
+	// Length of the image including its TLV trailer.  Anything following
+	// the trailer in the file (e.g., flash padding) is not part of the
+	// image.
+	TotalLen int
+}
+
+func readTlvArea(data []byte, off int, magic uint16,
+	protected bool) ([]ImageTlv, int, error) {
+
+	if off+IMAGE_TLV_INFO_SIZE > len(data) {
+		return nil, 0, fmt.Errorf("Image truncated; TLV info at offset %d "+
+			"missing", off)
+	}
+	if m := binary.LittleEndian.Uint16(data[off:]); m != magic {
+		return nil, 0, fmt.Errorf("Invalid TLV info magic at offset %d; "+
+			"have=0x%04x want=0x%04x", off, m, magic)
+	}
+
+	tot := int(binary.LittleEndian.Uint16(data[off+2:]))
+
+	parse := func(end int) ([]ImageTlv, bool) {
+		if end > len(data) {
+			return nil, false
+		}
+
+		tlvs := []ImageTlv{}
+		cur := off + IMAGE_TLV_INFO_SIZE
+		for cur < end {
+			if cur+IMAGE_TLV_HDR_SIZE > end {
+				return nil, false
+			}
+			typ := binary.LittleEndian.Uint16(data[cur:])
+			tlvLen := int(binary.LittleEndian.Uint16(data[cur+2:]))
+			val := cur + IMAGE_TLV_HDR_SIZE
+			if val+tlvLen > end {
+				return nil, false
+			}
+
+			tlvs = append(tlvs, ImageTlv{
+				Type:      typ,
+				Data:      data[val : val+tlvLen],
+				Protected: protected,
+				Offset:    cur,
+			})
+			cur = val + tlvLen
+		}
+
+		return tlvs, true
+	}
+
+	// The TLV total includes the info header.  Some older tools wrote the
+	// unprotected total without it; accept that too.
+	if tlvs, ok := parse(off + tot); ok {
+		return tlvs, off + tot, nil
+	}
+	if !protected {
+		end := off + IMAGE_TLV_INFO_SIZE + tot
+		if tlvs, ok := parse(end); ok {
+			return tlvs, end, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("Invalid TLV area at offset %d; total=%d "+
+		"file-size=%d", off, tot, len(data))
+}
+
+// Parse decodes the header and TLV trailer of an image file.  It checks the
+// structure of the file only; use Validate to check its contents.
+func Parse(data []byte) (*Image, error) {
+	img := &Image{
+		Data: data,
+	}
+
+	if len(data) < IMAGE_HEADER_SIZE {
+		return nil, fmt.Errorf("Not an image; file too short (%d bytes)",
+			len(data))
+	}
+
+	if err := binary.Read(bytes.NewReader(data), binary.LittleEndian,
+		&img.Hdr); err != nil {
+
+		return nil, err
+	}
+
+	switch img.Hdr.Magic {
+	case IMAGE_MAGIC:
+	case IMAGE_MAGIC_LEGACY:
+		return nil, fmt.Errorf("Unsupported legacy image format (magic 0x%08x)",
+			img.Hdr.Magic)
+	default:
+		return nil, fmt.Errorf("Not an image; bad magic 0x%08x",
+			img.Hdr.Magic)
+	}
+
+	if img.Hdr.HdrSz < IMAGE_HEADER_SIZE {
+		return nil, fmt.Errorf("Invalid image header size %d", img.Hdr.HdrSz)
+	}
+
+	off := int(img.Hdr.HdrSz) + int(img.Hdr.ImgSz)
+	if off > len(data) {
+		return nil, fmt.Errorf("Image truncated; header-size=%d "+
+			"image-size=%d file-size=%d", img.Hdr.HdrSz, img.Hdr.ImgSz,
+			len(data))
+	}
+
+	if img.Hdr.ProtTlvSz > 0 {
+		tlvs, end, err := readTlvArea(data, off, IMAGE_TLV_PROT_INFO_MAGIC,
+			true)
+		if err != nil {
+			return nil, err
+		}
+		if end-off != int(img.Hdr.ProtTlvSz) {
+			return nil, fmt.Errorf("Protected TLV size mismatch; header=%d "+
+				"trailer=%d", img.Hdr.ProtTlvSz, end-off)
+		}
+		img.Tlvs = append(img.Tlvs, tlvs...)
+		off = end
+	}
+	img.HashLen = off
+
+	tlvs, end, err := readTlvArea(data, off, IMAGE_TLV_INFO_MAGIC, false)
+	if err != nil {
+		return nil, err
+	}
+	img.Tlvs = append(img.Tlvs, tlvs...)
+	img.TotalLen = end
+
+	return img, nil
+}
+
+// FindTlvs returns the TLVs of the specified type, in file order.
+func (img *Image) FindTlvs(typ uint16) []ImageTlv {
+	var tlvs []ImageTlv
+	for _, t := range img.Tlvs {
+		if t.Type == typ {
+			tlvs = append(tlvs, t)
+		}
+	}
+
+	return tlvs
+}
+
+// The hash TLV types MCUboot supports, and the digest each one records.
+var hashTlvAlgs = map[uint16]crypto.Hash{
+	IMAGE_TLV_SHA256: crypto.SHA256,
+	IMAGE_TLV_SHA384: crypto.SHA384,
+	IMAGE_TLV_SHA512: crypto.SHA512,
+}
+
+// hashAlgBySize returns the supported digest that produces hashes of the
+// specified length, or 0 if there is none.
+func hashAlgBySize(sz int) crypto.Hash {
+	for _, alg := range hashTlvAlgs {
+		if alg.Size() == sz {
+			return alg
+		}
+	}
+
+	return 0
+}
+
+// hashTlvs returns the image's hash TLVs of every supported type, in file
+// order.
+func (img *Image) hashTlvs() []ImageTlv {
+	var tlvs []ImageTlv
+	for _, t := range img.Tlvs {
+		if _, ok := hashTlvAlgs[t.Type]; ok {
+			tlvs = append(tlvs, t)
+		}
+	}
+
+	return tlvs
+}
+
+// HashType returns the type of the image's hash TLV (IMAGE_TLV_SHA256,
+// IMAGE_TLV_SHA384 or IMAGE_TLV_SHA512).  Images without a hash TLV are
+// treated as SHA256 images.
+func (img *Image) HashType() uint16 {
+	tlvs := img.hashTlvs()
+	if len(tlvs) == 0 {
+		return IMAGE_TLV_SHA256
+	}
+
+	return tlvs[0].Type
+}
+
+// Hash returns the hash recorded in the image, or nil if there is none.
+// This is the hash the device reports for the image once it is uploaded.
+func (img *Image) Hash() []byte {
+	tlvs := img.hashTlvs()
+	if len(tlvs) == 0 {
+		return nil
+	}
+
+	return tlvs[0].Data
+}
+
+// CalcHash computes the hash over the header, body and protected TLVs, using
+// the digest named by the image's hash TLV.
+func (img *Image) CalcHash() []byte {
+	h := hashTlvAlgs[img.HashType()].New()
+	h.Write(img.Data[:img.HashLen])
+	return h.Sum(nil)
+}
+
+// KeyHashes returns the hashes of the public keys that the image was signed
+// with.
+func (img *Image) KeyHashes() [][]byte {
+	var hashes [][]byte
+	for _, t := range img.FindTlvs(IMAGE_TLV_KEYHASH) {
+		hashes = append(hashes, t.Data)
+	}
+
+	return hashes
+}
+
+// Signatures returns the signature TLVs of the image.
+func (img *Image) Signatures() []ImageTlv {
+	var sigs []ImageTlv
+	for _, t := range img.Tlvs {
+		if IsSigTlvType(t.Type) {
+			sigs = append(sigs, t)
+		}
+	}
+
+	return sigs
+}
+
+func (img *Image) Dependencies() ([]ImageDependency, error) {
+	var deps []ImageDependency
+	for _, t := range img.FindTlvs(IMAGE_TLV_DEPENDENCY) {
+		if len(t.Data) != IMAGE_TLV_DEPENDENCY_SZ {
+			return nil, fmt.Errorf("Invalid DEPENDENCY TLV length %d",
+				len(t.Data))
+		}
+
+		dep := ImageDependency{ImageNum: int(t.Data[0])}
+		binary.Read(bytes.NewReader(t.Data[4:]), binary.LittleEndian,
+			&dep.Vers)
+		deps = append(deps, dep)
+	}
+
+	return deps, nil
+}
+
+// Validate checks the contents of the image: exactly one hash TLV (SHA256,
+// SHA384 or SHA512) must be present and match the image data.  Signatures
+// are not checked.
+func (img *Image) Validate() error {
+	tlvs := img.hashTlvs()
+	if len(tlvs) == 0 {
+		return fmt.Errorf("Image has no hash TLV")
+	}
+	if len(tlvs) > 1 {
+		return fmt.Errorf("Image has multiple hash TLVs")
+	}
+	if tlvs[0].Protected {
+		return fmt.Errorf("Image %s TLV is in the protected area",
+			TlvTypeToString(tlvs[0].Type))
+	}
+
+	have := tlvs[0].Data
+	want := img.CalcHash()
+	if !bytes.Equal(have, want) {
+		return fmt.Errorf("Image hash mismatch; recorded=%s calculated=%s",
+			hex.EncodeToString(have), hex.EncodeToString(want))
+	}
+
+	// MCUboot hashes keys with the same digest as the image.
+	keyHashSz := hashTlvAlgs[tlvs[0].Type].Size()
+	for _, t := range img.FindTlvs(IMAGE_TLV_KEYHASH) {
+		if len(t.Data) != keyHashSz {
+			return fmt.Errorf("Invalid KEYHASH TLV length %d", len(t.Data))
+		}
+	}
+
+	if _, err := img.Dependencies(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CheckImageNum checks that the image can be uploaded to the specified image
+// number of a multi-image device.  Image files do not record their own image
+// number, but an image cannot depend on the image it replaces.
+func (img *Image) CheckImageNum(imageNum int) error {
+	deps, err := img.Dependencies()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range deps {
+		if d.ImageNum == imageNum {
+			return fmt.Errorf("Image depends on image %d (version %s); it "+
+				"cannot be uploaded as image %d", d.ImageNum, d.Vers.String(),
+				imageNum)
+		}
+	}
+
+	return nil
+}
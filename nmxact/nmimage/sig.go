@@ -0,0 +1,326 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmimage
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+)
+
+// PublicKey is a key trusted to sign images.
+type PublicKey struct {
+	// Identifies the key in reports, e.g., the name of its file.
+	Name string
+
+	// *ecdsa.PublicKey (P-256), *rsa.PublicKey (2048 or 3072 bits) or
+	// ed25519.PublicKey.
+	Key crypto.PublicKey
+
+	// The hash MCUboot uses to identify the key: the SHA256 of the key in the
+	// encoding embedded in the bootloader.
+	Hash []byte
+
+	// The key in the encoding embedded in the bootloader.
+	enc []byte
+}
+
+// matchesHash indicates whether the specified KEYHASH TLV identifies this
+// key.  SHA384 and SHA512 images hash keys with their own digest.
+func (k *PublicKey) matchesHash(keyHash []byte) bool {
+	if len(keyHash) == len(k.Hash) {
+		return bytes.Equal(k.Hash, keyHash)
+	}
+
+	alg := hashAlgBySize(len(keyHash))
+	if alg == 0 {
+		return false
+	}
+
+	h := alg.New()
+	h.Write(k.enc)
+	return bytes.Equal(h.Sum(nil), keyHash)
+}
+
+// ParsePublicKey decodes a PEM-encoded public key.  Both PKIX ("PUBLIC KEY")
+// and PKCS #1 ("RSA PUBLIC KEY") blocks are accepted.
+func ParsePublicKey(name string, data []byte) (*PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", name)
+	}
+
+	var key crypto.PublicKey
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block \"%s\"; a "+
+			"public key is required", name, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+
+	// MCUboot embeds RSA keys in PKCS #1 form and all others in PKIX form.
+	var enc []byte
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%s: unsupported ECDSA curve %s; only "+
+				"P-256 is supported", name, k.Curve.Params().Name)
+		}
+		enc, err = x509.MarshalPKIXPublicKey(k)
+
+	case *rsa.PublicKey:
+		if bits := k.N.BitLen(); bits != 2048 && bits != 3072 {
+			return nil, fmt.Errorf("%s: unsupported RSA key size %d; only "+
+				"2048 and 3072 are supported", name, bits)
+		}
+		enc = x509.MarshalPKCS1PublicKey(k)
+
+	case ed25519.PublicKey:
+		enc, err = x509.MarshalPKIXPublicKey(k)
+
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", name, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err.Error())
+	}
+
+	hash := sha256.Sum256(enc)
+	return &PublicKey{
+		Name: name,
+		Key:  key,
+		Hash: hash[:],
+		enc:  enc,
+	}, nil
+}
+
+// ReadPublicKeys reads PEM-encoded public keys from the specified files.
+func ReadPublicKeys(filenames []string) ([]*PublicKey, error) {
+	var keys []*PublicKey
+	for _, f := range filenames {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := ParsePublicKey(filepath.Base(f), data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// SigResult is the outcome of checking one signature TLV.
+type SigResult struct {
+	Type    string `json:"type"`
+	KeyHash string `json:"key_hash,omitempty"`
+
+	// Name of the trusted key that verified the signature.
+	Key string `json:"key,omitempty"`
+
+	Valid bool   `json:"valid"`
+	Err   string `json:"error,omitempty"`
+}
+
+// VerifyResult is the outcome of checking an image's signatures against a
+// set of trusted keys.
+type VerifyResult struct {
+	Hash      string      `json:"hash"`
+	HashValid bool        `json:"hash_valid"`
+	Sigs      []SigResult `json:"signatures"`
+
+	// The hash is valid and at least one signature was verified with a
+	// trusted key.
+	Verified bool `json:"verified"`
+}
+
+func (r *VerifyResult) String() string {
+	if !r.HashValid {
+		return "hash invalid"
+	}
+	for _, s := range r.Sigs {
+		if s.Valid {
+			return fmt.Sprintf("verified with key %s (%s)", s.Key, s.Type)
+		}
+	}
+	if len(r.Sigs) == 0 {
+		return "image is not signed"
+	}
+	return "no signature verified with a trusted key"
+}
+
+var errSigKeyType = fmt.Errorf("key type does not match signature type")
+
+// Checks a single signature over the image hash with a key.  Returns
+// errSigKeyType if the key cannot produce this type of signature.
+func verifySig(typ uint16, sig []byte, hash []byte, key crypto.PublicKey) error {
+	switch typ {
+	case IMAGE_TLV_ECDSA_SIG:
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errSigKeyType
+		}
+
+		// Signatures may be zero-padded to a fixed TLV length.
+		var rs struct {
+			R, S *big.Int
+		}
+		if _, err := asn1.Unmarshal(sig, &rs); err != nil {
+			return fmt.Errorf("malformed ECDSA signature: %s", err.Error())
+		}
+		if !ecdsa.Verify(k, hash, rs.R, rs.S) {
+			return fmt.Errorf("ECDSA signature mismatch")
+		}
+		return nil
+
+	case IMAGE_TLV_RSA2048_PSS, IMAGE_TLV_RSA3072_PSS:
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errSigKeyType
+		}
+
+		bits := 2048
+		if typ == IMAGE_TLV_RSA3072_PSS {
+			bits = 3072
+		}
+		if k.N.BitLen() != bits {
+			return errSigKeyType
+		}
+
+		alg := hashAlgBySize(len(hash))
+		if alg == 0 {
+			return fmt.Errorf("unsupported hash length %d", len(hash))
+		}
+
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+		if err := rsa.VerifyPSS(k, alg, hash, sig, opts); err != nil {
+			return fmt.Errorf("RSA-PSS signature mismatch")
+		}
+		return nil
+
+	case IMAGE_TLV_ED25519:
+		k, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return errSigKeyType
+		}
+
+		// MCUboot signs the image hash rather than the image itself.
+		if !ed25519.Verify(k, hash, sig) {
+			return fmt.Errorf("Ed25519 signature mismatch")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported signature type %s",
+			TlvTypeToString(typ))
+	}
+}
+
+// VerifySigs checks the image's signatures against the trusted keys.  Each
+// signature TLV is checked with the key named by the KEYHASH TLV preceding
+// it; if there is no KEYHASH TLV, every trusted key of a suitable type is
+// tried.  Signatures cover the image hash, so they are only checked if the
+// hash is valid.
+func (img *Image) VerifySigs(keys []*PublicKey) *VerifyResult {
+	hash := img.CalcHash()
+	res := &VerifyResult{
+		Hash:      hex.EncodeToString(hash),
+		HashValid: img.Validate() == nil,
+		Sigs:      []SigResult{},
+	}
+
+	var keyHash []byte
+	for _, t := range img.Tlvs {
+		if t.Type == IMAGE_TLV_KEYHASH {
+			keyHash = t.Data
+			continue
+		}
+		if !IsSigTlvType(t.Type) {
+			continue
+		}
+
+		sr := SigResult{
+			Type:    TlvTypeToString(t.Type),
+			KeyHash: hex.EncodeToString(keyHash),
+		}
+
+		var candidates []*PublicKey
+		for _, k := range keys {
+			if keyHash == nil || k.matchesHash(keyHash) {
+				candidates = append(candidates, k)
+			}
+		}
+
+		switch {
+		case !res.HashValid:
+			sr.Err = "image hash invalid"
+
+		case len(candidates) == 0:
+			sr.Err = "no trusted key matches the key hash"
+
+		default:
+			sr.Err = "no trusted key of a suitable type"
+			for _, k := range candidates {
+				err := verifySig(t.Type, t.Data, hash, k.Key)
+				if err == nil {
+					sr.Valid = true
+					sr.Key = k.Name
+					sr.Err = ""
+					break
+				}
+				if err != errSigKeyType {
+					sr.Err = err.Error()
+				}
+			}
+		}
+
+		if sr.Valid {
+			res.Verified = true
+		}
+		res.Sigs = append(res.Sigs, sr)
+
+		// A key hash applies to the signature that follows it.
+		keyHash = nil
+	}
+
+	return res
+}
@@ -50,6 +50,9 @@ func NewConfigReadRsp() *ConfigReadRsp {
 
 func (r *ConfigReadRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *ConfigReadRsp) RspRc() int      { return r.Rc }
+func (r *ConfigReadRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $write                                                                   //
 //////////////////////////////////////////////////////////////////////////////
@@ -79,3 +82,6 @@ func NewConfigWriteRsp() *ConfigWriteRsp {
 }
 
 func (r *ConfigWriteRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *ConfigWriteRsp) RspRc() int      { return r.Rc }
+func (r *ConfigWriteRsp) SetRspRc(rc int) { r.Rc = rc }
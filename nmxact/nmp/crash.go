@@ -44,3 +44,6 @@ func NewCrashRsp() *CrashRsp {
 }
 
 func (r *CrashRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *CrashRsp) RspRc() int      { return r.Rc }
+func (r *CrashRsp) SetRspRc(rc int) { r.Rc = rc }
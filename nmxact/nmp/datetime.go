@@ -49,6 +49,9 @@ func NewDateTimeReadRsp() *DateTimeReadRsp {
 
 func (r *DateTimeReadRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *DateTimeReadRsp) RspRc() int      { return r.Rc }
+func (r *DateTimeReadRsp) SetRspRc(rc int) { r.Rc = rc }
+
 ///////////////////////////////////////////////////////////////////////////////
 // $write                                                                    //
 ///////////////////////////////////////////////////////////////////////////////
@@ -76,3 +79,6 @@ func NewDateTimeWriteRsp() *DateTimeWriteRsp {
 }
 
 func (r *DateTimeWriteRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *DateTimeWriteRsp) RspRc() int      { return r.Rc }
+func (r *DateTimeWriteRsp) SetRspRc(rc int) { r.Rc = rc }
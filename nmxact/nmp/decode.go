@@ -21,6 +21,7 @@ package nmp
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/ugorji/go/codec"
 )
@@ -45,7 +46,8 @@ type Ogi struct {
 	Id    uint8
 }
 
-type rspCtor func() NmpRsp
+// RspCtor creates an empty response into which a response body is decoded.
+type RspCtor func() NmpRsp
 
 func echoRspCtor() NmpRsp          { return NewEchoRsp() }
 func taskStatRspCtor() NmpRsp      { return NewTaskStatRsp() }
@@ -71,11 +73,12 @@ func runTestRspCtor() NmpRsp       { return NewRunTestRsp() }
 func runListRspCtor() NmpRsp       { return NewRunListRsp() }
 func fsDownloadRspCtor() NmpRsp    { return NewFsDownloadRsp() }
 func fsUploadRspCtor() NmpRsp      { return NewFsUploadRsp() }
+func fsHashRspCtor() NmpRsp        { return NewFsHashRsp() }
 func configReadRspCtor() NmpRsp    { return NewConfigReadRsp() }
 func configWriteRspCtor() NmpRsp   { return NewConfigWriteRsp() }
 func shellExecRspCtor() NmpRsp     { return NewShellExecRsp() }
 
-var rspCtorMap = map[Ogi]rspCtor{
+var rspCtorMap = map[Ogi]RspCtor{
 	{op_wr, gr_def, NMP_ID_DEF_ECHO}:         echoRspCtor,
 	{op_rr, gr_def, NMP_ID_DEF_TASKSTAT}:     taskStatRspCtor,
 	{op_rr, gr_def, NMP_ID_DEF_MPSTAT}:       mpStatRspCtor,
@@ -101,16 +104,80 @@ var rspCtorMap = map[Ogi]rspCtor{
 	{op_rr, gr_run, NMP_ID_RUN_LIST}:         runListRspCtor,
 	{op_rr, gr_fil, NMP_ID_FS_FILE}:          fsDownloadRspCtor,
 	{op_wr, gr_fil, NMP_ID_FS_FILE}:          fsUploadRspCtor,
+	{op_rr, gr_fil, NMP_ID_FS_HASH}:          fsHashRspCtor,
 	{op_rr, gr_cfg, NMP_ID_CONFIG_VAL}:       configReadRspCtor,
 	{op_wr, gr_cfg, NMP_ID_CONFIG_VAL}:       configWriteRspCtor,
 	{op_wr, gr_she, NMP_ID_SHELL_EXEC}:       shellExecRspCtor,
 }
 
+// Protects rspCtorMap.
+var rspCtorMtx sync.RWMutex
+
+// The triples whose constructors are built in.  These cannot be unregistered.
+var builtinRspCtors = func() map[Ogi]bool {
+	m := make(map[Ogi]bool, len(rspCtorMap))
+	for ogi := range rspCtorMap {
+		m[ogi] = true
+	}
+	return m
+}()
+
+// RegisterRspCtor registers a constructor for responses with the specified
+// op, group, and ID.  Once registered, the NMP and OMP decoders produce
+// responses of the constructor's type rather than a RawRsp.  The op must be
+// NMP_OP_READ_RSP or NMP_OP_WRITE_RSP.  It is an error to register a
+// constructor for a triple that already has one.
+func RegisterRspCtor(op uint8, group uint16, id uint8, ctor RspCtor) error {
+	if !OpIsRsp(op) {
+		return fmt.Errorf("Cannot register NMP response constructor; "+
+			"op %d is not a response", op)
+	}
+	if ctor == nil {
+		return fmt.Errorf("Cannot register nil NMP response constructor")
+	}
+
+	rspCtorMtx.Lock()
+	defer rspCtorMtx.Unlock()
+
+	ogi := Ogi{op, group, id}
+	if rspCtorMap[ogi] != nil {
+		return fmt.Errorf("Duplicate NMP response constructor; "+
+			"op+group+id: %d, %d, %d", op, group, id)
+	}
+
+	rspCtorMap[ogi] = ctor
+	return nil
+}
+
+// UnregisterRspCtor removes a constructor added with RegisterRspCtor.
+// Subsequent responses with the specified op, group, and ID are decoded as
+// RawRsp.  Built-in constructors cannot be removed.
+func UnregisterRspCtor(op uint8, group uint16, id uint8) error {
+	ogi := Ogi{op, group, id}
+	if builtinRspCtors[ogi] {
+		return fmt.Errorf("Cannot unregister built-in NMP response "+
+			"constructor; op+group+id: %d, %d, %d", op, group, id)
+	}
+
+	rspCtorMtx.Lock()
+	defer rspCtorMtx.Unlock()
+
+	delete(rspCtorMap, ogi)
+	return nil
+}
+
+func lookupRspCtor(ogi Ogi) RspCtor {
+	rspCtorMtx.RLock()
+	defer rspCtorMtx.RUnlock()
+
+	return rspCtorMap[ogi]
+}
+
 func DecodeRspBody(hdr *NmpHdr, body []byte) (NmpRsp, error) {
-	cb := rspCtorMap[Ogi{hdr.Op, hdr.Group, hdr.Id}]
+	cb := lookupRspCtor(Ogi{hdr.Op, hdr.Group, hdr.Id})
 	if cb == nil {
-		return nil, fmt.Errorf("Unrecognized NMP op+group+id: %d, %d, %d",
-			hdr.Op, hdr.Group, hdr.Id)
+		// Unrecognized op+group+id; decode the body into a generic map.
+		return decodeRawRsp(hdr, body)
 	}
 
 	r := cb()
@@ -122,5 +189,94 @@ func DecodeRspBody(hdr *NmpHdr, body []byte) (NmpRsp, error) {
 	}
 
 	r.SetHdr(hdr)
+	setRawBody(r, body)
+	if err := applyRspStatus(r, body); err != nil {
+		return nil, err
+	}
+
 	return r, nil
 }
+
+// Retains a copy of the body a response was decoded from.  The caller's
+// buffer may be reused once decoding completes.
+func setRawBody(r NmpRsp, body []byte) {
+	if s, ok := r.(interface{ SetRawBody([]byte) }); ok {
+		s.SetRawBody(append([]byte{}, body...))
+	}
+}
+
+// The status fields common to all responses.  Version 1 responses report
+// errors with a top-level "rc"; version 2 responses report group-specific
+// errors as "err": {"group": <group>, "rc": <rc>}.
+type rspStatus struct {
+	Rc  int `codec:"rc"`
+	Err *struct {
+		Group uint16 `codec:"group"`
+		Rc    int    `codec:"rc"`
+	} `codec:"err"`
+}
+
+func decodeRspStatus(body []byte) (*MgmtError, error) {
+	var st rspStatus
+
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	dec := codec.NewDecoderBytes(body, new(codec.CborHandle))
+	if err := dec.Decode(&st); err != nil {
+		return nil, fmt.Errorf("Invalid response: %s", err.Error())
+	}
+
+	if st.Err != nil && st.Err.Rc != 0 {
+		return NewGroupMgmtError(st.Err.Group, st.Err.Rc), nil
+	}
+	if st.Rc != 0 {
+		return NewMgmtError(st.Rc), nil
+	}
+
+	return nil, nil
+}
+
+// Implemented by responses that report their status in an "rc" field.
+type RcRsp interface {
+	RspRc() int
+	SetRspRc(rc int)
+}
+
+// Records the status of a decoded response.  If the response reports a
+// group-scoped error, its code is also copied into the response's rc field
+// so that callers checking the field see the failure.
+func applyRspStatus(r NmpRsp, body []byte) error {
+	merr, err := decodeRspStatus(body)
+	if err != nil {
+		return err
+	}
+	if merr == nil {
+		return nil
+	}
+
+	if s, ok := r.(interface{ SetRspErr(*MgmtError) }); ok {
+		s.SetRspErr(merr)
+	}
+
+	if merr.GroupSpecific {
+		if rr, ok := r.(RcRsp); ok && rr.RspRc() == 0 {
+			rr.SetRspRc(merr.Rc)
+		}
+	}
+
+	return nil
+}
+
+// RspError returns the error status reported in a response, or nil if the
+// response indicates success.  The error is an *MgmtError.
+func RspError(rsp NmpRsp) error {
+	if r, ok := rsp.(interface{ RspErr() *MgmtError }); ok {
+		if merr := r.RspErr(); merr != nil {
+			return merr
+		}
+	}
+
+	return nil
+}
@@ -19,6 +19,11 @@
 
 package nmp
 
+import (
+	"fmt"
+	"sync"
+)
+
 const (
 	NMP_OP_READ      = 0
 	NMP_OP_READ_RSP  = 1
@@ -26,13 +31,40 @@ const (
 	NMP_OP_WRITE_RSP = 3
 )
 
+// SMP header versions.  The version occupies bits 3 and 4 of the op byte.
+// Version 1 devices ignore these bits and always respond with version 1.
 const (
-	NMP_ERR_OK       = 0
-	NMP_ERR_EUNKNOWN = 1
-	NMP_ERR_ENOMEM   = 2
-	NMP_ERR_EINVAL   = 3
-	NMP_ERR_ETIMEOUT = 4
-	NMP_ERR_ENOENT   = 5
+	NMP_VER1 = 0
+	NMP_VER2 = 1
+)
+
+// The SMP header version that new sessions start with.  A session that
+// starts with version 2 falls back to version 1 if its peer does not
+// support version 2.
+//
+// The library defaults to version 1 so that existing applications keep
+// sending exactly the requests they always have; they opt in to version 2 by
+// setting this variable before creating sessions.  The newtmgr tool opts in
+// by default (see its --smp-version option), which is why the two defaults
+// differ.
+var DfltVersion uint8 = NMP_VER1
+
+const (
+	NMP_ERR_OK                   = 0
+	NMP_ERR_EUNKNOWN             = 1
+	NMP_ERR_ENOMEM               = 2
+	NMP_ERR_EINVAL               = 3
+	NMP_ERR_ETIMEOUT             = 4
+	NMP_ERR_ENOENT               = 5
+	NMP_ERR_EBADSTATE            = 6
+	NMP_ERR_EMSGSIZE             = 7
+	NMP_ERR_ENOTSUP              = 8
+	NMP_ERR_ECORRUPT             = 9
+	NMP_ERR_EBUSY                = 10
+	NMP_ERR_EACCESSDENIED        = 11
+	NMP_ERR_EUNSUPPORTED_TOO_OLD = 12
+	NMP_ERR_EUNSUPPORTED_TOO_NEW = 13
+	NMP_ERR_EPERUSER             = 256
 )
 
 // First 64 groups are reserved for system level newtmgr commands.
@@ -106,9 +138,168 @@ const (
 // File system group (8).
 const (
 	NMP_ID_FS_FILE = 0
+	NMP_ID_FS_HASH = 2
 )
 
 // Shell group (8).
 const (
 	NMP_ID_SHELL_EXEC = 0
 )
+
+// Protects groupNameMap and idNameMap.
+var nameMtx sync.RWMutex
+
+var opNameMap = map[uint8]string{
+	NMP_OP_READ:      "read",
+	NMP_OP_READ_RSP:  "read-rsp",
+	NMP_OP_WRITE:     "write",
+	NMP_OP_WRITE_RSP: "write-rsp",
+}
+
+var groupNameMap = map[uint16]string{
+	NMP_GROUP_DEFAULT: "default",
+	NMP_GROUP_IMAGE:   "image",
+	NMP_GROUP_STAT:    "stat",
+	NMP_GROUP_CONFIG:  "config",
+	NMP_GROUP_LOG:     "log",
+	NMP_GROUP_CRASH:   "crash",
+	NMP_GROUP_SPLIT:   "split",
+	NMP_GROUP_RUN:     "run",
+	NMP_GROUP_FS:      "fs",
+	NMP_GROUP_SHELL:   "shell",
+}
+
+var idNameMap = map[uint16]map[uint8]string{
+	NMP_GROUP_DEFAULT: {
+		NMP_ID_DEF_ECHO:           "echo",
+		NMP_ID_DEF_CONS_ECHO_CTRL: "cons-echo-ctrl",
+		NMP_ID_DEF_TASKSTAT:       "taskstat",
+		NMP_ID_DEF_MPSTAT:         "mpstat",
+		NMP_ID_DEF_DATETIME_STR:   "datetime",
+		NMP_ID_DEF_RESET:          "reset",
+	},
+	NMP_GROUP_IMAGE: {
+		NMP_ID_IMAGE_STATE:    "state",
+		NMP_ID_IMAGE_UPLOAD:   "upload",
+		NMP_ID_IMAGE_CORELIST: "corelist",
+		NMP_ID_IMAGE_CORELOAD: "coreload",
+		NMP_ID_IMAGE_ERASE:    "erase",
+	},
+	NMP_GROUP_STAT: {
+		NMP_ID_STAT_READ: "read",
+		NMP_ID_STAT_LIST: "list",
+	},
+	NMP_GROUP_CONFIG: {
+		NMP_ID_CONFIG_VAL: "val",
+	},
+	NMP_GROUP_LOG: {
+		NMP_ID_LOG_SHOW:        "show",
+		NMP_ID_LOG_CLEAR:       "clear",
+		NMP_ID_LOG_APPEND:      "append",
+		NMP_ID_LOG_MODULE_LIST: "module-list",
+		NMP_ID_LOG_LEVEL_LIST:  "level-list",
+		NMP_ID_LOG_LIST:        "list",
+	},
+	NMP_GROUP_CRASH: {
+		NMP_ID_CRASH_TRIGGER: "trigger",
+	},
+	NMP_GROUP_RUN: {
+		NMP_ID_RUN_TEST: "test",
+		NMP_ID_RUN_LIST: "list",
+	},
+	NMP_GROUP_FS: {
+		NMP_ID_FS_FILE: "file",
+		NMP_ID_FS_HASH: "hash",
+	},
+	NMP_GROUP_SHELL: {
+		NMP_ID_SHELL_EXEC: "exec",
+	},
+}
+
+// Returns the name of an NMP op, or "" if the op is unknown.
+func OpName(op uint8) string {
+	return opNameMap[op]
+}
+
+// Returns the name of an NMP group, or "" if the group is unknown.
+func GroupName(group uint16) string {
+	nameMtx.RLock()
+	defer nameMtx.RUnlock()
+
+	return groupNameMap[group]
+}
+
+// Returns the NMP group with the specified name.
+func GroupFromName(name string) (uint16, bool) {
+	nameMtx.RLock()
+	defer nameMtx.RUnlock()
+
+	for group, n := range groupNameMap {
+		if n == name {
+			return group, true
+		}
+	}
+
+	return 0, false
+}
+
+// Returns the command ID with the specified name within an NMP group.
+func IdFromName(group uint16, name string) (uint8, bool) {
+	nameMtx.RLock()
+	defer nameMtx.RUnlock()
+
+	for id, n := range idNameMap[group] {
+		if n == name {
+			return id, true
+		}
+	}
+
+	return 0, false
+}
+
+// Returns the name of a command ID within an NMP group, or "" if the
+// command is unknown.
+func IdName(group uint16, id uint8) string {
+	nameMtx.RLock()
+	defer nameMtx.RUnlock()
+
+	return idNameMap[group][id]
+}
+
+// RegisterGroup names a custom NMP group and its command IDs.  The names are
+// used by the CLI and by diagnostic output.  Groups below NMP_GROUP_PERUSER
+// are reserved for system commands and cannot be registered.
+func RegisterGroup(group uint16, name string, ids map[uint8]string) error {
+	if group < NMP_GROUP_PERUSER {
+		return fmt.Errorf("Cannot register NMP group %d; groups below %d "+
+			"are reserved", group, NMP_GROUP_PERUSER)
+	}
+
+	nameMtx.Lock()
+	defer nameMtx.Unlock()
+
+	if _, ok := groupNameMap[group]; ok {
+		return fmt.Errorf("Duplicate NMP group: %d", group)
+	}
+	for g, n := range groupNameMap {
+		if n == name {
+			return fmt.Errorf("Duplicate NMP group name: %s (group %d)",
+				name, g)
+		}
+	}
+
+	groupNameMap[group] = name
+
+	idMap := make(map[uint8]string, len(ids))
+	for id, n := range ids {
+		idMap[id] = n
+	}
+	idNameMap[group] = idMap
+
+	return nil
+}
+
+// Indicates whether an NMP op is a response.
+func OpIsRsp(op uint8) bool {
+	return op == NMP_OP_READ_RSP || op == NMP_OP_WRITE_RSP
+}
@@ -35,6 +35,12 @@ type Listener struct {
 	ErrChan chan error
 	tmoChan chan time.Time
 	timer   *time.Timer
+
+	// Header of the request awaiting a response; nil if unknown.
+	req *NmpHdr
+
+	// Whether a response has already been delivered.
+	gotRsp bool
 }
 
 func NewListener() *Listener {
@@ -45,6 +51,16 @@ func NewListener() *Listener {
 	}
 }
 
+// Creates a listener for the response to the specified request.  Responses
+// that do not match the request's operation, group, and ID are rejected as
+// stale.
+func NewReqListener(req *NmpHdr) *Listener {
+	nl := NewListener()
+	hdr := *req
+	nl.req = &hdr
+	return nl
+}
+
 func (nl *Listener) AfterTimeout(tmo time.Duration) <-chan time.Time {
 	fn := func() {
 		nl.tmoChan <- time.Now()
@@ -53,6 +69,54 @@ func (nl *Listener) AfterTimeout(tmo time.Duration) <-chan time.Time {
 	return nl.tmoChan
 }
 
+// Indicates whether the specified response header answers the request this
+// listener is waiting on.
+func (nl *Listener) matchesRsp(hdr *NmpHdr) bool {
+	if nl.req == nil {
+		return true
+	}
+
+	return hdr.Op == nl.req.Op|1 &&
+		hdr.Group == nl.req.Group &&
+		hdr.Id == nl.req.Id
+}
+
+// Delivers a response to the listener.  A response that does not answer the
+// listener's request, or that arrives after a response has already been
+// delivered, is reported and dropped.  Returns true if the response was
+// delivered.  This function must not be called concurrently for the same
+// listener.
+func (nl *Listener) DeliverRsp(r NmpRsp) bool {
+	hdr := r.Hdr()
+
+	if !nl.matchesRsp(hdr) {
+		log.Warnf("Dropping stale NMP response; seq=%d "+
+			"expected op=%d group=%d id=%d, got op=%d group=%d id=%d",
+			hdr.Seq, nl.req.Op|1, nl.req.Group, nl.req.Id,
+			hdr.Op, hdr.Group, hdr.Id)
+		return false
+	}
+
+	if nl.gotRsp {
+		log.Warnf("Dropping duplicate NMP response; seq=%d", hdr.Seq)
+		return false
+	}
+
+	nl.gotRsp = true
+	nl.RspChan <- r
+	return true
+}
+
+// Reports an error to the listener.  A listener only consumes a single error,
+// so an error is dropped if one is already pending.  This prevents an error
+// source from blocking on a listener whose owner has stopped receiving.
+func (nl *Listener) DeliverErr(err error) {
+	select {
+	case nl.ErrChan <- err:
+	default:
+	}
+}
+
 func (nl *Listener) Close() {
 	if nl.timer != nil {
 		nl.timer.Stop()
@@ -68,6 +132,7 @@ func (nl *Listener) Close() {
 type Dispatcher struct {
 	seqListenerMap map[uint8]*Listener
 	reassembler    *Reassembler
+	rxTap          func(pkt []byte)
 	logDepth       int
 	mtx            sync.Mutex
 }
@@ -80,8 +145,14 @@ func NewDispatcher(logDepth int) *Dispatcher {
 	}
 }
 
-func (d *Dispatcher) AddListener(seq uint8) (*Listener, error) {
-	nmxutil.LogAddNmpListener(d.logDepth, seq)
+// Registers a function that receives every reassembled packet before it is
+// decoded.  This must be called before the dispatcher is used.
+func (d *Dispatcher) SetRxTap(tap func(pkt []byte)) {
+	d.rxTap = tap
+}
+
+func (d *Dispatcher) addListener(seq uint8, nl *Listener) (*Listener, error) {
+	nmxutil.LogAddNmpListener(d.logDepth+1, seq)
 
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
@@ -90,11 +161,29 @@ func (d *Dispatcher) AddListener(seq uint8) (*Listener, error) {
 		return nil, fmt.Errorf("Duplicate NMP listener; seq=%d", seq)
 	}
 
-	nl := NewListener()
 	d.seqListenerMap[seq] = nl
 	return nl, nil
 }
 
+func (d *Dispatcher) AddListener(seq uint8) (*Listener, error) {
+	return d.addListener(seq, NewListener())
+}
+
+// Adds a listener for the response to the specified request.
+func (d *Dispatcher) AddReqListener(req *NmpHdr) (*Listener, error) {
+	return d.addListener(req.Seq, NewReqListener(req))
+}
+
+// Indicates whether a listener is registered for the specified sequence
+// number.
+func (d *Dispatcher) HasListener(seq uint8) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	_, ok := d.seqListenerMap[seq]
+	return ok
+}
+
 func (d *Dispatcher) RemoveListener(seq uint8) *Listener {
 	nmxutil.LogRemoveNmpListener(d.logDepth, seq)
 
@@ -134,13 +223,12 @@ func (d *Dispatcher) DispatchRsp(r NmpRsp) bool {
 
 	nl := d.seqListenerMap[r.Hdr().Seq]
 	if nl == nil {
-		log.Debugf("No listener for incoming NMP message")
+		log.Debugf("No listener for incoming NMP message; seq=%d; "+
+			"dropping stale response", r.Hdr().Seq)
 		return false
 	}
 
-	nl.RspChan <- r
-
-	return true
+	return nl.DeliverRsp(r)
 }
 
 // Returns true if the response was dispatched.
@@ -150,6 +238,10 @@ func (d *Dispatcher) Dispatch(data []byte) bool {
 		return false
 	}
 
+	if d.rxTap != nil {
+		d.rxTap(pkt)
+	}
+
 	rsp, err := decodeRsp(pkt)
 	if err != nil {
 		log.Debugf("Failure decoding NMP rsp: %s\npacket=\n%s", err.Error(),
@@ -174,7 +266,7 @@ func (d *Dispatcher) ErrorOne(seq uint8, err error) error {
 		return fmt.Errorf("No NMP listener for seq %d", seq)
 	}
 
-	nl.ErrChan <- err
+	nl.DeliverErr(err)
 
 	return nil
 }
@@ -184,6 +276,6 @@ func (d *Dispatcher) ErrorAll(err error) {
 	defer d.mtx.Unlock()
 
 	for _, nl := range d.seqListenerMap {
-		nl.ErrChan <- err
+		nl.DeliverErr(err)
 	}
 }
@@ -45,3 +45,6 @@ func NewEchoRsp() *EchoRsp {
 }
 
 func (r *EchoRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *EchoRsp) RspRc() int      { return r.Rc }
+func (r *EchoRsp) SetRspRc(rc int) { r.Rc = rc }
@@ -53,6 +53,9 @@ func NewFsDownloadRsp() *FsDownloadRsp {
 
 func (r *FsDownloadRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *FsDownloadRsp) RspRc() int      { return r.Rc }
+func (r *FsDownloadRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $upload                                                                  //
 //////////////////////////////////////////////////////////////////////////////
@@ -84,3 +87,47 @@ func NewFsUploadRsp() *FsUploadRsp {
 }
 
 func (r *FsUploadRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *FsUploadRsp) RspRc() int      { return r.Rc }
+func (r *FsUploadRsp) SetRspRc(rc int) { r.Rc = rc }
+
+//////////////////////////////////////////////////////////////////////////////
+// $hash                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+const FS_HASH_SHA256 = "sha256"
+
+// Requests a hash of the range [Off, Off+Len) of a file.
+type FsHashReq struct {
+	NmpBase     `codec:"-"`
+	Name string `codec:"name"`
+	Type string `codec:"type"`
+	Off  uint32 `codec:"off"`
+	Len  uint32 `codec:"len"`
+}
+
+type FsHashRsp struct {
+	NmpBase
+	Rc     int    `codec:"rc"`
+	Type   string `codec:"type"`
+	Off    uint32 `codec:"off"`
+	Len    uint32 `codec:"len"`
+	Output []byte `codec:"output"`
+}
+
+func NewFsHashReq() *FsHashReq {
+	r := &FsHashReq{}
+	fillNmpReq(r, NMP_OP_READ, NMP_GROUP_FS, NMP_ID_FS_HASH)
+	return r
+}
+
+func (r *FsHashReq) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func NewFsHashRsp() *FsHashRsp {
+	return &FsHashRsp{}
+}
+
+func (r *FsHashRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *FsHashRsp) RspRc() int      { return r.Rc }
+func (r *FsHashRsp) SetRspRc(rc int) { r.Rc = rc }
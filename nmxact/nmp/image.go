@@ -59,6 +59,9 @@ func NewImageUploadRsp() *ImageUploadRsp {
 
 func (r *ImageUploadRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *ImageUploadRsp) RspRc() int      { return r.Rc }
+func (r *ImageUploadRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $state                                                                   //
 //////////////////////////////////////////////////////////////////////////////
@@ -138,6 +141,9 @@ func NewImageStateRsp() *ImageStateRsp {
 
 func (r *ImageStateRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *ImageStateRsp) RspRc() int      { return r.Rc }
+func (r *ImageStateRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $corelist                                                                //
 //////////////////////////////////////////////////////////////////////////////
@@ -165,6 +171,9 @@ func NewCoreListRsp() *CoreListRsp {
 
 func (r *CoreListRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *CoreListRsp) RspRc() int      { return r.Rc }
+func (r *CoreListRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $coreload                                                                //
 //////////////////////////////////////////////////////////////////////////////
@@ -196,6 +205,9 @@ func NewCoreLoadRsp() *CoreLoadRsp {
 
 func (r *CoreLoadRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *CoreLoadRsp) RspRc() int      { return r.Rc }
+func (r *CoreLoadRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $coreerase                                                               //
 //////////////////////////////////////////////////////////////////////////////
@@ -223,6 +235,9 @@ func NewCoreEraseRsp() *CoreEraseRsp {
 
 func (r *CoreEraseRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *CoreEraseRsp) RspRc() int      { return r.Rc }
+func (r *CoreEraseRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $erase                                                                   //
 //////////////////////////////////////////////////////////////////////////////
@@ -249,3 +264,6 @@ func NewImageEraseRsp() *ImageEraseRsp {
 }
 
 func (r *ImageEraseRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *ImageEraseRsp) RspRc() int      { return r.Rc }
+func (r *ImageEraseRsp) SetRspRc(rc int) { r.Rc = rc }
@@ -166,6 +166,9 @@ func NewLogShowRsp() *LogShowRsp {
 
 func (r *LogShowRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *LogShowRsp) RspRc() int      { return r.Rc }
+func (r *LogShowRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $list                                                                    //
 //////////////////////////////////////////////////////////////////////////////
@@ -194,6 +197,9 @@ func NewLogListRsp() *LogListRsp {
 
 func (r *LogListRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *LogListRsp) RspRc() int      { return r.Rc }
+func (r *LogListRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $module list                                                             //
 //////////////////////////////////////////////////////////////////////////////
@@ -222,6 +228,9 @@ func NewLogModuleListRsp() *LogModuleListRsp {
 
 func (r *LogModuleListRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *LogModuleListRsp) RspRc() int      { return r.Rc }
+func (r *LogModuleListRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $level list                                                              //
 //////////////////////////////////////////////////////////////////////////////
@@ -250,6 +259,9 @@ func NewLogLevelListRsp() *LogLevelListRsp {
 
 func (r *LogLevelListRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *LogLevelListRsp) RspRc() int      { return r.Rc }
+func (r *LogLevelListRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $clear                                                                   //
 //////////////////////////////////////////////////////////////////////////////
@@ -277,6 +289,9 @@ func NewLogClearRsp() *LogClearRsp {
 
 func (r *LogClearRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *LogClearRsp) RspRc() int      { return r.Rc }
+func (r *LogClearRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $LogType Marshal/Unmarshal                                               //
 //////////////////////////////////////////////////////////////////////////////
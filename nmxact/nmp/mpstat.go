@@ -44,3 +44,6 @@ func NewMempoolStatRsp() *MempoolStatRsp {
 }
 
 func (r *MempoolStatRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *MempoolStatRsp) RspRc() int      { return r.Rc }
+func (r *MempoolStatRsp) SetRspRc(rc int) { r.Rc = rc }
@@ -33,17 +33,23 @@ import (
 const NMP_HDR_SIZE = 8
 
 type NmpHdr struct {
-	Op    uint8 /* 3 bits of opcode */
-	Flags uint8
-	Len   uint16
-	Group uint16
-	Seq   uint8
-	Id    uint8
+	Op      uint8 /* 3 bits of opcode */
+	Version uint8 /* 2 bits of SMP version; NMP_VER1 or NMP_VER2 */
+	Flags   uint8
+	Len     uint16
+	Group   uint16
+	Seq     uint8
+	Id      uint8
 }
 
 type NmpMsg struct {
 	Hdr  NmpHdr
 	Body interface{}
+
+	// Optional; called with the request's sequence number once the sender
+	// has registered a listener for the response.  The sender may have
+	// replaced the number in Hdr if it was already in use.
+	SeqCb func(seq uint8)
 }
 
 // Combine req + rsp.
@@ -62,7 +68,9 @@ type NmpRsp interface {
 }
 
 type NmpBase struct {
-	hdr NmpHdr `codec:"-"`
+	hdr     NmpHdr     `codec:"-"`
+	rspErr  *MgmtError `codec:"-"`
+	rawBody []byte     `codec:"-"`
 }
 
 func (b *NmpBase) Hdr() *NmpHdr {
@@ -73,10 +81,30 @@ func (b *NmpBase) SetHdr(h *NmpHdr) {
 	b.hdr = *h
 }
 
+// Returns the error status carried by a decoded response, or nil if the
+// response indicates success.
+func (b *NmpBase) RspErr() *MgmtError {
+	return b.rspErr
+}
+
+func (b *NmpBase) SetRspErr(err *MgmtError) {
+	b.rspErr = err
+}
+
+// Returns the CBOR body that a response was decoded from, or nil if the
+// response was not produced by the decoder.
+func (b *NmpBase) RawBody() []byte {
+	return b.rawBody
+}
+
+func (b *NmpBase) SetRawBody(body []byte) {
+	b.rawBody = body
+}
+
 func MsgFromReq(r NmpReq) *NmpMsg {
 	return &NmpMsg{
-		*r.Hdr(),
-		r,
+		Hdr:  *r.Hdr(),
+		Body: r,
 	}
 }
 
@@ -92,7 +120,8 @@ func DecodeNmpHdr(data []byte) (*NmpHdr, error) {
 
 	hdr := &NmpHdr{}
 
-	hdr.Op = uint8(data[0])
+	hdr.Op = uint8(data[0]) & 0x07
+	hdr.Version = (uint8(data[0]) >> 3) & 0x03
 	hdr.Flags = uint8(data[1])
 	hdr.Len = binary.BigEndian.Uint16(data[2:4])
 	hdr.Group = binary.BigEndian.Uint16(data[4:6])
@@ -105,7 +134,7 @@ func DecodeNmpHdr(data []byte) (*NmpHdr, error) {
 func (hdr *NmpHdr) Bytes() []byte {
 	buf := make([]byte, 0, NMP_HDR_SIZE)
 
-	buf = append(buf, byte(hdr.Op))
+	buf = append(buf, byte(hdr.Op&0x07)|byte(hdr.Version&0x03)<<3)
 	buf = append(buf, byte(hdr.Flags))
 
 	u16b := make([]byte, 2)
@@ -152,12 +181,13 @@ func EncodeNmpPlain(nmr *NmpMsg) ([]byte, error) {
 
 func fillNmpReqWithSeq(req NmpReq, op uint8, group uint16, id uint8, seq uint8) {
 	hdr := NmpHdr{
-		Op:    op,
-		Flags: 0,
-		Len:   0,
-		Group: group,
-		Seq:   seq,
-		Id:    id,
+		Op:      op,
+		Version: NMP_VER1,
+		Flags:   0,
+		Len:     0,
+		Group:   group,
+		Seq:     seq,
+		Id:      id,
 	}
 
 	req.SetHdr(&hdr)
@@ -166,3 +196,9 @@ func fillNmpReqWithSeq(req NmpReq, op uint8, group uint16, id uint8, seq uint8)
 func fillNmpReq(req NmpReq, op uint8, group uint16, id uint8) {
 	fillNmpReqWithSeq(req, op, group, id, nmxutil.NextNmpSeq())
 }
+
+// FillNmpReq initializes the header of a custom request with the specified
+// op, group, and ID, and the next sequence number.
+func FillNmpReq(req NmpReq, op uint8, group uint16, id uint8) {
+	fillNmpReq(req, op, group, id)
+}
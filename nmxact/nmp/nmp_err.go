@@ -0,0 +1,229 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmp
+
+import (
+	"errors"
+	"fmt"
+)
+
+type errDesc struct {
+	name string
+	text string
+}
+
+// Generic management error codes.  These may be returned by any group.
+var errDescMap = map[int]errDesc{
+	NMP_ERR_OK:        {"EOK", "no error"},
+	NMP_ERR_EUNKNOWN:  {"EUNKNOWN", "unknown error"},
+	NMP_ERR_ENOMEM:    {"ENOMEM", "insufficient memory"},
+	NMP_ERR_EINVAL:    {"EINVAL", "error in request"},
+	NMP_ERR_ETIMEOUT:  {"ETIMEOUT", "operation timed out"},
+	NMP_ERR_ENOENT:    {"ENOENT", "no such file or entry"},
+	NMP_ERR_EBADSTATE: {"EBADSTATE", "current state disallows command"},
+	NMP_ERR_EMSGSIZE:  {"EMSGSIZE", "response too large"},
+	NMP_ERR_ENOTSUP:   {"ENOTSUP", "command not supported"},
+	NMP_ERR_ECORRUPT:  {"ECORRUPT", "data is corrupt"},
+	NMP_ERR_EBUSY:     {"EBUSY", "command blocked by another operation"},
+	NMP_ERR_EACCESSDENIED: {"EACCESSDENIED",
+		"access to command denied"},
+	NMP_ERR_EUNSUPPORTED_TOO_OLD: {"EUNSUPPORTED_TOO_OLD",
+		"protocol version too old for device"},
+	NMP_ERR_EUNSUPPORTED_TOO_NEW: {"EUNSUPPORTED_TOO_NEW",
+		"protocol version too new for device"},
+}
+
+// Group-specific error codes.  A device reports these in the group-scoped
+// error form of a response.
+var groupErrDescMap = map[uint16]map[int]errDesc{
+	NMP_GROUP_DEFAULT: {
+		1: {"UNKNOWN", "unknown error"},
+		2: {"INVALID_FORMAT", "invalid format"},
+		3: {"QUERY_YIELDS_NO_ANSWER", "query yields no answer"},
+		4: {"RTC_NOT_SET", "RTC is not set"},
+		5: {"RTC_COMMAND_FAILED", "RTC command failed"},
+	},
+	NMP_GROUP_IMAGE: {
+		1:  {"UNKNOWN", "unknown error"},
+		2:  {"FLASH_CONFIG_QUERY_FAIL", "failed to query flash area configuration"},
+		3:  {"NO_IMAGE", "no image in slot"},
+		4:  {"NO_TLVS", "slot image is missing TLV information"},
+		5:  {"INVALID_TLV", "slot image has an invalid TLV type or length"},
+		6:  {"TLV_MULTIPLE_HASHES_FOUND", "slot image has multiple hash TLVs"},
+		7:  {"TLV_INVALID_SIZE", "slot image has an invalid TLV size"},
+		8:  {"HASH_NOT_FOUND", "slot image does not have a hash TLV"},
+		9:  {"NO_FREE_SLOT", "no free slot to place image"},
+		10: {"FLASH_OPEN_FAILED", "flash area opening failed"},
+		11: {"FLASH_READ_FAILED", "flash area reading failed"},
+		12: {"FLASH_WRITE_FAILED", "flash area writing failed"},
+		13: {"FLASH_ERASE_FAILED", "flash area erase failed"},
+		14: {"INVALID_SLOT", "invalid slot"},
+		15: {"NO_FREE_MEMORY", "insufficient heap memory"},
+		16: {"FLASH_CONTEXT_ALREADY_SET", "flash context already set"},
+		17: {"FLASH_CONTEXT_NOT_SET", "flash context not set"},
+		18: {"FLASH_AREA_DEVICE_NULL", "flash area device is null"},
+		19: {"INVALID_PAGE_OFFSET", "invalid page offset"},
+		20: {"INVALID_OFFSET", "upload request offset is invalid"},
+		21: {"INVALID_LENGTH", "upload request length is invalid"},
+		22: {"INVALID_IMAGE_HEADER", "image header is invalid"},
+		23: {"INVALID_IMAGE_HEADER_MAGIC", "image header magic is invalid"},
+		24: {"INVALID_HASH", "hash is not valid"},
+		25: {"INVALID_FLASH_ADDRESS", "image load address is invalid"},
+		26: {"VERSION_GET_FAILED", "failed to get version of running image"},
+		27: {"CURRENT_VERSION_IS_NEWER", "running image is newer than upload"},
+		28: {"IMAGE_ALREADY_PENDING", "another image is already pending"},
+		29: {"INVALID_IMAGE_VECTOR_TABLE", "image vector table is invalid"},
+		30: {"INVALID_IMAGE_TOO_LARGE", "image is too large for slot"},
+		31: {"INVALID_IMAGE_DATA_OVERRUN", "data sent is larger than image"},
+		32: {"IMAGE_CONFIRMATION_DENIED", "image confirmation denied"},
+		33: {"IMAGE_SETTING_TEST_TO_ACTIVE_DENIED",
+			"setting active slot to test is denied"},
+		34: {"ACTIVE_SLOT_NOT_KNOWN", "active slot is not known"},
+	},
+	NMP_GROUP_STAT: {
+		1: {"UNKNOWN", "unknown error"},
+		2: {"INVALID_GROUP", "statistic group not found"},
+		3: {"INVALID_STAT_NAME", "statistic name not found"},
+		4: {"INVALID_STAT_SIZE", "size of statistic is not supported"},
+		5: {"WALK_ABORTED", "walk through statistics was aborted"},
+	},
+	NMP_GROUP_CONFIG: {
+		1: {"UNKNOWN", "unknown error"},
+		2: {"KEY_TOO_LONG", "setting key is too long"},
+		3: {"KEY_NOT_FOUND", "setting key not found"},
+		4: {"READ_NOT_SUPPORTED", "setting does not support reading"},
+		5: {"ROOT_KEY_NOT_FOUND", "setting root key not found"},
+		6: {"WRITE_NOT_SUPPORTED", "setting does not support writing"},
+		7: {"DELETE_NOT_SUPPORTED", "setting does not support deletion"},
+	},
+	NMP_GROUP_FS: {
+		1:  {"UNKNOWN", "unknown error"},
+		2:  {"FILE_INVALID_NAME", "invalid file name"},
+		3:  {"FILE_NOT_FOUND", "file not found"},
+		4:  {"FILE_IS_DIRECTORY", "path is a directory"},
+		5:  {"FILE_OPEN_FAILED", "error occurred opening file"},
+		6:  {"FILE_SEEK_FAILED", "error occurred seeking file"},
+		7:  {"FILE_READ_FAILED", "error occurred reading file"},
+		8:  {"FILE_TRUNCATE_FAILED", "error occurred truncating file"},
+		9:  {"FILE_DELETE_FAILED", "error occurred deleting file"},
+		10: {"FILE_WRITE_FAILED", "error occurred writing file"},
+		11: {"FILE_OFFSET_NOT_VALID", "offset is invalid"},
+		12: {"FILE_OFFSET_LARGER_THAN_FILE", "offset is larger than file"},
+		13: {"CHECKSUM_HASH_NOT_FOUND", "checksum or hash type not found"},
+		14: {"MOUNT_POINT_NOT_FOUND", "mount point not found"},
+		15: {"READ_ONLY_FILESYSTEM", "file system is read-only"},
+		16: {"FILE_EMPTY", "file is empty"},
+	},
+	NMP_GROUP_SHELL: {
+		1: {"UNKNOWN", "unknown error"},
+		2: {"COMMAND_TOO_LONG", "command line is too long"},
+		3: {"EMPTY_COMMAND", "no command specified"},
+	},
+}
+
+// MgmtError is an error status reported by a device in a management
+// response.  Generic codes apply to every group; group-specific codes are
+// only meaningful within the group that reported them.
+type MgmtError struct {
+	Rc int
+
+	// Indicates that Rc is a group-specific code defined by Group.
+	GroupSpecific bool
+	Group         uint16
+}
+
+func NewMgmtError(rc int) *MgmtError {
+	return &MgmtError{
+		Rc: rc,
+	}
+}
+
+func NewGroupMgmtError(group uint16, rc int) *MgmtError {
+	return &MgmtError{
+		Rc:            rc,
+		GroupSpecific: true,
+		Group:         group,
+	}
+}
+
+func (e *MgmtError) desc() (errDesc, bool) {
+	if e.GroupSpecific {
+		d, ok := groupErrDescMap[e.Group][e.Rc]
+		return d, ok
+	}
+
+	d, ok := errDescMap[e.Rc]
+	return d, ok
+}
+
+// Returns the symbolic name of the error code (e.g., "EBADSTATE").
+func (e *MgmtError) Name() string {
+	if d, ok := e.desc(); ok {
+		return d.name
+	}
+
+	if !e.GroupSpecific && e.Rc >= NMP_ERR_EPERUSER {
+		return fmt.Sprintf("EPERUSER+%d", e.Rc-NMP_ERR_EPERUSER)
+	}
+
+	return "UNKNOWN"
+}
+
+// Returns a short explanation of the error code.
+func (e *MgmtError) Explanation() string {
+	if d, ok := e.desc(); ok {
+		return d.text
+	}
+
+	if !e.GroupSpecific && e.Rc >= NMP_ERR_EPERUSER {
+		return "application-specific error"
+	}
+
+	return "unrecognized error code"
+}
+
+func (e *MgmtError) Error() string {
+	s := fmt.Sprintf("%s (%d): %s", e.Name(), e.Rc, e.Explanation())
+	if e.GroupSpecific {
+		group := GroupName(e.Group)
+		if group == "" {
+			group = fmt.Sprintf("group %d", e.Group)
+		}
+		s = group + ": " + s
+	}
+
+	return s
+}
+
+// Indicates whether err is, or wraps, a *MgmtError.
+func IsMgmtError(err error) bool {
+	var me *MgmtError
+	return errors.As(err, &me)
+}
+
+// Returns an error describing a response status code, or nil if the code
+// indicates success.
+func StatusError(rc int) error {
+	if rc == NMP_ERR_OK {
+		return nil
+	}
+
+	return NewMgmtError(rc)
+}
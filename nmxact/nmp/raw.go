@@ -0,0 +1,142 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmp
+
+import (
+	"fmt"
+
+	"github.com/ugorji/go/codec"
+)
+
+// RawReq is a request with an arbitrary op, group, and ID.  Its body is
+// encoded as a plain CBOR map.
+type RawReq struct {
+	NmpBase
+	Body map[string]interface{}
+}
+
+// RawRsp is a response whose op, group, and ID have no registered type.  Its
+// body is decoded into a generic map.
+type RawRsp struct {
+	NmpBase
+	Body map[string]interface{}
+}
+
+func NewRawReq(op uint8, group uint16, id uint8) *RawReq {
+	r := &RawReq{
+		Body: map[string]interface{}{},
+	}
+	fillNmpReq(r, op, group, id)
+	return r
+}
+
+func (r *RawReq) Msg() *NmpMsg {
+	body := r.Body
+	if body == nil {
+		body = map[string]interface{}{}
+	}
+
+	return &NmpMsg{
+		Hdr:  *r.Hdr(),
+		Body: body,
+	}
+}
+
+func NewRawRsp() *RawRsp {
+	return &RawRsp{
+		Body: map[string]interface{}{},
+	}
+}
+
+func (r *RawRsp) Msg() *NmpMsg {
+	return &NmpMsg{
+		Hdr:  *r.Hdr(),
+		Body: r.Body,
+	}
+}
+
+// Returns the response's status code, or 0 if it indicates success.
+func (r *RawRsp) Rc() int {
+	if merr := r.RspErr(); merr != nil {
+		return merr.Rc
+	}
+
+	switch rc := r.Body["rc"].(type) {
+	case int64:
+		return int(rc)
+	case uint64:
+		return int(rc)
+	case int:
+		return rc
+	default:
+		return 0
+	}
+}
+
+func decodeRawRsp(hdr *NmpHdr, body []byte) (*RawRsp, error) {
+	r := NewRawRsp()
+	if len(body) > 0 {
+		dec := codec.NewDecoderBytes(body, new(codec.CborHandle))
+		if err := dec.Decode(&r.Body); err != nil {
+			return nil, fmt.Errorf("Invalid response: %s", err.Error())
+		}
+	}
+
+	r.SetHdr(hdr)
+	setRawBody(r, body)
+	if err := applyRspStatus(r, body); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Converts any response into a RawRsp.  A decoded response is converted from
+// the CBOR body it was decoded from, so every field the peer sent is
+// preserved.  A response that was built rather than decoded is converted by
+// re-encoding it; fields not represented in its struct are not recovered.
+func ToRawRsp(rsp NmpRsp) (*RawRsp, error) {
+	if r, ok := rsp.(*RawRsp); ok {
+		return r, nil
+	}
+
+	var body []byte
+	if rb, ok := rsp.(interface{ RawBody() []byte }); ok {
+		body = rb.RawBody()
+	}
+
+	if body == nil {
+		var err error
+		body, err = BodyBytes(rsp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := decodeRawRsp(rsp.Hdr(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	// The body of an OMP response also carries the NMP header.
+	delete(r.Body, "_h")
+
+	return r, nil
+}
@@ -48,6 +48,9 @@ func NewRunTestRsp() *RunTestRsp {
 
 func (r *RunTestRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *RunTestRsp) RspRc() int      { return r.Rc }
+func (r *RunTestRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $list                                                                    //
 //////////////////////////////////////////////////////////////////////////////
@@ -75,3 +78,6 @@ func NewRunListRsp() *RunListRsp {
 }
 
 func (r *RunListRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *RunListRsp) RspRc() int      { return r.Rc }
+func (r *RunListRsp) SetRspRc(rc int) { r.Rc = rc }
@@ -42,3 +42,6 @@ func NewShellExecRsp() *ShellExecRsp {
 
 func (r *ShellExecReq) Msg() *NmpMsg { return MsgFromReq(r) }
 func (r *ShellExecRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *ShellExecRsp) RspRc() int      { return r.Rc }
+func (r *ShellExecRsp) SetRspRc(rc int) { r.Rc = rc }
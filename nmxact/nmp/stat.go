@@ -52,6 +52,9 @@ func NewStatReadRsp() *StatReadRsp {
 
 func (r *StatReadRsp) Msg() *NmpMsg { return MsgFromReq(r) }
 
+func (r *StatReadRsp) RspRc() int      { return r.Rc }
+func (r *StatReadRsp) SetRspRc(rc int) { r.Rc = rc }
+
 //////////////////////////////////////////////////////////////////////////////
 // $list                                                                    //
 //////////////////////////////////////////////////////////////////////////////
@@ -79,3 +82,6 @@ func NewStatListRsp() *StatListRsp {
 }
 
 func (r *StatListRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *StatListRsp) RspRc() int      { return r.Rc }
+func (r *StatListRsp) SetRspRc(rc int) { r.Rc = rc }
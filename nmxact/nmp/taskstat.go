@@ -44,3 +44,6 @@ func NewTaskStatRsp() *TaskStatRsp {
 }
 
 func (r *TaskStatRsp) Msg() *NmpMsg { return MsgFromReq(r) }
+
+func (r *TaskStatRsp) RspRc() int      { return r.Rc }
+func (r *TaskStatRsp) SetRspRc(rc int) { r.Rc = rc }
@@ -0,0 +1,200 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmserial
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"sync"
+
+	"github.com/joaojeronimo/go-crc16"
+	log "github.com/sirupsen/logrus"
+)
+
+// Deframer reassembles packets from the lines of a serial console.  Each
+// packet is carried by a start frame ("\x06\x09") followed by zero or more
+// continuation frames ("\x04\x14"); each frame is a line of base64 text.
+// The decoded data consists of a two-byte length, the packet, and a CRC16.
+type Deframer struct {
+	// Largest packet accepted; the length carried in the packet header.
+	MaxPacketSize int
+
+	pkt *Packet
+
+	// Set when a continuation frame with no packet in progress is received.
+	// Further orphaned continuations are counted but not reported until the
+	// next start frame.
+	desynced bool
+
+	statsMtx sync.Mutex
+	stats    XportStats
+}
+
+func NewDeframer(maxPacketSize int) *Deframer {
+	if maxPacketSize <= 0 {
+		maxPacketSize = DFLT_MAX_PACKET_SIZE
+	}
+
+	return &Deframer{
+		MaxPacketSize: maxPacketSize,
+	}
+}
+
+// Returns a snapshot of the deframer's receive counters.
+func (d *Deframer) Stats() XportStats {
+	d.statsMtx.Lock()
+	defer d.statsMtx.Unlock()
+
+	return d.stats
+}
+
+func (d *Deframer) updateStats(fn func(st *XportStats)) {
+	d.statsMtx.Lock()
+	defer d.statsMtx.Unlock()
+
+	fn(&d.stats)
+}
+
+// Discards any partially received packet without reporting it.
+func (d *Deframer) Reset() {
+	d.pkt = nil
+	d.desynced = false
+}
+
+// Abandons the packet currently being received, if any.  Returns a
+// TruncatedPktError describing the lost packet, or nil if no packet was in
+// progress.
+func (d *Deframer) Abandon() error {
+	if d.pkt == nil {
+		return nil
+	}
+
+	d.updateStats(func(st *XportStats) { st.TruncatedPkts++ })
+	err := NewTruncatedPktError(int(d.pkt.expectedLen), d.pkt.buffer.Len())
+	err.Data = d.pkt.GetBytes()
+	d.pkt = nil
+
+	return err
+}
+
+// Reports a frame that cannot belong to any packet.
+func (d *Deframer) desyncError(format string, args ...interface{}) error {
+	d.updateStats(func(st *XportStats) { st.DesyncFrames++ })
+	return FmtDesyncError(format, args...)
+}
+
+// Processes a single line of console input.  Returns the assembled packet,
+// with its length and CRC removed, if this line completes one.  Lines which
+// are not frames are ignored.  A non-nil error indicates corruption on the
+// link.
+func (d *Deframer) RxLine(line []byte) ([]byte, error) {
+	for len(line) > 1 && line[0] == '\r' {
+		line = line[1:]
+	}
+	for len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+
+	if len(line) < 2 || ((line[0] != 4 || line[1] != 20) &&
+		(line[0] != 6 || line[1] != 9)) {
+
+		if len(line) > 0 {
+			d.updateStats(func(st *XportStats) { st.NoiseLines++ })
+		}
+		return nil, nil
+	}
+
+	start := line[0] == 6 && line[1] == 9
+
+	if !start && d.pkt == nil {
+		if d.desynced {
+			d.updateStats(func(st *XportStats) { st.DesyncFrames++ })
+			return nil, nil
+		}
+		d.desynced = true
+		return nil, d.desyncError(
+			"Serial continuation frame without a packet in progress")
+	}
+
+	base64Data := string(line[2:])
+
+	data, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		d.pkt = nil
+		return nil, d.desyncError("Couldn't decode base64 string:"+
+			" %s\nPacket hex dump:\n%s",
+			base64Data, hex.Dump(line))
+	}
+
+	if start {
+		d.desynced = false
+
+		if d.pkt != nil {
+			// The previous packet will never complete.  By now, the
+			// request it answered has most likely timed out, and the new
+			// packet may be the response to a later request, so just
+			// count the loss.
+			err := d.Abandon()
+			log.Debugf("%s", err.Error())
+		}
+
+		if len(data) < 2 {
+			return nil, d.desyncError("Serial start frame too short")
+		}
+
+		pktLen := binary.BigEndian.Uint16(data[0:2])
+		if pktLen < 2 || int(pktLen) > d.MaxPacketSize {
+			return nil, d.desyncError("Invalid serial packet length: %d; "+
+				"max=%d", pktLen, d.MaxPacketSize)
+		}
+
+		d.pkt, err = NewPacket(pktLen)
+		if err != nil {
+			return nil, err
+		}
+		data = data[2:]
+	}
+
+	full := d.pkt.AddBytes(data)
+	if !full {
+		return nil, nil
+	}
+
+	pkt := d.pkt
+	d.pkt = nil
+
+	crcOk := crc16.Crc16(pkt.GetBytes()) == 0
+
+	/*
+	 * Trim away the 2 bytes of CRC
+	 */
+	pkt.TrimEnd(2)
+
+	if !crcOk {
+		d.updateStats(func(st *XportStats) { st.CrcErrors++ })
+		err := NewCrcError("CRC error")
+		err.Data = pkt.GetBytes()
+		return nil, err
+	}
+
+	d.updateStats(func(st *XportStats) { st.RxPkts++ })
+	return pkt.GetBytes(), nil
+}
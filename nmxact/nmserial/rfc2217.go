@@ -0,0 +1,219 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmserial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Telnet commands and options (RFC 854, RFC 856, RFC 858, RFC 2217).
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptBinary  = 0
+	telnetOptSGA     = 3
+	telnetOptComPort = 44
+
+	comPortSetBaud     = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+
+	comPortParityNone = 1
+	comPortStopSize1  = 1
+)
+
+type telnetState int
+
+const (
+	telnetStateData telnetState = iota
+	telnetStateIac
+	telnetStateOpt
+	telnetStateSub
+	telnetStateSubIac
+)
+
+// rfc2217Conn carries serial data over a telnet connection to an RFC 2217
+// console server.  Telnet commands are stripped from incoming data and IAC
+// bytes in outgoing data are escaped.
+type rfc2217Conn struct {
+	conn net.Conn
+
+	// Read-side telnet parser state.
+	state telnetState
+	cmd   byte
+
+	// Serializes writes from the reader (option replies) and the caller.
+	wmtx sync.Mutex
+}
+
+func newRfc2217Conn(conn net.Conn, baud int) (*rfc2217Conn, error) {
+	c := &rfc2217Conn{
+		conn: conn,
+	}
+
+	// Request an 8-bit clean channel and configure the remote port.
+	b := &bytes.Buffer{}
+	b.Write([]byte{telnetIAC, telnetWILL, telnetOptBinary})
+	b.Write([]byte{telnetIAC, telnetDO, telnetOptBinary})
+	b.Write([]byte{telnetIAC, telnetDO, telnetOptSGA})
+	b.Write([]byte{telnetIAC, telnetWILL, telnetOptComPort})
+
+	baudBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(baudBytes, uint32(baud))
+	c.writeSub(b, comPortSetBaud, baudBytes)
+	c.writeSub(b, comPortSetDataSize, []byte{8})
+	c.writeSub(b, comPortSetParity, []byte{comPortParityNone})
+	c.writeSub(b, comPortSetStopSize, []byte{comPortStopSize1})
+
+	if err := c.writeRaw(b.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// writeSub appends a COM-PORT-OPTION subnegotiation to the buffer.
+func (c *rfc2217Conn) writeSub(b *bytes.Buffer, cmd byte, val []byte) {
+	b.Write([]byte{telnetIAC, telnetSB, telnetOptComPort, cmd})
+	b.Write(escapeIac(val))
+	b.Write([]byte{telnetIAC, telnetSE})
+}
+
+func (c *rfc2217Conn) writeRaw(b []byte) error {
+	c.wmtx.Lock()
+	defer c.wmtx.Unlock()
+
+	_, err := c.conn.Write(b)
+	return err
+}
+
+func escapeIac(b []byte) []byte {
+	return bytes.Replace(b, []byte{telnetIAC}, []byte{telnetIAC, telnetIAC},
+		-1)
+}
+
+// replyOpt refuses any option the client did not request.
+func (c *rfc2217Conn) replyOpt(cmd byte, opt byte) {
+	var rsp byte
+
+	switch cmd {
+	case telnetDO:
+		if opt == telnetOptBinary || opt == telnetOptComPort {
+			return
+		}
+		rsp = telnetWONT
+
+	case telnetWILL:
+		if opt == telnetOptBinary || opt == telnetOptSGA {
+			return
+		}
+		rsp = telnetDONT
+
+	default:
+		return
+	}
+
+	if err := c.writeRaw([]byte{telnetIAC, rsp, opt}); err != nil {
+		log.Debugf("Failed to send telnet option reply: %s", err.Error())
+	}
+}
+
+func (c *rfc2217Conn) Read(p []byte) (int, error) {
+	for {
+		buf := make([]byte, len(p))
+		n, err := c.conn.Read(buf)
+
+		out := 0
+		for _, b := range buf[:n] {
+			switch c.state {
+			case telnetStateData:
+				if b == telnetIAC {
+					c.state = telnetStateIac
+				} else {
+					p[out] = b
+					out++
+				}
+
+			case telnetStateIac:
+				switch b {
+				case telnetIAC:
+					p[out] = b
+					out++
+					c.state = telnetStateData
+				case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+					c.cmd = b
+					c.state = telnetStateOpt
+				case telnetSB:
+					c.state = telnetStateSub
+				default:
+					c.state = telnetStateData
+				}
+
+			case telnetStateOpt:
+				c.replyOpt(c.cmd, b)
+				c.state = telnetStateData
+
+			case telnetStateSub:
+				// Server notifications are ignored.
+				if b == telnetIAC {
+					c.state = telnetStateSubIac
+				}
+
+			case telnetStateSubIac:
+				if b == telnetSE {
+					c.state = telnetStateData
+				} else {
+					c.state = telnetStateSub
+				}
+			}
+		}
+
+		// Don't report a zero-length read unless the connection failed; the
+		// caller would mistake it for end of stream.
+		if out > 0 || err != nil {
+			return out, err
+		}
+	}
+}
+
+func (c *rfc2217Conn) Write(p []byte) (int, error) {
+	if err := c.writeRaw(escapeIac(p)); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *rfc2217Conn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,122 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmserial
+
+import (
+	"fmt"
+)
+
+// Indicates a received packet whose CRC did not match its contents.
+type CrcError struct {
+	Text string
+
+	// The packet, without its CRC.
+	Data []byte
+}
+
+func NewCrcError(text string) *CrcError {
+	return &CrcError{
+		Text: text,
+	}
+}
+
+func (e *CrcError) Error() string {
+	return e.Text
+}
+
+func IsCrcError(err error) bool {
+	_, ok := err.(*CrcError)
+	return ok
+}
+
+// Indicates that a packet was abandoned before all of its bytes were
+// received.
+type TruncatedPktError struct {
+	Text     string
+	Expected int
+	Received int
+
+	// The part of the packet that was received.
+	Data []byte
+}
+
+func NewTruncatedPktError(expected int, received int) *TruncatedPktError {
+	return &TruncatedPktError{
+		Text: fmt.Sprintf("Truncated serial packet; expected %d bytes, "+
+			"received %d", expected, received),
+		Expected: expected,
+		Received: received,
+	}
+}
+
+func (e *TruncatedPktError) Error() string {
+	return e.Text
+}
+
+func IsTruncatedPkt(err error) bool {
+	_, ok := err.(*TruncatedPktError)
+	return ok
+}
+
+// Indicates a frame that cannot belong to any packet: a continuation frame
+// with no packet in progress, a frame that is not valid base64, or a packet
+// header specifying an unacceptable length.
+type DesyncError struct {
+	Text string
+}
+
+func NewDesyncError(text string) *DesyncError {
+	return &DesyncError{
+		Text: text,
+	}
+}
+
+func FmtDesyncError(format string, args ...interface{}) *DesyncError {
+	return NewDesyncError(fmt.Sprintf(format, args...))
+}
+
+func (e *DesyncError) Error() string {
+	return e.Text
+}
+
+func IsDesync(err error) bool {
+	_, ok := err.(*DesyncError)
+	return ok
+}
+
+// Indicates whether an error was caused by a corrupt or incomplete frame on
+// the serial link.
+func IsFrameError(err error) bool {
+	return IsCrcError(err) || IsTruncatedPkt(err) || IsDesync(err)
+}
+
+// Returns the received bytes of the packet that a frame error concerns, or
+// nil if the error cannot be attributed to a packet.  The bytes may be
+// corrupt.
+func FrameErrorData(err error) []byte {
+	switch e := err.(type) {
+	case *CrcError:
+		return e.Data
+	case *TruncatedPktError:
+		return e.Data
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,143 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmserial
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tarm/serial"
+)
+
+const (
+	// Device path prefix indicating a raw TCP connection to a console server
+	// (e.g., ser2net in raw mode).
+	SOCKET_PREFIX = "socket://"
+
+	// Device path prefix indicating a telnet connection to a console server
+	// that implements RFC 2217 (e.g., ser2net in telnet mode).
+	RFC2217_PREFIX = "rfc2217://"
+)
+
+// Implemented by ports which can discard buffered data.
+type flusher interface {
+	Flush() error
+}
+
+// Implemented by ports whose reads return io.EOF on timeout rather than on
+// disconnect.
+type timeoutReader interface {
+	eofIsTimeout() bool
+}
+
+// ttyPort wraps a tarm serial port.  tarm reports a read timeout as a
+// zero-length read, which the scanner sees as EOF.
+type ttyPort struct {
+	*serial.Port
+}
+
+func (p ttyPort) eofIsTimeout() bool {
+	return true
+}
+
+// isNetworkPath indicates whether a device path names a network endpoint
+// rather than a local device.
+func isNetworkPath(path string) bool {
+	return strings.HasPrefix(path, SOCKET_PREFIX) ||
+		strings.HasPrefix(path, RFC2217_PREFIX)
+}
+
+// openPort opens the stream described by the transport configuration.
+func openPort(cfg *XportCfg) (io.ReadWriteCloser, error) {
+	if cfg.Port != nil {
+		return cfg.Port, nil
+	}
+
+	switch {
+	case strings.HasPrefix(cfg.DevPath, SOCKET_PREFIX):
+		addr := strings.TrimPrefix(cfg.DevPath, SOCKET_PREFIX)
+		return dialSocket(addr, cfg)
+
+	case strings.HasPrefix(cfg.DevPath, RFC2217_PREFIX):
+		addr := strings.TrimPrefix(cfg.DevPath, RFC2217_PREFIX)
+		conn, err := dialSocket(addr, cfg)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := newRfc2217Conn(conn, cfg.Baud)
+		if err != nil {
+			return nil, err
+		}
+		return rc, nil
+
+	default:
+		return openTty(cfg)
+	}
+}
+
+func dialSocket(addr string, cfg *XportCfg) (net.Conn, error) {
+	timeout := cfg.ConnectTimeout
+	if timeout == 0 {
+		timeout = DFLT_CONNECT_TIMEOUT
+	}
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to serial socket %s: %s",
+			addr, err.Error())
+	}
+
+	return conn, nil
+}
+
+func openTty(cfg *XportCfg) (io.ReadWriteCloser, error) {
+	c := &serial.Config{
+		Name:        cfg.DevPath,
+		Baud:        cfg.Baud,
+		ReadTimeout: cfg.ReadTimeout,
+	}
+
+	port, err := serial.OpenPort(c)
+	if err == nil {
+		return ttyPort{port}, nil
+	}
+
+	// Pseudo terminals (e.g., created by socat) may reject the line settings
+	// that a real UART requires.  Fall back to opening them as plain
+	// character devices.
+	fi, serr := os.Stat(cfg.DevPath)
+	if serr != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return nil, err
+	}
+
+	log.Debugf("Failed to configure %s as a serial port (%s); "+
+		"opening as a character device", cfg.DevPath, err.Error())
+
+	f, ferr := os.OpenFile(cfg.DevPath, os.O_RDWR, 0)
+	if ferr != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,309 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmserial
+
+import (
+	"fmt"
+
+	"github.com/runtimeco/go-coap"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// Maximum number of outstanding CoAP tokens tracked per transport.  Tokens
+// of requests which never receive a response are evicted beyond this limit;
+// a late response then falls back to being offered to every session.
+const maxCoapRoutes = 256
+
+// Identifies the transaction that a serial packet belongs to.
+type pktKey struct {
+	isCoap  bool
+	token   string
+	seq     uint8
+	request bool
+	observe bool
+}
+
+// Indicates whether a packet contains a CoAP message (as opposed to a plain
+// NMP message).  CoAP messages always start with a version of 1 in the top
+// two bits; NMP requests and responses never do.
+func isCoapPkt(b []byte) bool {
+	return len(b) > 0 && b[0]>>6 == 1
+}
+
+func parsePktKey(b []byte) (pktKey, error) {
+	if isCoapPkt(b) {
+		m, err := coap.ParseDgramMessage(b)
+		if err != nil {
+			return pktKey{}, err
+		}
+
+		return pktKey{
+			isCoap:  true,
+			token:   string(m.Token()),
+			request: m.Code() >= coap.GET && m.Code() <= coap.DELETE,
+			observe: m.Option(coap.Observe) != nil,
+		}, nil
+	}
+
+	if len(b) < nmp.NMP_HDR_SIZE {
+		return pktKey{}, fmt.Errorf("Serial packet too short: %d", len(b))
+	}
+
+	hdr, err := nmp.DecodeNmpHdr(b)
+	if err != nil {
+		return pktKey{}, err
+	}
+
+	return pktKey{
+		seq: hdr.Seq,
+		request: hdr.Op == nmp.NMP_OP_READ ||
+			hdr.Op == nmp.NMP_OP_WRITE,
+	}, nil
+}
+
+// Like parsePktKey, but only requires the header of the packet.  This allows
+// the packet of a frame error to be attributed to a transaction.
+func parsePktKeyHdr(b []byte) (pktKey, bool) {
+	if isCoapPkt(b) {
+		if len(b) < 4 {
+			return pktKey{}, false
+		}
+		tkl := int(b[0] & 0x0f)
+		if tkl > 8 || len(b) < 4+tkl {
+			return pktKey{}, false
+		}
+
+		code := coap.COAPCode(b[1])
+		return pktKey{
+			isCoap:  true,
+			token:   string(b[4 : 4+tkl]),
+			request: code >= coap.GET && code <= coap.DELETE,
+		}, true
+	}
+
+	hdr, err := nmp.DecodeNmpHdr(b)
+	if err != nil {
+		return pktKey{}, false
+	}
+
+	return pktKey{
+		seq: hdr.Seq,
+		request: hdr.Op == nmp.NMP_OP_READ ||
+			hdr.Op == nmp.NMP_OP_WRITE,
+	}, true
+}
+
+type coapRoute struct {
+	s *SerialSesn
+
+	// Observe registrations remain in place across responses.
+	observe bool
+}
+
+// Registers an open client session with the transport.
+func (sx *SerialXport) addSesn(s *SerialSesn) {
+	sx.Lock()
+	defer sx.Unlock()
+
+	sx.sesns[s] = struct{}{}
+}
+
+// Unregisters a session and forgets all of its outstanding requests.
+func (sx *SerialXport) removeSesn(s *SerialSesn) {
+	sx.Lock()
+	defer sx.Unlock()
+
+	delete(sx.sesns, s)
+
+	for seq, rs := range sx.nmpRoutes {
+		if rs == s {
+			delete(sx.nmpRoutes, seq)
+		}
+	}
+	for tok, r := range sx.coapRoutes {
+		if r.s == s {
+			delete(sx.coapRoutes, tok)
+		}
+	}
+
+	if s == sx.acceptSesn {
+		sx.acceptSesn = nil
+	}
+	if s == sx.reqSesn {
+		sx.reqSesn = nil
+	}
+}
+
+// Indicates whether a session is still waiting for the response to the
+// request with the specified key.  Routes of requests that timed out or were
+// aborted remain in the tables until they are reused.
+func (s *SerialSesn) awaiting(key pktKey) bool {
+	if !key.isCoap {
+		return s.txvr.Listening(key.seq)
+	}
+	if len(key.token) == 1 {
+		// OMP token; the token is the NMP sequence number.
+		return s.txvr.Listening(key.token[0])
+	}
+	return true
+}
+
+// Indicates whether a response carrying the specified sequence number, as an
+// NMP sequence number or as an OMP token, is awaited by some session.  The
+// caller must lock the transport.
+func (sx *SerialXport) seqRouted(seq uint8) bool {
+	if s, ok := sx.nmpRoutes[seq]; ok && s.awaiting(pktKey{seq: seq}) {
+		return true
+	}
+
+	tok := string(nmxutil.SeqToToken(seq))
+	if r, ok := sx.coapRoutes[tok]; ok &&
+		r.s.awaiting(pktKey{isCoap: true, token: tok}) {
+
+		return true
+	}
+
+	return false
+}
+
+// Allocates a sequence number for a request sent by any session on the
+// transport.  Sessions share the route tables, so numbers awaiting a
+// response are skipped; otherwise, a response could be routed to the wrong
+// session.
+func (sx *SerialXport) nextSeq() uint8 {
+	sx.Lock()
+	defer sx.Unlock()
+
+	for i := 0; i < 256; i++ {
+		seq := sx.seq
+		sx.seq++
+
+		if !sx.seqRouted(seq) {
+			return seq
+		}
+	}
+
+	// Every number is in use; the send will fail.
+	seq := sx.seq
+	sx.seq++
+	return seq
+}
+
+// Records which session is expecting the response to an outgoing packet.
+// Fails if another session is still expecting a response with the same
+// sequence number or token.
+func (sx *SerialXport) addRoute(s *SerialSesn, b []byte) error {
+	key, err := parsePktKey(b)
+	if err != nil || !key.request {
+		return nil
+	}
+
+	sx.Lock()
+	defer sx.Unlock()
+
+	if !key.isCoap {
+		if rs, ok := sx.nmpRoutes[key.seq]; ok && rs != s &&
+			rs.awaiting(key) {
+
+			return fmt.Errorf("NMP sequence number %d is in use by "+
+				"another serial session", key.seq)
+		}
+		sx.nmpRoutes[key.seq] = s
+		return nil
+	}
+
+	if r, ok := sx.coapRoutes[key.token]; ok && r.s != s && r.s.awaiting(key) {
+		return fmt.Errorf("CoAP token %x is in use by another serial "+
+			"session", key.token)
+	}
+
+	if _, ok := sx.coapRoutes[key.token]; !ok &&
+		len(sx.coapRoutes) >= maxCoapRoutes {
+
+		for tok, r := range sx.coapRoutes {
+			if !r.observe {
+				delete(sx.coapRoutes, tok)
+				break
+			}
+		}
+	}
+	sx.coapRoutes[key.token] = coapRoute{
+		s:       s,
+		observe: key.observe,
+	}
+
+	return nil
+}
+
+// Determines which sessions an incoming response should be delivered to.
+// The caller must lock the transport.
+func (sx *SerialXport) rspTargets(key pktKey) []*SerialSesn {
+	if key.isCoap {
+		if r, ok := sx.coapRoutes[key.token]; ok {
+			if !key.observe {
+				delete(sx.coapRoutes, key.token)
+			}
+			return []*SerialSesn{r.s}
+		}
+	} else {
+		if s, ok := sx.nmpRoutes[key.seq]; ok {
+			delete(sx.nmpRoutes, key.seq)
+			return []*SerialSesn{s}
+		}
+	}
+
+	// Unsolicited or unknown; let each session's dispatcher decide.
+	var sesns []*SerialSesn
+	for s := range sx.sesns {
+		if key.isCoap || s.cfg.MgmtProto == sesn.MGMT_PROTO_NMP {
+			sesns = append(sesns, s)
+		}
+	}
+	return sesns
+}
+
+// Determines which session was expecting the packet that a frame error
+// concerns.  Returns nil if the packet cannot be identified or no session is
+// expecting it.  The caller must lock the transport.
+func (sx *SerialXport) frameErrTarget(err error) *SerialSesn {
+	key, ok := parsePktKeyHdr(FrameErrorData(err))
+	if !ok || key.request {
+		return nil
+	}
+
+	if key.isCoap {
+		r, ok := sx.coapRoutes[key.token]
+		if !ok || r.observe {
+			return nil
+		}
+		delete(sx.coapRoutes, key.token)
+		return r.s
+	}
+
+	s, ok := sx.nmpRoutes[key.seq]
+	if !ok {
+		return nil
+	}
+	delete(sx.nmpRoutes, key.seq)
+	return s
+}
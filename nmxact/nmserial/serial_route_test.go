@@ -0,0 +1,228 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package nmserial
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// Starts a client transport connected to a device transport through a pipe.
+// Packets that the device transport receives are discarded.
+func newRouteTestXports(t *testing.T) (*SerialXport, *SerialXport) {
+	host, dev := net.Pipe()
+
+	hcfg := NewXportCfg()
+	hcfg.Port = host
+	hcfg.FrameDelay = 0
+	hx := NewSerialXport(hcfg)
+
+	dcfg := NewXportCfg()
+	dcfg.Port = dev
+	dcfg.FrameDelay = 0
+	dx := NewSerialXport(dcfg)
+
+	for _, x := range []*SerialXport{hx, dx} {
+		if err := x.Start(); err != nil {
+			t.Fatalf("Start(): %s", err.Error())
+		}
+	}
+
+	return hx, dx
+}
+
+func newRouteTestSesn(t *testing.T, sx *SerialXport) *SerialSesn {
+	s, err := NewSerialSesn(sx, sesn.NewSesnCfg())
+	if err != nil {
+		t.Fatalf("NewSerialSesn(): %s", err.Error())
+	}
+	if err := s.Open(); err != nil {
+		t.Fatalf("Open(): %s", err.Error())
+	}
+
+	return s
+}
+
+type routeTestReq struct {
+	rspc chan nmp.NmpRsp
+	errc chan error
+}
+
+// Sends an echo request with the specified sequence number.
+func routeTestEcho(s *SerialSesn, seq uint8) (*routeTestReq, error) {
+	r := &routeTestReq{
+		rspc: make(chan nmp.NmpRsp, 1),
+		errc: make(chan error, 1),
+	}
+
+	req := nmp.NewEchoReq()
+	req.Payload = "hi"
+	m := req.Msg()
+	m.Hdr.Seq = seq
+
+	err := s.TxRxMgmtAsync(m, time.Minute, r.rspc, r.errc)
+	return r, err
+}
+
+// Sends an echo response with the specified sequence number from the
+// device.
+func routeTestRsp(t *testing.T, dx *SerialXport, seq uint8) {
+	rsp := nmp.NewEchoRsp()
+	rsp.Payload = "hi"
+	m := rsp.Msg()
+	m.Hdr = nmp.NmpHdr{
+		Op:    nmp.NMP_OP_WRITE_RSP,
+		Group: nmp.NMP_GROUP_DEFAULT,
+		Id:    nmp.NMP_ID_DEF_ECHO,
+		Seq:   seq,
+	}
+
+	b, err := nmp.EncodeNmpPlain(m)
+	if err != nil {
+		t.Fatalf("EncodeNmpPlain(): %s", err.Error())
+	}
+	if err := dx.Tx(b); err != nil {
+		t.Fatalf("Tx(): %s", err.Error())
+	}
+}
+
+func TestSerialSeqCollision(t *testing.T) {
+	hx, dx := newRouteTestXports(t)
+	defer dx.Stop()
+	defer hx.Stop()
+
+	s1 := newRouteTestSesn(t, hx)
+	defer s1.Close()
+	s2 := newRouteTestSesn(t, hx)
+	defer s2.Close()
+
+	const seq = 7
+
+	// A request from the second session must not take over the route of
+	// the first session's outstanding request.
+	r1, err := routeTestEcho(s1, seq)
+	if err != nil {
+		t.Fatalf("first request: %s", err.Error())
+	}
+	if _, err := routeTestEcho(s2, seq); err == nil {
+		t.Fatalf("second request with seq %d accepted", seq)
+	}
+
+	// The transport does not allocate a number that is awaited.
+	hx.Lock()
+	hx.seq = seq
+	hx.Unlock()
+	for _, s := range []*SerialSesn{s1, s2} {
+		if n := s.NextSeq(); n == seq {
+			t.Fatalf("NextSeq() returned awaited seq %d", seq)
+		}
+	}
+
+	routeTestRsp(t, dx, seq)
+	select {
+	case <-r1.rspc:
+	case err := <-r1.errc:
+		t.Fatalf("first request failed: %s", err.Error())
+	case <-time.After(5 * time.Second):
+		t.Fatalf("response not delivered to first session")
+	}
+
+	// The number is free once the first request is answered.
+	r2, err := routeTestEcho(s2, seq)
+	if err != nil {
+		t.Fatalf("second request after response: %s", err.Error())
+	}
+
+	routeTestRsp(t, dx, seq)
+	select {
+	case <-r2.rspc:
+	case err := <-r2.errc:
+		t.Fatalf("second request failed: %s", err.Error())
+	case <-time.After(5 * time.Second):
+		t.Fatalf("response not delivered to second session")
+	}
+}
+
+func TestSerialSeqAbandoned(t *testing.T) {
+	hx, dx := newRouteTestXports(t)
+	defer dx.Stop()
+	defer hx.Stop()
+
+	s1 := newRouteTestSesn(t, hx)
+	defer s1.Close()
+	s2 := newRouteTestSesn(t, hx)
+	defer s2.Close()
+
+	const seq = 9
+
+	// A request that was aborted without a response leaves its route
+	// behind; the number can be reused by another session.
+	r1, err := routeTestEcho(s1, seq)
+	if err != nil {
+		t.Fatalf("first request: %s", err.Error())
+	}
+	s1.AbortRx(seq)
+	select {
+	case <-r1.errc:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("aborted request did not fail")
+	}
+
+	r2, err := routeTestEcho(s2, seq)
+	if err != nil {
+		t.Fatalf("second request: %s", err.Error())
+	}
+
+	routeTestRsp(t, dx, seq)
+	select {
+	case <-r2.rspc:
+	case err := <-r2.errc:
+		t.Fatalf("second request failed: %s", err.Error())
+	case <-time.After(5 * time.Second):
+		t.Fatalf("response not delivered to second session")
+	}
+}
+
+func TestSerialNextSeqUnique(t *testing.T) {
+	hx, dx := newRouteTestXports(t)
+	defer dx.Stop()
+	defer hx.Stop()
+
+	s1 := newRouteTestSesn(t, hx)
+	defer s1.Close()
+	s2 := newRouteTestSesn(t, hx)
+	defer s2.Close()
+
+	// Sessions draw from a single counter, so they never receive the same
+	// number while fewer than 256 are allocated.
+	seen := map[uint8]bool{}
+	for i := 0; i < 128; i++ {
+		for _, s := range []*SerialSesn{s1, s2} {
+			seq := s.NextSeq()
+			if seen[seq] {
+				t.Fatalf("seq %d allocated twice", seq)
+			}
+			seen[seq] = true
+		}
+	}
+}
@@ -21,10 +21,12 @@ package nmserial
 
 import (
 	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"github.com/runtimeco/go-coap"
+	log "github.com/sirupsen/logrus"
 
 	"github.com/recogni/newtmgr/nmxact/mgmt"
 	"github.com/recogni/newtmgr/nmxact/nmcoap"
@@ -38,6 +40,7 @@ type SerialSesn struct {
 	cfg    sesn.SesnCfg
 	sx     *SerialXport
 	txvr   *mgmt.Transceiver
+	rxTap  sesn.RxTapFn
 	isOpen bool
 
 	// This mutex ensures:
@@ -83,6 +86,7 @@ func (s *SerialSesn) Open() error {
 		return err
 	}
 	s.txvr = txvr
+	s.txvr.SetRxTap(s.rxTap)
 	s.errChan = make(chan error)
 	s.msgChan = make(chan []byte, 16)
 	s.connChan = make(chan *SerialSesn, 4)
@@ -93,6 +97,7 @@ func (s *SerialSesn) Open() error {
 	if s.cfg.MgmtProto == sesn.MGMT_PROTO_COAP_SERVER {
 		return nil
 	}
+	s.sx.addSesn(s)
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
@@ -103,13 +108,18 @@ func (s *SerialSesn) Open() error {
 				if !ok {
 					continue
 				}
-				if s.cfg.MgmtProto == sesn.MGMT_PROTO_OMP {
-					s.txvr.DispatchCoap(msg)
-				} else if s.cfg.MgmtProto == sesn.MGMT_PROTO_NMP {
-					s.txvr.DispatchNmpRsp(msg)
+				s.dispatch(msg)
+			case err := <-s.errChan:
+				// Deliver any packets received before the error.
+				s.drainMsgs()
+				if err == io.EOF {
+					s.txvr.ErrorAll(nmxutil.NewXportError(
+						"Serial connection closed by peer"))
+				} else if IsFrameError(err) {
+					s.frameErr(err)
+				} else {
+					s.txvr.ErrorAll(err)
 				}
-			case <-s.errChan:
-				// XXX pass it on
 			case <-s.stopChan:
 				return
 			}
@@ -118,6 +128,62 @@ func (s *SerialSesn) Open() error {
 	return nil
 }
 
+func (s *SerialSesn) dispatch(msg []byte) {
+	if isCoapPkt(msg) {
+		s.txvr.DispatchCoap(msg)
+	} else {
+		s.txvr.DispatchNmpRsp(msg)
+	}
+}
+
+// Fails the request whose response was lost to a frame error.
+func (s *SerialSesn) frameErr(err error) {
+	key, ok := parsePktKeyHdr(FrameErrorData(err))
+	if !ok {
+		return
+	}
+
+	if !key.isCoap {
+		s.txvr.ErrorOne(key.seq, err)
+	} else if s.cfg.MgmtProto == sesn.MGMT_PROTO_OMP && len(key.token) == 1 {
+		// OMP responses carry the request's sequence number as their token.
+		s.txvr.ErrorOne(key.token[0], err)
+	} else {
+		log.Debugf("Serial frame error in CoAP response: %s", err.Error())
+	}
+}
+
+// Hands a received packet to the session.  Packets that arrive while the
+// session is closing are dropped.
+func (s *SerialSesn) deliverMsg(msg []byte) {
+	select {
+	case s.msgChan <- msg:
+	case <-s.stopChan:
+	}
+}
+
+// Reports a receive error to the session, unless the session is closing.
+func (s *SerialSesn) deliverErr(err error) {
+	select {
+	case s.errChan <- err:
+	case <-s.stopChan:
+	}
+}
+
+func (s *SerialSesn) drainMsgs() {
+	for {
+		select {
+		case msg, ok := <-s.msgChan:
+			if !ok {
+				return
+			}
+			s.dispatch(msg)
+		default:
+			return
+		}
+	}
+}
+
 func (s *SerialSesn) Close() error {
 	s.m.Lock()
 
@@ -128,19 +194,17 @@ func (s *SerialSesn) Close() error {
 	}
 
 	s.isOpen = false
+
+	// Release the transport's receive goroutine if it is blocked delivering
+	// to this session; it holds the transport lock while it does so.
+	close(s.stopChan)
+
+	// Stop receiving before shutting down the dispatch goroutine.
+	s.sx.removeSesn(s)
+
 	s.txvr.ErrorAll(fmt.Errorf("closed"))
 	s.txvr.Stop()
-	close(s.stopChan)
 	close(s.connChan)
-	s.sx.Lock()
-	if s == s.sx.acceptSesn {
-		s.sx.acceptSesn = nil
-
-	}
-	if s == s.sx.reqSesn {
-		s.sx.reqSesn = nil
-	}
-	s.sx.Unlock()
 	s.m.Unlock()
 
 	s.wg.Wait()
@@ -188,10 +252,20 @@ func (s *SerialSesn) MtuOut() int {
 }
 
 func (s *SerialSesn) AbortRx(seq uint8) error {
-	s.txvr.ErrorAll(fmt.Errorf("Rx aborted"))
+	s.txvr.AbortRx(seq)
 	return nil
 }
 
+// Sequence numbers are allocated by the transport, as all of its sessions
+// share its route tables.
+func (s *SerialSesn) NextSeq() uint8 {
+	return s.sx.nextSeq()
+}
+
+func (s *SerialSesn) tx(b []byte) error {
+	return s.sx.txSesn(s, b)
+}
+
 func (s *SerialSesn) TxRxMgmt(m *nmp.NmpMsg,
 	timeout time.Duration) (nmp.NmpRsp, error) {
 
@@ -200,29 +274,18 @@ func (s *SerialSesn) TxRxMgmt(m *nmp.NmpMsg,
 			"Attempt to transmit over closed serial session")
 	}
 
-	txFn := func(b []byte) error {
-		return s.sx.Tx(b)
-	}
-
-	err := s.sx.setRspSesn(s)
-	if err != nil {
-		return nil, err
-	}
-	defer s.sx.setRspSesn(nil)
-
-	return s.txvr.TxRxMgmt(txFn, m, s.MtuOut(), timeout)
+	return s.txvr.TxRxMgmt(s.tx, m, s.MtuOut(), timeout)
 }
 
 func (s *SerialSesn) TxRxMgmtAsync(m *nmp.NmpMsg,
 	timeout time.Duration, ch chan nmp.NmpRsp, errc chan error) error {
 
-	rsp, err := s.TxRxMgmt(m, timeout)
-	if err != nil {
-		errc <- err
-	} else {
-		ch <- rsp
+	if !s.isOpen {
+		return nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed serial session")
 	}
-	return nil
+
+	return s.txvr.TxRxMgmtAsync(s.tx, m, s.MtuOut(), timeout, ch, errc)
 }
 
 func (s *SerialSesn) TxCoap(m coap.Message) error {
@@ -231,12 +294,7 @@ func (s *SerialSesn) TxCoap(m coap.Message) error {
 			"attempt to transmit over closed serial session")
 	}
 
-	err := s.sx.setRspSesn(s)
-	if err != nil {
-		return err
-	}
-
-	return s.txvr.TxCoap(s.sx.Tx, m, s.MtuOut())
+	return s.txvr.TxCoap(s.tx, m, s.MtuOut())
 }
 
 func (s *SerialSesn) ListenCoap(
@@ -342,3 +400,10 @@ func (s *SerialSesn) SetFilters(txFilter nmcoap.TxMsgFilter,
 
 	s.txvr.SetFilters(txFilter, rxFilter)
 }
+
+func (s *SerialSesn) SetRxTap(tap sesn.RxTapFn) {
+	s.rxTap = tap
+	if s.txvr != nil {
+		s.txvr.SetRxTap(tap)
+	}
+}
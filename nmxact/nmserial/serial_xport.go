@@ -26,56 +26,154 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
 	"sync"
 	"time"
 
 	"github.com/joaojeronimo/go-crc16"
-	"github.com/runtimeco/go-coap"
 	log "github.com/sirupsen/logrus"
-	"github.com/tarm/serial"
 
 	"github.com/recogni/newtmgr/nmxact/sesn"
 	"mynewt.apache.org/newt/util"
 )
 
 type XportCfg struct {
+	// Serial device to open.  Besides local tty devices, this can be a
+	// "socket://<host>:<port>" raw TCP endpoint or a
+	// "rfc2217://<host>:<port>" telnet endpoint of a console server.
 	DevPath     string
 	Baud        int
 	Mtu         int
 	ReadTimeout time.Duration
+
+	// How long to wait for a "socket://" or "rfc2217://" endpoint to accept
+	// the connection.
+	ConnectTimeout time.Duration
+
+	// Number of base64 characters carried by each transmitted frame,
+	// excluding the two-byte frame marker and the trailing newline.  Must be
+	// a multiple of 4.
+	SegmentSize int
+
+	// Delay between consecutive frames of a single packet.  Slow targets
+	// with small receive buffers need time to process each frame.
+	FrameDelay time.Duration
+
+	// Largest packet, in bytes, accepted in either direction.  This is the
+	// length carried in the packet header: the payload plus the CRC.
+	MaxPacketSize int
+
+	// If non-nil, the transport communicates over this stream rather than
+	// opening DevPath.  The transport closes it when stopped.
+	Port io.ReadWriteCloser
 }
 
+const (
+	// A full frame (marker, 124 base64 characters, and CR-LF) fits in the
+	// 128-byte line buffer of a Mynewt console.
+	DFLT_SEGMENT_SIZE    = 124
+	DFLT_FRAME_DELAY     = 20 * time.Millisecond
+	DFLT_MAX_PACKET_SIZE = math.MaxUint16
+	DFLT_CONNECT_TIMEOUT = 5 * time.Second
+
+	// Bounds of the delay between attempts to reconnect to a network port
+	// whose peer closed the connection.
+	RECONNECT_MIN_DELAY = 100 * time.Millisecond
+	RECONNECT_MAX_DELAY = 5 * time.Second
+)
+
 var errTimeout error = errors.New("Timeout reading from serial connection")
 
 func NewXportCfg() *XportCfg {
 	return &XportCfg{
-		ReadTimeout: 10 * time.Second,
-		Mtu:         512,
+		ReadTimeout:    10 * time.Second,
+		ConnectTimeout: DFLT_CONNECT_TIMEOUT,
+		Mtu:            512,
+		SegmentSize:    DFLT_SEGMENT_SIZE,
+		FrameDelay:     DFLT_FRAME_DELAY,
+		MaxPacketSize:  DFLT_MAX_PACKET_SIZE,
 	}
 }
 
+// Frame-level counters for a serial transport.
+type XportStats struct {
+	// Packets successfully transmitted and received.
+	TxPkts uint64
+	RxPkts uint64
+
+	// Received packets discarded due to a CRC mismatch.
+	CrcErrors uint64
+
+	// Packets abandoned before they were fully received.
+	TruncatedPkts uint64
+
+	// Frames which could not be associated with a packet.
+	DesyncFrames uint64
+
+	// Received lines without a frame marker (e.g., console output).
+	NoiseLines uint64
+}
+
 type SerialXport struct {
 	cfg     *XportCfg
-	port    *serial.Port
+	port    io.ReadWriteCloser
 	scanner *bufio.Scanner
 
 	wg sync.WaitGroup
 	sync.Mutex
 	closing bool
 
+	// Closed when the transport is stopped; interrupts reconnection.
+	stopChan chan struct{}
+
+	// Server sessions: the session listening for incoming connections and
+	// the session handling requests from the peer.
 	reqSesn    *SerialSesn
 	acceptSesn *SerialSesn
-	rspSesn    *SerialSesn
 
-	pkt *Packet
+	// Open client sessions, and the sessions awaiting responses keyed by
+	// NMP sequence number or CoAP token.
+	sesns      map[*SerialSesn]struct{}
+	nmpRoutes  map[uint8]*SerialSesn
+	coapRoutes map[string]coapRoute
+
+	// Next sequence number to allocate to a session's request.
+	seq uint8
+
+	// Serializes transmission of packets, each of which may span several
+	// frames.  Also guards replacement of the port on reconnect.
+	txMtx sync.Mutex
+
+	deframer *Deframer
+
+	statsMtx sync.Mutex
+	txPkts   uint64
 }
 
 func NewSerialXport(cfg *XportCfg) *SerialXport {
 	return &SerialXport{
-		cfg: cfg,
+		cfg:        cfg,
+		sesns:      map[*SerialSesn]struct{}{},
+		nmpRoutes:  map[uint8]*SerialSesn{},
+		coapRoutes: map[string]coapRoute{},
+		seq:        uint8(rand.Uint32()),
+		deframer:   NewDeframer(cfg.MaxPacketSize),
 	}
 }
 
+// Returns a snapshot of the transport's frame-level counters.
+func (sx *SerialXport) Stats() XportStats {
+	st := sx.deframer.Stats()
+
+	sx.statsMtx.Lock()
+	defer sx.statsMtx.Unlock()
+
+	st.TxPkts = sx.txPkts
+	return st
+}
+
 func (sx *SerialXport) BuildSesn(cfg sesn.SesnCfg) (sesn.Sesn, error) {
 	return NewSerialSesn(sx, cfg)
 }
@@ -106,21 +204,35 @@ func (sx *SerialXport) Start() error {
 		return nil
 	}
 
-	c := &serial.Config{
-		Name:        sx.cfg.DevPath,
-		Baud:        sx.cfg.Baud,
-		ReadTimeout: sx.cfg.ReadTimeout,
+	if sx.cfg.SegmentSize == 0 {
+		sx.cfg.SegmentSize = DFLT_SEGMENT_SIZE
+	}
+	if sx.cfg.SegmentSize < 0 || sx.cfg.SegmentSize%4 != 0 {
+		return fmt.Errorf("Invalid serial segment size: %d; "+
+			"must be a positive multiple of 4", sx.cfg.SegmentSize)
+	}
+	if sx.cfg.MaxPacketSize == 0 {
+		sx.cfg.MaxPacketSize = DFLT_MAX_PACKET_SIZE
 	}
+	if sx.cfg.MaxPacketSize < 0 || sx.cfg.MaxPacketSize > math.MaxUint16 {
+		return fmt.Errorf("Invalid serial max packet size: %d; "+
+			"must be between 1 and %d",
+			sx.cfg.MaxPacketSize, math.MaxUint16)
+	}
+
+	sx.deframer.MaxPacketSize = sx.cfg.MaxPacketSize
 
-	var err error
-	sx.port, err = serial.OpenPort(c)
+	port, err := openPort(sx.cfg)
 	if err != nil {
 		return err
 	}
+	sx.port = port
+	sx.stopChan = make(chan struct{})
 
-	err = sx.port.Flush()
-	if err != nil {
-		return err
+	if f, ok := sx.port.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
 	}
 
 	sx.wg.Add(1)
@@ -135,65 +247,135 @@ func (sx *SerialXport) Start() error {
 			msg, err := sx.Rx()
 			sx.Lock()
 			if err != nil {
-				if sx.rspSesn != nil {
-					sx.rspSesn.errChan <- err
-				}
+				sx.dispatchErr(err)
 			}
 			if sx.closing {
 				sx.Unlock()
 				return
 			}
-			if msg == nil {
+			if err == io.EOF {
+				// The remote end of the stream closed.  Network ports are
+				// reopened; anything else is finished.
 				sx.Unlock()
-				continue
-			}
-			if len(msg) >= 4 {
-				if sx.reqSesn != nil || sx.acceptSesn != nil {
-					msgType := coap.COAPCode(msg[1])
-					if msgType <= coap.DELETE {
-						if sx.reqSesn != nil {
-							sx.reqSesn.msgChan <- msg
-							sx.Unlock()
-							continue
-						}
-						if sx.acceptSesn != nil {
-							s, err := sx.acceptServerSesn(
-								sx.acceptSesn)
-							if err != nil {
-								log.Errorf("Cannot create server sesn: %v", err)
-								sx.Unlock()
-								continue
-							}
-							s.msgChan <- msg
-							sx.Unlock()
-							continue
-						}
-					}
+				if !sx.canReconnect() || !sx.reconnect() {
+					return
 				}
+				continue
 			}
-			if sx.rspSesn != nil {
-				sx.rspSesn.msgChan <- msg
+			if msg != nil {
+				sx.dispatchMsg(msg)
 			}
 			sx.Unlock()
 		}
-
 	}()
 	return nil
 }
 
-func (sx *SerialXport) setRspSesn(s *SerialSesn) error {
-	sx.Lock()
-	defer sx.Unlock()
+// Indicates whether the transport reopens its port after the peer closes
+// it.  Only ports the transport dialed itself are reopened.
+func (sx *SerialXport) canReconnect() bool {
+	return sx.cfg.Port == nil && isNetworkPath(sx.cfg.DevPath)
+}
 
-	if sx.closing {
-		return fmt.Errorf("Transport closed")
+// Reopens a network port whose peer closed the connection, retrying with
+// an increasing delay until it succeeds.  Returns false if the transport was
+// stopped first.  Must only be called by the receive goroutine.
+func (sx *SerialXport) reconnect() bool {
+	delay := RECONNECT_MIN_DELAY
+	for {
+		log.Debugf("Reconnecting to %s in %s", sx.cfg.DevPath, delay)
+		select {
+		case <-sx.stopChan:
+			return false
+		case <-time.After(delay):
+		}
+
+		port, err := openPort(sx.cfg)
+		if err != nil {
+			log.Debugf("Failed to reconnect: %s", err.Error())
+			delay *= 2
+			if delay > RECONNECT_MAX_DELAY {
+				delay = RECONNECT_MAX_DELAY
+			}
+			continue
+		}
+
+		sx.Lock()
+		defer sx.Unlock()
+
+		if sx.closing {
+			port.Close()
+			return false
+		}
+
+		// The old port stays open until now so that Stop always has a
+		// port to close.
+		sx.txMtx.Lock()
+		sx.port.Close()
+		sx.port = port
+		sx.txMtx.Unlock()
+
+		sx.scanner = bufio.NewScanner(port)
+		sx.deframer.Reset()
+
+		log.Debugf("Reconnected to %s", sx.cfg.DevPath)
+		return true
 	}
-	if s != nil && sx.rspSesn != nil && s != sx.rspSesn {
-		return fmt.Errorf("Transport busy")
+}
+
+// Delivers a received packet to the session(s) it is destined for.  The
+// caller must lock the transport.
+func (sx *SerialXport) dispatchMsg(msg []byte) {
+	key, err := parsePktKey(msg)
+	if err != nil {
+		log.Debugf("Discarding unrecognized serial packet: %s", err.Error())
+		return
 	}
 
-	sx.rspSesn = s
-	return nil
+	if key.isCoap && key.request {
+		// Request from the peer; hand it to the server session.
+		if sx.reqSesn != nil {
+			sx.reqSesn.deliverMsg(msg)
+			return
+		}
+		if sx.acceptSesn != nil {
+			s, err := sx.acceptServerSesn(sx.acceptSesn)
+			if err != nil {
+				log.Errorf("Cannot create server sesn: %v", err)
+				return
+			}
+			s.deliverMsg(msg)
+			return
+		}
+	}
+
+	for _, s := range sx.rspTargets(key) {
+		s.deliverMsg(msg)
+	}
+}
+
+// Reports a receive error to the sessions it affects.  The caller must lock
+// the transport.
+func (sx *SerialXport) dispatchErr(err error) {
+	var sesns []*SerialSesn
+
+	if err == io.EOF {
+		for s := range sx.sesns {
+			sesns = append(sesns, s)
+		}
+	} else if IsFrameError(err) {
+		// Only the request that the corrupt packet answered is failed.  If
+		// the packet cannot be identified, the request times out instead.
+		if s := sx.frameErrTarget(err); s != nil {
+			sesns = append(sesns, s)
+		} else {
+			log.Debugf("Unattributed serial frame error: %s", err.Error())
+		}
+	}
+
+	for _, s := range sesns {
+		s.deliverErr(err)
+	}
 }
 
 func (sx *SerialXport) setAcceptSesn(s *SerialSesn) error {
@@ -222,9 +404,15 @@ func (sx *SerialXport) setReqSesn(s *SerialSesn) error {
 }
 
 func (sx *SerialXport) Stop() error {
+	sx.Lock()
 	sx.closing = true
+	close(sx.stopChan)
+	sx.Unlock()
 
+	sx.txMtx.Lock()
 	err := sx.port.Close()
+	sx.txMtx.Unlock()
+
 	sx.wg.Wait()
 
 	if err == nil {
@@ -232,6 +420,12 @@ func (sx *SerialXport) Stop() error {
 	}
 
 	sx.closing = false
+	sx.deframer.Reset()
+
+	st := sx.Stats()
+	log.Debugf("Serial stats: tx=%d rx=%d crc_errors=%d truncated=%d "+
+		"desync=%d noise=%d", st.TxPkts, st.RxPkts, st.CrcErrors,
+		st.TruncatedPkts, st.DesyncFrames, st.NoiseLines)
 
 	return err
 }
@@ -247,11 +441,28 @@ func (sx *SerialXport) txRaw(bytes []byte) error {
 	return nil
 }
 
+// Transmits a packet on behalf of a session, remembering that the session
+// expects the response.
+func (sx *SerialXport) txSesn(s *SerialSesn, bytes []byte) error {
+	if err := sx.addRoute(s, bytes); err != nil {
+		return err
+	}
+	return sx.Tx(bytes)
+}
+
 func (sx *SerialXport) Tx(bytes []byte) error {
+	sx.txMtx.Lock()
+	defer sx.txMtx.Unlock()
+
 	log.Debugf("Base64 encoding request:\n%s", hex.Dump(bytes))
 
 	pktData := make([]byte, 2)
 
+	if len(bytes)+2 > sx.cfg.MaxPacketSize {
+		return fmt.Errorf("Serial packet too large; size=%d max=%d",
+			len(bytes)+2, sx.cfg.MaxPacketSize)
+	}
+
 	crc := crc16.Crc16(bytes)
 	binary.BigEndian.PutUint16(pktData, crc)
 	bytes = append(bytes, pktData...)
@@ -270,32 +481,37 @@ func (sx *SerialXport) Tx(bytes []byte) error {
 	for written < totlen {
 		/* write the packet stat designators. They are
 		 * different whether we are starting a new packet or continuing one */
+		var start []byte
 		if written == 0 {
-			sx.txRaw([]byte{6, 9})
+			start = []byte{6, 9}
 		} else {
 			/* slower platforms take some time to process each segment
 			 * and have very small receive buffers.  Give them a bit of
 			 * time here */
-			time.Sleep(20 * time.Millisecond)
-			sx.txRaw([]byte{4, 20})
+			time.Sleep(sx.cfg.FrameDelay)
+			start = []byte{4, 20}
 		}
 
-		/* ensure that the total frame fits into 128 bytes.
-		 * base 64 is 3 ascii to 4 base 64 byte encoding.  so
-		 * the number below should be a multiple of 4.  Also,
-		 * we need to save room for the header (2 byte) and
+		/* base 64 is 3 ascii to 4 base 64 byte encoding.  so
+		 * the segment size should be a multiple of 4.  The
+		 * receiver also needs room for the header (2 byte) and
 		 * carriage return (and possibly LF 2 bytes), */
-
-		/* all totaled, 124 bytes should work */
-		writeLen := util.Min(124, totlen-written)
+		writeLen := util.Min(sx.cfg.SegmentSize, totlen-written)
 
 		writeBytes := base64Data[written : written+writeLen]
-		sx.txRaw(writeBytes)
-		sx.txRaw([]byte{'\n'})
+		for _, b := range [][]byte{start, writeBytes, []byte{'\n'}} {
+			if err := sx.txRaw(b); err != nil {
+				return err
+			}
+		}
 
 		written += writeLen
 	}
 
+	sx.statsMtx.Lock()
+	sx.txPkts++
+	sx.statsMtx.Unlock()
+
 	return nil
 }
 
@@ -312,50 +528,12 @@ func (sx *SerialXport) Rx() ([]byte, error) {
 			}
 		}
 		log.Debugf("Rx serial:\n%s", hex.Dump(line))
-		if len(line) < 2 || ((line[0] != 4 || line[1] != 20) &&
-			(line[0] != 6 || line[1] != 9)) {
-			continue
-		}
-
-		base64Data := string(line[2:])
 
-		data, err := base64.StdEncoding.DecodeString(base64Data)
+		b, err := sx.deframer.RxLine(line)
 		if err != nil {
-			return nil, fmt.Errorf("Couldn't decode base64 string:"+
-				" %s\nPacket hex dump:\n%s",
-				base64Data, hex.Dump(line))
+			return nil, err
 		}
-
-		if line[0] == 6 && line[1] == 9 {
-			if len(data) < 2 {
-				continue
-			}
-
-			pktLen := binary.BigEndian.Uint16(data[0:2])
-			sx.pkt, err = NewPacket(pktLen)
-			if err != nil {
-				return nil, err
-			}
-			data = data[2:]
-		}
-
-		if sx.pkt == nil {
-			continue
-		}
-
-		full := sx.pkt.AddBytes(data)
-		if full {
-			if crc16.Crc16(sx.pkt.GetBytes()) != 0 {
-				return nil, fmt.Errorf("CRC error")
-			}
-
-			/*
-			 * Trim away the 2 bytes of CRC
-			 */
-			sx.pkt.TrimEnd(2)
-			b := sx.pkt.GetBytes()
-			sx.pkt = nil
-
+		if b != nil {
 			log.Debugf("Decoded input:\n%s", hex.Dump(b))
 			return b, nil
 		}
@@ -363,10 +541,22 @@ func (sx *SerialXport) Rx() ([]byte, error) {
 
 	err := sx.scanner.Err()
 	if err == nil {
+		tr, ok := sx.port.(timeoutReader)
+		if !ok || !tr.eofIsTimeout() {
+			// The other end of a socket or pipe closed the stream.
+			return nil, io.EOF
+		}
+
 		// Scanner hit EOF, so we'll need to create a new one.  This only
 		// happens on timeouts.
 		err = errTimeout
 		sx.scanner = bufio.NewScanner(sx.port)
+
+		// A packet still in progress after a read timeout has lost
+		// frames.
+		if terr := sx.deframer.Abandon(); terr != nil {
+			err = terr
+		}
 	}
 	return nil, err
 }
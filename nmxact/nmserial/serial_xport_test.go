@@ -0,0 +1,123 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmserial
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// A session that closes while the receive goroutine is blocked delivering
+// to it must release the goroutine, and the transport must keep working.
+func TestSerialCloseWhileDelivering(t *testing.T) {
+	hx, dx := newRouteTestXports(t)
+	defer dx.Stop()
+	defer hx.Stop()
+
+	cfg := sesn.NewSesnCfg()
+	cfg.MgmtProto = sesn.MGMT_PROTO_COAP_SERVER
+	srv, err := NewSerialSesn(hx, cfg)
+	if err != nil {
+		t.Fatalf("NewSerialSesn(): %s", err.Error())
+	}
+	if err := srv.Open(); err != nil {
+		t.Fatalf("Open(): %s", err.Error())
+	}
+	hx.Lock()
+	err = hx.setReqSesn(srv)
+	hx.Unlock()
+	if err != nil {
+		t.Fatalf("setReqSesn(): %s", err.Error())
+	}
+
+	// Nothing reads the server session's requests; overflow its queue.
+	// The pipe is unbuffered, so the device blocks once the host's receive
+	// goroutine does.
+	txc := make(chan error, 1)
+	go func() {
+		get := []byte{0x40, 0x01, 0x00, 0x01}
+		for i := 0; i < cap(srv.msgChan)+2; i++ {
+			if err := dx.Tx(get); err != nil {
+				txc <- err
+				return
+			}
+		}
+		txc <- nil
+	}()
+	for len(srv.msgChan) < cap(srv.msgChan) {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		srv.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Close() blocked by receive goroutine")
+	}
+
+	if err := <-txc; err != nil {
+		t.Fatalf("Tx(): %s", err.Error())
+	}
+
+	s := newRouteTestSesn(t, hx)
+	defer s.Close()
+
+	r, err := routeTestEcho(s, 3)
+	if err != nil {
+		t.Fatalf("request: %s", err.Error())
+	}
+	routeTestRsp(t, dx, 3)
+
+	select {
+	case <-r.rspc:
+	case err := <-r.errc:
+		t.Fatalf("request failed: %s", err.Error())
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no response after session close")
+	}
+}
+
+func TestSerialTxError(t *testing.T) {
+	host, dev := net.Pipe()
+	dev.Close()
+
+	cfg := NewXportCfg()
+	cfg.Port = host
+	cfg.FrameDelay = 0
+	sx := NewSerialXport(cfg)
+	if err := sx.Start(); err != nil {
+		t.Fatalf("Start(): %s", err.Error())
+	}
+	defer sx.Stop()
+
+	if err := sx.Tx([]byte{0, 0, 0, 0, 0, 0, 0, 0}); err == nil {
+		t.Fatalf("Tx() to closed port succeeded")
+	}
+	if n := sx.Stats().TxPkts; n != 0 {
+		t.Fatalf("failed write counted; TxPkts=%d", n)
+	}
+}
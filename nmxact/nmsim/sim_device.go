@@ -0,0 +1,696 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmsim
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/runtimeco/go-coap"
+	log "github.com/sirupsen/logrus"
+	"github.com/ugorji/go/codec"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// Handler processes a single management request addressed to a simulated
+// device.  The request body is CBOR-encoded; for OMP requests it also
+// contains the "_h" header key, which handlers should ignore.  A handler
+// returns the response body and a status code.  If the status code is
+// nonzero, the response body is discarded and a response containing only
+// the status code is sent.
+//
+// Handlers are called with the device lock held; they must not call
+// exported Device methods.
+type Handler func(hdr *nmp.NmpHdr, body []byte) (interface{}, int)
+
+// ImageSlot describes the contents of a single image slot.
+type ImageSlot struct {
+	Image     int
+	Slot      int
+	Version   string
+	Hash      []byte
+	Data      []byte
+	Bootable  bool
+	Pending   bool
+	Confirmed bool
+	Active    bool
+	Permanent bool
+}
+
+// ImageUpload tracks the most recent image upload.
+type ImageUpload struct {
+	Image   int
+	Len     uint32
+	DataSha []byte
+	Data    []byte
+}
+
+// Log is a single log maintained by a simulated device.
+type Log struct {
+	Name    string
+	Type    int
+	Entries []nmp.LogEntry
+}
+
+// DeviceState contains everything a simulated device remembers.  It is
+// serialized as JSON when the device is saved to a file.
+type DeviceState struct {
+	Images       []*ImageSlot
+	Upload       *ImageUpload
+	Logs         []*Log
+	NextLogIndex uint32
+	LogModules   map[string]int
+	LogLevels    map[string]int
+	Config       map[string]string
+	Stats        map[string]map[string]int
+	Files        map[string][]byte
+	Core         []byte
+	ClockOffset  time.Duration
+	BootCount    int
+	Tests        []string
+}
+
+// Device is a simulated Mynewt device.  It decodes NMP and OMP requests,
+// emulates the image, log, stat, config, file system, and default
+// management groups, and encodes the corresponding responses.
+type Device struct {
+	state    *DeviceState
+	handlers map[nmp.Ogi]Handler
+
+	// Maximum size of a single response frame for the request currently
+	// being processed.
+	mtu int
+
+	// If non-empty, the device reboots with this reason after the current
+	// response has been sent.
+	resetReason string
+
+	// The device ignores all requests until this time.
+	downUntil  time.Time
+	rebootTime time.Duration
+	bootTime   time.Time
+
+	// Highest SMP header version the device supports.  A version 1 device
+	// ignores the version bits of requests and responds with version 1.
+	smpVer uint8
+
+	mtx sync.Mutex
+}
+
+const (
+	SIM_LOG_NAME        = "log"
+	SIM_REBOOT_LOG_NAME = "reboot_log"
+
+	// Module ID used for log entries written by the simulator itself.
+	SIM_LOG_MODULE = 64
+)
+
+var simDefaultVersion = "1.0.0"
+
+func newDeviceState() *DeviceState {
+	return &DeviceState{
+		Logs: []*Log{
+			&Log{Name: SIM_LOG_NAME, Type: nmp.MEMORY_LOG},
+			&Log{Name: SIM_REBOOT_LOG_NAME, Type: nmp.STORAGE_LOG},
+		},
+		LogModules: map[string]int{},
+		LogLevels:  map[string]int{},
+		Config: map[string]string{
+			"id/serial": "sim-0001",
+			"sim/name":  "simdev",
+		},
+		Stats: map[string]map[string]int{
+			"smp": map[string]int{
+				"rx_reqs": 0,
+				"tx_rsps": 0,
+				"tx_errs": 0,
+			},
+			"os": map[string]int{
+				"resets": 0,
+			},
+		},
+		Files: map[string][]byte{},
+		Tests: []string{"sim_test_pass", "sim_test_fail"},
+	}
+}
+
+// NewDevice creates a simulated device in its factory state: a single
+// confirmed image in slot 0 and a handful of log entries from the initial
+// boot.
+func NewDevice() *Device {
+	d := &Device{
+		state:    newDeviceState(),
+		handlers: map[nmp.Ogi]Handler{},
+		smpVer:   nmp.NMP_VER2,
+	}
+
+	for id, name := range nmp.LogModuleNameMap {
+		d.state.LogModules[name] = id
+	}
+	d.state.LogModules["SIM"] = SIM_LOG_MODULE
+	for id, name := range nmp.LogLevelNameMap {
+		d.state.LogLevels[name] = id
+	}
+
+	img := []byte("simulated image " + simDefaultVersion)
+	d.state.Images = []*ImageSlot{
+		&ImageSlot{
+			Image:     0,
+			Slot:      0,
+			Version:   simDefaultVersion,
+			Hash:      simImageHash(img),
+			Data:      img,
+			Bootable:  true,
+			Confirmed: true,
+			Active:    true,
+		},
+	}
+
+	d.registerHandlers()
+	d.reboot("HARD")
+
+	return d
+}
+
+// SetHandler installs a handler for the specified request.  It replaces any
+// existing handler, including the built-in ones.
+func (d *Device) SetHandler(op uint8, group uint16, id uint8, h Handler) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.handlers[nmp.Ogi{Op: op, Group: group, Id: id}] = h
+}
+
+// SetRebootTime configures how long the device stays unresponsive after a
+// reset.
+func (d *Device) SetRebootTime(dur time.Duration) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.rebootTime = dur
+}
+
+// SetSmpVersion configures the highest SMP header version the device
+// supports.
+func (d *Device) SetSmpVersion(ver uint8) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.smpVer = ver
+}
+
+// State returns a deep copy of the device's current state.
+func (d *Device) State() (*DeviceState, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	b, err := json.Marshal(d.state)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &DeviceState{}
+	if err := json.Unmarshal(b, st); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+// Load replaces the device state with the contents of the specified file.
+// If the file does not exist, the device state is left unchanged.
+func (d *Device) Load(path string) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Error reading sim state: %s", err.Error())
+	}
+
+	st := newDeviceState()
+	if err := json.Unmarshal(b, st); err != nil {
+		return fmt.Errorf("Error parsing sim state file %s: %s",
+			path, err.Error())
+	}
+
+	d.state = st
+	return nil
+}
+
+// Save writes the device state to the specified file.
+func (d *Device) Save(path string) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	b, err := json.MarshalIndent(d.state, "", "    ")
+	if err != nil {
+		return fmt.Errorf("Error encoding sim state: %s", err.Error())
+	}
+
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		return fmt.Errorf("Error writing sim state: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Process passes a complete request packet to the device and returns the
+// response frames, each no larger than mtu bytes.  An empty result indicates
+// that the device did not respond.
+func (d *Device) Process(proto sesn.MgmtProto, req []byte, mtu int) [][]byte {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if time.Now().Before(d.downUntil) {
+		log.Debugf("sim: device rebooting; dropping request")
+		return nil
+	}
+
+	d.mtu = mtu
+
+	var rsps [][]byte
+	switch proto {
+	case sesn.MGMT_PROTO_NMP:
+		rsps = d.processNmp(req)
+	case sesn.MGMT_PROTO_OMP:
+		rsps = d.processOmp(req)
+	}
+
+	if d.resetReason != "" {
+		d.reboot(d.resetReason)
+		d.resetReason = ""
+	}
+
+	return rsps
+}
+
+func (d *Device) processNmp(req []byte) [][]byte {
+	hdr, err := nmp.DecodeNmpHdr(req)
+	if err != nil {
+		log.Debugf("sim: %s", err.Error())
+		return nil
+	}
+
+	body := req[nmp.NMP_HDR_SIZE:]
+	if len(body) < int(hdr.Len) {
+		log.Debugf("sim: truncated NMP request")
+		return nil
+	}
+	body = body[:hdr.Len]
+
+	rspHdr, rspBody := d.dispatch(hdr, body)
+	if rspBody == nil {
+		return nil
+	}
+
+	bb, err := nmp.BodyBytes(rspBody)
+	if err != nil {
+		log.Debugf("sim: %s", err.Error())
+		return nil
+	}
+	rspHdr.Len = uint16(len(bb))
+
+	data := append(rspHdr.Bytes(), bb...)
+	return nmxutil.Fragment(data, d.mtu)
+}
+
+func (d *Device) processOmp(req []byte) [][]byte {
+	m, err := coap.ParseDgramMessage(req)
+	if err != nil {
+		log.Debugf("sim: invalid CoAP request: %s", err.Error())
+		return nil
+	}
+
+	if m.PathString() != strings.TrimPrefix(nmxutil.OmpRes, "/") {
+		return d.processCoapRes(m)
+	}
+
+	var om struct {
+		Hdr []byte `codec:"_h"`
+	}
+	dec := codec.NewDecoderBytes(m.Payload(), new(codec.CborHandle))
+	if err := dec.Decode(&om); err != nil {
+		log.Debugf("sim: invalid OMP request: %s", err.Error())
+		return nil
+	}
+
+	hdr, err := nmp.DecodeNmpHdr(om.Hdr)
+	if err != nil {
+		log.Debugf("sim: %s", err.Error())
+		return nil
+	}
+
+	rspHdr, rspBody := d.dispatch(hdr, m.Payload())
+	if rspBody == nil {
+		return nil
+	}
+
+	bb, err := nmp.BodyBytes(rspBody)
+	if err != nil {
+		log.Debugf("sim: %s", err.Error())
+		return nil
+	}
+	fields, err := nmxutil.DecodeCborMap(bb)
+	if err != nil {
+		log.Debugf("sim: %s", err.Error())
+		return nil
+	}
+	rspHdr.Len = uint16(len(bb))
+	fields["_h"] = rspHdr.Bytes()
+
+	payload, err := nmxutil.EncodeCborMap(fields)
+	if err != nil {
+		log.Debugf("sim: %s", err.Error())
+		return nil
+	}
+
+	code := coap.Changed
+	if hdr.Op == nmp.NMP_OP_READ {
+		code = coap.Content
+	}
+
+	return d.coapRsp(m, code, payload)
+}
+
+// coapRsp builds the response to the specified CoAP request.
+func (d *Device) coapRsp(req coap.Message, code coap.COAPCode,
+	payload []byte) [][]byte {
+
+	typ := coap.NonConfirmable
+	if req.IsConfirmable() {
+		typ = coap.Acknowledgement
+	}
+
+	rsp := coap.NewDgramMessage(coap.MessageParams{
+		Type:      typ,
+		Code:      code,
+		MessageID: req.MessageID(),
+		Token:     req.Token(),
+		Payload:   payload,
+	})
+
+	b, err := rsp.MarshalBinary()
+	if err != nil {
+		log.Debugf("sim: failed to encode CoAP response: %s", err.Error())
+		return nil
+	}
+
+	return [][]byte{b}
+}
+
+// processCoapRes handles a request for a plain CoAP resource.  The device
+// stores resource values in the "coap" file system directory.
+func (d *Device) processCoapRes(m coap.Message) [][]byte {
+	name := "/coap/" + m.PathString()
+
+	switch m.Code() {
+	case coap.GET:
+		val, ok := d.state.Files[name]
+		if !ok {
+			return d.coapRsp(m, coap.NotFound, nil)
+		}
+		return d.coapRsp(m, coap.Content, val)
+
+	case coap.PUT:
+		d.state.Files[name] = m.Payload()
+		return d.coapRsp(m, coap.Changed, nil)
+
+	case coap.POST:
+		d.state.Files[name] = m.Payload()
+		return d.coapRsp(m, coap.Created, nil)
+
+	case coap.DELETE:
+		if _, ok := d.state.Files[name]; !ok {
+			return d.coapRsp(m, coap.NotFound, nil)
+		}
+		delete(d.state.Files, name)
+		return d.coapRsp(m, coap.Deleted, nil)
+
+	default:
+		return d.coapRsp(m, coap.MethodNotAllowed, nil)
+	}
+}
+
+type simErrRsp struct {
+	Rc int `codec:"rc"`
+}
+
+// dispatch passes a request to the appropriate handler and returns the
+// response header and body.  A nil body indicates that no response should be
+// sent.
+func (d *Device) dispatch(hdr *nmp.NmpHdr,
+	body []byte) (nmp.NmpHdr, interface{}) {
+
+	rspHdr := *hdr
+	rspHdr.Flags = 0
+	if rspHdr.Version > d.smpVer {
+		rspHdr.Version = d.smpVer
+	}
+
+	switch hdr.Op {
+	case nmp.NMP_OP_READ:
+		rspHdr.Op = nmp.NMP_OP_READ_RSP
+	case nmp.NMP_OP_WRITE:
+		rspHdr.Op = nmp.NMP_OP_WRITE_RSP
+	default:
+		log.Debugf("sim: ignoring non-request op: %d", hdr.Op)
+		return rspHdr, nil
+	}
+
+	d.incStat("smp", "rx_reqs")
+
+	var rsp interface{}
+	var rc int
+
+	h := d.handlers[nmp.Ogi{Op: hdr.Op, Group: hdr.Group, Id: hdr.Id}]
+	if h == nil {
+		rc = nmp.NMP_ERR_ENOENT
+	} else {
+		rsp, rc = h(hdr, body)
+	}
+
+	if rc != 0 {
+		d.incStat("smp", "tx_errs")
+		rsp = &simErrRsp{Rc: rc}
+	}
+
+	d.incStat("smp", "tx_rsps")
+	return rspHdr, rsp
+}
+
+// decodeReq decodes a CBOR request body into the supplied struct.
+func decodeReq(body []byte, req interface{}) int {
+	dec := codec.NewDecoderBytes(body, new(codec.CborHandle))
+	if err := dec.Decode(req); err != nil {
+		log.Debugf("sim: invalid request: %s", err.Error())
+		return nmp.NMP_ERR_EINVAL
+	}
+
+	return 0
+}
+
+// chunkSize returns the maximum amount of bulk data that can be placed in a
+// single response.
+func (d *Device) chunkSize() int {
+	sz := d.mtu - 64
+	if sz < 32 {
+		sz = 32
+	}
+	return sz
+}
+
+func (d *Device) incStat(group string, field string) {
+	g := d.state.Stats[group]
+	if g == nil {
+		g = map[string]int{}
+		d.state.Stats[group] = g
+	}
+	g[field]++
+}
+
+// now returns the device's wall-clock time.
+func (d *Device) now() time.Time {
+	return time.Now().Add(d.state.ClockOffset)
+}
+
+func (d *Device) findLog(name string) *Log {
+	for _, l := range d.state.Logs {
+		if l.Name == name {
+			return l
+		}
+	}
+
+	return nil
+}
+
+// appendLog adds an entry to the named log.
+func (d *Device) appendLog(name string, module int, level int,
+	typ nmp.LogEntryType, msg []byte) {
+
+	l := d.findLog(name)
+	if l == nil {
+		return
+	}
+
+	var imgHash []byte
+	if img := d.findSlot(0, 0); img != nil && len(img.Hash) >= 4 {
+		imgHash = img.Hash[:4]
+	}
+
+	l.Entries = append(l.Entries, nmp.LogEntry{
+		Index:     d.state.NextLogIndex,
+		Timestamp: d.now().UnixNano() / 1000,
+		Module:    uint8(module),
+		Level:     uint8(level),
+		Type:      typ,
+		ImgHash:   imgHash,
+		Msg:       msg,
+	})
+	d.state.NextLogIndex++
+}
+
+func (d *Device) logString(module int, level int, msg string) {
+	d.appendLog(SIM_LOG_NAME, module, level, nmp.LOG_ENTRY_TYPE_STRING,
+		[]byte(msg))
+}
+
+func (d *Device) findSlot(image int, slot int) *ImageSlot {
+	for _, img := range d.state.Images {
+		if img.Image == image && img.Slot == slot {
+			return img
+		}
+	}
+
+	return nil
+}
+
+func (d *Device) removeSlot(image int, slot int) {
+	for i, img := range d.state.Images {
+		if img.Image == image && img.Slot == slot {
+			d.state.Images = append(d.state.Images[:i],
+				d.state.Images[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *Device) sortImages() {
+	sort.Slice(d.state.Images, func(i, j int) bool {
+		a := d.state.Images[i]
+		b := d.state.Images[j]
+		if a.Image != b.Image {
+			return a.Image < b.Image
+		}
+		return a.Slot < b.Slot
+	})
+}
+
+// reboot emulates a device reset.  Image swaps are performed with MCUboot
+// semantics: a pending image in slot 1 is swapped into slot 0; an unconfirmed
+// image in slot 0 is reverted.
+func (d *Device) reboot(reason string) {
+	images := map[int]bool{}
+	for _, img := range d.state.Images {
+		images[img.Image] = true
+	}
+
+	for image, _ := range images {
+		pri := d.findSlot(image, 0)
+		sec := d.findSlot(image, 1)
+
+		if sec != nil && sec.Pending {
+			// Test or permanent swap.
+			sec.Slot = 0
+			sec.Confirmed = sec.Permanent
+			sec.Pending = false
+			sec.Permanent = false
+			if pri != nil {
+				pri.Slot = 1
+				pri.Confirmed = false
+			}
+		} else if sec != nil && pri != nil && !pri.Confirmed {
+			// Revert.
+			sec.Slot = 0
+			sec.Confirmed = true
+			pri.Slot = 1
+		}
+
+		for _, img := range d.state.Images {
+			if img.Image == image {
+				img.Active = img.Slot == 0
+			}
+		}
+	}
+	d.sortImages()
+
+	// Memory logs don't survive a reset.  As on a real device, the log index
+	// restarts after the newest persisted entry.
+	d.state.NextLogIndex = 0
+	for _, l := range d.state.Logs {
+		if l.Type == nmp.MEMORY_LOG {
+			l.Entries = nil
+		}
+		for _, e := range l.Entries {
+			if e.Index >= d.state.NextLogIndex {
+				d.state.NextLogIndex = e.Index + 1
+			}
+		}
+	}
+
+	d.state.BootCount++
+	d.incStat("os", "resets")
+	d.bootTime = time.Now()
+	d.downUntil = d.bootTime.Add(d.rebootTime)
+
+	ver := ""
+	var hash []byte
+	if img := d.findSlot(0, 0); img != nil {
+		ver = img.Version
+		hash = img.Hash
+	}
+
+	msg, err := nmxutil.EncodeCborMap(map[string]interface{}{
+		"rsn":  reason,
+		"cnt":  d.state.BootCount,
+		"img":  ver,
+		"hash": fmt.Sprintf("%x", hash),
+	})
+	if err == nil {
+		d.appendLog(SIM_REBOOT_LOG_NAME, nmp.MODULE_REBOOT,
+			nmp.LEVEL_CRITICAL, nmp.LOG_ENTRY_TYPE_CBOR, msg)
+	}
+
+	d.logString(SIM_LOG_MODULE, nmp.LEVEL_INFO,
+		fmt.Sprintf("sim: booted; reason=%s count=%d", reason,
+			d.state.BootCount))
+}
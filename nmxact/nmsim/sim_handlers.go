@@ -0,0 +1,537 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmsim
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+)
+
+func (d *Device) registerHandlers() {
+	const rd = nmp.NMP_OP_READ
+	const wr = nmp.NMP_OP_WRITE
+
+	reg := func(op uint8, group uint16, id uint8, h Handler) {
+		d.handlers[nmp.Ogi{Op: op, Group: group, Id: id}] = h
+	}
+
+	reg(wr, nmp.NMP_GROUP_DEFAULT, nmp.NMP_ID_DEF_ECHO, d.echo)
+	reg(wr, nmp.NMP_GROUP_DEFAULT, nmp.NMP_ID_DEF_CONS_ECHO_CTRL,
+		d.consEchoCtrl)
+	reg(rd, nmp.NMP_GROUP_DEFAULT, nmp.NMP_ID_DEF_TASKSTAT, d.taskStat)
+	reg(rd, nmp.NMP_GROUP_DEFAULT, nmp.NMP_ID_DEF_MPSTAT, d.mpStat)
+	reg(rd, nmp.NMP_GROUP_DEFAULT, nmp.NMP_ID_DEF_DATETIME_STR, d.dateTimeRead)
+	reg(wr, nmp.NMP_GROUP_DEFAULT, nmp.NMP_ID_DEF_DATETIME_STR, d.dateTimeWrite)
+	reg(wr, nmp.NMP_GROUP_DEFAULT, nmp.NMP_ID_DEF_RESET, d.reset)
+
+	reg(rd, nmp.NMP_GROUP_IMAGE, nmp.NMP_ID_IMAGE_STATE, d.imageStateRead)
+	reg(wr, nmp.NMP_GROUP_IMAGE, nmp.NMP_ID_IMAGE_STATE, d.imageStateWrite)
+	reg(wr, nmp.NMP_GROUP_IMAGE, nmp.NMP_ID_IMAGE_UPLOAD, d.imageUpload)
+	reg(wr, nmp.NMP_GROUP_IMAGE, nmp.NMP_ID_IMAGE_ERASE, d.imageErase)
+	reg(rd, nmp.NMP_GROUP_IMAGE, nmp.NMP_ID_IMAGE_CORELIST, d.coreList)
+	reg(rd, nmp.NMP_GROUP_IMAGE, nmp.NMP_ID_IMAGE_CORELOAD, d.coreLoad)
+	reg(wr, nmp.NMP_GROUP_IMAGE, nmp.NMP_ID_IMAGE_CORELOAD, d.coreErase)
+
+	reg(rd, nmp.NMP_GROUP_STAT, nmp.NMP_ID_STAT_READ, d.statRead)
+	reg(rd, nmp.NMP_GROUP_STAT, nmp.NMP_ID_STAT_LIST, d.statList)
+
+	reg(rd, nmp.NMP_GROUP_CONFIG, nmp.NMP_ID_CONFIG_VAL, d.configRead)
+	reg(wr, nmp.NMP_GROUP_CONFIG, nmp.NMP_ID_CONFIG_VAL, d.configWrite)
+
+	reg(rd, nmp.NMP_GROUP_LOG, nmp.NMP_ID_LOG_SHOW, d.logShow)
+	reg(wr, nmp.NMP_GROUP_LOG, nmp.NMP_ID_LOG_CLEAR, d.logClear)
+	reg(rd, nmp.NMP_GROUP_LOG, nmp.NMP_ID_LOG_MODULE_LIST, d.logModuleList)
+	reg(rd, nmp.NMP_GROUP_LOG, nmp.NMP_ID_LOG_LEVEL_LIST, d.logLevelList)
+	reg(rd, nmp.NMP_GROUP_LOG, nmp.NMP_ID_LOG_LIST, d.logList)
+
+	reg(wr, nmp.NMP_GROUP_CRASH, nmp.NMP_ID_CRASH_TRIGGER, d.crash)
+
+	reg(wr, nmp.NMP_GROUP_RUN, nmp.NMP_ID_RUN_TEST, d.runTest)
+	reg(rd, nmp.NMP_GROUP_RUN, nmp.NMP_ID_RUN_LIST, d.runList)
+
+	reg(rd, nmp.NMP_GROUP_FS, nmp.NMP_ID_FS_FILE, d.fsDownload)
+	reg(wr, nmp.NMP_GROUP_FS, nmp.NMP_ID_FS_FILE, d.fsUpload)
+	reg(rd, nmp.NMP_GROUP_FS, nmp.NMP_ID_FS_HASH, d.fsHash)
+
+	reg(wr, nmp.NMP_GROUP_SHELL, nmp.NMP_ID_SHELL_EXEC, d.shellExec)
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $default                                                                 //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) echo(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.EchoReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	rsp := nmp.NewEchoRsp()
+	rsp.Payload = req.Payload
+	return rsp, 0
+}
+
+func (d *Device) consEchoCtrl(hdr *nmp.NmpHdr,
+	body []byte) (interface{}, int) {
+
+	return &simErrRsp{}, 0
+}
+
+func (d *Device) taskStat(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	uptime := int(time.Since(d.bootTime) / time.Millisecond)
+
+	rsp := nmp.NewTaskStatRsp()
+	rsp.Tasks = map[string]map[string]int{
+		"idle": {
+			"prio": 255, "tid": 0, "state": 1, "stkuse": 48,
+			"stksiz": 64, "cswcnt": uptime / 10, "runtime": uptime,
+			"last_checkin": 0, "next_checkin": 0,
+		},
+		"main": {
+			"prio": 127, "tid": 1, "state": 2, "stkuse": 312,
+			"stksiz": 1024, "cswcnt": uptime / 100, "runtime": uptime / 50,
+			"last_checkin": 0, "next_checkin": 0,
+		},
+	}
+	return rsp, 0
+}
+
+func (d *Device) mpStat(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	rsp := nmp.NewMempoolStatRsp()
+	rsp.Mpools = map[string]map[string]int{
+		"msys_1": {"blksiz": 292, "nblks": 12, "nfree": 10, "min": 8},
+	}
+	return rsp, 0
+}
+
+var simDateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+}
+
+func (d *Device) dateTimeRead(hdr *nmp.NmpHdr,
+	body []byte) (interface{}, int) {
+
+	rsp := nmp.NewDateTimeReadRsp()
+	rsp.DateTime = d.now().Format("2006-01-02T15:04:05.000000-07:00")
+	return rsp, 0
+}
+
+func (d *Device) dateTimeWrite(hdr *nmp.NmpHdr,
+	body []byte) (interface{}, int) {
+
+	req := nmp.DateTimeWriteReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	for _, layout := range simDateTimeLayouts {
+		t, err := time.Parse(layout, req.DateTime)
+		if err == nil {
+			d.state.ClockOffset = time.Until(t)
+			return nmp.NewDateTimeWriteRsp(), 0
+		}
+	}
+
+	return nil, nmp.NMP_ERR_EINVAL
+}
+
+func (d *Device) reset(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	d.resetReason = "SOFT"
+	return nmp.NewResetRsp(), 0
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $stat                                                                    //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) statRead(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.StatReadReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	g := d.state.Stats[req.Name]
+	if g == nil {
+		return nil, nmp.NMP_ERR_ENOENT
+	}
+
+	rsp := nmp.NewStatReadRsp()
+	rsp.Name = req.Name
+	rsp.Group = req.Name
+	rsp.Fields = map[string]interface{}{}
+	for k, v := range g {
+		rsp.Fields[k] = v
+	}
+	return rsp, 0
+}
+
+func (d *Device) statList(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	rsp := nmp.NewStatListRsp()
+	for name, _ := range d.state.Stats {
+		rsp.List = append(rsp.List, name)
+	}
+	sort.Strings(rsp.List)
+	return rsp, 0
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $config                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) configRead(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.ConfigReadReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	val, ok := d.state.Config[req.Name]
+	if !ok {
+		return nil, nmp.NMP_ERR_ENOENT
+	}
+
+	rsp := nmp.NewConfigReadRsp()
+	rsp.Val = val
+	return rsp, 0
+}
+
+func (d *Device) configWrite(hdr *nmp.NmpHdr,
+	body []byte) (interface{}, int) {
+
+	req := nmp.ConfigWriteReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	if req.Name == "" {
+		// A save request without a name commits all settings.
+		if !req.Save {
+			return nil, nmp.NMP_ERR_EINVAL
+		}
+		return nmp.NewConfigWriteRsp(), 0
+	}
+
+	d.state.Config[req.Name] = req.Val
+	return nmp.NewConfigWriteRsp(), 0
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $log                                                                     //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) logShow(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.LogShowReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	var logs []*Log
+	if req.Name == "" {
+		logs = d.state.Logs
+	} else {
+		l := d.findLog(req.Name)
+		if l == nil {
+			return nil, nmp.NMP_ERR_ENOENT
+		}
+		logs = []*Log{l}
+	}
+
+	rsp := nmp.NewLogShowRsp()
+	rsp.NextIndex = d.state.NextLogIndex
+
+	// Limit the response to a single frame.  A status code of 1 indicates
+	// that more entries remain.
+	room := d.chunkSize()
+
+	for _, l := range logs {
+		sl := nmp.LogShowLog{
+			Name:    l.Name,
+			Type:    l.Type,
+			Entries: []nmp.LogEntry{},
+		}
+
+		entries := l.Entries
+		if req.Timestamp == -1 {
+			// Only the most recent entry was requested.
+			if len(entries) > 0 {
+				entries = entries[len(entries)-1:]
+			}
+		}
+
+		for _, e := range entries {
+			if e.Index < req.Index {
+				continue
+			}
+			if req.Timestamp > 0 && e.Timestamp < req.Timestamp {
+				continue
+			}
+
+			eb, err := nmp.BodyBytes(e)
+			if err != nil {
+				return nil, nmp.NMP_ERR_EUNKNOWN
+			}
+			if len(eb) > room {
+				rsp.Rc = 1
+				break
+			}
+			room -= len(eb)
+
+			sl.Entries = append(sl.Entries, e)
+		}
+
+		if rsp.Rc != 0 {
+			// Omit a truncated log if none of its entries fit; the client
+			// continues from the last entry it received.
+			if len(sl.Entries) > 0 {
+				rsp.Logs = append(rsp.Logs, sl)
+			}
+			break
+		}
+		rsp.Logs = append(rsp.Logs, sl)
+	}
+
+	return rsp, 0
+}
+
+func (d *Device) logClear(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	for _, l := range d.state.Logs {
+		l.Entries = nil
+	}
+
+	return nmp.NewLogClearRsp(), 0
+}
+
+func (d *Device) logModuleList(hdr *nmp.NmpHdr,
+	body []byte) (interface{}, int) {
+
+	rsp := nmp.NewLogModuleListRsp()
+	rsp.Map = d.state.LogModules
+	return rsp, 0
+}
+
+func (d *Device) logLevelList(hdr *nmp.NmpHdr,
+	body []byte) (interface{}, int) {
+
+	rsp := nmp.NewLogLevelListRsp()
+	rsp.Map = d.state.LogLevels
+	return rsp, 0
+}
+
+func (d *Device) logList(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	rsp := nmp.NewLogListRsp()
+	for _, l := range d.state.Logs {
+		rsp.List = append(rsp.List, l.Name)
+	}
+	return rsp, 0
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $crash                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) crash(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.CrashReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	switch req.CrashType {
+	case "div0", "jump0", "ref0", "assert", "wdog":
+	default:
+		return nil, nmp.NMP_ERR_EINVAL
+	}
+
+	var hash []byte
+	if img := d.findSlot(0, 0); img != nil {
+		hash = img.Hash
+	}
+	d.state.Core = simCore(hash)
+	d.resetReason = strings.ToUpper(req.CrashType)
+
+	return nmp.NewCrashRsp(), 0
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $run                                                                     //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) runTest(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.RunTestReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	var tests []string
+	if req.Testname == "all" {
+		tests = d.state.Tests
+	} else {
+		for _, t := range d.state.Tests {
+			if t == req.Testname {
+				tests = []string{t}
+			}
+		}
+		if tests == nil {
+			return nil, nmp.NMP_ERR_ENOENT
+		}
+	}
+
+	for _, t := range tests {
+		level := nmp.LEVEL_INFO
+		result := "passed"
+		if strings.HasSuffix(t, "_fail") {
+			level = nmp.LEVEL_ERROR
+			result = "failed"
+		}
+		d.logString(nmp.MODULE_TEST, level,
+			fmt.Sprintf("[%s] test %s %s", req.Token, t, result))
+	}
+
+	return nmp.NewRunTestRsp(), 0
+}
+
+func (d *Device) runList(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	rsp := nmp.NewRunListRsp()
+	rsp.List = append([]string{}, d.state.Tests...)
+	return rsp, 0
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $fs                                                                      //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) fsDownload(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.FsDownloadReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	data, ok := d.state.Files[req.Name]
+	if !ok {
+		return nil, nmp.NMP_ERR_ENOENT
+	}
+	if int(req.Off) > len(data) {
+		return nil, nmp.NMP_ERR_EINVAL
+	}
+
+	end := int(req.Off) + d.chunkSize()
+	if end > len(data) {
+		end = len(data)
+	}
+
+	rsp := nmp.NewFsDownloadRsp()
+	rsp.Off = req.Off
+	rsp.Len = uint32(len(data))
+	rsp.Data = data[req.Off:end]
+	return rsp, 0
+}
+
+func (d *Device) fsUpload(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.FsUploadReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	if req.Name == "" {
+		return nil, nmp.NMP_ERR_EINVAL
+	}
+
+	data := d.state.Files[req.Name]
+	if req.Off == 0 {
+		data = nil
+	}
+
+	rsp := nmp.NewFsUploadRsp()
+	if int(req.Off) != len(data) {
+		// Unexpected offset; drop the data and tell the client where to
+		// continue from.
+		rsp.Off = uint32(len(data))
+		return rsp, 0
+	}
+
+	data = append(data, req.Data...)
+	d.state.Files[req.Name] = data
+
+	rsp.Off = uint32(len(data))
+	return rsp, 0
+}
+
+func (d *Device) fsHash(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.FsHashReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	data, ok := d.state.Files[req.Name]
+	if !ok {
+		return nil, nmp.NMP_ERR_ENOENT
+	}
+	if req.Type != nmp.FS_HASH_SHA256 {
+		return nil, nmp.NMP_ERR_ENOTSUP
+	}
+	if int(req.Off) > len(data) {
+		return nil, nmp.NMP_ERR_EINVAL
+	}
+
+	end := len(data)
+	if req.Len != 0 && int(req.Off+req.Len) < end {
+		end = int(req.Off + req.Len)
+	}
+	sum := sha256.Sum256(data[req.Off:end])
+
+	rsp := nmp.NewFsHashRsp()
+	rsp.Type = req.Type
+	rsp.Off = req.Off
+	rsp.Len = uint32(end - int(req.Off))
+	rsp.Output = sum[:]
+	return rsp, 0
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $shell                                                                   //
+//////////////////////////////////////////////////////////////////////////////
+
+func (d *Device) shellExec(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.ShellExecReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	if len(req.Argv) == 0 {
+		return nil, nmp.NMP_ERR_EINVAL
+	}
+
+	rsp := nmp.NewShellExecRsp()
+	switch req.Argv[0] {
+	case "echo":
+		rsp.O = strings.Join(req.Argv[1:], " ") + "\n"
+	case "date":
+		rsp.O = d.now().Format(time.RFC3339) + "\n"
+	case "help":
+		rsp.O = "Available commands: date echo help\n"
+	default:
+		rsp.O = fmt.Sprintf("Unrecognized command: %s\n", req.Argv[0])
+	}
+	return rsp, 0
+}
@@ -0,0 +1,303 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmsim
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/recogni/newtmgr/nmxact/nmimage"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+)
+
+const (
+	simCoreMagic  = 0x690c47c3
+	simCoreTlvImg = 1
+	simCoreTlvMem = 2
+	simCoreTlvReg = 3
+)
+
+// simImageVersion extracts the version string from a Mynewt / MCUboot image
+// header.  If the data is not an image, the default version is returned.
+func simImageVersion(data []byte) string {
+	img, err := nmimage.Parse(data)
+	if err != nil {
+		return simDefaultVersion
+	}
+
+	return img.Hdr.Vers.String()
+}
+
+// simImageHash returns the hash a Mynewt device reports for the specified
+// image: the hash TLV if the image contains one, or the SHA256 of the
+// entire image otherwise.
+func simImageHash(data []byte) []byte {
+	if img, err := nmimage.Parse(data); err == nil {
+		if hash := img.Hash(); hash != nil {
+			return append([]byte{}, hash...)
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	return sum[:]
+}
+
+// simCore builds a small core dump in the format produced by Mynewt's
+// coredump package.
+func simCore(imgHash []byte) []byte {
+	type tlv struct {
+		typ  uint8
+		off  uint32
+		data []byte
+	}
+
+	regs := make([]byte, 17*4)
+	mem := make([]byte, 256)
+	for i := range mem {
+		mem[i] = byte(i)
+	}
+
+	tlvs := []tlv{
+		{simCoreTlvImg, 0, imgHash},
+		{simCoreTlvReg, 0, regs},
+		{simCoreTlvMem, 0x20000000, mem},
+	}
+
+	body := &bytes.Buffer{}
+	for _, t := range tlvs {
+		binary.Write(body, binary.LittleEndian, t.typ)
+		binary.Write(body, binary.LittleEndian, uint8(0))
+		binary.Write(body, binary.LittleEndian, uint16(len(t.data)))
+		binary.Write(body, binary.LittleEndian, t.off)
+		body.Write(t.data)
+	}
+
+	core := &bytes.Buffer{}
+	binary.Write(core, binary.LittleEndian, uint32(simCoreMagic))
+	binary.Write(core, binary.LittleEndian, uint32(8+body.Len()))
+	core.Write(body.Bytes())
+
+	return core.Bytes()
+}
+
+func (d *Device) imageStateRsp() *nmp.ImageStateRsp {
+	rsp := nmp.NewImageStateRsp()
+	for _, img := range d.state.Images {
+		rsp.Images = append(rsp.Images, nmp.ImageStateEntry{
+			Image:     img.Image,
+			Slot:      img.Slot,
+			Version:   img.Version,
+			Hash:      img.Hash,
+			Bootable:  img.Bootable,
+			Pending:   img.Pending,
+			Confirmed: img.Confirmed,
+			Active:    img.Active,
+			Permanent: img.Permanent,
+		})
+	}
+
+	return rsp
+}
+
+func (d *Device) imageStateRead(hdr *nmp.NmpHdr,
+	body []byte) (interface{}, int) {
+
+	return d.imageStateRsp(), 0
+}
+
+func (d *Device) imageStateWrite(hdr *nmp.NmpHdr,
+	body []byte) (interface{}, int) {
+
+	req := nmp.ImageStateWriteReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	if len(req.Hash) == 0 {
+		// Confirm the running image.
+		if !req.Confirm {
+			return nil, nmp.NMP_ERR_EINVAL
+		}
+		img := d.findSlot(0, 0)
+		if img == nil {
+			return nil, nmp.NMP_ERR_ENOENT
+		}
+		img.Confirmed = true
+		return d.imageStateRsp(), 0
+	}
+
+	var img *ImageSlot
+	for _, i := range d.state.Images {
+		if bytes.Equal(i.Hash, req.Hash) {
+			img = i
+			break
+		}
+	}
+	if img == nil {
+		return nil, nmp.NMP_ERR_EINVAL
+	}
+
+	if img.Active {
+		if req.Confirm {
+			img.Confirmed = true
+		}
+	} else {
+		if !img.Bootable {
+			return nil, nmp.NMP_ERR_EINVAL
+		}
+		img.Pending = true
+		img.Permanent = req.Confirm
+	}
+
+	return d.imageStateRsp(), 0
+}
+
+func (d *Device) imageUpload(hdr *nmp.NmpHdr,
+	body []byte) (interface{}, int) {
+
+	req := nmp.ImageUploadReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	up := d.state.Upload
+	image := int(req.ImageNum)
+
+	if req.Off == 0 {
+		if req.Len == 0 {
+			return nil, nmp.NMP_ERR_EINVAL
+		}
+
+		// Resume an interrupted upload of the same data.
+		if up != nil && up.Image == image && up.Len == req.Len &&
+			len(req.DataSha) > 0 && bytes.Equal(up.DataSha, req.DataSha) &&
+			len(up.Data) > 0 && len(up.Data) < int(up.Len) {
+
+			rsp := nmp.NewImageUploadRsp()
+			rsp.Off = uint32(len(up.Data))
+			return rsp, 0
+		}
+
+		sec := d.findSlot(image, 1)
+		if sec != nil && (sec.Pending || sec.Active) {
+			return nil, nmp.NMP_ERR_EINVAL
+		}
+		d.removeSlot(image, 1)
+
+		up = &ImageUpload{
+			Image:   image,
+			Len:     req.Len,
+			DataSha: req.DataSha,
+		}
+		d.state.Upload = up
+	}
+
+	rsp := nmp.NewImageUploadRsp()
+	if up == nil || up.Image != image || int(req.Off) != len(up.Data) {
+		// Unexpected offset; tell the client where to continue from.
+		if up != nil && up.Image == image {
+			rsp.Off = uint32(len(up.Data))
+		}
+		return rsp, 0
+	}
+
+	if len(up.Data)+len(req.Data) > int(up.Len) {
+		return nil, nmp.NMP_ERR_EINVAL
+	}
+	up.Data = append(up.Data, req.Data...)
+	rsp.Off = uint32(len(up.Data))
+
+	if len(up.Data) == int(up.Len) {
+		d.state.Images = append(d.state.Images, &ImageSlot{
+			Image:    up.Image,
+			Slot:     1,
+			Version:  simImageVersion(up.Data),
+			Hash:     simImageHash(up.Data),
+			Data:     up.Data,
+			Bootable: true,
+		})
+		d.sortImages()
+
+		// The upload is retained so that retransmitted chunks are answered
+		// with the final offset.
+
+		d.logString(SIM_LOG_MODULE, nmp.LEVEL_INFO,
+			fmt.Sprintf("sim: image upload complete; image=%d len=%d",
+				up.Image, up.Len))
+	}
+
+	return rsp, 0
+}
+
+func (d *Device) imageErase(hdr *nmp.NmpHdr,
+	body []byte) (interface{}, int) {
+
+	sec := d.findSlot(0, 1)
+	if sec != nil && (sec.Pending || sec.Active) {
+		return nil, nmp.NMP_ERR_EINVAL
+	}
+
+	d.removeSlot(0, 1)
+	if d.state.Upload != nil && d.state.Upload.Image == 0 {
+		d.state.Upload = nil
+	}
+
+	return nmp.NewImageEraseRsp(), 0
+}
+
+func (d *Device) coreList(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	if d.state.Core == nil {
+		return nil, nmp.NMP_ERR_ENOENT
+	}
+
+	return nmp.NewCoreListRsp(), 0
+}
+
+func (d *Device) coreLoad(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	req := nmp.CoreLoadReq{}
+	if rc := decodeReq(body, &req); rc != 0 {
+		return nil, rc
+	}
+
+	if d.state.Core == nil {
+		return nil, nmp.NMP_ERR_ENOENT
+	}
+	if int(req.Off) > len(d.state.Core) {
+		return nil, nmp.NMP_ERR_EINVAL
+	}
+
+	end := int(req.Off) + d.chunkSize()
+	if end > len(d.state.Core) {
+		end = len(d.state.Core)
+	}
+
+	rsp := nmp.NewCoreLoadRsp()
+	rsp.Off = req.Off
+	rsp.Len = uint32(len(d.state.Core))
+	rsp.Data = d.state.Core[req.Off:end]
+	return rsp, 0
+}
+
+func (d *Device) coreErase(hdr *nmp.NmpHdr, body []byte) (interface{}, int) {
+	d.state.Core = nil
+	return nmp.NewCoreEraseRsp(), 0
+}
@@ -0,0 +1,309 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmsim
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/runtimeco/go-coap"
+
+	"github.com/recogni/newtmgr/nmxact/mgmt"
+	"github.com/recogni/newtmgr/nmxact/nmcoap"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/omp"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// A response frame waiting to be delivered to the session.
+type simRsp struct {
+	data []byte
+	due  time.Time
+}
+
+type SimSesn struct {
+	cfg    sesn.SesnCfg
+	sx     *SimXport
+	txvr   *mgmt.Transceiver
+	rxTap  sesn.RxTapFn
+	reasm  *nmp.Reassembler
+	isOpen bool
+
+	// This mutex ensures:
+	//     * accesses to isOpen are protected.
+	//     * request frames are passed to the device one at a time.
+	m  sync.Mutex
+	wg sync.WaitGroup
+
+	rspChan  chan simRsp
+	stopChan chan struct{}
+}
+
+func NewSimSesn(sx *SimXport, cfg sesn.SesnCfg) (*SimSesn, error) {
+	if cfg.MgmtProto != sesn.MGMT_PROTO_NMP &&
+		cfg.MgmtProto != sesn.MGMT_PROTO_OMP {
+
+		return nil, fmt.Errorf("Invalid management protocol for sim "+
+			"session: %s", cfg.MgmtProto)
+	}
+
+	// The transceiver is created when the session is opened.
+	s := &SimSesn{
+		cfg: cfg,
+		sx:  sx,
+	}
+
+	return s, nil
+}
+
+func (s *SimSesn) Open() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.isOpen {
+		return nmxutil.NewSesnAlreadyOpenError(
+			"Attempt to open an already-open sim session")
+	}
+
+	txvr, err := mgmt.NewTransceiver(s.cfg.TxFilter, s.cfg.RxFilter, false,
+		s.cfg.MgmtProto, 3)
+	if err != nil {
+		return err
+	}
+	s.txvr = txvr
+	s.txvr.SetRxTap(s.rxTap)
+	s.reasm = nmp.NewReassembler()
+	s.rspChan = make(chan simRsp, 64)
+	s.stopChan = make(chan struct{})
+	s.isOpen = true
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			select {
+			case rsp := <-s.rspChan:
+				if d := time.Until(rsp.due); d > 0 {
+					select {
+					case <-time.After(d):
+					case <-s.stopChan:
+						return
+					}
+				}
+				if s.cfg.MgmtProto == sesn.MGMT_PROTO_OMP {
+					txvr.DispatchCoap(rsp.data)
+				} else {
+					txvr.DispatchNmpRsp(rsp.data)
+				}
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (s *SimSesn) Close() error {
+	s.m.Lock()
+
+	if !s.isOpen {
+		s.m.Unlock()
+		return nmxutil.NewSesnClosedError(
+			"Attempt to close an unopened sim session")
+	}
+
+	s.isOpen = false
+	s.txvr.ErrorAll(fmt.Errorf("closed"))
+	s.txvr.Stop()
+	close(s.stopChan)
+	s.m.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *SimSesn) IsOpen() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.isOpen
+}
+
+func (s *SimSesn) MtuIn() int {
+	return s.mtu()
+}
+
+func (s *SimSesn) MtuOut() int {
+	return s.mtu()
+}
+
+// Only OMP messages carry the CoAP overhead.
+func (s *SimSesn) mtu() int {
+	mtu := s.sx.cfg.Mtu - nmp.NMP_HDR_SIZE
+	if s.cfg.MgmtProto == sesn.MGMT_PROTO_OMP {
+		mtu -= omp.OMP_MSG_OVERHEAD
+	}
+	return mtu
+}
+
+// txRaw hands a single outgoing frame to the simulated device and queues
+// the device's responses for delivery.
+func (s *SimSesn) txRaw(b []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.isOpen {
+		return nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed sim session")
+	}
+
+	req := b
+	if s.cfg.MgmtProto == sesn.MGMT_PROTO_NMP {
+		req = s.reasm.RxFrag(b)
+		if req == nil {
+			// More fragments to come.
+			return nil
+		}
+	}
+
+	rsps, err := s.sx.process(s.cfg.MgmtProto, req)
+	if err != nil {
+		return err
+	}
+
+	due := time.Now().Add(s.sx.cfg.Latency)
+	for _, rsp := range rsps {
+		select {
+		case s.rspChan <- simRsp{data: rsp, due: due}:
+		default:
+			return nmxutil.NewXportError("Sim session response queue full")
+		}
+	}
+
+	return nil
+}
+
+func (s *SimSesn) TxRxMgmt(m *nmp.NmpMsg,
+	timeout time.Duration) (nmp.NmpRsp, error) {
+
+	if !s.IsOpen() {
+		return nil, nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed sim session")
+	}
+
+	return s.txvr.TxRxMgmt(s.txRaw, m, s.MtuOut(), timeout)
+}
+
+func (s *SimSesn) TxRxMgmtAsync(m *nmp.NmpMsg,
+	timeout time.Duration, ch chan nmp.NmpRsp, errc chan error) error {
+
+	if !s.IsOpen() {
+		return nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed sim session")
+	}
+
+	return s.txvr.TxRxMgmtAsync(s.txRaw, m, s.MtuOut(), timeout, ch, errc)
+}
+
+func (s *SimSesn) AbortRx(seq uint8) error {
+	if s.txvr == nil {
+		return nmxutil.NewSesnClosedError(
+			"Attempt to abort receive on unopened sim session")
+	}
+
+	s.txvr.AbortRx(seq)
+	return nil
+}
+
+func (s *SimSesn) NextSeq() uint8 {
+	if s.txvr == nil {
+		return nmxutil.NextNmpSeq()
+	}
+	return s.txvr.NextSeq()
+}
+
+func (s *SimSesn) TxCoap(m coap.Message) error {
+	if !s.IsOpen() {
+		return nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed sim session")
+	}
+
+	return s.txvr.TxCoap(s.txRaw, m, s.MtuOut())
+}
+
+func (s *SimSesn) MgmtProto() sesn.MgmtProto {
+	return s.cfg.MgmtProto
+}
+
+func (s *SimSesn) ListenCoap(mc nmcoap.MsgCriteria) (*nmcoap.Listener, error) {
+	if s.txvr == nil {
+		return nil, nmxutil.NewSesnClosedError(
+			"Attempt to listen on unopened sim session")
+	}
+
+	return s.txvr.ListenCoap(mc)
+}
+
+func (s *SimSesn) StopListenCoap(mc nmcoap.MsgCriteria) {
+	if s.txvr != nil {
+		s.txvr.StopListenCoap(mc)
+	}
+}
+
+func (s *SimSesn) CoapIsTcp() bool {
+	return false
+}
+
+func (s *SimSesn) RxAccept() (sesn.Sesn, *sesn.SesnCfg, error) {
+	return nil, nil, fmt.Errorf("Op not implemented yet")
+}
+
+func (s *SimSesn) RxCoap(opt sesn.TxOptions) (coap.Message, error) {
+	return nil, fmt.Errorf("Op not implemented yet")
+}
+
+func (s *SimSesn) Filters() (nmcoap.TxMsgFilter, nmcoap.RxMsgFilter) {
+	if s.txvr == nil {
+		return s.cfg.TxFilter, s.cfg.RxFilter
+	}
+	return s.txvr.Filters()
+}
+
+// Filters set before the session is opened are applied when it opens.
+func (s *SimSesn) SetFilters(txFilter nmcoap.TxMsgFilter,
+	rxFilter nmcoap.RxMsgFilter) {
+
+	s.cfg.TxFilter = txFilter
+	s.cfg.RxFilter = rxFilter
+	if s.txvr != nil {
+		s.txvr.SetFilters(txFilter, rxFilter)
+	}
+}
+
+func (s *SimSesn) SetRxTap(tap sesn.RxTapFn) {
+	s.rxTap = tap
+	if s.txvr != nil {
+		s.txvr.SetRxTap(tap)
+	}
+}
@@ -0,0 +1,156 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package nmsim
+
+import (
+	"sync"
+	"time"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+type XportCfg struct {
+	// Maximum size of a single request or response frame.
+	Mtu int
+
+	// Delay applied to every response before it is delivered.
+	Latency time.Duration
+
+	// Length of time the device stays unresponsive after a reset.
+	RebootTime time.Duration
+
+	// Highest SMP header version the device supports.
+	SmpVersion uint8
+
+	// If non-empty, device state is loaded from this file when the
+	// transport starts and written back after every request.  This allows
+	// the state of a simulated device to persist across newtmgr
+	// invocations.
+	StatePath string
+
+	// If non-nil, the transport uses this device rather than creating a
+	// fresh one.
+	Device *Device
+}
+
+func NewXportCfg() *XportCfg {
+	return &XportCfg{
+		Mtu:        512,
+		SmpVersion: nmp.NMP_VER2,
+	}
+}
+
+// SimXport is an in-memory transport connected to a simulated Mynewt device.
+// It requires no hardware and is intended for testing newtmgr and nmxact
+// clients.
+type SimXport struct {
+	cfg     *XportCfg
+	dev     *Device
+	started bool
+
+	sync.Mutex
+}
+
+func NewSimXport(cfg *XportCfg) *SimXport {
+	return &SimXport{
+		cfg: cfg,
+	}
+}
+
+// Device returns the simulated device backing this transport.  It is nil
+// until the transport has been started.
+func (sx *SimXport) Device() *Device {
+	sx.Lock()
+	defer sx.Unlock()
+
+	return sx.dev
+}
+
+func (sx *SimXport) BuildSesn(cfg sesn.SesnCfg) (sesn.Sesn, error) {
+	return NewSimSesn(sx, cfg)
+}
+
+func (sx *SimXport) Start() error {
+	sx.Lock()
+	defer sx.Unlock()
+
+	if sx.started {
+		return nmxutil.NewXportError("Sim xport started twice")
+	}
+
+	dev := sx.cfg.Device
+	if dev == nil {
+		dev = NewDevice()
+	}
+	if sx.cfg.StatePath != "" {
+		if err := dev.Load(sx.cfg.StatePath); err != nil {
+			return nmxutil.NewXportError(err.Error())
+		}
+	}
+	dev.SetRebootTime(sx.cfg.RebootTime)
+	dev.SetSmpVersion(sx.cfg.SmpVersion)
+
+	sx.dev = dev
+	sx.started = true
+	return nil
+}
+
+func (sx *SimXport) Stop() error {
+	sx.Lock()
+	defer sx.Unlock()
+
+	if !sx.started {
+		return nmxutil.NewXportError("Sim xport stopped twice")
+	}
+
+	sx.started = false
+	return nil
+}
+
+func (sx *SimXport) Tx(bytes []byte) error {
+	return nmxutil.NewXportError("unsupported")
+}
+
+// process passes a single request frame to the simulated device and returns
+// the resulting response frames.
+func (sx *SimXport) process(proto sesn.MgmtProto,
+	req []byte) ([][]byte, error) {
+
+	sx.Lock()
+	dev := sx.dev
+	started := sx.started
+	sx.Unlock()
+
+	if !started {
+		return nil, nmxutil.NewXportError("Sim xport not started")
+	}
+
+	rsps := dev.Process(proto, req, sx.cfg.Mtu)
+
+	if sx.cfg.StatePath != "" {
+		if err := dev.Save(sx.cfg.StatePath); err != nil {
+			return nil, nmxutil.NewXportError(err.Error())
+		}
+	}
+
+	return rsps, nil
+}
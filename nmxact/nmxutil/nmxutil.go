@@ -20,6 +20,7 @@
 package nmxutil
 
 import (
+	"encoding/hex"
 	"fmt"
 	"math"
 	"math/rand"
@@ -71,6 +72,9 @@ func Assert(cond bool) {
 	}
 }
 
+// Returns the next number from a process-wide counter.  Requests are
+// initialized with this number, but sessions assign their own numbers when
+// the request is sent; see sesn.NextSeq().
 func NextNmpSeq() uint8 {
 	seqMutex.Lock()
 	defer seqMutex.Unlock()
@@ -149,6 +153,39 @@ func EncodeCbor(value interface{}) ([]byte, error) {
 	return b, nil
 }
 
+// CborToJson converts a decoded CBOR value into something the JSON encoder
+// accepts: map keys become strings and byte strings become hex.
+func CborToJson(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprintf("%v", k)] = CborToJson(v)
+		}
+		return m
+
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[k] = CborToJson(v)
+		}
+		return m
+
+	case []interface{}:
+		s := make([]interface{}, len(t))
+		for i, v := range t {
+			s[i] = CborToJson(v)
+		}
+		return s
+
+	case []byte:
+		return hex.EncodeToString(t)
+
+	default:
+		return v
+	}
+}
+
 func StopAndDrainTimer(timer *time.Timer) {
 	if !timer.Stop() {
 		<-timer.C
@@ -61,7 +61,9 @@ func NewDispatcher(rxFilter nmcoap.RxMsgFilter, isTcp bool,
 	return d, nil
 }
 
-func (d *Dispatcher) addOmpListener(seq uint8) (*Listener, error) {
+func (d *Dispatcher) addOmpListener(seq uint8,
+	nmpl *nmp.Listener) (*Listener, error) {
+
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
 
@@ -80,7 +82,7 @@ func (d *Dispatcher) addOmpListener(seq uint8) (*Listener, error) {
 	}
 
 	ompl := &Listener{
-		nmpl:   nmp.NewListener(),
+		nmpl:   nmpl,
 		coapl:  ol,
 		stopCh: make(chan struct{}),
 	}
@@ -88,7 +90,6 @@ func (d *Dispatcher) addOmpListener(seq uint8) (*Listener, error) {
 
 	d.wg.Add(1)
 	go func() {
-		defer d.RemoveCoapListener(mc)
 		defer d.wg.Done()
 
 		// Listen for events.  All feedback is sent to the client via the NMP
@@ -96,19 +97,25 @@ func (d *Dispatcher) addOmpListener(seq uint8) (*Listener, error) {
 		// OMP/NMP agnostic.
 		for {
 			select {
-			case m := <-ompl.coapl.RspChan:
+			case m, ok := <-ompl.coapl.RspChan:
+				if !ok {
+					return
+				}
 				rsp, err := DecodeOmp(m, d.rxFilter)
 				if err != nil {
-					ompl.nmpl.ErrChan <- err
+					ompl.nmpl.DeliverErr(err)
 				} else if rsp != nil {
-					ompl.nmpl.RspChan <- rsp
+					ompl.nmpl.DeliverRsp(rsp)
 				} else {
 					/* no error, no response */
 				}
 
-			case err := <-ompl.coapl.ErrChan:
+			case err, ok := <-ompl.coapl.ErrChan:
+				if !ok {
+					return
+				}
 				if err != nil {
-					ompl.nmpl.ErrChan <- err
+					ompl.nmpl.DeliverErr(err)
 				}
 
 			case <-ompl.stopCh:
@@ -120,6 +127,18 @@ func (d *Dispatcher) addOmpListener(seq uint8) (*Listener, error) {
 	return ompl, nil
 }
 
+// Removes the CoAP listener synchronously so that the sequence number can be
+// reused as soon as this function returns.  Assumes the lock is held.
+func (d *Dispatcher) removeOmpListener(seq uint8, ompl *Listener) {
+	delete(d.seqListenerMap, seq)
+	close(ompl.stopCh)
+
+	d.RemoveCoapListener(nmcoap.MsgCriteria{
+		Token: nmxutil.SeqToToken(seq),
+		Path:  "",
+	})
+}
+
 func (d *Dispatcher) Stop() {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
@@ -130,8 +149,7 @@ func (d *Dispatcher) Stop() {
 	d.stopped = true
 
 	for seq, ompl := range d.seqListenerMap {
-		delete(d.seqListenerMap, seq)
-		close(ompl.stopCh)
+		d.removeOmpListener(seq, ompl)
 	}
 	d.wg.Wait()
 }
@@ -157,7 +175,7 @@ func (d *Dispatcher) RemoveCoapListener(
 }
 
 func (d *Dispatcher) AddNmpListener(seq uint8) (*nmp.Listener, error) {
-	ompl, err := d.addOmpListener(seq)
+	ompl, err := d.addOmpListener(seq, nmp.NewListener())
 	if err != nil {
 		return nil, err
 	}
@@ -166,6 +184,28 @@ func (d *Dispatcher) AddNmpListener(seq uint8) (*nmp.Listener, error) {
 	return ompl.nmpl, nil
 }
 
+// Adds a listener for the response to the specified request.
+func (d *Dispatcher) AddReqNmpListener(req *nmp.NmpHdr) (*nmp.Listener,
+	error) {
+
+	ompl, err := d.addOmpListener(req.Seq, nmp.NewReqListener(req))
+	if err != nil {
+		return nil, err
+	}
+
+	nmxutil.LogAddNmpListener(d.logDepth, req.Seq)
+	return ompl.nmpl, nil
+}
+
+// Indicates whether a listener is registered for the specified sequence
+// number.
+func (d *Dispatcher) HasNmpListener(seq uint8) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return d.seqListenerMap[seq] != nil
+}
+
 func (d *Dispatcher) RemoveNmpListener(seq uint8) *nmp.Listener {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
@@ -175,8 +215,7 @@ func (d *Dispatcher) RemoveNmpListener(seq uint8) *nmp.Listener {
 		return nil
 	}
 
-	delete(d.seqListenerMap, seq)
-	close(ompl.stopCh)
+	d.removeOmpListener(seq, ompl)
 
 	nmxutil.LogRemoveNmpListener(d.logDepth, seq)
 	return ompl.nmpl
@@ -191,7 +230,7 @@ func (d *Dispatcher) ErrorOneNmp(seq uint8, err error) error {
 		return fmt.Errorf("no nmp listener for seq %d", seq)
 	}
 
-	ompl.nmpl.ErrChan <- err
+	ompl.nmpl.DeliverErr(err)
 	return nil
 }
 
@@ -206,3 +245,9 @@ func (d *Dispatcher) SetRxFilter(rxFilter nmcoap.RxMsgFilter) {
 func (d *Dispatcher) RxFilter() nmcoap.RxMsgFilter {
 	return d.rxFilter
 }
+
+// Registers a function that receives every complete incoming CoAP message
+// before it is parsed.  This must be called before the dispatcher is used.
+func (d *Dispatcher) SetRxTap(tap func(msg []byte)) {
+	d.coapd.SetRxTap(tap)
+}
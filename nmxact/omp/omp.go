@@ -90,6 +90,11 @@ func DecodeOmp(m coap.Message, rxFilter nmcoap.RxMsgFilter) (nmp.NmpRsp, error)
 		return nil, nil
 	}
 
+	// The header is not part of a raw response's body.
+	if rr, ok := rsp.(*nmp.RawRsp); ok {
+		delete(rr.Body, "_h")
+	}
+
 	return rsp, nil
 }
 
@@ -119,10 +124,18 @@ func encodeOmpBase(txFilter nmcoap.TxMsgFilter, isTcp bool, nmr *nmp.NmpMsg) (en
 	payload := []byte{}
 	enc := codec.NewEncoderBytes(&payload, new(codec.CborHandle))
 
-	// Convert request struct to map, use "codec" tag which is compatible with "structs"
-	s := structs.New(nmr.Body)
-	s.TagName = "codec"
-	er.fieldMap = s.Map()
+	if m, ok := nmr.Body.(map[string]interface{}); ok {
+		// Raw request; copy the map so the caller's body is not modified.
+		er.fieldMap = make(map[string]interface{}, len(m)+1)
+		for k, v := range m {
+			er.fieldMap[k] = v
+		}
+	} else {
+		// Convert request struct to map, use "codec" tag which is compatible with "structs"
+		s := structs.New(nmr.Body)
+		s.TagName = "codec"
+		er.fieldMap = s.Map()
+	}
 
 	// Add the NMP header to the OMP response map.
 	er.hdrBytes = nmr.Hdr.Bytes()
@@ -0,0 +1,218 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package sesn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+)
+
+// Classes of errors that a retry policy can retry.
+type RetryClass int
+
+const (
+	// The peer did not respond in time (nmxutil.RspTimeoutError).
+	RETRY_ON_TIMEOUT RetryClass = 1 << iota
+
+	// The transport failed (nmxutil.XportError).
+	RETRY_ON_XPORT
+
+	// The BLE connection dropped (nmxutil.BleSesnDisconnectError).  The
+	// session is reopened before the retry.
+	RETRY_ON_DISCONNECT
+
+	// Any other error, except for context cancellation.
+	RETRY_ON_OTHER
+)
+
+var retryClassNames = []struct {
+	class RetryClass
+	name  string
+}{
+	{RETRY_ON_TIMEOUT, "timeout"},
+	{RETRY_ON_XPORT, "xport"},
+	{RETRY_ON_DISCONNECT, "disconnect"},
+	{RETRY_ON_OTHER, "other"},
+}
+
+func (c RetryClass) String() string {
+	var names []string
+	for _, n := range retryClassNames {
+		if c&n.class != 0 {
+			names = append(names, n.name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseRetryClass parses a comma separated list of error class names
+// ("timeout", "xport", "disconnect", "other").  The special names "none" and
+// "all" are also accepted.
+func ParseRetryClass(s string) (RetryClass, error) {
+	var c RetryClass
+
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		switch f {
+		case "", "none":
+			continue
+		case "all":
+			c |= RETRY_ON_TIMEOUT | RETRY_ON_XPORT | RETRY_ON_DISCONNECT |
+				RETRY_ON_OTHER
+			continue
+		}
+
+		found := false
+		for _, n := range retryClassNames {
+			if f == n.name {
+				c |= n.class
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("Invalid retry error class: \"%s\"", f)
+		}
+	}
+
+	return c, nil
+}
+
+// RetryPolicy specifies how a failed management transaction is retried.  The
+// maximum number of attempts is given by TxOptions.Tries.  The zero value
+// stands for DfltRetryPolicy; to disable retries, set Tries to 1.
+type RetryPolicy struct {
+	// Delay before the first retry.  Zero retries immediately.
+	Backoff time.Duration
+
+	// Upper bound on the delay between attempts.  Zero means no bound.
+	MaxBackoff time.Duration
+
+	// Factor by which the delay grows after each retry.  Values below 1
+	// are treated as 1 (constant delay).
+	Multiplier float64
+
+	// Fraction of each delay, between 0 and 1, by which the delay is
+	// randomly lengthened or shortened.
+	Jitter float64
+
+	// The error classes that trigger a retry.
+	RetryOn RetryClass
+}
+
+var DfltRetryPolicy = RetryPolicy{
+	Multiplier: 2,
+	RetryOn:    RETRY_ON_TIMEOUT | RETRY_ON_DISCONNECT,
+}
+
+func NewRetryPolicy() RetryPolicy {
+	return DfltRetryPolicy
+}
+
+// Retryable indicates whether the policy permits retrying a transaction that
+// failed with the specified error.
+func (p *RetryPolicy) Retryable(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded):
+
+		return false
+	case nmxutil.IsRspTimeout(err):
+		return p.RetryOn&RETRY_ON_TIMEOUT != 0
+	case nmxutil.IsBleSesnDisconnect(err):
+		return p.RetryOn&RETRY_ON_DISCONNECT != 0
+	case nmxutil.IsXport(err):
+		return p.RetryOn&RETRY_ON_XPORT != 0
+	default:
+		return p.RetryOn&RETRY_ON_OTHER != 0
+	}
+}
+
+// Delay returns the length of time to wait before the specified retry.  The
+// first retry is number 1.
+func (p *RetryPolicy) Delay(retry int) time.Duration {
+	if p.Backoff <= 0 || retry < 1 {
+		return 0
+	}
+
+	mult := math.Max(p.Multiplier, 1)
+	d := float64(p.Backoff) * math.Pow(mult, float64(retry-1))
+	if p.MaxBackoff > 0 {
+		d = math.Min(d, float64(p.MaxBackoff))
+	}
+
+	if p.Jitter > 0 {
+		j := math.Min(p.Jitter, 1)
+		d += d * j * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
+
+// Prepares to retry a transaction after its specified attempt failed with
+// err.  If the options do not permit another attempt, err is returned.
+// Otherwise, this function waits for the policy's backoff delay and, if the
+// session was disconnected, reopens it.  It returns nil if the transaction
+// should be retried.
+func retryWait(ctx context.Context, s Sesn, o TxOptions, attempt int,
+	err error) error {
+
+	policy := o.RetryPolicy()
+	if attempt >= o.Tries || !policy.Retryable(err) {
+		return err
+	}
+
+	d := policy.Delay(attempt)
+	log.Debugf("Attempt %d of %d failed (%s); retrying in %s",
+		attempt, o.Tries, err.Error(), d)
+
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if nmxutil.IsBleSesnDisconnect(err) && !s.IsOpen() {
+		if err := s.Open(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
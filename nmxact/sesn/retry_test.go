@@ -0,0 +1,362 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package sesn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+)
+
+func TestParseRetryClass(t *testing.T) {
+	tests := []struct {
+		in      string
+		class   RetryClass
+		str     string
+		invalid bool
+	}{
+		{in: "", class: 0, str: "none"},
+		{in: "none", class: 0, str: "none"},
+		{in: "timeout", class: RETRY_ON_TIMEOUT, str: "timeout"},
+		{
+			in:    "disconnect, timeout",
+			class: RETRY_ON_TIMEOUT | RETRY_ON_DISCONNECT,
+			str:   "timeout,disconnect",
+		},
+		{
+			in:    "xport,other",
+			class: RETRY_ON_XPORT | RETRY_ON_OTHER,
+			str:   "xport,other",
+		},
+		{
+			in: "all",
+			class: RETRY_ON_TIMEOUT | RETRY_ON_XPORT | RETRY_ON_DISCONNECT |
+				RETRY_ON_OTHER,
+			str: "timeout,xport,disconnect,other",
+		},
+		{in: "timeout,none", class: RETRY_ON_TIMEOUT, str: "timeout"},
+		{in: "timeout,bogus", invalid: true},
+		{in: "Timeout", invalid: true},
+	}
+
+	for _, tt := range tests {
+		c, err := ParseRetryClass(tt.in)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("ParseRetryClass(%q): expected error, got %v",
+					tt.in, c)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseRetryClass(%q): unexpected error: %s",
+				tt.in, err.Error())
+			continue
+		}
+		if c != tt.class {
+			t.Errorf("ParseRetryClass(%q) = %d; want %d", tt.in, c, tt.class)
+		}
+		if s := c.String(); s != tt.str {
+			t.Errorf("ParseRetryClass(%q).String() = %q; want %q",
+				tt.in, s, tt.str)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tmo := nmxutil.NewRspTimeoutError("timeout")
+	disc := nmxutil.NewBleSesnDisconnectError(0x13, "disconnected")
+	xport := nmxutil.NewXportError("xport")
+	other := errors.New("other")
+	cancelled := fmt.Errorf("wrapped: %w", context.Canceled)
+	expired := context.DeadlineExceeded
+
+	all := RETRY_ON_TIMEOUT | RETRY_ON_XPORT | RETRY_ON_DISCONNECT |
+		RETRY_ON_OTHER
+
+	tests := []struct {
+		name    string
+		retryOn RetryClass
+		err     error
+		want    bool
+	}{
+		{"nil", all, nil, false},
+		{"cancelled", all, cancelled, false},
+		{"deadline", all, expired, false},
+		{"timeout", RETRY_ON_TIMEOUT, tmo, true},
+		{"timeout not enabled", RETRY_ON_XPORT, tmo, false},
+		{"disconnect", RETRY_ON_DISCONNECT, disc, true},
+		{"disconnect not enabled", RETRY_ON_TIMEOUT, disc, false},
+		{"xport", RETRY_ON_XPORT, xport, true},
+		{"xport not enabled", RETRY_ON_OTHER, xport, false},
+		{"other", RETRY_ON_OTHER, other, true},
+		{"other not enabled", RETRY_ON_TIMEOUT, other, false},
+		{"none", 0, tmo, false},
+	}
+
+	for _, tt := range tests {
+		p := RetryPolicy{RetryOn: tt.retryOn}
+		if got := p.Retryable(tt.err); got != tt.want {
+			t.Errorf("%s: Retryable() = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		retry  int
+		want   time.Duration
+	}{
+		{"no backoff", RetryPolicy{Multiplier: 2}, 3, 0},
+		{"retry zero", RetryPolicy{Backoff: 10 * ms}, 0, 0},
+		{"first", RetryPolicy{Backoff: 10 * ms, Multiplier: 2}, 1, 10 * ms},
+		{"second", RetryPolicy{Backoff: 10 * ms, Multiplier: 2}, 2, 20 * ms},
+		{"fourth", RetryPolicy{Backoff: 10 * ms, Multiplier: 2}, 4, 80 * ms},
+		{
+			"bounded",
+			RetryPolicy{Backoff: 10 * ms, Multiplier: 2, MaxBackoff: 50 * ms},
+			4,
+			50 * ms,
+		},
+		{"constant", RetryPolicy{Backoff: 10 * ms, Multiplier: 1}, 5, 10 * ms},
+		{
+			"multiplier below one",
+			RetryPolicy{Backoff: 10 * ms, Multiplier: 0.5},
+			3,
+			10 * ms,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.Delay(tt.retry); got != tt.want {
+			t.Errorf("%s: Delay(%d) = %s; want %s",
+				tt.name, tt.retry, got, tt.want)
+		}
+	}
+}
+
+func TestRetryDelayJitter(t *testing.T) {
+	tests := []struct {
+		jitter float64
+		min    time.Duration
+		max    time.Duration
+	}{
+		{0.25, 75 * time.Millisecond, 125 * time.Millisecond},
+		{1, 0, 200 * time.Millisecond},
+
+		// Jitter beyond 1 is treated as 1.
+		{5, 0, 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		p := RetryPolicy{Backoff: 100 * time.Millisecond, Jitter: tt.jitter}
+		for i := 0; i < 100; i++ {
+			d := p.Delay(1)
+			if d < tt.min || d > tt.max {
+				t.Fatalf("jitter %g: Delay(1) = %s; want between %s and %s",
+					tt.jitter, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestTxOptionsRetryPolicy(t *testing.T) {
+	custom := RetryPolicy{Backoff: time.Second, RetryOn: RETRY_ON_XPORT}
+
+	tests := []struct {
+		name string
+		opt  TxOptions
+		want RetryPolicy
+	}{
+		{"zero value", TxOptions{Tries: 3}, DfltRetryPolicy},
+		{"default", NewTxOptions(), DfltRetryPolicy},
+		{"custom", TxOptions{Tries: 3, Retry: custom}, custom},
+	}
+
+	for _, tt := range tests {
+		if got := tt.opt.RetryPolicy(); got != tt.want {
+			t.Errorf("%s: RetryPolicy() = %+v; want %+v",
+				tt.name, got, tt.want)
+		}
+	}
+}
+
+// A session that only supports being opened; retryWait uses nothing else.
+type retrySesn struct {
+	Sesn
+	open  bool
+	opens int
+}
+
+func (s *retrySesn) IsOpen() bool {
+	return s.open
+}
+
+func (s *retrySesn) Open() error {
+	s.opens++
+	s.open = true
+	return nil
+}
+
+func TestRetryWait(t *testing.T) {
+	tmo := nmxutil.NewRspTimeoutError("timeout")
+	disc := nmxutil.NewBleSesnDisconnectError(0x13, "disconnected")
+	other := errors.New("other")
+
+	tests := []struct {
+		name    string
+		opt     TxOptions
+		attempt int
+		err     error
+		open    bool
+		retry   bool
+		opens   int
+	}{
+		{
+			name:    "retry timeout",
+			opt:     TxOptions{Tries: 3},
+			attempt: 1,
+			err:     tmo,
+			open:    true,
+			retry:   true,
+		},
+		{
+			name:    "tries exhausted",
+			opt:     TxOptions{Tries: 3},
+			attempt: 3,
+			err:     tmo,
+			open:    true,
+		},
+		{
+			name:    "single try",
+			opt:     TxOptions{Tries: 1},
+			attempt: 1,
+			err:     tmo,
+			open:    true,
+		},
+		{
+			name:    "not retryable",
+			opt:     TxOptions{Tries: 3},
+			attempt: 1,
+			err:     other,
+			open:    true,
+		},
+		{
+			name:    "reopen after disconnect",
+			opt:     TxOptions{Tries: 2},
+			attempt: 1,
+			err:     disc,
+			retry:   true,
+			opens:   1,
+		},
+		{
+			name: "disconnect not retried",
+			opt: TxOptions{
+				Tries: 2,
+				Retry: RetryPolicy{RetryOn: RETRY_ON_TIMEOUT},
+			},
+			attempt: 1,
+			err:     disc,
+		},
+	}
+
+	for _, tt := range tests {
+		s := &retrySesn{open: tt.open}
+		err := retryWait(context.Background(), s, tt.opt, tt.attempt, tt.err)
+		if tt.retry && err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err.Error())
+		}
+		if !tt.retry && err != tt.err {
+			t.Errorf("%s: err = %v; want %v", tt.name, err, tt.err)
+		}
+		if s.opens != tt.opens {
+			t.Errorf("%s: session opened %d times; want %d",
+				tt.name, s.opens, tt.opens)
+		}
+	}
+}
+
+func TestRetryWaitCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	opt := TxOptions{
+		Tries: 2,
+		Retry: RetryPolicy{Backoff: time.Hour, RetryOn: RETRY_ON_TIMEOUT},
+	}
+	err := retryWait(ctx, &retrySesn{open: true}, opt, 1,
+		nmxutil.NewRspTimeoutError("timeout"))
+	if err != context.Canceled {
+		t.Errorf("err = %v; want %v", err, context.Canceled)
+	}
+}
+
+// A session whose requests always time out after registering a listener.
+type timeoutSesn struct {
+	retrySesn
+	tries int
+}
+
+func (s *timeoutSesn) TxRxMgmt(m *nmp.NmpMsg,
+	timeout time.Duration) (nmp.NmpRsp, error) {
+
+	s.tries++
+	if m.SeqCb != nil {
+		m.SeqCb(m.Hdr.Seq)
+	}
+	return nil, nmxutil.NewRspTimeoutError("timeout")
+}
+
+func TestTxRxMgmtCtxSeqCb(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := &timeoutSesn{retrySesn: retrySesn{open: true}}
+	calls := 0
+	m := nmp.NewEchoReq().Msg()
+	m.SeqCb = func(seq uint8) { calls++ }
+
+	opt := TxOptions{
+		Tries: 3,
+		Retry: RetryPolicy{RetryOn: RETRY_ON_TIMEOUT},
+	}
+	if _, err := TxRxMgmtCtx(ctx, s, m, opt); !nmxutil.IsRspTimeout(err) {
+		t.Fatalf("err = %v; want timeout", err)
+	}
+	if calls != s.tries {
+		t.Fatalf("SeqCb called %d times over %d tries", calls, s.tries)
+	}
+
+	// The caller's callback must not be left wrapped.
+	m.SeqCb(0)
+	if calls != s.tries+1 {
+		t.Fatalf("SeqCb still wrapped after return")
+	}
+}
@@ -31,19 +31,37 @@ import (
 var DfltTxOptions = TxOptions{
 	Timeout: 10 * time.Second,
 	Tries:   1,
+	Retry:   DfltRetryPolicy,
 }
 
 type NotifyCb func(msg coap.Message, err error)
 
 type TxOptions struct {
+	// Length of time to wait for each response.
 	Timeout time.Duration
-	Tries   int
+
+	// Maximum number of attempts, including the first.
+	Tries int
+
+	// Governs which failures are retried and the delay between attempts.
+	// The zero value means DfltRetryPolicy, so that options built without
+	// a policy still retry timeouts when Tries permits.
+	Retry RetryPolicy
 }
 
 func NewTxOptions() TxOptions {
 	return DfltTxOptions
 }
 
+// RetryPolicy returns the policy that governs retries: Retry, or
+// DfltRetryPolicy if Retry is unset.
+func (opt *TxOptions) RetryPolicy() RetryPolicy {
+	if opt.Retry == (RetryPolicy{}) {
+		return DfltRetryPolicy
+	}
+	return opt.Retry
+}
+
 func (opt *TxOptions) AfterTimeout() <-chan time.Time {
 	if opt.Timeout == 0 {
 		return nil
@@ -52,6 +70,16 @@ func (opt *TxOptions) AfterTimeout() <-chan time.Time {
 	}
 }
 
+// Implemented by sessions that allocate the sequence numbers of their own
+// outgoing management requests.  Such a session may reassign a request's
+// number when it sends the request; if it registers a listener for the
+// response, it calls the request's SeqCb with the final number.
+type SeqAllocator interface {
+	// Allocates a sequence number for an outgoing management request.
+	// Numbers in use by outstanding requests on this session are skipped.
+	NextSeq() uint8
+}
+
 // Represents a communication session with a specific peer.  The particulars
 // vary according to protocol and transport. Several Sesn instances can use the
 // same Xport.
@@ -122,3 +150,17 @@ type Sesn interface {
 	// messages
 	SetFilters(txFilter nmcoap.TxMsgFilter, rxFilter nmcoap.RxMsgFilter)
 }
+
+// Receives each complete frame read from the transport, before it is decoded.
+// coap indicates whether the frame is a CoAP message (OMP or plain CoAP)
+// rather than a plain NMP packet.  The frame must not be retained or
+// modified.
+type RxTapFn func(frame []byte, coap bool)
+
+// Optional interface implemented by sessions that can report the raw frames
+// they receive.
+type RxTapper interface {
+	// Registers a function that receives every incoming frame.  A nil
+	// function removes the tap.
+	SetRxTap(tap RxTapFn)
+}
@@ -50,6 +50,7 @@ type OnCloseFn func(s Sesn, err error)
 type PeerSpec struct {
 	Ble bledefs.BleDev
 	Udp string
+	Tcp string
 }
 
 type SesnCfgBleCentral struct {
@@ -69,6 +70,10 @@ type SesnCfgBle struct {
 	Central SesnCfgBleCentral
 }
 
+type SesnCfgTcp struct {
+	ConnTimeout time.Duration
+}
+
 type SesnCfgLora struct {
 	Addr        string
 	SegSz       int
@@ -85,6 +90,7 @@ type SesnCfg struct {
 	// Transport-specific configuration.
 	Ble  SesnCfgBle
 	Lora SesnCfgLora
+	Tcp  SesnCfgTcp
 
 	// Filters
 	TxFilter nmcoap.TxMsgFilter
@@ -110,5 +116,8 @@ func NewSesnCfg() SesnCfg {
 			ConfirmedTx: false,
 			Port:        lora.COAP_LORA_PORT,
 		},
+		Tcp: SesnCfgTcp{
+			ConnTimeout: 10 * time.Second,
+		},
 	}
 }
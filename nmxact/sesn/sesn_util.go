@@ -20,6 +20,7 @@
 package sesn
 
 import (
+	"context"
 	"time"
 
 	"github.com/runtimeco/go-coap"
@@ -29,31 +30,147 @@ import (
 	"github.com/recogni/newtmgr/nmxact/nmxutil"
 )
 
+// NextSeq allocates a sequence number for a request sent over the specified
+// session.  If the session does not allocate its own numbers, the number is
+// taken from the process-wide counter.
+func NextSeq(s Sesn) uint8 {
+	if sa, ok := s.(SeqAllocator); ok {
+		return sa.NextSeq()
+	}
+	return nmxutil.NextNmpSeq()
+}
+
 // TxRxMgmt sends a management command (NMP / OMP) and listens for the
-// response.
+// response.  Failed attempts are retried according to the options' retry
+// policy.
 func TxRxMgmt(s Sesn, m *nmp.NmpMsg, o TxOptions) (nmp.NmpRsp, error) {
-	retries := o.Tries - 1
-	for i := 0; ; i++ {
-		r, err := s.TxRxMgmt(m, o.Timeout)
+	return TxRxMgmtCtx(context.Background(), s, m, o)
+}
+
+// Bounds of the interval at which a cancelled transaction is re-aborted, for
+// sessions that do not report when the response listener is registered.  The
+// interval doubles after each abort.
+const (
+	abortRetryMin = 10 * time.Millisecond
+	abortRetryMax = time.Second
+)
+
+// TxRxMgmtCtx is like TxRxMgmt, but stops when the context is done.  If the
+// context is done while a request is outstanding, the request's listener is
+// aborted and removed before this function returns ctx.Err().  A context
+// deadline applies in addition to the per-try timeout in the TxOptions.
+func TxRxMgmtCtx(ctx context.Context, s Sesn, m *nmp.NmpMsg,
+	o TxOptions) (nmp.NmpRsp, error) {
+
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		r, err := txRxMgmtOnceCtx(ctx, s, m, o.Timeout)
 		if err == nil {
 			return r, nil
 		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
 
-		if !nmxutil.IsRspTimeout(err) || i >= retries {
+		if err := retryWait(ctx, s, o, attempt, err); err != nil {
 			return nil, err
 		}
 	}
 }
 
+func txRxMgmtOnceCtx(ctx context.Context, s Sesn, m *nmp.NmpMsg,
+	timeout time.Duration) (nmp.NmpRsp, error) {
+
+	// Avoid the extra goroutine for contexts that can never be cancelled.
+	if ctx.Done() == nil {
+		return s.TxRxMgmt(m, timeout)
+	}
+
+	type result struct {
+		rsp nmp.NmpRsp
+		err error
+	}
+
+	// The session reports the request's final sequence number once the
+	// response listener is registered; an abort issued earlier would be lost.
+	// The callback is installed on a copy of the request so that the
+	// caller's callback is not wrapped again on each try.
+	regc := make(chan uint8, 1)
+	req := *m
+	seqCb := m.SeqCb
+	req.SeqCb = func(seq uint8) {
+		if seqCb != nil {
+			seqCb(seq)
+		}
+		select {
+		case regc <- seq:
+		default:
+		}
+	}
+
+	// Sessions that do not allocate sequence numbers never reassign them.
+	seq := m.Hdr.Seq
+
+	resc := make(chan result, 1)
+	go func() {
+		r, err := s.TxRxMgmt(&req, timeout)
+		resc <- result{r, err}
+	}()
+
+	select {
+	case res := <-resc:
+		m.Hdr = req.Hdr
+		return res.rsp, res.err
+	case <-ctx.Done():
+	}
+
+	// Abort the request once, when its listener is registered.  Sessions
+	// that do not allocate sequence numbers never reassign them, and may not
+	// report registration; they are aborted with backoff instead.
+	var retryc <-chan time.Time
+	delay := abortRetryMin
+	_, allocates := s.(SeqAllocator)
+	if !allocates {
+		s.AbortRx(seq)
+		retryc = time.After(delay)
+	}
+
+	for {
+		select {
+		case <-resc:
+			m.Hdr = req.Hdr
+			return nil, ctx.Err()
+
+		case regSeq := <-regc:
+			if allocates {
+				s.AbortRx(regSeq)
+			}
+
+		case <-retryc:
+			s.AbortRx(seq)
+			if delay *= 2; delay > abortRetryMax {
+				delay = abortRetryMax
+			}
+			retryc = time.After(delay)
+		}
+	}
+}
+
+// TxRxMgmtAsync sends a management command without waiting for the
+// response.  Only failures to send are retried; the caller is responsible for
+// retrying requests whose response reports an error on errc.
 func TxRxMgmtAsync(s Sesn, m *nmp.NmpMsg, o TxOptions, ch chan nmp.NmpRsp, errc chan error) error {
-	retries := o.Tries - 1
-	for i := 0; ; i++ {
+	for attempt := 1; ; attempt++ {
 		err := s.TxRxMgmtAsync(m, o.Timeout, ch, errc)
 		if err == nil {
 			return nil
 		}
 
-		if !nmxutil.IsRspTimeout(err) || i >= retries {
+		err = retryWait(context.Background(), s, o, attempt, err)
+		if err != nil {
 			return err
 		}
 	}
@@ -72,6 +189,13 @@ func TxCoap(s Sesn, mp nmcoap.MsgParams) error {
 // RxCoap performs a blocking receive of a CoAP message.  It returns a nil
 // message if the specified listener is closed while the function is running.
 func RxCoap(cl *nmcoap.Listener, timeout time.Duration) (coap.Message, error) {
+	return RxCoapCtx(context.Background(), cl, timeout)
+}
+
+// RxCoapCtx is like RxCoap, but returns ctx.Err() when the context is done.
+func RxCoapCtx(ctx context.Context, cl *nmcoap.Listener,
+	timeout time.Duration) (coap.Message, error) {
+
 	if timeout != 0 {
 		for {
 			select {
@@ -83,6 +207,8 @@ func RxCoap(cl *nmcoap.Listener, timeout time.Duration) (coap.Message, error) {
 				if ok {
 					return nil, nmxutil.NewRspTimeoutError("CoAP timeout")
 				}
+			case <-ctx.Done():
+				return nil, ctx.Err()
 			}
 		}
 	} else {
@@ -91,6 +217,8 @@ func RxCoap(cl *nmcoap.Listener, timeout time.Duration) (coap.Message, error) {
 			return nil, err
 		case rsp := <-cl.RspChan:
 			return rsp, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 	}
 }
@@ -99,6 +227,13 @@ func RxCoap(cl *nmcoap.Listener, timeout time.Duration) (coap.Message, error) {
 func TxRxCoap(s Sesn, mp nmcoap.MsgParams,
 	opts TxOptions) (coap.Message, error) {
 
+	return TxRxCoapCtx(context.Background(), s, mp, opts)
+}
+
+// TxRxCoapCtx is like TxRxCoap, but stops listening when the context is done.
+func TxRxCoapCtx(ctx context.Context, s Sesn, mp nmcoap.MsgParams,
+	opts TxOptions) (coap.Message, error) {
+
 	mc := nmcoap.MsgCriteria{Token: mp.Token}
 	cl, err := s.ListenCoap(mc)
 	if err != nil {
@@ -107,21 +242,27 @@ func TxRxCoap(s Sesn, mp nmcoap.MsgParams,
 	defer s.StopListenCoap(mc)
 
 	listenOnce := func() (coap.Message, error) {
-		return RxCoap(cl, opts.Timeout)
+		return RxCoapCtx(ctx, cl, opts.Timeout)
 	}
 
-	retries := opts.Tries - 1
-	for i := 0; ; i++ {
-		if err := TxCoap(s, mp); err != nil {
+	for attempt := 1; ; attempt++ {
+		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
 
-		rsp, err := listenOnce()
+		err := TxCoap(s, mp)
 		if err == nil {
-			return rsp, nil
+			var rsp coap.Message
+			rsp, err = listenOnce()
+			if err == nil {
+				return rsp, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, err
 		}
 
-		if !nmxutil.IsRspTimeout(err) || i >= retries {
+		if err := retryWait(ctx, s, opts, attempt, err); err != nil {
 			return nil, err
 		}
 	}
@@ -0,0 +1,161 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package tcp
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/recogni/newtmgr/nmxact/nmcoap"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+)
+
+const MAX_PACKET_SIZE = 2048
+
+// Extracts complete messages from a byte stream.
+type framer interface {
+	// Rx appends stream data and returns the messages it completes.  An
+	// error indicates that the stream is corrupt and cannot be
+	// resynchronized.
+	Rx(data []byte) ([][]byte, error)
+}
+
+// nmpFramer splits a stream of back-to-back NMP packets.  Each packet is
+// delimited by the length field in its header.
+type nmpFramer struct {
+	cur []byte
+}
+
+func (f *nmpFramer) Rx(data []byte) ([][]byte, error) {
+	f.cur = append(f.cur, data...)
+
+	var pkts [][]byte
+	for len(f.cur) >= nmp.NMP_HDR_SIZE {
+		hdr, err := nmp.DecodeNmpHdr(f.cur)
+		if err != nil {
+			return pkts, err
+		}
+
+		// Nothing delimits packets other than their headers, so the start
+		// of the next packet cannot be found after a bad length.
+		pktLen := nmp.NMP_HDR_SIZE + int(hdr.Len)
+		if pktLen > MAX_PACKET_SIZE {
+			f.cur = nil
+			return pkts, fmt.Errorf("NMP packet too large: %d > %d",
+				pktLen, MAX_PACKET_SIZE)
+		}
+		if len(f.cur) < pktLen {
+			// More data to come.
+			break
+		}
+
+		pkts = append(pkts, f.cur[:pktLen])
+		f.cur = f.cur[pktLen:]
+	}
+
+	if len(f.cur) == 0 {
+		f.cur = nil
+	} else {
+		f.cur = append([]byte{}, f.cur...)
+	}
+
+	return pkts, nil
+}
+
+// coapFramer splits a stream of RFC 8323 CoAP-over-TCP messages.
+type coapFramer struct {
+	reassembler *nmcoap.Reassembler
+}
+
+func (f *coapFramer) Rx(data []byte) ([][]byte, error) {
+	var msgs [][]byte
+
+	for {
+		tm, raw := f.reassembler.RxFragRaw(data)
+		if tm == nil {
+			break
+		}
+		data = nil
+
+		msgs = append(msgs, raw)
+	}
+
+	return msgs, nil
+}
+
+func newFramer(isCoap bool) framer {
+	if isCoap {
+		return &coapFramer{
+			reassembler: nmcoap.NewReassembler(),
+		}
+	} else {
+		return &nmpFramer{}
+	}
+}
+
+// Dial connects to the specified TCP peer.
+func Dial(peerString string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", peerString, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to TCP peer %s: %s",
+			peerString, err.Error())
+	}
+
+	return conn, nil
+}
+
+// StartRx starts a goroutine that reads from the connection and passes each
+// complete incoming message to dispatchCb.  When the connection is closed or
+// a read fails, closeCb is called with the cause.  If the incoming stream is
+// corrupt, the connection is closed and closeCb is called with an
+// nmxutil.XportError.
+func StartRx(conn net.Conn, isCoap bool, dispatchCb func(data []byte),
+	closeCb func(err error)) {
+
+	go func() {
+		data := make([]byte, MAX_PACKET_SIZE)
+		f := newFramer(isCoap)
+
+		for {
+			nr, err := conn.Read(data)
+			if err != nil {
+				// Connection closed or read error.
+				closeCb(err)
+				return
+			}
+
+			log.Debugf("Received %d bytes from %v", nr, conn.RemoteAddr())
+			msgs, err := f.Rx(data[0:nr])
+			for _, msg := range msgs {
+				dispatchCb(msg)
+			}
+			if err != nil {
+				conn.Close()
+				closeCb(nmxutil.NewXportError(
+					"Corrupt TCP stream: " + err.Error()))
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,269 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package tcp
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/runtimeco/go-coap"
+
+	"github.com/recogni/newtmgr/nmxact/mgmt"
+	"github.com/recogni/newtmgr/nmxact/nmcoap"
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/omp"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+type TcpSesn struct {
+	cfg   sesn.SesnCfg
+	conn  net.Conn
+	txvr  *mgmt.Transceiver
+	rxTap sesn.RxTapFn
+
+	// Protects conn.
+	m sync.Mutex
+
+	// Serializes writes so that frames of concurrent requests do not
+	// interleave on the stream.
+	txMtx sync.Mutex
+}
+
+// The transceiver splits frames into MTU-sized pieces.  A stream has no
+// packet size limit, so each frame is passed to txRaw whole; this lets txMtx
+// keep it contiguous.
+const TX_FRAME_MTU = math.MaxInt32
+
+func NewTcpSesn(cfg sesn.SesnCfg) (*TcpSesn, error) {
+	s := &TcpSesn{
+		cfg: cfg,
+	}
+	txvr, err := mgmt.NewTransceiver(cfg.TxFilter, cfg.RxFilter, true,
+		cfg.MgmtProto, 3)
+	if err != nil {
+		return nil, err
+	}
+	s.txvr = txvr
+
+	return s, nil
+}
+
+func (s *TcpSesn) Open() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.conn != nil {
+		return nmxutil.NewSesnAlreadyOpenError(
+			"Attempt to open an already-open TCP session")
+	}
+
+	txvr, err := mgmt.NewTransceiver(s.cfg.TxFilter, s.cfg.RxFilter, true,
+		s.cfg.MgmtProto, 3)
+	if err != nil {
+		return err
+	}
+	s.txvr = txvr
+	s.txvr.SetRxTap(s.rxTap)
+
+	conn, err := Dial(s.cfg.PeerSpec.Tcp, s.cfg.Tcp.ConnTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+
+	isCoap := s.cfg.MgmtProto != sesn.MGMT_PROTO_NMP
+	StartRx(conn, isCoap,
+		func(data []byte) {
+			if isCoap {
+				txvr.DispatchCoap(data)
+			} else {
+				txvr.DispatchNmpRsp(data)
+			}
+		},
+		func(err error) {
+			s.onDisconnect(conn, err)
+		})
+
+	return nil
+}
+
+// onDisconnect is called when the read side of a connection terminates.  If
+// the session did not initiate the close, the session is closed and all
+// pending requests fail with an nmxutil.XportError.
+func (s *TcpSesn) onDisconnect(conn net.Conn, err error) {
+	s.m.Lock()
+	if s.conn != conn {
+		// Closed by us.
+		s.m.Unlock()
+		return
+	}
+
+	conn.Close()
+	s.conn = nil
+	txvr := s.txvr
+	s.m.Unlock()
+
+	if !nmxutil.IsXport(err) {
+		err = nmxutil.NewXportError(
+			"TCP connection closed by peer: " + err.Error())
+	}
+	txvr.ErrorAll(err)
+	txvr.Stop()
+
+	if s.cfg.OnCloseCb != nil {
+		s.cfg.OnCloseCb(s, err)
+	}
+}
+
+func (s *TcpSesn) Close() error {
+	s.m.Lock()
+	if s.conn == nil {
+		s.m.Unlock()
+		return nmxutil.NewSesnClosedError(
+			"Attempt to close an unopened TCP session")
+	}
+
+	conn := s.conn
+	s.conn = nil
+	txvr := s.txvr
+	s.m.Unlock()
+
+	conn.Close()
+	txvr.ErrorAll(fmt.Errorf("closed"))
+	txvr.Stop()
+	return nil
+}
+
+func (s *TcpSesn) IsOpen() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.conn != nil
+}
+
+func (s *TcpSesn) MtuIn() int {
+	return MAX_PACKET_SIZE -
+		omp.OMP_MSG_OVERHEAD -
+		nmp.NMP_HDR_SIZE
+}
+
+func (s *TcpSesn) MtuOut() int {
+	return MAX_PACKET_SIZE -
+		omp.OMP_MSG_OVERHEAD -
+		nmp.NMP_HDR_SIZE
+}
+
+func (s *TcpSesn) txRaw(b []byte) error {
+	s.m.Lock()
+	conn := s.conn
+	s.m.Unlock()
+
+	if conn == nil {
+		return nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed TCP session")
+	}
+
+	s.txMtx.Lock()
+	defer s.txMtx.Unlock()
+
+	_, err := conn.Write(b)
+	return err
+}
+
+func (s *TcpSesn) TxRxMgmt(m *nmp.NmpMsg,
+	timeout time.Duration) (nmp.NmpRsp, error) {
+
+	if !s.IsOpen() {
+		return nil, nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed TCP session")
+	}
+
+	return s.txvr.TxRxMgmt(s.txRaw, m, TX_FRAME_MTU, timeout)
+}
+
+func (s *TcpSesn) TxRxMgmtAsync(m *nmp.NmpMsg,
+	timeout time.Duration, ch chan nmp.NmpRsp, errc chan error) error {
+
+	if !s.IsOpen() {
+		return nmxutil.NewSesnClosedError(
+			"Attempt to transmit over closed TCP session")
+	}
+
+	return s.txvr.TxRxMgmtAsync(s.txRaw, m, TX_FRAME_MTU, timeout, ch,
+		errc)
+}
+
+func (s *TcpSesn) AbortRx(seq uint8) error {
+	s.txvr.AbortRx(seq)
+	return nil
+}
+
+func (s *TcpSesn) NextSeq() uint8 {
+	return s.txvr.NextSeq()
+}
+
+func (s *TcpSesn) TxCoap(m coap.Message) error {
+	return s.txvr.TxCoap(s.txRaw, m, TX_FRAME_MTU)
+}
+
+func (s *TcpSesn) MgmtProto() sesn.MgmtProto {
+	return s.cfg.MgmtProto
+}
+
+func (s *TcpSesn) ListenCoap(mc nmcoap.MsgCriteria) (*nmcoap.Listener, error) {
+	return s.txvr.ListenCoap(mc)
+}
+
+func (s *TcpSesn) StopListenCoap(mc nmcoap.MsgCriteria) {
+	s.txvr.StopListenCoap(mc)
+}
+
+func (s *TcpSesn) CoapIsTcp() bool {
+	return true
+}
+
+func (s *TcpSesn) RxAccept() (sesn.Sesn, *sesn.SesnCfg, error) {
+	return nil, nil, fmt.Errorf("Op not implemented yet")
+}
+
+func (s *TcpSesn) RxCoap(opt sesn.TxOptions) (coap.Message, error) {
+	return nil, fmt.Errorf("Op not implemented yet")
+}
+
+func (s *TcpSesn) Filters() (nmcoap.TxMsgFilter, nmcoap.RxMsgFilter) {
+	return s.txvr.Filters()
+}
+
+func (s *TcpSesn) SetFilters(txFilter nmcoap.TxMsgFilter,
+	rxFilter nmcoap.RxMsgFilter) {
+
+	s.txvr.SetFilters(txFilter, rxFilter)
+}
+
+func (s *TcpSesn) SetRxTap(tap sesn.RxTapFn) {
+	s.rxTap = tap
+	if s.txvr != nil {
+		s.txvr.SetRxTap(tap)
+	}
+}
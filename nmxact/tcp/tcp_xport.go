@@ -0,0 +1,59 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package tcp
+
+import (
+	"fmt"
+
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+type TcpXport struct {
+	started bool
+}
+
+func NewTcpXport() *TcpXport {
+	return &TcpXport{}
+}
+
+func (tx *TcpXport) BuildSesn(cfg sesn.SesnCfg) (sesn.Sesn, error) {
+	return NewTcpSesn(cfg)
+}
+
+func (tx *TcpXport) Start() error {
+	if tx.started {
+		return nmxutil.NewXportError("TCP xport started twice")
+	}
+	tx.started = true
+	return nil
+}
+
+func (tx *TcpXport) Stop() error {
+	if !tx.started {
+		return nmxutil.NewXportError("TCP xport stopped twice")
+	}
+	tx.started = false
+	return nil
+}
+
+func (tx *TcpXport) Tx(bytes []byte) error {
+	return fmt.Errorf("unsupported")
+}
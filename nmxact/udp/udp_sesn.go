@@ -138,10 +138,14 @@ func (s *UdpSesn) TxRxMgmtAsync(m *nmp.NmpMsg,
 }
 
 func (s *UdpSesn) AbortRx(seq uint8) error {
-	s.txvr.ErrorAll(fmt.Errorf("Rx aborted"))
+	s.txvr.AbortRx(seq)
 	return nil
 }
 
+func (s *UdpSesn) NextSeq() uint8 {
+	return s.txvr.NextSeq()
+}
+
 func (s *UdpSesn) TxCoap(m coap.Message) error {
 	txRaw := func(b []byte) error {
 		_, err := s.conn.WriteToUDP(b, s.addr)
@@ -184,3 +188,7 @@ func (s *UdpSesn) SetFilters(txFilter nmcoap.TxMsgFilter,
 
 	s.txvr.SetFilters(txFilter, rxFilter)
 }
+
+func (s *UdpSesn) SetRxTap(tap sesn.RxTapFn) {
+	s.txvr.SetRxTap(tap)
+}
@@ -20,8 +20,11 @@
 package xact
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 
+	"github.com/recogni/newtmgr/nmxact/nmp"
 	"github.com/recogni/newtmgr/nmxact/sesn"
 )
 
@@ -29,6 +32,32 @@ type Result interface {
 	Status() int
 }
 
+// Implemented by results that carry a single response.
+type RspResult interface {
+	Result
+
+	// Retrieves the response the result was built from.
+	Response() nmp.NmpRsp
+}
+
+// StatusError returns the error status reported in a command's result, or
+// nil if the command succeeded.  A non-nil error is an *nmp.MgmtError.  If
+// the result carries a single response, a group-scoped error reported by the
+// device is preserved.
+func StatusError(r Result) error {
+	if r.Status() == 0 {
+		return nil
+	}
+
+	if rr, ok := r.(RspResult); ok {
+		if err := nmp.RspError(rr.Response()); err != nil {
+			return err
+		}
+	}
+
+	return nmp.StatusError(r.Status())
+}
+
 type Cmd interface {
 	// Transmits request and listens for response; blocking.
 	Run(s sesn.Sesn) (Result, error)
@@ -36,13 +65,23 @@ type Cmd interface {
 
 	TxOptions() sesn.TxOptions
 	SetTxOptions(opt sesn.TxOptions)
+
+	// The context governing the command's transactions.  When the context
+	// is done, outstanding transactions are aborted and Run returns
+	// ctx.Err().
+	Context() context.Context
+	SetContext(ctx context.Context)
 }
 
 type CmdBase struct {
 	txOptions sesn.TxOptions
-	curNmpSeq uint8
+	ctx       context.Context
 	curSesn   sesn.Sesn
 	abortErr  error
+
+	// Sequence number of the outstanding request.  Accessed atomically; the
+	// session updates it from its own goroutine if it reassigns the number.
+	curNmpSeq uint32
 }
 
 func NewCmdBase() CmdBase {
@@ -59,9 +98,28 @@ func (c *CmdBase) SetTxOptions(opt sesn.TxOptions) {
 	c.txOptions = opt
 }
 
+func (c *CmdBase) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+func (c *CmdBase) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+func (c *CmdBase) setCurNmpSeq(seq uint8) {
+	atomic.StoreUint32(&c.curNmpSeq, uint32(seq))
+}
+
+func (c *CmdBase) getCurNmpSeq() uint8 {
+	return uint8(atomic.LoadUint32(&c.curNmpSeq))
+}
+
 func (c *CmdBase) Abort() error {
 	if c.curSesn != nil {
-		if err := c.curSesn.AbortRx(c.curNmpSeq); err != nil {
+		if err := c.curSesn.AbortRx(c.getCurNmpSeq()); err != nil {
 			return err
 		}
 	}
@@ -69,3 +127,11 @@ func (c *CmdBase) Abort() error {
 	c.abortErr = fmt.Errorf("Command aborted")
 	return nil
 }
+
+// RunCtx executes a command under the specified context.  Cancelling the
+// context, or reaching its deadline, aborts the command's in-flight
+// transactions; multi-step commands do not start further steps.
+func RunCtx(ctx context.Context, c Cmd, s sesn.Sesn) (Result, error) {
+	c.SetContext(ctx)
+	return c.Run(s)
+}
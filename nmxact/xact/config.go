@@ -51,6 +51,10 @@ func (r *ConfigReadResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *ConfigReadResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *ConfigReadCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewConfigReadReq()
 	r.Name = c.Name
@@ -95,6 +99,10 @@ func (r *ConfigWriteResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *ConfigWriteResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *ConfigWriteCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewConfigWriteReq()
 	r.Name = c.Name
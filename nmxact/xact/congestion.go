@@ -0,0 +1,247 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package xact
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Smallest amount of data per request that the congestion controller reduces
+// an upload to.
+const PIPELINE_MIN_CHUNK = 64
+
+// Queuing delay below which the round trip time is not considered inflated.
+const congMinQueueDelay = 20 * time.Millisecond
+
+// congestionCtl sizes the window of a pipelined transfer from the round trip
+// times and losses it observes and, for writes, the amount of data carried by
+// each request.
+//
+// The window follows AIMD.  It grows by one request per acknowledgement up to
+// the slow start threshold, then by one request per window.  Growth pauses
+// while the smoothed RTT is more than double the minimum, i.e., while
+// requests are queuing on the link.  A loss halves the window; further losses
+// of requests sent before the reduction belong to the same congestion event
+// and are not acted on.
+//
+// A congestion event that occurs with a window of one shrinks the chunk
+// length by a quarter instead, as small requests are less likely to be lost
+// on fragmenting links and cheaper to retransmit.  The chunk length
+// recovers by an eighth each time two windows are acknowledged without loss.
+type congestionCtl struct {
+	maxWinSz int
+	cwnd     float64
+	ssthresh float64
+
+	srtt    time.Duration
+	rttvar  time.Duration
+	minRtt  time.Duration
+	maxRtt  time.Duration
+	samples int
+
+	// Upper bound on the data per request; 0 means no bound.
+	chunkLen int
+
+	// Largest chunk sent so far.
+	maxChunk int
+
+	// Acknowledgements since the last loss or chunk length increase.
+	clean int
+
+	// Losses of requests sent before this time have been acted on.
+	recoverAt time.Time
+}
+
+func newCongestionCtl(maxWinSz int) *congestionCtl {
+	if maxWinSz < 1 {
+		maxWinSz = 1
+	}
+
+	return &congestionCtl{
+		maxWinSz: maxWinSz,
+		cwnd:     PIPELINE_START_WS,
+		ssthresh: float64(maxWinSz),
+	}
+}
+
+func fmtChunkLen(n int) string {
+	if n == 0 {
+		return "max"
+	}
+	return fmt.Sprintf("%d", n)
+}
+
+// The number of requests that may be in flight.
+func (c *congestionCtl) window() int {
+	w := int(c.cwnd)
+	if w < 1 {
+		w = 1
+	}
+	if w > c.maxWinSz {
+		w = c.maxWinSz
+	}
+	return w
+}
+
+func (c *congestionCtl) queuing() bool {
+	return c.samples > 0 && c.srtt > 2*c.minRtt &&
+		c.srtt-c.minRtt > congMinQueueDelay
+}
+
+// Records the length of a chunk about to be sent.
+func (c *congestionCtl) onChunk(n int) {
+	if n > c.maxChunk {
+		c.maxChunk = n
+	}
+}
+
+// Records a round trip time sample (RFC 6298 smoothing).
+func (c *congestionCtl) onRtt(rtt time.Duration) {
+	if c.samples == 0 {
+		c.srtt = rtt
+		c.rttvar = rtt / 2
+		c.minRtt = rtt
+		c.maxRtt = rtt
+	} else {
+		dev := c.srtt - rtt
+		if dev < 0 {
+			dev = -dev
+		}
+		c.rttvar = (3*c.rttvar + dev) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+
+		if rtt < c.minRtt {
+			c.minRtt = rtt
+		}
+		if rtt > c.maxRtt {
+			c.maxRtt = rtt
+		}
+	}
+	c.samples++
+}
+
+// Records an acknowledgement of new data.
+func (c *congestionCtl) onAck() {
+	oldWin := c.window()
+	if !c.queuing() {
+		if c.cwnd < c.ssthresh {
+			c.cwnd++
+		} else {
+			c.cwnd += 1 / c.cwnd
+		}
+		c.cwnd = math.Min(c.cwnd, float64(c.maxWinSz))
+	}
+	if w := c.window(); w != oldWin {
+		log.Debugf("Pipeline window %d -> %d (srtt=%s min-rtt=%s)",
+			oldWin, w, c.srtt, c.minRtt)
+	}
+
+	c.clean++
+	if c.chunkLen > 0 && c.clean >= 2*c.window() {
+		c.clean = 0
+
+		oldLen := c.chunkLen
+		c.chunkLen += c.chunkLen/8 + 1
+		if c.chunkLen >= c.maxChunk {
+			c.chunkLen = 0
+		}
+		log.Debugf("Pipeline chunk length %s -> %s", fmtChunkLen(oldLen),
+			fmtChunkLen(c.chunkLen))
+	}
+}
+
+// Records the loss of a request sent at the specified time.
+func (c *congestionCtl) onLoss(sent time.Time) {
+	c.clean = 0
+	if sent.Before(c.recoverAt) {
+		return
+	}
+	c.recoverAt = time.Now()
+
+	oldWin := c.window()
+	c.ssthresh = math.Max(c.cwnd/2, 1)
+	c.cwnd = c.ssthresh
+
+	// Shorter chunks only help once the window can shrink no further.
+	oldLen := c.chunkLen
+	cur := c.chunkLen
+	if cur == 0 {
+		cur = c.maxChunk
+	}
+	if oldWin <= 1 && cur > 0 {
+		c.chunkLen = cur * 3 / 4
+		if c.chunkLen < PIPELINE_MIN_CHUNK {
+			c.chunkLen = PIPELINE_MIN_CHUNK
+		}
+	}
+
+	log.Debugf("Pipeline loss; window %d -> %d, chunk length %s -> %s "+
+		"(srtt=%s rttvar=%s)", oldWin, c.window(), fmtChunkLen(oldLen),
+		fmtChunkLen(c.chunkLen), c.srtt, c.rttvar)
+}
+
+// XferStats summarises a pipelined transfer.
+type XferStats struct {
+	// Amount of data transferred, in bytes.
+	Bytes   int
+	Elapsed time.Duration
+
+	// Requests sent, requests that failed, and requests that repeated an
+	// earlier one.
+	Requests    int
+	Losses      int
+	Retransmits int
+
+	// Round trip times; AvgRtt is the smoothed RTT at the end of the
+	// transfer.
+	MinRtt time.Duration
+	AvgRtt time.Duration
+	MaxRtt time.Duration
+
+	// Largest window used and final chunk length limit (0 = no limit).
+	MaxWinSz int
+	ChunkLen int
+}
+
+// Throughput returns the transfer rate in bytes per second.
+func (s *XferStats) Throughput() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Bytes) / s.Elapsed.Seconds()
+}
+
+func (s *XferStats) String() string {
+	str := fmt.Sprintf("%d bytes in %s (%.2f KiB/s); requests=%d lost=%d "+
+		"retransmitted=%d; rtt min/avg/max=%s/%s/%s; max-window=%d",
+		s.Bytes, s.Elapsed.Round(time.Millisecond), s.Throughput()/1024,
+		s.Requests, s.Losses, s.Retransmits,
+		s.MinRtt.Round(time.Microsecond), s.AvgRtt.Round(time.Microsecond),
+		s.MaxRtt.Round(time.Microsecond), s.MaxWinSz)
+	if s.ChunkLen > 0 {
+		str += fmt.Sprintf("; chunk-limit=%d", s.ChunkLen)
+	}
+
+	return str
+}
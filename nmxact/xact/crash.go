@@ -92,6 +92,10 @@ func (r *CrashResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *CrashResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *CrashCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewCrashReq()
 	r.CrashType = CrashTypeToString(c.CrashType)
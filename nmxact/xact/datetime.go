@@ -50,6 +50,10 @@ func (r *DateTimeReadResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *DateTimeReadResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *DateTimeReadCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewDateTimeReadReq()
 
@@ -91,6 +95,10 @@ func (r *DateTimeWriteResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *DateTimeWriteResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *DateTimeWriteCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewDateTimeWriteReq()
 	r.DateTime = c.DateTime
@@ -47,6 +47,10 @@ func (r *EchoResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *EchoResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *EchoCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewEchoReq()
 	r.Payload = c.Payload
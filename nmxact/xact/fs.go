@@ -20,8 +20,12 @@
 package xact
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 
+	log "github.com/sirupsen/logrus"
+
 	"github.com/recogni/newtmgr/nmxact/mgmt"
 	"github.com/recogni/newtmgr/nmxact/nmp"
 	"github.com/recogni/newtmgr/nmxact/sesn"
@@ -41,11 +45,13 @@ type FsDownloadCmd struct {
 	CmdBase
 	Name       string
 	ProgressCb FsDownloadProgressCb
+	MaxWinSz   int
 }
 
 func NewFsDownloadCmd() *FsDownloadCmd {
 	return &FsDownloadCmd{
-		CmdBase: NewCmdBase(),
+		CmdBase:  NewCmdBase(),
+		MaxWinSz: PIPELINE_DEF_MAX_WS,
 	}
 }
 
@@ -64,34 +70,37 @@ func (r *FsDownloadResult) Status() int {
 
 func (c *FsDownloadCmd) Run(s sesn.Sesn) (Result, error) {
 	res := newFsDownloadResult()
-	off := 0
-
-	for {
-		r := nmp.NewFsDownloadReq()
-		r.Name = c.Name
-		r.Off = uint32(off)
-
-		rsp, err := txReq(s, r.Msg(), &c.CmdBase)
-		if err != nil {
-			return nil, err
-		}
-		frsp := rsp.(*nmp.FsDownloadRsp)
-		res.Rsps = append(res.Rsps, frsp)
-
-		if frsp.Rc != 0 {
-			break
-		}
 
-		if c.ProgressCb != nil {
-			c.ProgressCb(c, frsp)
-		}
-
-		if len(frsp.Data) == 0 {
-			// Download complete.
-			break
-		}
+	p := &pipeline{
+		c:        &c.CmdBase,
+		s:        s,
+		maxWinSz: c.MaxWinSz,
+
+		build: func(off int, _ int) (*nmp.NmpMsg, int, error) {
+			r := nmp.NewFsDownloadReq()
+			r.Name = c.Name
+			r.Off = uint32(off)
+			return r.Msg(), off, nil
+		},
+		parse: func(off int, rsp nmp.NmpRsp) pipeAck {
+			frsp := rsp.(*nmp.FsDownloadRsp)
+			return byteReadAck(off, frsp.Rc, frsp.Len, len(frsp.Data))
+		},
+		trim: func(rsp nmp.NmpRsp, off int, end int) {
+			frsp := rsp.(*nmp.FsDownloadRsp)
+			frsp.Data = frsp.Data[:end-off]
+		},
+		deliver: func(rsp nmp.NmpRsp) {
+			frsp := rsp.(*nmp.FsDownloadRsp)
+			res.Rsps = append(res.Rsps, frsp)
+			if frsp.Rc == 0 && c.ProgressCb != nil {
+				c.ProgressCb(c, frsp)
+			}
+		},
+	}
 
-		off = int(frsp.Off) + len(frsp.Data)
+	if err := p.run(); err != nil {
+		return nil, err
 	}
 
 	return res, nil
@@ -106,17 +115,29 @@ type FsUploadCmd struct {
 	CmdBase
 	Name       string
 	Data       []byte
+	StartOff   int
 	ProgressCb FsUploadProgressCb
+	MaxWinSz   int
+
+	// If non-nil, progress is recorded here so that an interrupted upload
+	// can be resumed.  DeviceId identifies the device in the records.
+	Resume   *XferStateStore
+	DeviceId string
 }
 
 func NewFsUploadCmd() *FsUploadCmd {
 	return &FsUploadCmd{
-		CmdBase: NewCmdBase(),
+		CmdBase:  NewCmdBase(),
+		MaxWinSz: PIPELINE_DEF_MAX_WS,
 	}
 }
 
 type FsUploadResult struct {
 	Rsps []*nmp.FsUploadRsp
+
+	// The offset an interrupted upload was resumed from; 0 if the upload
+	// started from StartOff.
+	ResumeOff int
 }
 
 func newFsUploadResult() *FsUploadResult {
@@ -143,10 +164,15 @@ func buildFsUploadReq(name string, fileSz int, chunk []byte,
 	return r
 }
 
-func nextFsUploadReq(s sesn.Sesn, name string, data []byte, off int) (
-	*nmp.FsUploadReq, error) {
+// Builds the request for the chunk at off.  The chunk carries as much data as
+// fits in the MTU, up to maxLen bytes if maxLen is nonzero.
+func nextFsUploadReq(s sesn.Sesn, name string, data []byte, off int,
+	maxLen int) (*nmp.FsUploadReq, error) {
 
 	room := cMaxFSUploadChunk
+	if maxLen > 0 {
+		room = min(room, maxLen)
+	}
 	if room <= 0 {
 		return nil, fmt.Errorf("Cannot create file upload request; " +
 			"MTU too low to fit any file data")
@@ -175,31 +201,157 @@ func nextFsUploadReq(s sesn.Sesn, name string, data []byte, off int) (
 	return r, nil
 }
 
-func (c *FsUploadCmd) Run(s sesn.Sesn) (Result, error) {
-	res := newFsUploadResult()
+func (c *FsUploadCmd) readChunk(s sesn.Sesn,
+	off int) (*nmp.FsDownloadRsp, error) {
 
-	for off := 0; off < len(c.Data); {
-		r, err := nextFsUploadReq(s, c.Name, c.Data, off)
-		if err != nil {
-			return nil, err
+	r := nmp.NewFsDownloadReq()
+	r.Name = c.Name
+	r.Off = uint32(off)
+
+	rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+	if err != nil {
+		return nil, err
+	}
+
+	return rsp.(*nmp.FsDownloadRsp), nil
+}
+
+// Indicates whether a chunk read from the device matches the file's contents.
+func (c *FsUploadCmd) chunkMatches(rsp *nmp.FsDownloadRsp, off int) bool {
+	return rsp.Rc == 0 && off+len(rsp.Data) <= len(c.Data) &&
+		bytes.Equal(rsp.Data, c.Data[off:off+len(rsp.Data)])
+}
+
+// Indicates whether the first sz bytes of the device's copy of the file match
+// the file's contents.  head is the device's first chunk, which has already
+// been compared.  The rest is compared by hash if the device supports the
+// file hash command, and by reading it back otherwise.
+func (c *FsUploadCmd) prefixMatches(s sesn.Sesn, head *nmp.FsDownloadRsp,
+	sz int) (bool, error) {
+
+	if sz <= len(head.Data) {
+		return true, nil
+	}
+
+	r := nmp.NewFsHashReq()
+	r.Name = c.Name
+	r.Type = nmp.FS_HASH_SHA256
+	r.Len = uint32(sz)
+
+	rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+	if err == nil {
+		hrsp := rsp.(*nmp.FsHashRsp)
+		if hrsp.Rc == 0 && hrsp.Type == nmp.FS_HASH_SHA256 &&
+			hrsp.Off == 0 && int(hrsp.Len) == sz {
+
+			sum := sha256.Sum256(c.Data[:sz])
+			return bytes.Equal(hrsp.Output, sum[:]), nil
 		}
+	} else if c.abortErr != nil || c.Context().Err() != nil {
+		return false, err
+	}
+	log.Debugf("Cannot hash %s on the device; reading it back", c.Name)
 
-		rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+	for off := len(head.Data); off < sz; {
+		rsp, err := c.readChunk(s, off)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
-		crsp := rsp.(*nmp.FsUploadRsp)
+		if len(rsp.Data) == 0 || !c.chunkMatches(rsp, off) {
+			return false, nil
+		}
+		off += len(rsp.Data)
+	}
 
-		off = int(crsp.Off)
+	return true, nil
+}
+
+// Determines where an interrupted upload of the file can continue from.  The
+// device reports the size of its partial copy of the file in response to a
+// download request.  The copy is only trusted if it is no longer than the
+// file and all of it matches the file's contents; otherwise the upload starts
+// over.
+func (c *FsUploadCmd) resumeOff(s sesn.Sesn) (int, error) {
+	head, err := c.readChunk(s, 0)
+	if err != nil {
+		return 0, err
+	}
+	sz := int(head.Len)
+	if head.Rc != 0 || sz == 0 || sz > len(c.Data) || !c.chunkMatches(head, 0) {
+		return 0, nil
+	}
+
+	match, err := c.prefixMatches(s, head, sz)
+	if err != nil || !match {
+		return 0, err
+	}
 
-		if c.ProgressCb != nil {
-			c.ProgressCb(c, crsp)
+	if sz == len(c.Data) {
+		// The device has the whole file.  Send the final part again so that
+		// the device acknowledges the upload's completion.
+		if sz > len(head.Data) {
+			return sz - len(head.Data), nil
 		}
+		return 0, nil
+	}
 
-		res.Rsps = append(res.Rsps, crsp)
-		if crsp.Rc != 0 {
-			break
+	return sz, nil
+}
+
+func (c *FsUploadCmd) Run(s sesn.Sesn) (Result, error) {
+	res := newFsUploadResult()
+
+	xs, resume := openXferState(c.Resume, c.DeviceId, XFER_KIND_FILE,
+		c.Name, c.Data)
+
+	start := c.StartOff
+	if resume {
+		off, err := c.resumeOff(s)
+		if err != nil {
+			return nil, err
 		}
+		if off > 0 {
+			start = off
+			res.ResumeOff = off
+		}
+	}
+
+	p := &pipeline{
+		c:        &c.CmdBase,
+		s:        s,
+		write:    true,
+		start:    start,
+		total:    len(c.Data),
+		maxWinSz: c.MaxWinSz,
+
+		build: func(off int, maxLen int) (*nmp.NmpMsg, int, error) {
+			r, err := nextFsUploadReq(s, c.Name, c.Data, off, maxLen)
+			if err != nil {
+				return nil, 0, err
+			}
+			return r.Msg(), off + len(r.Data), nil
+		},
+		parse: func(off int, rsp nmp.NmpRsp) pipeAck {
+			crsp := rsp.(*nmp.FsUploadRsp)
+			return pipeAck{end: int(crsp.Off), fail: crsp.Rc != 0}
+		},
+		deliver: func(rsp nmp.NmpRsp) {
+			crsp := rsp.(*nmp.FsUploadRsp)
+			if crsp.Rc == 0 {
+				xs.update(int(crsp.Off))
+			}
+			if c.ProgressCb != nil {
+				c.ProgressCb(c, crsp)
+			}
+			res.Rsps = append(res.Rsps, crsp)
+		},
+	}
+
+	if err := p.run(); err != nil {
+		return nil, err
+	}
+	if res.Status() == 0 {
+		xs.remove()
 	}
 
 	return res, nil
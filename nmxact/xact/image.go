@@ -20,21 +20,22 @@
 package xact
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"fmt"
+	"strconv"
 
+	log "github.com/sirupsen/logrus"
 	pb "gopkg.in/cheggaaa/pb.v1"
 
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/recogni/newtmgr/newtmgr/nmutil"
 	"github.com/recogni/newtmgr/nmxact/mgmt"
+	"github.com/recogni/newtmgr/nmxact/nmimage"
 	"github.com/recogni/newtmgr/nmxact/nmp"
-	"github.com/recogni/newtmgr/nmxact/nmxutil"
 	"github.com/recogni/newtmgr/nmxact/sesn"
-	log "github.com/sirupsen/logrus"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -44,8 +45,8 @@ const IMAGE_UPLOAD_MAX_CHUNK = 1024
 const IMAGE_UPLOAD_MIN_1ST_CHUNK = 32
 const IMAGE_UPLOAD_STATUS_MISSED = -1
 const IMAGE_UPLOAD_CHUNK_MISSED_WM = -1
-const IMAGE_UPLOAD_START_WS = 1
-const IMAGE_UPLOAD_DEF_MAX_WS = 5
+const IMAGE_UPLOAD_START_WS = PIPELINE_START_WS
+const IMAGE_UPLOAD_DEF_MAX_WS = PIPELINE_DEF_MAX_WS
 const IMAGE_UPLOAD_STATUS_EXPECTED = 0
 const IMAGE_UPLOAD_STATUS_RQ = 1
 
@@ -60,6 +61,11 @@ type ImageUploadCmd struct {
 	MaxWinSz   int
 }
 
+// Tracks the window of an image upload.
+//
+// Deprecated: ImageUploadCmd now runs uploads through the chunked transfer
+// pipeline and no longer uses this type.  It is kept, with its original
+// behavior, for callers that drive uploads themselves.
 type ImageUploadIntTracker struct {
 	Mutex    sync.Mutex
 	TuneWS   bool
@@ -68,15 +74,31 @@ type ImageUploadIntTracker struct {
 	WCap     int
 	Off      int
 	MaxRxOff int32
+
+	// Retry policy applied to failed chunks.
+	TxOpts sesn.TxOptions
+
+	// Number of failed attempts for each chunk offset.
+	Fails map[int]int
+
+	// Earliest time a failed chunk may be retransmitted.
+	RetryAt time.Time
+
+	// Set when a chunk fails and may not be retried; ends the upload.
+	Err error
 }
 
 type ImageUploadResult struct {
 	Rsps []*nmp.ImageUploadRsp
+
+	// Throughput and round trip time report for the upload.
+	Stats XferStats
 }
 
 func NewImageUploadCmd() *ImageUploadCmd {
 	return &ImageUploadCmd{
-		CmdBase: NewCmdBase(),
+		CmdBase:  NewCmdBase(),
+		MaxWinSz: IMAGE_UPLOAD_DEF_MAX_WS,
 	}
 }
 
@@ -130,11 +152,14 @@ func encodeUploadReq(s sesn.Sesn, hash []byte, upgrade bool, data []byte,
 }
 
 func findChunkLen(s sesn.Sesn, hash []byte, upgrade bool, data []byte,
-	off int, imageNum int, seq uint8) (int, error) {
+	off int, imageNum int, seq uint8, maxLen int) (int, error) {
 
 	// Let's start by encoding max allowed chunk len and we will see how many
 	// bytes we need to cut
 	chunklen := min(len(data)-off, IMAGE_UPLOAD_MAX_CHUNK)
+	if maxLen > 0 {
+		chunklen = min(chunklen, maxLen)
+	}
 
 	// Keep reducing the chunk size until the request fits the MTU.
 	for {
@@ -155,8 +180,10 @@ func findChunkLen(s sesn.Sesn, hash []byte, upgrade bool, data []byte,
 	return chunklen, nil
 }
 
-func nextImageUploadReq(s sesn.Sesn, upgrade bool, data []byte, off int, imageNum int) (
-	*nmp.ImageUploadReq, error) {
+// Builds the request for the chunk at off.  The chunk carries as much data as
+// fits in the MTU, up to maxLen bytes if maxLen is nonzero.
+func nextImageUploadReq(s sesn.Sesn, upgrade bool, data []byte, off int,
+	imageNum int, maxLen int) (*nmp.ImageUploadReq, error) {
 	var hash []byte = nil
 
 	// Ensure we produce consistent requests while we calculate the chunk
@@ -173,10 +200,11 @@ func nextImageUploadReq(s sesn.Sesn, upgrade bool, data []byte, off int, imageNu
 		hash = sha[:]
 	}
 
-	seq := nmxutil.NextNmpSeq()
+	seq := sesn.NextSeq(s)
 
 	// Find chunk length
-	chunklen, err := findChunkLen(s, hash, upgrade, data, off, imageNum, seq)
+	chunklen, err := findChunkLen(s, hash, upgrade, data, off, imageNum, seq,
+		maxLen)
 	if err != nil {
 		return nil, err
 	}
@@ -185,7 +213,8 @@ func nextImageUploadReq(s sesn.Sesn, upgrade bool, data []byte, off int, imageNu
 	// fit we'll recalculate without hash
 	if off == 0 && chunklen < IMAGE_UPLOAD_MIN_1ST_CHUNK {
 		hash = nil
-		chunklen, err = findChunkLen(s, hash, upgrade, data, off, imageNum, seq)
+		chunklen, err = findChunkLen(s, hash, upgrade, data, off, imageNum, seq,
+			maxLen)
 		if err != nil {
 			return nil, err
 		}
@@ -305,13 +334,25 @@ func (t *ImageUploadIntTracker) HandleResponse(c *ImageUploadCmd, rsp nmp.NmpRsp
 
 func (t *ImageUploadIntTracker) HandleError(off int, err error) bool {
 	/*XXX: there could be an Unauthorize or EOF error  when the rate is too high
-	  due to a large window, we retry. example:
+	  due to a large window.  example:
 	  "failed to decrypt message: coap_sec_tunnel: decode GCM fail EOF"
-	  Since the error is sent with fmt.Errorf() API, with no code,
-	  the string may have to be parsed to know the particular error */
+	  Since the error is sent with fmt.Errorf() API, with no code, it is
+	  only retried if the retry policy includes sesn.RETRY_ON_OTHER. */
 	t.Mutex.Lock()
 	defer t.Mutex.Unlock()
 	log.Debugf("HandleError off %v error %v", off, err)
+
+	t.Fails[off]++
+	if t.Fails[off] >= t.TxOpts.Tries || !t.TxOpts.Retry.Retryable(err) {
+		if t.Err == nil {
+			t.Err = err
+		}
+	} else {
+		retryAt := time.Now().Add(t.TxOpts.Retry.Delay(t.Fails[off]))
+		if retryAt.After(t.RetryAt) {
+			t.RetryAt = retryAt
+		}
+	}
 	var wFull = false
 	if t.WCap == t.WCount {
 		wFull = true
@@ -336,76 +377,42 @@ func (t *ImageUploadIntTracker) HandleError(off int, err error) bool {
 
 func (c *ImageUploadCmd) Run(s sesn.Sesn) (Result, error) {
 	res := newImageUploadResult()
-	ch := make(chan int)
-	rspc := make(chan nmp.NmpRsp, c.MaxWinSz)
-	errc := make(chan error, c.MaxWinSz)
-
-	t := ImageUploadIntTracker{
-		TuneWS:   true,
-		WCount:   0,
-		WCap:     IMAGE_UPLOAD_START_WS,
-		Off:      c.StartOff,
-		RspMap:   make(map[int]int),
-		MaxRxOff: 0,
-	}
-
-	for int(atomic.LoadInt32(&t.MaxRxOff)) < len(c.Data) {
-		// Block if window is full
-		if !t.CheckWindow() {
-			ch <- 1
-		}
-
-		t.ProcessMissedChunks(c.TxOptions().Timeout)
 
-		if t.Off == len(c.Data) {
-			continue
-		}
-
-		t.Mutex.Lock()
-		r, err := nextImageUploadReq(s, c.Upgrade, c.Data, t.Off, c.ImageNum)
-		if err != nil {
-			t.Mutex.Unlock()
-			return nil, err
-		}
-
-		t.Off = (int(r.Off) + len(r.Data))
-
-		// Use up a chunk in window
-		t.WCount += 1
-		err = txReqAsync(s, r.Msg(), &c.CmdBase, rspc, errc)
-		if err != nil {
-			log.Debugf("err txReqAsync %v", err)
-			t.Mutex.Unlock()
-			break
-		}
-		// Mark the expected offset in successful tx of this chunk. i.e off + len
-		t.UpdateTracker(int(r.Off)+len(r.Data), IMAGE_UPLOAD_STATUS_EXPECTED)
-		t.Mutex.Unlock()
-
-		go func(off int) {
-			select {
-			case err := <-errc:
-				sig := t.HandleError(off, err)
-				if sig {
-					<-ch
-				}
-				return
-			case rsp := <-rspc:
-				sig := t.HandleResponse(c, rsp, res)
-				if sig {
-					<-ch
-				}
-				return
+	p := &pipeline{
+		c:        &c.CmdBase,
+		s:        s,
+		write:    true,
+		start:    c.StartOff,
+		total:    len(c.Data),
+		maxWinSz: c.MaxWinSz,
+
+		build: func(off int, maxLen int) (*nmp.NmpMsg, int, error) {
+			r, err := nextImageUploadReq(s, c.Upgrade, c.Data, off,
+				c.ImageNum, maxLen)
+			if err != nil {
+				return nil, 0, err
+			}
+			return r.Msg(), off + len(r.Data), nil
+		},
+		parse: func(off int, rsp nmp.NmpRsp) pipeAck {
+			irsp := rsp.(*nmp.ImageUploadRsp)
+			return pipeAck{end: int(irsp.Off), fail: irsp.Rc != 0}
+		},
+		deliver: func(rsp nmp.NmpRsp) {
+			irsp := rsp.(*nmp.ImageUploadRsp)
+			res.Rsps = append(res.Rsps, irsp)
+			if c.ProgressCb != nil {
+				c.ProgressCb(c, irsp)
 			}
-		}(int(r.Off))
+		},
 	}
 
-	if int(t.MaxRxOff) == len(c.Data) {
-		return res, nil
-	} else {
-		return nil, fmt.Errorf("ImageUpload unexpected error after %d/%d bytes",
-			t.MaxRxOff, len(c.Data))
+	if err := p.run(); err != nil {
+		return nil, err
 	}
+	res.Stats = p.stats()
+
+	return res, nil
 }
 
 //////////////////////////////////////////////////////////////////////////////
@@ -425,6 +432,12 @@ func (c *ImageUploadCmd) Run(s sesn.Sesn) (Result, error) {
 //    to step 5.
 // 5. Execute the upload command.  If the connection drops before the final
 //    part is uploaded, reconnect and retry the previous part.
+//
+// If the command has a transfer state store and it holds a record of an
+// unfinished upload of the same image to the same device, the erase is
+// skipped and the upload resumes where the device left off.  The device
+// decides whether its partial data can be kept: it only continues an upload
+// whose length and hash match those of the image.
 
 type ImageUpgradeCmd struct {
 	CmdBase
@@ -436,11 +449,20 @@ type ImageUpgradeCmd struct {
 	ProgressBar *pb.ProgressBar
 	ImageNum    int
 	MaxWinSz    int
+
+	// If non-nil, progress is recorded here so that an interrupted upload
+	// can be resumed.  DeviceId identifies the device in the records.
+	Resume   *XferStateStore
+	DeviceId string
 }
 
 type ImageUpgradeResult struct {
 	EraseRes  *ImageEraseResult
 	UploadRes *ImageUploadResult
+
+	// The offset an interrupted upload was resumed from; 0 if the upload
+	// started from the beginning.
+	ResumeOff int
 }
 
 func NewImageUpgradeCmd() *ImageUpgradeCmd {
@@ -448,6 +470,7 @@ func NewImageUpgradeCmd() *ImageUpgradeCmd {
 		CmdBase:  NewCmdBase(),
 		NoErase:  false,
 		ImageNum: 0,
+		MaxWinSz: IMAGE_UPLOAD_DEF_MAX_WS,
 	}
 }
 
@@ -467,7 +490,16 @@ func (r *ImageUpgradeResult) Status() int {
 
 // Attempts to recover from a disconnect.
 func (c *ImageUpgradeCmd) rescue(s sesn.Sesn, err error) error {
-	if err != nil {
+	// Don't attempt recovery if the command was cancelled.
+	if cerr := c.Context().Err(); cerr != nil {
+		return cerr
+	}
+
+	// Only a dropped connection is recovered from, and only if the retry
+	// policy permits it.
+	opt := c.TxOptions()
+	retryOn := opt.RetryPolicy().RetryOn
+	if err != nil && retryOn&sesn.RETRY_ON_DISCONNECT != 0 {
 		if !s.IsOpen() {
 			if err := s.Open(); err == nil {
 				return nil
@@ -481,6 +513,7 @@ func (c *ImageUpgradeCmd) rescue(s sesn.Sesn, err error) error {
 func (c *ImageUpgradeCmd) runErase(s sesn.Sesn) (*ImageEraseResult, error) {
 	cmd := NewImageEraseCmd()
 	cmd.SetTxOptions(c.TxOptions())
+	cmd.SetContext(c.Context())
 	res, err := cmd.Run(s)
 
 	if err := c.rescue(s, err); err != nil {
@@ -496,20 +529,46 @@ func (c *ImageUpgradeCmd) runErase(s sesn.Sesn) (*ImageEraseResult, error) {
 	return res.(*ImageEraseResult), nil
 }
 
-func (c *ImageUpgradeCmd) runUpload(s sesn.Sesn) (*ImageUploadResult, error) {
-	startOff := 0
+// Asks the device how much of an interrupted upload of the image it holds.
+// The first chunk is sent along with the image's length and hash, as at the
+// start of any upload.  A device with a partial upload of the same image
+// answers with the offset it expects next; otherwise it starts a new upload.
+func (c *ImageUpgradeCmd) queryResume(s sesn.Sesn) (
+	*nmp.ImageUploadReq, *nmp.ImageUploadRsp, error) {
+
+	req, err := nextImageUploadReq(s, c.Upgrade, c.Data, 0, c.ImageNum, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rsp, err := txReq(s, req.Msg(), &c.CmdBase)
+	if err != nil {
+		return nil, nil, err
+	}
+	irsp := rsp.(*nmp.ImageUploadRsp)
+
+	if irsp.Rc == 0 && int(irsp.Off) > len(c.Data) {
+		return nil, nil, fmt.Errorf("Device reports invalid upload offset "+
+			"%d; image length %d", irsp.Off, len(c.Data))
+	}
+
+	return req, irsp, nil
+}
+
+func (c *ImageUpgradeCmd) runUpload(s sesn.Sesn, xs *XferState,
+	startOff int) (*ImageUploadResult, error) {
+
 	progressCb := func(uc *ImageUploadCmd, r *nmp.ImageUploadRsp) {
 		if r.Rc == 0 {
 			startOff = int(r.Off)
+			xs.update(startOff)
 		}
 		c.ProgressCb(uc, r)
 	}
 
 	for {
-		var opt = sesn.TxOptions{
-			Timeout: 3 * time.Second,
-			Tries:   1,
-		}
+		var opt = c.TxOptions()
+		opt.Timeout = 3 * time.Second
 		if nmutil.Timeout < float64(opt.Timeout / time.Second) {
 			opt.Timeout = time.Duration(nmutil.Timeout * float64(time.Second))
 		}
@@ -520,6 +579,7 @@ func (c *ImageUpgradeCmd) runUpload(s sesn.Sesn) (*ImageUploadResult, error) {
 		cmd.ProgressCb = progressCb
 		cmd.ImageNum = c.ImageNum
 		cmd.SetTxOptions(opt)
+		cmd.SetContext(c.Context())
 		cmd.MaxWinSz = c.MaxWinSz
 
 		res, err := cmd.Run(s)
@@ -540,7 +600,39 @@ func (c *ImageUpgradeCmd) Run(s sesn.Sesn) (Result, error) {
 	var eres *ImageEraseResult = nil
 	var err error
 
-	if c.NoErase == false {
+	upgradeRes := newImageUpgradeResult()
+
+	xs, resume := openXferState(c.Resume, c.DeviceId, XFER_KIND_IMAGE,
+		strconv.Itoa(c.ImageNum), c.Data)
+
+	startOff := 0
+	if resume {
+		req, rsp, err := c.queryResume(s)
+		if err != nil {
+			return nil, err
+		}
+
+		if rsp.Rc != 0 || int(rsp.Off) == len(c.Data) {
+			// Failed, or the device already has the whole image.
+			upgradeRes.UploadRes = newImageUploadResult()
+			upgradeRes.UploadRes.Rsps = []*nmp.ImageUploadRsp{rsp}
+			if rsp.Rc == 0 {
+				upgradeRes.ResumeOff = int(rsp.Off)
+				xs.remove()
+			}
+			return upgradeRes, nil
+		}
+
+		startOff = int(rsp.Off)
+		if startOff > len(req.Data) {
+			upgradeRes.ResumeOff = startOff
+		} else {
+			log.Debugf("Device did not resume upload; starting over")
+		}
+	}
+
+	// Erasing the slot would discard the partial upload being resumed.
+	if c.NoErase == false && !resume {
 		eres, err = c.runErase(s)
 		if err != nil {
 			return nil, err
@@ -548,17 +640,431 @@ func (c *ImageUpgradeCmd) Run(s sesn.Sesn) (Result, error) {
 	} else {
 		eres = nil
 	}
-	ures, err := c.runUpload(s)
+	ures, err := c.runUpload(s, xs, startOff)
 	if err != nil {
 		return nil, err
 	}
+	if ures.Status() == 0 {
+		xs.remove()
+	}
 
-	upgradeRes := newImageUpgradeResult()
 	upgradeRes.EraseRes = eres
 	upgradeRes.UploadRes = ures
 	return upgradeRes, nil
 }
 
+//////////////////////////////////////////////////////////////////////////////
+// $deploy                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+// Image deploy installs an image and makes it permanent:
+// 1. Upload the image with the image upgrade command.
+// 2. Mark the image for test on the next reboot.
+// 3. Reset the device.
+// 4. Wait for the device to come back, reconnecting if necessary.
+// 5. Verify that the new image is active.
+// 6. Run the health check.
+// 7. Confirm the image.
+// If the device reverted to the previous image, or the health check fails,
+// the deploy is rolled back.  In the latter case the device is reset again;
+// as the new image is not confirmed, the boot loader reverts it.
+
+const IMAGE_DEPLOY_DEF_BOOT_TIMEOUT = 60 * time.Second
+const IMAGE_DEPLOY_DEF_POLL_INTERVAL = 1 * time.Second
+
+// Upper bound on the wait for a response to a single poll while the device
+// is rebooting; a device that is down would otherwise hold up each poll for
+// the full transaction timeout.
+const IMAGE_DEPLOY_POLL_TIMEOUT = 2 * time.Second
+
+type ImageDeployStage int
+
+const (
+	IMAGE_DEPLOY_UPLOAD ImageDeployStage = iota
+	IMAGE_DEPLOY_TEST
+	IMAGE_DEPLOY_RESET
+	IMAGE_DEPLOY_WAIT
+	IMAGE_DEPLOY_VERIFY
+	IMAGE_DEPLOY_HEALTH
+	IMAGE_DEPLOY_CONFIRM
+	IMAGE_DEPLOY_ROLLBACK
+)
+
+var imageDeployStageNames = map[ImageDeployStage]string{
+	IMAGE_DEPLOY_UPLOAD:   "upload",
+	IMAGE_DEPLOY_TEST:     "test",
+	IMAGE_DEPLOY_RESET:    "reset",
+	IMAGE_DEPLOY_WAIT:     "wait",
+	IMAGE_DEPLOY_VERIFY:   "verify",
+	IMAGE_DEPLOY_HEALTH:   "health",
+	IMAGE_DEPLOY_CONFIRM:  "confirm",
+	IMAGE_DEPLOY_ROLLBACK: "rollback",
+}
+
+func (s ImageDeployStage) String() string {
+	if name, ok := imageDeployStageNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("%d", int(s))
+}
+
+type ImageDeployStageFn func(c *ImageDeployCmd, stage ImageDeployStage)
+
+type ImageDeployCmd struct {
+	CmdBase
+	Data        []byte
+	ImageNum    int
+	NoErase     bool
+	Upgrade     bool
+	MaxWinSz    int
+	ProgressCb  ImageUploadProgressFn
+	ProgressBar *pb.ProgressBar
+	StageCb     ImageDeployStageFn
+
+	// How long to wait for the device to come back after a reset, and how
+	// often to try to reach it meanwhile.
+	BootTimeout  time.Duration
+	PollInterval time.Duration
+
+	// Checks that the new image works before it is confirmed.  If nil, the
+	// device must answer an echo request.
+	HealthCheck func(s sesn.Sesn) error
+
+	// Leave the new image unconfirmed; the next reset reverts it.
+	NoConfirm bool
+
+	// Passed to the image upgrade command so that an interrupted upload
+	// can be resumed.
+	Resume   *XferStateStore
+	DeviceId string
+}
+
+type ImageDeployResult struct {
+	// The hash of the deployed image.
+	Hash []byte
+
+	UpgradeRes *ImageUpgradeResult
+	TestRes    *ImageStateWriteResult
+	ConfirmRes *ImageStateWriteResult
+
+	// The image state reported by the device after the last reset.
+	StateRsp *nmp.ImageStateRsp
+
+	// The image was already running and confirmed; nothing was done.
+	AlreadyActive bool
+
+	// The device is not running the new image.
+	RolledBack     bool
+	RollbackReason string
+}
+
+func NewImageDeployCmd() *ImageDeployCmd {
+	return &ImageDeployCmd{
+		CmdBase:      NewCmdBase(),
+		MaxWinSz:     IMAGE_UPLOAD_DEF_MAX_WS,
+		BootTimeout:  IMAGE_DEPLOY_DEF_BOOT_TIMEOUT,
+		PollInterval: IMAGE_DEPLOY_DEF_POLL_INTERVAL,
+	}
+}
+
+func newImageDeployResult() *ImageDeployResult {
+	return &ImageDeployResult{}
+}
+
+func (r *ImageDeployResult) Status() int {
+	if r.UpgradeRes != nil && r.UpgradeRes.Status() != 0 {
+		return r.UpgradeRes.Status()
+	}
+	if r.TestRes != nil && r.TestRes.Status() != 0 {
+		return r.TestRes.Status()
+	}
+	if r.StateRsp != nil && r.StateRsp.Rc != 0 {
+		return r.StateRsp.Rc
+	}
+	if r.ConfirmRes != nil && r.ConfirmRes.Status() != 0 {
+		return r.ConfirmRes.Status()
+	}
+	return 0
+}
+
+func (c *ImageDeployCmd) stage(stage ImageDeployStage) {
+	log.Debugf("Image deploy: %s", stage.String())
+	if c.StageCb != nil {
+		c.StageCb(c, stage)
+	}
+}
+
+// Finds the state entry of the image with the specified hash.
+func (c *ImageDeployCmd) findImage(rsp *nmp.ImageStateRsp,
+	hash []byte) *nmp.ImageStateEntry {
+
+	for i, e := range rsp.Images {
+		if e.Image == c.ImageNum && bytes.Equal(e.Hash, hash) {
+			return &rsp.Images[i]
+		}
+	}
+
+	return nil
+}
+
+func (c *ImageDeployCmd) runState(s sesn.Sesn, hash []byte, confirm bool) (
+	*ImageStateWriteResult, error) {
+
+	cmd := NewImageStateWriteCmd()
+	cmd.SetTxOptions(c.TxOptions())
+	cmd.SetContext(c.Context())
+	cmd.Hash = hash
+	cmd.Confirm = confirm
+
+	res, err := cmd.Run(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.(*ImageStateWriteResult), nil
+}
+
+// Resets the device.  The device may go down before its response arrives,
+// so a missing response is not an error.
+func (c *ImageDeployCmd) reset(s sesn.Sesn) error {
+	opt := c.TxOptions()
+	opt.Tries = 1
+
+	cmd := NewResetCmd()
+	cmd.SetTxOptions(opt)
+	cmd.SetContext(c.Context())
+
+	if _, err := cmd.Run(s); err != nil {
+		if cerr := c.Context().Err(); cerr != nil {
+			return cerr
+		}
+		log.Debugf("Image deploy: no reset response: %s", err.Error())
+	}
+
+	return nil
+}
+
+// Waits for the device to come back after a reset.  The device is polled
+// with image state read requests until it answers with a state that
+// satisfies booted, reconnecting as necessary.  booted distinguishes the
+// rebooted device from one that has not gone down yet.
+func (c *ImageDeployCmd) awaitBoot(s sesn.Sesn,
+	booted func(rsp *nmp.ImageStateRsp) bool) (*nmp.ImageStateRsp, error) {
+
+	ctx := c.Context()
+	deadline := time.Now().Add(c.BootTimeout)
+
+	var lastErr error
+	for {
+		if !s.IsOpen() {
+			lastErr = s.Open()
+		}
+
+		if s.IsOpen() {
+			opt := c.TxOptions()
+			opt.Tries = 1
+			if opt.Timeout == 0 || opt.Timeout > IMAGE_DEPLOY_POLL_TIMEOUT {
+				opt.Timeout = IMAGE_DEPLOY_POLL_TIMEOUT
+			}
+			if left := time.Until(deadline); left > 0 && left < opt.Timeout {
+				opt.Timeout = left
+			}
+
+			cmd := NewImageStateReadCmd()
+			cmd.SetTxOptions(opt)
+			cmd.SetContext(ctx)
+
+			res, err := cmd.Run(s)
+			if err == nil {
+				rsp := res.(*ImageStateReadResult).Rsp
+				if rsp.Rc != 0 || booted(rsp) {
+					return rsp, nil
+				}
+			}
+			lastErr = err
+		}
+
+		if cerr := ctx.Err(); cerr != nil {
+			return nil, cerr
+		}
+		if !time.Now().Before(deadline) {
+			msg := fmt.Sprintf("Device did not come back within %s after "+
+				"reset", c.BootTimeout)
+			if lastErr != nil {
+				msg += "; " + lastErr.Error()
+			}
+			return nil, fmt.Errorf("%s", msg)
+		}
+
+		select {
+		case <-time.After(c.PollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *ImageDeployCmd) healthCheck(s sesn.Sesn) error {
+	if c.HealthCheck != nil {
+		return c.HealthCheck(s)
+	}
+
+	cmd := NewEchoCmd()
+	cmd.SetTxOptions(c.TxOptions())
+	cmd.SetContext(c.Context())
+	cmd.Payload = "deploy"
+
+	res, err := cmd.Run(s)
+	if err != nil {
+		return err
+	}
+
+	return StatusError(res)
+}
+
+// Resets the device so that the boot loader reverts the unconfirmed image.
+func (c *ImageDeployCmd) rollback(s sesn.Sesn, res *ImageDeployResult,
+	reason string) (*ImageDeployResult, error) {
+
+	res.RolledBack = true
+	res.RollbackReason = reason
+
+	c.stage(IMAGE_DEPLOY_ROLLBACK)
+	if err := c.reset(s); err != nil {
+		return nil, err
+	}
+
+	rsp, err := c.awaitBoot(s, func(rsp *nmp.ImageStateRsp) bool {
+		e := c.findImage(rsp, res.Hash)
+		return e == nil || !e.Active
+	})
+	if err != nil {
+		return nil, err
+	}
+	res.StateRsp = rsp
+
+	return res, nil
+}
+
+func (c *ImageDeployCmd) Run(s sesn.Sesn) (Result, error) {
+	res := newImageDeployResult()
+
+	img, err := nmimage.Parse(c.Data)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot deploy image: %s", err.Error())
+	}
+	res.Hash = img.Hash()
+	if res.Hash == nil {
+		return nil, fmt.Errorf("Cannot deploy image: image has no hash")
+	}
+
+	// Nothing to do if the image is already running permanently.
+	scmd := NewImageStateReadCmd()
+	scmd.SetTxOptions(c.TxOptions())
+	scmd.SetContext(c.Context())
+	sres, err := scmd.Run(s)
+	if err != nil {
+		return nil, err
+	}
+	if rsp := sres.(*ImageStateReadResult).Rsp; rsp.Rc == 0 {
+		if e := c.findImage(rsp, res.Hash); e != nil && e.Active &&
+			e.Confirmed {
+
+			res.AlreadyActive = true
+			res.StateRsp = rsp
+			return res, nil
+		}
+	}
+
+	c.stage(IMAGE_DEPLOY_UPLOAD)
+	ucmd := NewImageUpgradeCmd()
+	ucmd.SetTxOptions(c.TxOptions())
+	ucmd.SetContext(c.Context())
+	ucmd.Data = c.Data
+	ucmd.ImageNum = c.ImageNum
+	ucmd.NoErase = c.NoErase
+	ucmd.Upgrade = c.Upgrade
+	ucmd.MaxWinSz = c.MaxWinSz
+	ucmd.ProgressBar = c.ProgressBar
+	ucmd.Resume = c.Resume
+	ucmd.DeviceId = c.DeviceId
+	ucmd.ProgressCb = func(uc *ImageUploadCmd, rsp *nmp.ImageUploadRsp) {
+		if c.ProgressCb != nil {
+			c.ProgressCb(uc, rsp)
+		}
+	}
+
+	ures, err := ucmd.Run(s)
+	if err != nil {
+		return nil, err
+	}
+	res.UpgradeRes = ures.(*ImageUpgradeResult)
+	if res.UpgradeRes.Status() != 0 {
+		return res, nil
+	}
+
+	c.stage(IMAGE_DEPLOY_TEST)
+	res.TestRes, err = c.runState(s, res.Hash, false)
+	if err != nil {
+		return nil, err
+	}
+	if res.TestRes.Status() != 0 {
+		return res, nil
+	}
+
+	c.stage(IMAGE_DEPLOY_RESET)
+	if err := c.reset(s); err != nil {
+		return nil, err
+	}
+
+	// Until the device resets, it reports the new image as pending.
+	c.stage(IMAGE_DEPLOY_WAIT)
+	res.StateRsp, err = c.awaitBoot(s, func(rsp *nmp.ImageStateRsp) bool {
+		e := c.findImage(rsp, res.Hash)
+		return e == nil || !e.Pending
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.StateRsp.Rc != 0 {
+		return res, nil
+	}
+
+	c.stage(IMAGE_DEPLOY_VERIFY)
+	e := c.findImage(res.StateRsp, res.Hash)
+	if e == nil {
+		res.RolledBack = true
+		res.RollbackReason = "image no longer present after reset"
+		return res, nil
+	}
+	if !e.Active {
+		res.RolledBack = true
+		res.RollbackReason = "device reverted to the previous image"
+		return res, nil
+	}
+
+	c.stage(IMAGE_DEPLOY_HEALTH)
+	if err := c.healthCheck(s); err != nil {
+		if cerr := c.Context().Err(); cerr != nil {
+			return nil, cerr
+		}
+		return c.rollback(s, res, fmt.Sprintf("health check failed: %s",
+			err.Error()))
+	}
+
+	if c.NoConfirm {
+		return res, nil
+	}
+
+	c.stage(IMAGE_DEPLOY_CONFIRM)
+	res.ConfirmRes, err = c.runState(s, res.Hash, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
 //////////////////////////////////////////////////////////////////////////////
 // $state read                                                              //
 //////////////////////////////////////////////////////////////////////////////
@@ -585,6 +1091,10 @@ func (r *ImageStateReadResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *ImageStateReadResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *ImageStateReadCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewImageStateReadReq()
 
@@ -627,6 +1137,10 @@ func (r *ImageStateWriteResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *ImageStateWriteResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *ImageStateWriteCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewImageStateWriteReq()
 	r.Hash = c.Hash
@@ -669,6 +1183,10 @@ func (r *CoreListResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *CoreListResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *CoreListCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewCoreListReq()
 
@@ -709,6 +1227,10 @@ func (r *ImageEraseResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *ImageEraseResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *ImageEraseCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewImageEraseReq()
 
@@ -731,6 +1253,7 @@ type CoreLoadProgressFn func(c *CoreLoadCmd, r *nmp.CoreLoadRsp)
 type CoreLoadCmd struct {
 	CmdBase
 	ProgressCb CoreLoadProgressFn
+	MaxWinSz   int
 }
 
 type CoreLoadResult struct {
@@ -739,7 +1262,8 @@ type CoreLoadResult struct {
 
 func NewCoreLoadCmd() *CoreLoadCmd {
 	return &CoreLoadCmd{
-		CmdBase: NewCmdBase(),
+		CmdBase:  NewCmdBase(),
+		MaxWinSz: PIPELINE_DEF_MAX_WS,
 	}
 }
 
@@ -754,33 +1278,36 @@ func (r *CoreLoadResult) Status() int {
 
 func (c *CoreLoadCmd) Run(s sesn.Sesn) (Result, error) {
 	res := newCoreLoadResult()
-	off := 0
-
-	for {
-		r := nmp.NewCoreLoadReq()
-		r.Off = uint32(off)
 
-		rsp, err := txReq(s, r.Msg(), &c.CmdBase)
-		if err != nil {
-			return nil, err
-		}
-		irsp := rsp.(*nmp.CoreLoadRsp)
-
-		if c.ProgressCb != nil {
-			c.ProgressCb(c, irsp)
-		}
-
-		res.Rsps = append(res.Rsps, irsp)
-		if irsp.Rc != 0 {
-			break
-		}
-
-		if len(irsp.Data) == 0 {
-			// Download complete.
-			break
-		}
+	p := &pipeline{
+		c:        &c.CmdBase,
+		s:        s,
+		maxWinSz: c.MaxWinSz,
+
+		build: func(off int, _ int) (*nmp.NmpMsg, int, error) {
+			r := nmp.NewCoreLoadReq()
+			r.Off = uint32(off)
+			return r.Msg(), off, nil
+		},
+		parse: func(off int, rsp nmp.NmpRsp) pipeAck {
+			irsp := rsp.(*nmp.CoreLoadRsp)
+			return byteReadAck(off, irsp.Rc, irsp.Len, len(irsp.Data))
+		},
+		trim: func(rsp nmp.NmpRsp, off int, end int) {
+			irsp := rsp.(*nmp.CoreLoadRsp)
+			irsp.Data = irsp.Data[:end-off]
+		},
+		deliver: func(rsp nmp.NmpRsp) {
+			irsp := rsp.(*nmp.CoreLoadRsp)
+			if c.ProgressCb != nil {
+				c.ProgressCb(c, irsp)
+			}
+			res.Rsps = append(res.Rsps, irsp)
+		},
+	}
 
-		off = int(irsp.Off) + len(irsp.Data)
+	if err := p.run(); err != nil {
+		return nil, err
 	}
 
 	return res, nil
@@ -812,6 +1339,10 @@ func (r *CoreEraseResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *CoreEraseResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *CoreEraseCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewCoreEraseReq()
 
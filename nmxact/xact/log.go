@@ -20,10 +20,104 @@
 package xact
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"regexp"
+	"time"
+
 	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
 	"github.com/recogni/newtmgr/nmxact/sesn"
 )
 
+//////////////////////////////////////////////////////////////////////////////
+// $filter                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+// LogFilter selects log entries on the client side.  The device has no
+// notion of these criteria, so entries are still transferred in full and
+// then discarded.  Zero-valued fields match every entry.
+type LogFilter struct {
+	// If non-empty, only entries from one of these modules match.
+	Modules []uint8
+
+	// Entries with a lower level don't match.
+	MinLevel uint8
+
+	// If nonzero, entries with an earlier or later timestamp, respectively,
+	// don't match.  Timestamps are in microseconds.
+	MinTimestamp int64
+	MaxTimestamp int64
+
+	// If non-nil, only entries whose message text matches this expression
+	// match.  The text of a string entry is the string itself; a CBOR entry
+	// is matched as JSON and any other entry as hex.
+	Msg *regexp.Regexp
+}
+
+// Returns the text that LogFilter.Msg is matched against.
+func logEntryText(e nmp.LogEntry) string {
+	switch e.Type {
+	case nmp.LOG_ENTRY_TYPE_STRING:
+		return string(e.Msg)
+
+	case nmp.LOG_ENTRY_TYPE_CBOR:
+		m, err := nmxutil.DecodeCborMap(e.Msg)
+		if err == nil {
+			if b, err := json.Marshal(nmxutil.CborToJson(m)); err == nil {
+				return string(b)
+			}
+		}
+	}
+
+	return hex.EncodeToString(e.Msg)
+}
+
+func (f *LogFilter) Match(e nmp.LogEntry) bool {
+	if len(f.Modules) > 0 {
+		found := false
+		for _, m := range f.Modules {
+			if m == e.Module {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if e.Level < f.MinLevel {
+		return false
+	}
+	if f.MinTimestamp != 0 && e.Timestamp < f.MinTimestamp {
+		return false
+	}
+	if f.MaxTimestamp != 0 && e.Timestamp > f.MaxTimestamp {
+		return false
+	}
+
+	if f.Msg != nil && !f.Msg.MatchString(logEntryText(e)) {
+		return false
+	}
+
+	return true
+}
+
+// Removes the entries that don't match from each log in rsp.  Logs left
+// without entries are retained.
+func (f *LogFilter) Apply(rsp *nmp.LogShowRsp) {
+	for i, l := range rsp.Logs {
+		entries := []nmp.LogEntry{}
+		for _, e := range l.Entries {
+			if f.Match(e) {
+				entries = append(entries, e)
+			}
+		}
+		rsp.Logs[i].Entries = entries
+	}
+}
+
 //////////////////////////////////////////////////////////////////////////////
 // $show                                                                    //
 //////////////////////////////////////////////////////////////////////////////
@@ -33,6 +127,9 @@ type LogShowCmd struct {
 	Name      string
 	Timestamp int64
 	Index     uint32
+
+	// If non-nil, entries that don't match are removed from the response.
+	Filter *LogFilter
 }
 
 func NewLogShowCmd() *LogShowCmd {
@@ -53,6 +150,10 @@ func (r *LogShowResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *LogShowResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *LogShowCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewLogShowReq()
 	r.Name = c.Name
@@ -64,6 +165,9 @@ func (c *LogShowCmd) Run(s sesn.Sesn) (Result, error) {
 		return nil, err
 	}
 	srsp := rsp.(*nmp.LogShowRsp)
+	if c.Filter != nil {
+		c.Filter.Apply(srsp)
+	}
 
 	res := newLogShowResult()
 	res.Rsp = srsp
@@ -80,11 +184,20 @@ type LogShowFullCmd struct {
 	Name       string
 	Index      uint32
 	ProgressCb LogShowFullProgressFn
+
+	// If non-nil, entries that don't match are removed from each response
+	// before it is delivered.  The whole log is still read.
+	Filter *LogFilter
+
+	// Only applies when a single log is read.  Entries of different logs
+	// are interleaved, so reading all logs is strictly sequential.
+	MaxWinSz int
 }
 
 func NewLogShowFullCmd() *LogShowFullCmd {
 	return &LogShowFullCmd{
-		CmdBase: NewCmdBase(),
+		CmdBase:  NewCmdBase(),
+		MaxWinSz: PIPELINE_DEF_MAX_WS,
 	}
 }
 
@@ -115,43 +228,329 @@ func (c *LogShowFullCmd) buildReq(idx uint32) *nmp.LogShowReq {
 func (c *LogShowFullCmd) Run(s sesn.Sesn) (Result, error) {
 	res := newLogShowFullResult()
 
-	idx := c.Index
-	for {
-		r := c.buildReq(idx)
+	p := &pipeline{
+		c:        &c.CmdBase,
+		s:        s,
+		start:    int(c.Index),
+		maxWinSz: c.MaxWinSz,
+
+		build: func(idx int, _ int) (*nmp.NmpMsg, int, error) {
+			return c.buildReq(uint32(idx)).Msg(), idx, nil
+		},
+		parse: func(idx int, rsp nmp.NmpRsp) pipeAck {
+			srsp := rsp.(*nmp.LogShowRsp)
+
+			// A status code of 1 means there logs to read.  For historical
+			// reasons, 1 doesn't map to an appropriate error code, so just
+			// hardcode it here.
+			a := pipeAck{
+				end:   idx,
+				eof:   srsp.Rc != 1,
+				total: -1,
+				fail:  srsp.Rc != 0 && srsp.Rc != 1,
+			}
+			for _, l := range srsp.Logs {
+				for _, e := range l.Entries {
+					if int(e.Index) >= a.end {
+						a.end = int(e.Index) + 1
+					}
+				}
+			}
+			return a
+		},
+		trim: func(rsp nmp.NmpRsp, idx int, end int) {
+			srsp := rsp.(*nmp.LogShowRsp)
+			for i, l := range srsp.Logs {
+				entries := []nmp.LogEntry{}
+				for _, e := range l.Entries {
+					if int(e.Index) < end {
+						entries = append(entries, e)
+					}
+				}
+				srsp.Logs[i].Entries = entries
+			}
+		},
+		deliver: func(rsp nmp.NmpRsp) {
+			srsp := rsp.(*nmp.LogShowRsp)
+			if c.Filter != nil {
+				c.Filter.Apply(srsp)
+			}
+			if c.ProgressCb != nil {
+				c.ProgressCb(c, srsp)
+			}
+			res.Rsps = append(res.Rsps, srsp)
+		},
+	}
 
-		rsp, err := txReq(s, r.Msg(), &c.CmdBase)
-		if err != nil {
-			return nil, err
+	if c.Name == "" {
+		p.maxWinSz = 1
+	}
+
+	if err := p.run(); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+// $follow                                                                  //
+//////////////////////////////////////////////////////////////////////////////
+
+const LOG_FOLLOW_DEF_INTERVAL = time.Second
+
+// The number of most recently shown entries that follow remembers.  After a
+// reset, the log is read again from the start; remembered entries that are
+// still on the device are not shown a second time.
+const LOG_FOLLOW_HISTORY_SZ = 8192
+
+type LogFollowProgressFn func(c *LogFollowCmd, r *nmp.LogShowRsp)
+type LogFollowResetFn func(c *LogFollowCmd, last uint32, next uint32)
+type LogFollowErrFn func(c *LogFollowCmd, err error)
+
+// LogFollowCmd reads a log from the specified index and then keeps polling it
+// for new entries, like `tail -f`.  It runs until its context is done or the
+// device reports an error.
+type LogFollowCmd struct {
+	CmdBase
+	Name     string
+	Index    uint32
+	MaxWinSz int
+
+	// Time between polls once all entries have been read.
+	Interval time.Duration
+
+	// If non-nil, only matching entries are passed to ProgressCb.
+	Filter *LogFilter
+
+	// Called with each response that carries new entries.
+	ProgressCb LogFollowProgressFn
+
+	// Called when the device's next log index falls below the index being
+	// followed, i.e., the log was cleared, wrapped, or lost in a reset.
+	// Following restarts at index 0, skipping entries that were already
+	// passed to ProgressCb.
+	ResetCb LogFollowResetFn
+
+	// Called when a poll fails with a transport error or timeout.  The
+	// session is reopened if necessary and polling continues.
+	ErrCb LogFollowErrFn
+}
+
+func NewLogFollowCmd() *LogFollowCmd {
+	return &LogFollowCmd{
+		CmdBase:  NewCmdBase(),
+		MaxWinSz: PIPELINE_DEF_MAX_WS,
+		Interval: LOG_FOLLOW_DEF_INTERVAL,
+	}
+}
+
+type LogFollowResult struct {
+	// The last response received.
+	Rsp *nmp.LogShowRsp
+
+	// The index from which following would continue.
+	NextIndex uint32
+}
+
+func newLogFollowResult() *LogFollowResult {
+	return &LogFollowResult{}
+}
+
+func (r *LogFollowResult) Status() int {
+	if r.Rsp == nil {
+		return 0
+	}
+	return r.Rsp.Rc
+}
+
+func (r *LogFollowResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
+// Indicates whether a poll that failed with err can be retried.
+func logFollowTransient(err error) bool {
+	return nmxutil.IsRspTimeout(err) ||
+		nmxutil.IsXport(err) ||
+		nmxutil.IsBleSesnDisconnect(err) ||
+		nmxutil.IsSesnClosed(err)
+}
+
+// Identifies a log entry that has been shown.  An entry that survives a
+// reset keeps its index and timestamp; an entry written after the reset may
+// reuse an index but not, in general, the timestamp as well.
+type logFollowKey struct {
+	log   string
+	index uint32
+	ts    int64
+}
+
+// Remembers the entries that follow has passed on, up to
+// LOG_FOLLOW_HISTORY_SZ of the most recent ones.
+type logFollowHistory struct {
+	shown map[logFollowKey]struct{}
+	order []logFollowKey
+}
+
+func newLogFollowHistory() *logFollowHistory {
+	return &logFollowHistory{
+		shown: map[logFollowKey]struct{}{},
+	}
+}
+
+// Removes the entries that were already shown from the response, and
+// records the remaining ones.
+func (h *logFollowHistory) dedup(rsp *nmp.LogShowRsp) {
+	for i, l := range rsp.Logs {
+		entries := []nmp.LogEntry{}
+		for _, e := range l.Entries {
+			key := logFollowKey{l.Name, e.Index, e.Timestamp}
+			if _, ok := h.shown[key]; ok {
+				continue
+			}
+
+			h.shown[key] = struct{}{}
+			h.order = append(h.order, key)
+			entries = append(entries, e)
 		}
-		srsp := rsp.(*nmp.LogShowRsp)
+		rsp.Logs[i].Entries = entries
+	}
 
-		if c.ProgressCb != nil {
-			c.ProgressCb(c, srsp)
+	if over := len(h.order) - LOG_FOLLOW_HISTORY_SZ; over > 0 {
+		for _, key := range h.order[:over] {
+			delete(h.shown, key)
 		}
+		h.order = append([]logFollowKey{}, h.order[over:]...)
+	}
+}
 
-		res.Rsps = append(res.Rsps, srsp)
+// Reads all entries from index next onwards.  It returns the last response
+// and the index following the newest entry read.
+func (c *LogFollowCmd) poll(s sesn.Sesn, next uint32,
+	hist *logFollowHistory) (*LogShowFullResult, uint32, error) {
+
+	fc := NewLogShowFullCmd()
+	fc.SetTxOptions(c.TxOptions())
+	fc.SetContext(c.Context())
+	fc.Name = c.Name
+	fc.Index = next
+	fc.MaxWinSz = c.MaxWinSz
+	fc.ProgressCb = func(_ *LogShowFullCmd, rsp *nmp.LogShowRsp) {
+		for _, l := range rsp.Logs {
+			for _, e := range l.Entries {
+				if e.Index >= next {
+					next = e.Index + 1
+				}
+			}
+		}
 
-		// A status code of 1 means there logs to read.  For historical
-		// reasons, 1 doesn't map to an appropriate error code, so just
-		// hardcode it here.
-		if srsp.Rc != 1 {
-			break
+		// Filter only after advancing past the entries that were read.
+		hist.dedup(rsp)
+		if c.Filter != nil {
+			c.Filter.Apply(rsp)
 		}
 
-		if len(srsp.Logs) == 0 {
-			break
+		found := false
+		for _, l := range rsp.Logs {
+			if len(l.Entries) > 0 {
+				found = true
+			}
 		}
-		lastLog := srsp.Logs[len(srsp.Logs)-1]
 
-		if len(lastLog.Entries) == 0 {
-			break
+		if found && c.ProgressCb != nil {
+			c.ProgressCb(c, rsp)
 		}
-		lastEntry := lastLog.Entries[len(lastLog.Entries)-1]
+	}
 
-		idx = lastEntry.Index + 1
+	res, err := fc.Run(s)
+	if err != nil {
+		return nil, next, err
 	}
 
-	return res, nil
+	return res.(*LogShowFullResult), next, nil
+}
+
+func (c *LogFollowCmd) wait() error {
+	ctx := c.Context()
+
+	select {
+	case <-time.After(c.Interval):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *LogFollowCmd) Run(s sesn.Sesn) (Result, error) {
+	ctx := c.Context()
+	res := newLogFollowResult()
+
+	next := c.Index
+	hist := newLogFollowHistory()
+
+	// Devices that don't report a next index always report 0; only treat a
+	// low next index as a reset if the device has reported one before.
+	haveNextIdx := false
+
+	for {
+		if c.abortErr != nil {
+			return nil, c.abortErr
+		}
+
+		err := ctx.Err()
+		if err == nil && !s.IsOpen() {
+			// The device may be resetting or re-enumerating; keep trying.
+			if err = s.Open(); err != nil && c.ErrCb != nil {
+				c.ErrCb(c, err)
+			}
+		} else if err == nil {
+			var fres *LogShowFullResult
+			var n uint32
+
+			fres, n, err = c.poll(s, next, hist)
+			if err == nil {
+				res.Rsp = fres.Rsps[len(fres.Rsps)-1]
+				if res.Status() != 0 {
+					return res, nil
+				}
+
+				if res.Rsp.NextIndex != 0 {
+					haveNextIdx = true
+				}
+
+				if n == next && haveNextIdx && res.Rsp.NextIndex < next {
+					if c.ResetCb != nil {
+						c.ResetCb(c, next, res.Rsp.NextIndex)
+					}
+					next = 0
+					res.NextIndex = next
+					continue
+				}
+
+				next = n
+				res.NextIndex = next
+			} else if ctx.Err() == nil {
+				if !logFollowTransient(err) {
+					return nil, err
+				}
+				if c.ErrCb != nil {
+					c.ErrCb(c, err)
+				}
+
+				// Reconnect unless the device merely failed to respond.
+				if !nmxutil.IsRspTimeout(err) && s.IsOpen() {
+					s.Close()
+				}
+			}
+		}
+
+		if cerr := ctx.Err(); cerr != nil {
+			return nil, cerr
+		}
+		if err := c.wait(); err != nil {
+			return nil, err
+		}
+	}
 }
 
 //////////////////////////////////////////////////////////////////////////////
@@ -180,6 +579,10 @@ func (r *LogListResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *LogListResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *LogListCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewLogListReq()
 
@@ -220,6 +623,10 @@ func (r *LogModuleListResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *LogModuleListResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *LogModuleListCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewLogModuleListReq()
 
@@ -260,6 +667,10 @@ func (r *LogLevelListResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *LogLevelListResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *LogLevelListCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewLogLevelListReq()
 
@@ -300,6 +711,10 @@ func (r *LogClearResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *LogClearResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *LogClearCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewLogClearReq()
 
@@ -0,0 +1,239 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package xact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// Log module and level names.
+//
+// nmp only knows the names of the standard log modules and levels.  A device
+// defines its own beyond those and reports all of them in its module and
+// level lists.  The names only change when the firmware does, so a
+// LogNameStore caches each device's lists, keyed by the hash of the image
+// that reported them.
+
+// LogNames maps the names of a device's log modules and levels to their
+// numbers, as reported by the device.
+type LogNames struct {
+	Modules map[string]int `json:"modules"`
+	Levels  map[string]int `json:"levels"`
+}
+
+// Returns the name that m gives to id, or "" if there is none.  If several
+// names map to id, the first in alphabetical order is returned.
+func logNameOf(m map[string]int, id int) string {
+	names := []string{}
+	for name, v := range m {
+		if v == id {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+
+	sort.Strings(names)
+	return names[0]
+}
+
+// Returns the device's name for a log module, or the name nmp gives it if the
+// device doesn't name it.  A nil LogNames only uses nmp's names.
+func (n *LogNames) ModuleString(id int) string {
+	if n != nil {
+		if name := logNameOf(n.Modules, id); name != "" {
+			return name
+		}
+	}
+	return nmp.LogModuleToString(id)
+}
+
+// Returns the device's name for a log level, or the name nmp gives it if the
+// device doesn't name it.  A nil LogNames only uses nmp's names.
+func (n *LogNames) LevelString(id int) string {
+	if n != nil {
+		if name := logNameOf(n.Levels, id); name != "" {
+			return name
+		}
+	}
+	return nmp.LogLevelToString(id)
+}
+
+// FetchLogNames retrieves a device's log module and level lists.
+func FetchLogNames(s sesn.Sesn, opt sesn.TxOptions) (*LogNames, error) {
+	mc := NewLogModuleListCmd()
+	mc.SetTxOptions(opt)
+
+	res, err := mc.Run(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := StatusError(res); err != nil {
+		return nil, err
+	}
+	mres := res.(*LogModuleListResult)
+
+	lc := NewLogLevelListCmd()
+	lc.SetTxOptions(opt)
+
+	res, err = lc.Run(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := StatusError(res); err != nil {
+		return nil, err
+	}
+	lres := res.(*LogLevelListResult)
+
+	names := &LogNames{
+		Modules: mres.Rsp.Map,
+		Levels:  lres.Rsp.Map,
+	}
+	if names.Modules == nil {
+		names.Modules = map[string]int{}
+	}
+	if names.Levels == nil {
+		names.Levels = map[string]int{}
+	}
+
+	return names, nil
+}
+
+type logNameImage struct {
+	LogNames
+	Updated time.Time `json:"updated"`
+}
+
+// The cached names of one device, keyed by image hash in hex.
+type logNameRecord struct {
+	Device string                   `json:"device"`
+	Images map[string]*logNameImage `json:"images"`
+}
+
+type LogNameStore struct {
+	Dir string
+}
+
+func NewLogNameStore(dir string) *LogNameStore {
+	return &LogNameStore{
+		Dir: dir,
+	}
+}
+
+func (st *LogNameStore) path(device string) string {
+	key := sha256.Sum256([]byte(device))
+	return filepath.Join(st.Dir, hex.EncodeToString(key[:16])+".json")
+}
+
+func (st *LogNameStore) read(device string) (*logNameRecord, error) {
+	rec := &logNameRecord{
+		Device: device,
+		Images: map[string]*logNameImage{},
+	}
+
+	path := st.path(device)
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rec, nil
+		}
+		return nil, err
+	}
+
+	prev := &logNameRecord{}
+	if err := json.Unmarshal(blob, prev); err != nil {
+		return nil, fmt.Errorf("error reading log names (%s): %s",
+			path, err.Error())
+	}
+
+	// Guard against a colliding key.
+	if prev.Device != device || prev.Images == nil {
+		return rec, nil
+	}
+
+	return prev, nil
+}
+
+// Load retrieves the names cached for a device's image.  The hash is in hex
+// and may be shortened; log entries only carry the first few bytes of the
+// image hash.  It returns nil if no names are cached for the image.
+func (st *LogNameStore) Load(device string, hash string) (*LogNames, error) {
+	if hash == "" {
+		return nil, nil
+	}
+
+	rec, err := st.read(device)
+	if err != nil {
+		return nil, err
+	}
+
+	for h, img := range rec.Images {
+		if strings.HasPrefix(h, hash) || strings.HasPrefix(hash, h) {
+			return &img.LogNames, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Save caches the names reported by a device's image.  The hash is in hex.
+func (st *LogNameStore) Save(device string, hash string,
+	names *LogNames) error {
+
+	rec, err := st.read(device)
+	if err != nil {
+		return err
+	}
+
+	rec.Images[hash] = &logNameImage{
+		LogNames: *names,
+		Updated:  time.Now(),
+	}
+
+	blob, err := json.MarshalIndent(rec, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	path := st.path(device)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so that a crash never leaves
+	// a truncated record behind.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
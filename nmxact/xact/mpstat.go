@@ -46,6 +46,10 @@ func (r *MempoolStatResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *MempoolStatResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *MempoolStatCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewMempoolStatReq()
 
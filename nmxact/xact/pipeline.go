@@ -0,0 +1,630 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package xact
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// Initial and default maximum number of requests that a chunked transfer
+// keeps in flight.
+const PIPELINE_START_WS = 1
+const PIPELINE_DEF_MAX_WS = 5
+
+// Minimum number of times a chunk is sent before the transfer is abandoned.
+// Lost chunks are routine on lossy links and cheap to resend, so they are not
+// limited by TxOptions.Tries, which is meant for single transactions and
+// defaults to 1.  A larger Tries raises the limit.
+const pipeChunkTries = 16
+
+// Number of times a write may go back to the same position, in addition to
+// the chunk tries, before the transfer is abandoned.
+const pipeMaxRewinds = 8
+
+// pipeAck describes the device's response to a single pipelined request.
+type pipeAck struct {
+	// For writes, the position that the device expects next.  For reads,
+	// the end of the range covered by the response; the range starts at the
+	// position of the request.
+	end int
+
+	// Reads only: nothing follows end.
+	eof bool
+
+	// Reads only: the size of the transfer, or -1 if unknown.
+	total int
+
+	// The response reports an error.  The transfer ends once the response
+	// is delivered.
+	fail bool
+}
+
+type pipeEvent struct {
+	id   int
+	pos  int
+	sent time.Time
+	rsp  nmp.NmpRsp
+	err  error
+}
+
+// pipeReq is an outstanding pipelined request.
+type pipeReq struct {
+	pos  int
+	seq  uint8
+	sent time.Time
+}
+
+// pipeline drives a chunked transfer over a sliding window of outstanding
+// requests.  A chunk is identified by its position: a byte offset or, for
+// logs, an entry index.
+//
+// Writes (uploads) use go-back-N.  The device acknowledges each chunk with
+// the position it expects next and rejects chunks that do not start there.
+// Whenever the chunk the device expects is not in flight, the transfer goes
+// back and resends from that position.  A rejection of a later chunk shows
+// that the expected one was lost, so the transfer need not wait for it to
+// time out.
+//
+// Reads (downloads) request positions speculatively, using the span of the
+// most recent response as the stride.  Responses are buffered and delivered
+// in order.  Gaps between responses are requested explicitly and overlapping
+// responses are trimmed.
+//
+// The window and, for writes, the chunk length are sized by a congestion
+// controller.  Failed requests are retried according to the command's
+// TxOptions' retry policy, except that lost chunks are always resent, up to
+// chunkTries() times.  All transfer state is owned by the goroutine calling
+// run().
+type pipeline struct {
+	c        *CmdBase
+	s        sesn.Sesn
+	write    bool
+	start    int
+	total    int // Writes only: the size of the transfer.
+	maxWinSz int
+
+	// Builds the request for the chunk at pos.  For writes, the chunk
+	// carries at most maxLen bytes of data (0 means no limit), and the
+	// position following the chunk is also returned.
+	build func(pos int, maxLen int) (*nmp.NmpMsg, int, error)
+
+	// Interprets the response to the request at pos.
+	parse func(pos int, rsp nmp.NmpRsp) pipeAck
+
+	// Reads only: truncates the response to the request at pos so that it
+	// ends at end.
+	trim func(rsp nmp.NmpRsp, pos int, end int)
+
+	// Passes a response to the command.
+	deliver func(rsp nmp.NmpRsp)
+
+	ctl       *congestionCtl
+	numOut    int
+	inflight  map[int]int
+	reqs      map[int]*pipeReq
+	nextId    int
+	abandoned map[int]bool
+	fails     map[int]int
+	holdUntil time.Time
+	reopen    bool
+	evc       chan pipeEvent
+	done      chan struct{}
+
+	// Statistics.
+	startTime   time.Time
+	progress    int
+	requests    int
+	losses      int
+	retransmits int
+	maxWin      int
+	sentPos     map[int]bool
+}
+
+func (p *pipeline) run() error {
+	p.ctl = newCongestionCtl(p.maxWinSz)
+	p.inflight = map[int]int{}
+	p.reqs = map[int]*pipeReq{}
+	p.abandoned = map[int]bool{}
+	p.fails = map[int]int{}
+	p.sentPos = map[int]bool{}
+	p.startTime = time.Now()
+	p.evc = make(chan pipeEvent)
+	p.done = make(chan struct{})
+
+	defer p.abort()
+
+	var err error
+	if p.write {
+		err = p.runWrite()
+	} else {
+		err = p.runRead()
+	}
+	if err != nil {
+		return err
+	}
+
+	stats := p.stats()
+	log.Debugf("Chunked transfer complete; %s", stats.String())
+	return nil
+}
+
+// Stops the response forwarders and removes the listeners of all
+// outstanding requests.
+func (p *pipeline) abort() {
+	close(p.done)
+	for _, r := range p.reqs {
+		p.s.AbortRx(r.seq)
+	}
+}
+
+// Summarises the transfer so far.
+func (p *pipeline) stats() XferStats {
+	return XferStats{
+		Bytes:       p.progress,
+		Elapsed:     time.Since(p.startTime),
+		Requests:    p.requests,
+		Losses:      p.losses,
+		Retransmits: p.retransmits,
+		MinRtt:      p.ctl.minRtt,
+		AvgRtt:      p.ctl.srtt,
+		MaxRtt:      p.ctl.maxRtt,
+		MaxWinSz:    p.maxWin,
+		ChunkLen:    p.ctl.chunkLen,
+	}
+}
+
+// Indicates whether another request can be sent now.  If a failed request
+// left the session disconnected, the session is reopened first.
+func (p *pipeline) canSend() (bool, error) {
+	if p.numOut >= p.ctl.window() || time.Now().Before(p.holdUntil) {
+		return false, nil
+	}
+
+	if p.reopen {
+		p.reopen = false
+		if !p.s.IsOpen() {
+			if err := p.s.Open(); err != nil {
+				return false, err
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// Sends the request for the chunk at pos.  For writes, returns the position
+// following the chunk.
+func (p *pipeline) send(pos int) (int, error) {
+	m, end, err := p.build(pos, p.ctl.chunkLen)
+	if err != nil {
+		return 0, err
+	}
+	if p.write {
+		p.ctl.onChunk(end - pos)
+	}
+
+	// Each request gets its own channels so that its listener can be
+	// aborted individually.
+	rspc := make(chan nmp.NmpRsp, 1)
+	errc := make(chan error, 1)
+	if err := txReqAsync(p.s, m, p.c, rspc, errc); err != nil {
+		return 0, err
+	}
+
+	id := p.nextId
+	p.nextId++
+	sent := time.Now()
+	p.reqs[id] = &pipeReq{pos: pos, seq: m.Hdr.Seq, sent: sent}
+	p.inflight[pos]++
+	p.numOut++
+
+	p.requests++
+	if p.sentPos[pos] {
+		p.retransmits++
+	}
+	p.sentPos[pos] = true
+	if p.numOut > p.maxWin {
+		p.maxWin = p.numOut
+	}
+
+	go func() {
+		ev := pipeEvent{id: id, pos: pos, sent: sent}
+		select {
+		case ev.rsp = <-rspc:
+		case ev.err = <-errc:
+		case <-p.done:
+			return
+		}
+
+		select {
+		case p.evc <- ev:
+		case <-p.done:
+		}
+	}()
+
+	return end, nil
+}
+
+// Forgets the outstanding request with the specified ID.
+func (p *pipeline) retire(id int) {
+	r := p.reqs[id]
+	delete(p.reqs, id)
+	p.numOut--
+	if p.inflight[r.pos]--; p.inflight[r.pos] <= 0 {
+		delete(p.inflight, r.pos)
+	}
+}
+
+// Abandons the outstanding requests for pos that were sent before the
+// specified time.  Their listeners are removed and any events they still
+// produce are discarded.  Returns the number of requests abandoned.
+func (p *pipeline) abandon(pos int, before time.Time) int {
+	n := 0
+	for id, r := range p.reqs {
+		if r.pos != pos || !r.sent.Before(before) {
+			continue
+		}
+
+		p.s.AbortRx(r.seq)
+		p.abandoned[id] = true
+		p.retire(id)
+
+		p.losses++
+		p.ctl.onLoss(r.sent)
+		n++
+	}
+
+	return n
+}
+
+// Waits for the next response or error.  Returns a nil event if a retry
+// backoff expired first.
+func (p *pipeline) wait(pos int) (*pipeEvent, error) {
+	var timer <-chan time.Time
+
+	d := time.Until(p.holdUntil)
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		timer = t.C
+	} else if p.numOut == 0 {
+		return nil, fmt.Errorf("Chunked transfer stalled at position %d", pos)
+	}
+
+	ctx := p.c.Context()
+	for {
+		select {
+		case ev := <-p.evc:
+			if p.abandoned[ev.id] {
+				// This is the error caused by aborting the request.
+				delete(p.abandoned, ev.id)
+				continue
+			}
+
+			p.retire(ev.id)
+			if ev.rsp != nil {
+				p.ctl.onRtt(time.Since(ev.sent))
+			}
+			return &ev, nil
+
+		case <-timer:
+			return nil, nil
+
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Returns the number of times a chunk may be sent.
+func (p *pipeline) chunkTries() int {
+	if tries := p.c.TxOptions().Tries; tries > pipeChunkTries {
+		return tries
+	}
+	return pipeChunkTries
+}
+
+// Indicates whether a chunk that failed with err may be resent.  A chunk that
+// timed out was lost and is always resent; other failures are retried
+// according to the retry policy.
+func pipeRetryable(policy *sesn.RetryPolicy, err error) bool {
+	return nmxutil.IsRspTimeout(err) || policy.Retryable(err)
+}
+
+// Records the failure of the request for pos sent at the specified time.
+// Returns a non-nil error if the request may not be retried.
+func (p *pipeline) fail(pos int, sent time.Time, err error) error {
+	opt := p.c.TxOptions()
+	policy := opt.RetryPolicy()
+	tries := p.chunkTries()
+
+	p.losses++
+	p.ctl.onLoss(sent)
+
+	p.fails[pos]++
+	if p.fails[pos] >= tries || !pipeRetryable(&policy, err) {
+		return err
+	}
+
+	d := policy.Delay(p.fails[pos])
+	log.Debugf("Chunk at position %d failed (%s); attempt %d of %d, "+
+		"retrying in %s", pos, err.Error(), p.fails[pos], tries, d)
+
+	if t := time.Now().Add(d); t.After(p.holdUntil) {
+		p.holdUntil = t
+	}
+	if nmxutil.IsBleSesnDisconnect(err) {
+		p.reopen = true
+	}
+
+	return nil
+}
+
+func (p *pipeline) runWrite() error {
+	acked := p.start
+	next := p.start
+	rewinds := map[int]int{}
+
+	for acked < p.total {
+		for next < p.total {
+			ok, err := p.canSend()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+
+			next, err = p.send(next)
+			if err != nil {
+				return err
+			}
+		}
+
+		ev, err := p.wait(acked)
+		if err != nil {
+			return err
+		}
+		if ev == nil {
+			continue
+		}
+
+		if ev.err != nil {
+			// Failures of chunks the device already has don't matter.
+			if ev.pos >= acked {
+				if err := p.fail(ev.pos, ev.sent, ev.err); err != nil {
+					return err
+				}
+			}
+		} else {
+			a := p.parse(ev.pos, ev.rsp)
+			p.deliver(ev.rsp)
+			if a.fail {
+				return nil
+			}
+
+			if a.end > acked {
+				acked = a.end
+				p.progress = acked - p.start
+				p.ctl.onAck()
+			}
+			if a.end > next {
+				// The device already has more than we sent (e.g., a
+				// resumed upload).
+				next = a.end
+			}
+
+			// The device rejected this chunk because it never received
+			// the one it expects.  Copies of that chunk sent before this
+			// one were lost; don't wait for them to time out.
+			if ev.pos > a.end && p.abandon(a.end, ev.sent) > 0 {
+				log.Debugf("Chunk at position %d lost", a.end)
+			}
+		}
+
+		// If the chunk that the device expects is not on its way, go back
+		// and resend from there.
+		if acked < next && p.inflight[acked] == 0 {
+			rewinds[acked]++
+			if rewinds[acked] > p.chunkTries()+pipeMaxRewinds {
+				return fmt.Errorf("Device repeatedly rejected chunk at "+
+					"position %d", acked)
+			}
+
+			log.Debugf("Resending chunks from position %d", acked)
+			next = acked
+		}
+	}
+
+	return nil
+}
+
+func (p *pipeline) runRead() error {
+	type chunk struct {
+		ack pipeAck
+		rsp nmp.NmpRsp
+	}
+
+	limit := -1
+	stride := 0
+	next := p.start
+	deliverPos := p.start
+
+	// Positions that have been requested or are to be re-requested, but
+	// have not been delivered yet.
+	pending := map[int]bool{}
+	holes := []int{}
+	ready := map[int]*chunk{}
+
+	beyond := func(pos int) bool {
+		return limit >= 0 && pos >= limit
+	}
+
+	// Returns the first pending position after pos, or -1 if there is none.
+	after := func(pos int) int {
+		n := -1
+		for q, _ := range pending {
+			if q > pos && (n < 0 || q < n) {
+				n = q
+			}
+		}
+		return n
+	}
+
+	addHole := func(pos int) {
+		pending[pos] = true
+		holes = append(holes, pos)
+		sort.Ints(holes)
+	}
+
+	for {
+		// Deliver buffered responses in order.
+		for ready[deliverPos] != nil {
+			ch := ready[deliverPos]
+			delete(ready, deliverPos)
+			delete(pending, deliverPos)
+
+			if ch.ack.fail {
+				p.deliver(ch.rsp)
+				return nil
+			}
+
+			end := ch.ack.end
+			if n := after(deliverPos); n >= 0 && end > n {
+				p.trim(ch.rsp, deliverPos, n)
+				end = n
+			}
+			p.deliver(ch.rsp)
+
+			if end <= deliverPos {
+				return nil
+			}
+			deliverPos = end
+			p.progress = deliverPos - p.start
+		}
+		if beyond(deliverPos) {
+			return nil
+		}
+
+		for {
+			ok, err := p.canSend()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+
+			var pos int
+			if len(holes) > 0 {
+				pos = holes[0]
+				holes = holes[1:]
+				if beyond(pos) {
+					delete(pending, pos)
+					continue
+				}
+			} else if stride > 0 && !beyond(next) {
+				pos = next
+				next += stride
+			} else if stride == 0 && len(pending) == 0 {
+				// Nothing is known about the transfer yet; probe with the
+				// first request.
+				pos = next
+			} else {
+				break
+			}
+
+			if _, err := p.send(pos); err != nil {
+				return err
+			}
+			pending[pos] = true
+		}
+
+		ev, err := p.wait(deliverPos)
+		if err != nil {
+			return err
+		}
+		if ev == nil {
+			continue
+		}
+
+		if ev.err != nil {
+			if beyond(ev.pos) {
+				delete(pending, ev.pos)
+				continue
+			}
+			if err := p.fail(ev.pos, ev.sent, ev.err); err != nil {
+				return err
+			}
+			addHole(ev.pos)
+			continue
+		}
+
+		a := p.parse(ev.pos, ev.rsp)
+		ready[ev.pos] = &chunk{ack: a, rsp: ev.rsp}
+		if a.fail {
+			continue
+		}
+		p.ctl.onAck()
+
+		if a.total >= 0 && !beyond(a.total) {
+			limit = a.total
+		}
+		if a.eof || a.end <= ev.pos {
+			if !beyond(a.end) {
+				limit = a.end
+			}
+			continue
+		}
+
+		stride = a.end - ev.pos
+		if n := after(ev.pos); n < 0 {
+			// This was the last request; continue from where it ended.
+			next = a.end
+		} else if a.end < n && !pending[a.end] {
+			addHole(a.end)
+		}
+	}
+}
+
+// Interprets the response to a read of a byte stream.  n is the number of
+// bytes the response carries and length is the size of the stream, if the
+// device reported it.
+func byteReadAck(off int, rc int, length uint32, n int) pipeAck {
+	a := pipeAck{
+		end:   off + n,
+		eof:   n == 0,
+		total: -1,
+		fail:  rc != 0,
+	}
+	if length > 0 {
+		a.total = int(length)
+	}
+
+	return a
+}
@@ -0,0 +1,495 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+package xact
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/nmxutil"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// Drop functions for pipeSesn.  try counts the requests for off, starting at
+// 1.
+type pipeDropFn func(off int, try int) bool
+
+func dropNone(off int, try int) bool {
+	return false
+}
+
+// Loses the first request for each of the specified offsets.
+func dropFirst(offs ...int) pipeDropFn {
+	return func(off int, try int) bool {
+		for _, o := range offs {
+			if off == o && try == 1 {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Loses the specified requests, counting from 1 in the order the device
+// receives them.
+func dropRequests(ns ...int) pipeDropFn {
+	cnt := 0
+	return func(off int, try int) bool {
+		cnt++
+		for _, n := range ns {
+			if cnt == n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Loses every request for the specified offset.
+func dropAlways(o int) pipeDropFn {
+	return func(off int, try int) bool {
+		return off == o
+	}
+}
+
+// Loses every other request.
+func dropAlternate() pipeDropFn {
+	n := 0
+	return func(off int, try int) bool {
+		n++
+		return n%2 == 0
+	}
+}
+
+type pipeSesnReq struct {
+	errc chan error
+	done bool
+}
+
+// pipeSesn is a session with a simulated device that serves file uploads
+// and downloads.  Responses are delivered asynchronously; lost requests
+// fail with a response timeout.
+type pipeSesn struct {
+	// Methods not used by the pipeline are left unimplemented.
+	sesn.Sesn
+
+	drop pipeDropFn
+
+	// Downloads: number of bytes per response.
+	rdLen int
+
+	// If nonzero, the response rc of every request.
+	rc int
+
+	mtx    sync.Mutex
+	file   []byte
+	tries  map[int]int
+	reqs   map[uint8]*pipeSesnReq
+	out    int
+	maxOut int
+	lost   int
+}
+
+func newPipeSesn(file []byte, drop pipeDropFn) *pipeSesn {
+	return &pipeSesn{
+		drop:  drop,
+		rdLen: 100,
+		file:  file,
+		tries: map[int]int{},
+		reqs:  map[uint8]*pipeSesnReq{},
+	}
+}
+
+func (s *pipeSesn) IsOpen() bool {
+	return true
+}
+
+func (s *pipeSesn) MtuOut() int {
+	return 256
+}
+
+func (s *pipeSesn) MgmtProto() sesn.MgmtProto {
+	return sesn.MGMT_PROTO_NMP
+}
+
+// Processes a request; returns nil if the request is lost.
+func (s *pipeSesn) process(body interface{}) (nmp.NmpRsp, error) {
+	switch req := body.(type) {
+	case *nmp.FsUploadReq:
+		off := int(req.Off)
+		s.tries[off]++
+		if s.drop(off, s.tries[off]) {
+			return nil, nil
+		}
+
+		rsp := nmp.NewFsUploadRsp()
+		rsp.Rc = s.rc
+		if off == len(s.file) && s.rc == 0 {
+			s.file = append(s.file, req.Data...)
+		}
+		rsp.Off = uint32(len(s.file))
+		return rsp, nil
+
+	case *nmp.FsDownloadReq:
+		off := int(req.Off)
+		s.tries[off]++
+		if s.drop(off, s.tries[off]) {
+			return nil, nil
+		}
+
+		rsp := nmp.NewFsDownloadRsp()
+		rsp.Rc = s.rc
+		if s.rc != 0 {
+			return rsp, nil
+		}
+
+		end := off + s.rdLen
+		if end > len(s.file) {
+			end = len(s.file)
+		}
+		rsp.Off = req.Off
+		rsp.Len = uint32(len(s.file))
+		rsp.Data = append([]byte{}, s.file[off:end]...)
+		return rsp, nil
+
+	default:
+		return nil, fmt.Errorf("unexpected request %T", body)
+	}
+}
+
+func (s *pipeSesn) TxRxMgmtAsync(m *nmp.NmpMsg, timeout time.Duration,
+	ch chan nmp.NmpRsp, errc chan error) error {
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rsp, err := s.process(m.Body)
+	if err != nil {
+		return err
+	}
+	if rsp == nil {
+		s.lost++
+	}
+
+	r := &pipeSesnReq{errc: errc}
+	seq := m.Hdr.Seq
+	s.reqs[seq] = r
+	s.out++
+	if s.out > s.maxOut {
+		s.maxOut = s.out
+	}
+
+	// Completes the request unless it was aborted first.
+	finish := func() bool {
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+
+		if r.done {
+			return false
+		}
+		r.done = true
+		s.out--
+		return true
+	}
+
+	if rsp == nil {
+		time.AfterFunc(timeout, func() {
+			if finish() {
+				errc <- nmxutil.NewRspTimeoutError("lost")
+			}
+		})
+	} else {
+		time.AfterFunc(time.Millisecond, func() {
+			if finish() {
+				ch <- rsp
+			}
+		})
+	}
+
+	return nil
+}
+
+func (s *pipeSesn) AbortRx(seq uint8) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	r := s.reqs[seq]
+	if r == nil || r.done {
+		return nil
+	}
+	r.done = true
+	s.out--
+
+	select {
+	case r.errc <- errors.New("rx aborted"):
+	default:
+	}
+	return nil
+}
+
+func pipeTestData(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i * 7)
+	}
+	return b
+}
+
+func pipeTestTxOptions() sesn.TxOptions {
+	return sesn.TxOptions{
+		Timeout: 20 * time.Millisecond,
+		Tries:   1,
+	}
+}
+
+func TestPipelineWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		maxWinSz int
+		drop     pipeDropFn
+		lost     bool
+		rc       int
+		status   int
+		fail     bool
+	}{
+		{name: "window 1", size: 1000, maxWinSz: 1, drop: dropNone},
+		{name: "window 5", size: 3000, maxWinSz: 5, drop: dropNone},
+		{name: "single chunk", size: 10, maxWinSz: 5, drop: dropNone},
+		{
+			name:     "first chunk lost",
+			size:     2000,
+			maxWinSz: 5,
+			drop:     dropFirst(0),
+			lost:     true,
+		},
+		{
+			name:     "middle chunks lost",
+			size:     3000,
+			maxWinSz: 5,
+			drop:     dropRequests(4, 5, 9),
+			lost:     true,
+		},
+		{
+			name:     "alternate requests lost",
+			size:     2000,
+			maxWinSz: 4,
+			drop:     dropAlternate(),
+			lost:     true,
+		},
+		{
+			name:     "chunk always lost",
+			size:     2000,
+			maxWinSz: 3,
+			drop:     dropAlways(0),
+			fail:     true,
+		},
+		{
+			name:     "device error",
+			size:     2000,
+			maxWinSz: 3,
+			drop:     dropNone,
+			rc:       nmp.NMP_ERR_ENOMEM,
+			status:   nmp.NMP_ERR_ENOMEM,
+		},
+	}
+
+	for _, tt := range tests {
+		data := pipeTestData(tt.size)
+		s := newPipeSesn(nil, tt.drop)
+		s.rc = tt.rc
+
+		c := NewFsUploadCmd()
+		c.SetTxOptions(pipeTestTxOptions())
+		c.Name = "/test"
+		c.Data = data
+		c.MaxWinSz = tt.maxWinSz
+
+		res, err := c.Run(s)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			} else if !nmxutil.IsRspTimeout(err) {
+				t.Errorf("%s: unexpected error: %s", tt.name, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err.Error())
+			continue
+		}
+
+		if st := res.Status(); st != tt.status {
+			t.Errorf("%s: status = %d; want %d", tt.name, st, tt.status)
+		}
+		if tt.status == 0 && !bytes.Equal(s.file, data) {
+			t.Errorf("%s: device received %d bytes; want %d identical "+
+				"bytes", tt.name, len(s.file), len(data))
+		}
+		if (s.lost > 0) != tt.lost {
+			t.Errorf("%s: %d requests lost", tt.name, s.lost)
+		}
+		if s.maxOut > tt.maxWinSz {
+			t.Errorf("%s: %d requests outstanding; window is %d",
+				tt.name, s.maxOut, tt.maxWinSz)
+		}
+	}
+}
+
+func TestPipelineWriteResumed(t *testing.T) {
+	// The device already holds the start of the file; its first
+	// acknowledgement moves the transfer forward.
+	data := pipeTestData(3000)
+	s := newPipeSesn(append([]byte{}, data[:1700]...), dropNone)
+
+	c := NewFsUploadCmd()
+	c.SetTxOptions(pipeTestTxOptions())
+	c.Name = "/test"
+	c.Data = data
+	c.StartOff = 1000
+
+	if _, err := c.Run(s); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if !bytes.Equal(s.file, data) {
+		t.Fatalf("device received %d bytes; want %d identical bytes",
+			len(s.file), len(data))
+	}
+	for off := range s.tries {
+		if off > 1000 && off < 1700 {
+			t.Errorf("chunk at %d sent after the device acknowledged 1700",
+				off)
+		}
+	}
+}
+
+func TestPipelineRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		rdLen    int
+		maxWinSz int
+		drop     pipeDropFn
+		lost     bool
+		rc       int
+		status   int
+		fail     bool
+	}{
+		{name: "empty", size: 0, rdLen: 100, maxWinSz: 5, drop: dropNone},
+		{name: "window 1", size: 1000, rdLen: 100, maxWinSz: 1,
+			drop: dropNone},
+		{name: "window 5", size: 3050, rdLen: 100, maxWinSz: 5,
+			drop: dropNone},
+		{name: "exact multiple", size: 500, rdLen: 100, maxWinSz: 5,
+			drop: dropNone},
+		{name: "first chunk lost", size: 1000, rdLen: 100, maxWinSz: 5,
+			drop: dropFirst(0), lost: true},
+		{name: "several chunks lost", size: 2000, rdLen: 100, maxWinSz: 5,
+			drop: dropFirst(300, 400, 1900), lost: true},
+		{name: "alternate requests lost", size: 1500, rdLen: 100,
+			maxWinSz: 4, drop: dropAlternate(), lost: true},
+		{name: "chunk always lost", size: 1000, rdLen: 100, maxWinSz: 3,
+			drop: dropAlways(500), fail: true},
+		{name: "device error", size: 1000, rdLen: 100, maxWinSz: 3,
+			drop: dropNone, rc: nmp.NMP_ERR_ENOENT,
+			status: nmp.NMP_ERR_ENOENT},
+	}
+
+	for _, tt := range tests {
+		data := pipeTestData(tt.size)
+		s := newPipeSesn(data, tt.drop)
+		s.rdLen = tt.rdLen
+		s.rc = tt.rc
+
+		c := NewFsDownloadCmd()
+		c.SetTxOptions(pipeTestTxOptions())
+		c.Name = "/test"
+		c.MaxWinSz = tt.maxWinSz
+
+		// Responses must be delivered in order and without overlap.
+		got := []byte{}
+		c.ProgressCb = func(_ *FsDownloadCmd, rsp *nmp.FsDownloadRsp) {
+			if int(rsp.Off) != len(got) {
+				t.Errorf("%s: response at offset %d delivered after %d "+
+					"bytes", tt.name, rsp.Off, len(got))
+			}
+			got = append(got, rsp.Data...)
+		}
+
+		res, err := c.Run(s)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			} else if !nmxutil.IsRspTimeout(err) {
+				t.Errorf("%s: unexpected error: %s", tt.name, err.Error())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.name, err.Error())
+			continue
+		}
+
+		if st := res.Status(); st != tt.status {
+			t.Errorf("%s: status = %d; want %d", tt.name, st, tt.status)
+		}
+		if tt.status == 0 && !bytes.Equal(got, data) {
+			t.Errorf("%s: read %d bytes; want %d identical bytes",
+				tt.name, len(got), len(data))
+		}
+		if (s.lost > 0) != tt.lost {
+			t.Errorf("%s: %d requests lost", tt.name, s.lost)
+		}
+		if s.maxOut > tt.maxWinSz {
+			t.Errorf("%s: %d requests outstanding; window is %d",
+				tt.name, s.maxOut, tt.maxWinSz)
+		}
+	}
+}
+
+func TestByteReadAck(t *testing.T) {
+	tests := []struct {
+		off    int
+		rc     int
+		length uint32
+		n      int
+		want   pipeAck
+	}{
+		{0, 0, 1000, 100, pipeAck{end: 100, total: 1000}},
+		{900, 0, 1000, 100, pipeAck{end: 1000, total: 1000}},
+		{1000, 0, 1000, 0, pipeAck{end: 1000, eof: true, total: 1000}},
+		{200, 0, 0, 50, pipeAck{end: 250, total: -1}},
+		{0, 0, 0, 0, pipeAck{end: 0, eof: true, total: -1}},
+		{300, nmp.NMP_ERR_EINVAL, 0, 0,
+			pipeAck{end: 300, eof: true, total: -1, fail: true}},
+	}
+
+	for _, tt := range tests {
+		got := byteReadAck(tt.off, tt.rc, tt.length, tt.n)
+		if got != tt.want {
+			t.Errorf("byteReadAck(%d, %d, %d, %d) = %+v; want %+v",
+				tt.off, tt.rc, tt.length, tt.n, got, tt.want)
+		}
+	}
+}
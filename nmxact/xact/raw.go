@@ -0,0 +1,80 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package xact
+
+import (
+	"github.com/recogni/newtmgr/nmxact/nmp"
+	"github.com/recogni/newtmgr/nmxact/sesn"
+)
+
+// RawCmd sends a request with an arbitrary op, group, and ID.  It allows
+// management handlers without built-in support to be exercised.
+type RawCmd struct {
+	CmdBase
+	Op    uint8
+	Group uint16
+	Id    uint8
+	Body  map[string]interface{}
+}
+
+func NewRawCmd() *RawCmd {
+	return &RawCmd{
+		CmdBase: NewCmdBase(),
+		Op:      nmp.NMP_OP_READ,
+	}
+}
+
+type RawResult struct {
+	Rsp *nmp.RawRsp
+}
+
+func newRawResult() *RawResult {
+	return &RawResult{}
+}
+
+func (r *RawResult) Status() int {
+	return r.Rsp.Rc()
+}
+
+func (r *RawResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
+func (c *RawCmd) Run(s sesn.Sesn) (Result, error) {
+	r := nmp.NewRawReq(c.Op, c.Group, c.Id)
+	if c.Body != nil {
+		r.Body = c.Body
+	}
+
+	rsp, err := txReq(s, r.Msg(), &c.CmdBase)
+	if err != nil {
+		return nil, err
+	}
+
+	// Responses with a registered type are converted to a generic map.
+	srsp, err := nmp.ToRawRsp(rsp)
+	if err != nil {
+		return nil, err
+	}
+
+	res := newRawResult()
+	res.Rsp = srsp
+	return res, nil
+}
@@ -59,7 +59,7 @@ func (c *ResCmd) Run(s sesn.Sesn) (Result, error) {
 	var rsp coap.Message
 	var err error
 
-	rsp, err = sesn.TxRxCoap(s, c.MsgParams, c.txOptions)
+	rsp, err = sesn.TxRxCoapCtx(c.Context(), s, c.MsgParams, c.txOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -93,6 +93,10 @@ func (r *ResNoRxResult) Status() int {
 }
 
 func (c *ResNoRxCmd) Run(s sesn.Sesn) (Result, error) {
+	if err := c.Context().Err(); err != nil {
+		return nil, err
+	}
+
 	if err := sesn.TxCoap(s, c.MsgParams); err != nil {
 		return nil, err
 	}
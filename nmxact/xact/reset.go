@@ -47,6 +47,10 @@ func (r *ResetResult) Status() int {
 	return 0
 }
 
+func (r *ResetResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *ResetCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewResetReq()
 
@@ -0,0 +1,211 @@
+/**
+ * Licensed to the Apache Software Foundation (ASF) under one
+ * or more contributor license agreements.  See the NOTICE file
+ * distributed with this work for additional information
+ * regarding copyright ownership.  The ASF licenses this file
+ * to you under the Apache License, Version 2.0 (the
+ * "License"); you may not use this file except in compliance
+ * with the License.  You may obtain a copy of the License at
+ *
+ *  http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing,
+ * software distributed under the License is distributed on an
+ * "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+ * KIND, either express or implied.  See the License for the
+ * specific language governing permissions and limitations
+ * under the License.
+ */
+
+package xact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Upload resumption.
+//
+// An interrupted upload leaves partial data on the device.  If an upload
+// command is given a transfer state store, it records its progress there,
+// keyed by the identity of the device and the hash of the data being
+// uploaded.  A later upload of the same data to the same device finds the
+// record, asks the device how much of the data it already holds, checks that
+// data against the file, and continues from there rather than starting over.
+// The record is removed once the upload completes.
+
+const XFER_KIND_IMAGE = "image"
+const XFER_KIND_FILE = "file"
+
+// Minimum interval between progress updates written to the store.
+const XFER_STATE_SAVE_INTERVAL = 1 * time.Second
+
+type XferState struct {
+	// Identifies the device being uploaded to, e.g., by its connection
+	// string.
+	Device string `json:"device"`
+
+	// What is being uploaded (XFER_KIND_IMAGE or XFER_KIND_FILE) and where
+	// to: the image number or the destination file name.
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+
+	// SHA256 of the data being uploaded, in hex, and its length.
+	Hash string `json:"hash"`
+	Len  int    `json:"len"`
+
+	// Number of bytes the device had acknowledged at the last update.
+	Off     int       `json:"off"`
+	Updated time.Time `json:"updated"`
+
+	path  string
+	saved time.Time
+}
+
+type XferStateStore struct {
+	Dir string
+}
+
+func NewXferStateStore(dir string) *XferStateStore {
+	return &XferStateStore{
+		Dir: dir,
+	}
+}
+
+func (st *XferStateStore) path(device string, kind string, target string,
+	hash string) string {
+
+	key := sha256.Sum256([]byte(
+		strings.Join([]string{device, kind, target, hash}, "\x00")))
+	return filepath.Join(st.Dir, hex.EncodeToString(key[:16])+".json")
+}
+
+// Open retrieves the record of an earlier, unfinished upload of data to the
+// specified target on a device.  If there is no such record, a new one is
+// created.  The returned bool indicates whether an earlier record was found.
+func (st *XferStateStore) Open(device string, kind string, target string,
+	data []byte) (*XferState, bool, error) {
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	xs := &XferState{
+		Device: device,
+		Kind:   kind,
+		Target: target,
+		Hash:   hash,
+		Len:    len(data),
+		path:   st.path(device, kind, target, hash),
+	}
+
+	blob, err := ioutil.ReadFile(xs.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return xs, false, xs.save()
+		}
+		return nil, false, err
+	}
+
+	prev := *xs
+	if err := json.Unmarshal(blob, &prev); err != nil {
+		return nil, false, fmt.Errorf("error reading transfer state (%s): %s",
+			xs.path, err.Error())
+	}
+
+	// Guard against a colliding key.
+	if prev.Device != device || prev.Kind != kind || prev.Target != target ||
+		prev.Hash != hash || prev.Len != len(data) {
+
+		return xs, false, xs.save()
+	}
+
+	return &prev, true, nil
+}
+
+func (xs *XferState) save() error {
+	xs.Updated = time.Now()
+
+	blob, err := json.MarshalIndent(xs, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(xs.path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so that a crash never leaves
+	// a truncated record behind.
+	tmp := xs.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, blob, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, xs.path); err != nil {
+		return err
+	}
+
+	xs.saved = xs.Updated
+	return nil
+}
+
+// Records that the device has acknowledged off bytes.  To limit the cost of
+// frequent updates, the record is written at most once per
+// XFER_STATE_SAVE_INTERVAL.  A nil state is ignored.
+func (xs *XferState) update(off int) {
+	if xs == nil || off <= xs.Off {
+		return
+	}
+
+	xs.Off = off
+	if time.Since(xs.saved) < XFER_STATE_SAVE_INTERVAL {
+		return
+	}
+
+	if err := xs.save(); err != nil {
+		log.Warnf("Failed to record transfer state: %s", err.Error())
+	}
+}
+
+// Removes the record of a completed upload.  A nil state is ignored.
+func (xs *XferState) remove() {
+	if xs == nil {
+		return
+	}
+
+	if err := os.Remove(xs.path); err != nil && !os.IsNotExist(err) {
+		log.Warnf("Failed to remove transfer state: %s", err.Error())
+	}
+}
+
+// Opens the transfer state for an upload command.  If the command has no
+// store, or the store cannot be used, the state is nil and the upload is not
+// recorded; resumption is best effort and never prevents an upload.
+func openXferState(st *XferStateStore, device string, kind string,
+	target string, data []byte) (*XferState, bool) {
+
+	if st == nil {
+		return nil, false
+	}
+
+	xs, found, err := st.Open(device, kind, target, data)
+	if err != nil {
+		log.Warnf("Cannot record transfer state: %s", err.Error())
+		return nil, false
+	}
+
+	if found {
+		log.Debugf("Found unfinished upload; device=%s kind=%s target=%s "+
+			"off=%d len=%d", device, kind, target, xs.Off, xs.Len)
+	}
+
+	return xs, found
+}
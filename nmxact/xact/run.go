@@ -52,6 +52,10 @@ func (r *RunTestResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *RunTestResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *RunTestCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewRunTestReq()
 	r.Testname = c.Testname
@@ -94,6 +98,10 @@ func (r *RunListResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *RunListResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *RunListCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewRunListReq()
 
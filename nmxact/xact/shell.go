@@ -47,6 +47,10 @@ func (r *ShellExecResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *ShellExecResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *ShellExecCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewShellExecReq()
 	r.Argv = c.Argv
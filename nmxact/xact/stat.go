@@ -51,6 +51,10 @@ func (r *StatReadResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *StatReadResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *StatReadCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewStatReadReq()
 	r.Name = c.Name
@@ -92,6 +96,10 @@ func (r *StatListResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *StatListResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *StatListCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewStatListReq()
 
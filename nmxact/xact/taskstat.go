@@ -46,6 +46,10 @@ func (r *TaskStatResult) Status() int {
 	return r.Rsp.Rc
 }
 
+func (r *TaskStatResult) Response() nmp.NmpRsp {
+	return r.Rsp
+}
+
 func (c *TaskStatCmd) Run(s sesn.Sesn) (Result, error) {
 	r := nmp.NewTaskStatReq()
 
@@ -31,15 +31,20 @@ func txReq(s sesn.Sesn, m *nmp.NmpMsg, c *CmdBase) (
 	if c.abortErr != nil {
 		return nil, c.abortErr
 	}
+	if err := c.Context().Err(); err != nil {
+		return nil, err
+	}
 
-	c.curNmpSeq = m.Hdr.Seq
+	m.Hdr.Seq = sesn.NextSeq(s)
+	m.SeqCb = c.setCurNmpSeq
+	c.setCurNmpSeq(m.Hdr.Seq)
 	c.curSesn = s
 	defer func() {
-		c.curNmpSeq = 0
+		c.setCurNmpSeq(0)
 		c.curSesn = nil
 	}()
 
-	rsp, err := sesn.TxRxMgmt(s, m, c.TxOptions())
+	rsp, err := sesn.TxRxMgmtCtx(c.Context(), s, m, c.TxOptions())
 	if err != nil {
 		return nil, err
 	}
@@ -52,18 +57,23 @@ func txReqAsync(s sesn.Sesn, m *nmp.NmpMsg, c *CmdBase, ch chan nmp.NmpRsp, errc
 	if c.abortErr != nil {
 		return c.abortErr
 	}
+	if err := c.Context().Err(); err != nil {
+		return err
+	}
 
-	c.curNmpSeq = m.Hdr.Seq
+	m.Hdr.Seq = sesn.NextSeq(s)
+	m.SeqCb = c.setCurNmpSeq
+	c.setCurNmpSeq(m.Hdr.Seq)
 	c.curSesn = s
 	defer func() {
-		c.curNmpSeq = 0
+		c.setCurNmpSeq(0)
 		c.curSesn = nil
 	}()
 
 	err := sesn.TxRxMgmtAsync(s, m, c.TxOptions(), ch, errc)
 	if err != nil {
 		log.Debugf("error %v TxRxMgmtAsync sesn %v seq %d",
-			err, c.curSesn, c.curNmpSeq)
+			err, c.curSesn, c.getCurNmpSeq())
 		return err
 	} else {
 		return nil